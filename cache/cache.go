@@ -5,33 +5,331 @@
 package cache
 
 import (
+	"container/list"
 	"mime"
+	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
+// Cache holds the contents of recently requested files in a Store, in
+// memory unless set otherwise.
+// If limits are set, the least recently used files are evicted to keep
+// the total size of the contents within a budget.
+//
+// Gets of cached files only take the cache lock for reading. Rather than
+// moving files to the front of the LRU list on every Get, which would need
+// the lock for writing, files are marked as used, and the eviction gives
+// marked files at the back of the list a second chance, as in the CLOCK
+// algorithm.
 type Cache struct {
-	sync.Mutex
-	files map[string]*CachedFile
+	counts counters // First, for the 64-bit alignment atomic operations need
+	sync.RWMutex
+	files    map[string]*CachedFile
+	lru      *list.List             // Cached files, roughly most recently used first
+	size     int64                  // Total size of the cached contents
+	maxBytes int64                  // Budget for size, or zero for no limit
+	maxEntry int64                  // Size of the largest file kept, or zero for no limit
+	interval time.Duration          // Revalidation interval of the cached files
+	watch    *watcher               // Watcher of the cached files, if Watch was called
+	watched  map[string]*CachedFile // Watched files, by clean path
+	compress bool                   // Whether to keep compressed copies of the files
+	stream   int64                  // Size above which files are read from disk, or zero
+	store    Store                  // Store holding the contents of the cached files
+	root     string                 // Real path of the directory files must lie within, if any
+	xforms   map[string]transformer // Transformers, by lower-case extension
 }
 
+// NewCache returns a Cache that keeps every file it is asked for.
 func NewCache() *Cache {
+	return NewCacheLimit(0, 0)
+}
+
+// NewCacheLimit returns a Cache that keeps the total size of the cached
+// contents within maxBytes, and does not keep files larger than maxEntry.
+// Such files are read from disk on every Get. Zero means no limit.
+func NewCacheLimit(maxBytes, maxEntry int64) *Cache {
 	return &Cache{
-		files: make(map[string]*CachedFile),
+		files:    make(map[string]*CachedFile),
+		lru:      list.New(),
+		maxBytes: maxBytes,
+		maxEntry: maxEntry,
+		store:    NewMemStore(),
 	}
 }
 
-func (c *Cache) Get(filename string) (content []byte, mimetype string, err error) {
+// SetStore sets the Store that holds the contents of the cached files,
+// e.g. a DiskStore for large trees. The cache is cleared. The byte budget
+// of the cache then bounds the size of the store.
+func (c *Cache) SetStore(s Store) {
 	c.Lock()
+	defer c.Unlock()
+	c.clear()
+	c.store = s
+}
+
+// Get returns the contents of filename, their MIME type, and their entity
+// tag, a quoted hash of the contents that is computed when the file is read.
+func (c *Cache) Get(filename string) (content []byte, mimetype, etag string, err error) {
+	content, mimetype, etag, _, err = c.get(filename, false)
+	return content, mimetype, etag, err
+}
+
+// SetStreamThreshold sets the size above which the contents of files are
+// not stored, but read from the file whenever it is opened. Only the
+// metadata of such files, including their hash, is cached. Zero, the
+// default, means all contents are stored.
+func (c *Cache) SetStreamThreshold(n int64) {
+	c.Lock()
+	defer c.Unlock()
+	c.stream = n
+	for _, f := range c.files {
+		f.SetStreamThreshold(n)
+	}
+}
+
+// GetGzip is like Get, but returns the gzip-compressed contents of the
+// file if compression is on and makes the contents smaller. The result
+// gzipped tells whether content is compressed. Compressed contents have
+// an entity tag of their own.
+func (c *Cache) GetGzip(filename string) (content []byte, mimetype, etag string, gzipped bool, err error) {
+	return c.get(filename, true)
+}
+
+func (c *Cache) get(filename string, gz bool) (content []byte, mimetype, etag string, gzipped bool, err error) {
+	r, mimetype, etag, gzipped, err := c.open(filename, gz)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	content, err = contentBytes(r)
+	if err != nil {
+		return nil, "", "", false, err
+	}
+	return content, mimetype, etag, gzipped, nil
+}
+
+func (c *Cache) open(filename string, gz bool) (content Content, mimetype, etag string, gzipped bool, err error) {
+	c.RLock()
 	f, ok := c.files[filename]
-	if !ok {
-		f = NewCachedFile(filename)
-		c.files[filename] = f
+	c.RUnlock()
+	if ok {
+		atomic.StoreUint32(&f.used, 1)
+	} else {
+		f = c.add(filename)
 	}
-	c.Unlock()
-	content, err = f.Get()
+	content, etag, gzipped, size, err := f.open(gz)
+	c.account(f, size, err)
 	if err == nil {
-		mimetype = mime.TypeByExtension(path.Ext(filename))
+		mimetype = f.mimetype
+		if mimetype == "" {
+			mimetype = mime.TypeByExtension(path.Ext(filename))
+		}
+	}
+	return content, mimetype, etag, gzipped, err
+}
+
+// add returns the CachedFile of filename, adding it to the cache if needed.
+func (c *Cache) add(filename string) *CachedFile {
+	c.Lock()
+	defer c.Unlock()
+	if f, ok := c.files[filename]; ok {
+		return f
+	}
+	f := NewCachedFile(filename)
+	f.interval = int64(c.interval)
+	f.compress = c.compress
+	f.stream = c.stream
+	f.counts = &c.counts
+	f.store = c.store
+	f.root = c.root
+	if t, ok := c.xforms[normalizeExt(path.Ext(filename))]; ok {
+		f.xform, f.mimetype = t.fn, t.mimetype
+	}
+	c.files[filename] = f
+	f.elem = c.lru.PushFront(f)
+	if c.watch != nil {
+		c.addWatch(f)
+	}
+	return f
+}
+
+// SetCompress sets whether a gzip-compressed copy of every file is kept
+// alongside its contents, for GetGzip. Files are compressed once, when
+// they are read.
+func (c *Cache) SetCompress(compress bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.compress = compress
+	for _, f := range c.files {
+		f.SetCompress(compress)
+	}
+}
+
+// SetRevalidateInterval sets how long cached contents are trusted before
+// their file is checked for changes again. Zero, the default, means files
+// are checked on every Get.
+func (c *Cache) SetRevalidateInterval(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.interval = d
+	for _, f := range c.files {
+		if !f.watched {
+			f.SetRevalidateInterval(d)
+		}
+	}
+}
+
+// Revalidate makes the next Get of filename check the file for changes,
+// regardless of the revalidation interval.
+func (c *Cache) Revalidate(filename string) {
+	c.RLock()
+	f := c.files[filename]
+	c.RUnlock()
+	if f != nil {
+		f.Revalidate()
+	}
+}
+
+// RevalidateAll makes the next Get of every cached file check the file
+// for changes.
+func (c *Cache) RevalidateAll() {
+	c.Lock()
+	defer c.Unlock()
+	for _, f := range c.files {
+		f.Revalidate()
+	}
+}
+
+// Invalidate drops filename from the cache.
+func (c *Cache) Invalidate(filename string) {
+	c.Lock()
+	defer c.Unlock()
+	if f, ok := c.files[filename]; ok {
+		c.remove(f)
+	}
+}
+
+// InvalidatePrefix drops the files in the directory dir, and in its
+// subdirectories, from the cache.
+func (c *Cache) InvalidatePrefix(dir string) {
+	dir = filepath.Clean(dir)
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	c.Lock()
+	defer c.Unlock()
+	for name, f := range c.files {
+		name = filepath.Clean(name)
+		if name == dir || strings.HasPrefix(name, prefix) {
+			c.remove(f)
+		}
+	}
+}
+
+// Clear drops all files from the cache.
+func (c *Cache) Clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.clear()
+}
+
+func (c *Cache) clear() {
+	for _, f := range c.files {
+		c.remove(f)
+	}
+}
+
+// Size returns the total size of the cached contents.
+func (c *Cache) Size() int64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.size
+}
+
+// account updates the size of f after a Get, dropping f if it failed
+// or is too large, and evicts the least recently used files as needed
+// to stay within the budget.
+func (c *Cache) account(f *CachedFile, size int64, err error) {
+	if err == nil && atomic.LoadInt64(&f.size) == size {
+		// Nothing changed, as on most Gets
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if f.elem == nil {
+		// Evicted meanwhile
+		return
+	}
+	if err != nil || (c.maxEntry > 0 && size > c.maxEntry) {
+		c.remove(f)
+		return
+	}
+	c.size += size - f.size
+	atomic.StoreInt64(&f.size, size)
+	chances := c.lru.Len()
+	for c.maxBytes > 0 && c.size > c.maxBytes {
+		e := c.lru.Back()
+		g := e.Value.(*CachedFile)
+		if chances > 0 && atomic.CompareAndSwapUint32(&g.used, 1, 0) {
+			chances--
+			c.lru.MoveToFront(e)
+			continue
+		}
+		c.remove(g)
+		c.counts.evict()
+	}
+}
+
+// remove drops f from the cache. The cache must be locked.
+func (c *Cache) remove(f *CachedFile) {
+	if c.watch != nil {
+		c.removeWatch(f)
+	}
+	f.release()
+	delete(c.files, f.fname)
+	c.lru.Remove(f.elem)
+	f.elem = nil
+	c.size -= f.size
+	atomic.StoreInt64(&f.size, 0)
+}
+
+// PreloadDir walks the directory tree at root and reads every file whose
+// base name matches one of patterns, as in filepath.Match, into the cache.
+// If patterns is empty, all files are read. Files are compressed and hashed
+// as on a Get. PreloadDir stops at the first error, except for files that
+// cannot be read, which are skipped.
+func (c *Cache) PreloadDir(root string, patterns []string) error {
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return err
+		}
+	}
+	return filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fi.Mode().IsRegular() || !matchAny(patterns, fi.Name()) {
+			return nil
+		}
+		c.Get(name)
+		return nil
+	})
+}
+
+// matchAny returns true if name matches one of patterns, or if there are no patterns.
+func matchAny(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
 	}
-	return content, mimetype, err
+	return false
 }
@@ -0,0 +1,506 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempDir returns a new temporary directory, and a function that removes it.
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "cache")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// writeFile writes n copies of the byte b to the file name in dir, and
+// returns the path of the file.
+func writeFile(t *testing.T, dir, name string, b byte, n int) string {
+	name = filepath.Join(dir, name)
+	if err := ioutil.WriteFile(name, []byte(strings.Repeat(string(b), n)), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	return name
+}
+
+// touch sets the modification time of the file name to sec seconds from
+// now, so that changes are seen however coarse the file times.
+func touch(t *testing.T, name string, sec int) {
+	mtime := time.Now().Add(time.Duration(sec) * time.Second)
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatalf("Chtimes: %s", err)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	a := writeFile(t, dir, "a", 'a', 100)
+	b := writeFile(t, dir, "b", 'b', 100)
+	d := writeFile(t, dir, "d", 'd', 100)
+
+	c := NewCacheLimit(250, 0)
+	for _, name := range []string{a, b} {
+		if _, _, _, err := c.Get(name); err != nil {
+			t.Fatalf("Get(%s): %s", name, err)
+		}
+	}
+	if n := c.Size(); n != 200 {
+		t.Fatalf("size %d, expected 200", n)
+	}
+	// a is used again, so b is evicted in its place
+	c.Get(a)
+	data, _, _, err := c.Get(d)
+	if err != nil || len(data) != 100 {
+		t.Fatalf("Get(%s) returned %d bytes, %v", d, len(data), err)
+	}
+	if n := c.Size(); n != 200 {
+		t.Errorf("size %d after eviction, expected 200", n)
+	}
+	s := c.Stats()
+	if s.Evictions != 1 || s.Files != 2 || s.Bytes != 200 {
+		t.Errorf("stats after eviction: %s", s)
+	}
+	c.RLock()
+	_, okA := c.files[a]
+	_, okB := c.files[b]
+	c.RUnlock()
+	if !okA || okB {
+		t.Errorf("cached a %v, b %v; expected a but not b", okA, okB)
+	}
+
+	// The evicted file is read again
+	data, _, _, err = c.Get(b)
+	if err != nil || string(data) != strings.Repeat("b", 100) {
+		t.Fatalf("Get(%s) after eviction returned %q, %v", b, data, err)
+	}
+	if n := c.Size(); n > 250 {
+		t.Errorf("size %d over budget", n)
+	}
+}
+
+func TestCacheMaxEntry(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	small := writeFile(t, dir, "small", 's', 10)
+	large := writeFile(t, dir, "large", 'l', 100)
+
+	c := NewCacheLimit(0, 50)
+	for i := 0; i < 2; i++ {
+		data, _, _, err := c.Get(large)
+		if err != nil || len(data) != 100 {
+			t.Fatalf("Get(%s) returned %d bytes, %v", large, len(data), err)
+		}
+	}
+	if _, _, _, err := c.Get(small); err != nil {
+		t.Fatalf("Get(%s): %s", small, err)
+	}
+	s := c.Stats()
+	if s.Files != 1 || s.Bytes != 10 {
+		t.Errorf("stats: %s; expected only the small file cached", s)
+	}
+	if s.Misses != 3 {
+		t.Errorf("%d misses, expected the large file to be read on every Get", s.Misses)
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	name := writeFile(t, dir, "f", 'x', 10)
+
+	c := NewCache()
+	_, _, etag, err := c.Get(name)
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	writeFile(t, dir, "f", 'y', 20)
+	touch(t, name, 1)
+	data, _, etag2, err := c.Get(name)
+	if err != nil || string(data) != strings.Repeat("y", 20) || etag2 == etag {
+		t.Fatalf("Get after change returned %q, %q, %v", data, etag2, err)
+	}
+	if n := c.Size(); n != 20 {
+		t.Errorf("size %d, expected 20", n)
+	}
+
+	// Within the staleness window, the file is not checked
+	c.SetRevalidateInterval(time.Hour)
+	c.Get(name)
+	writeFile(t, dir, "f", 'z', 5)
+	touch(t, name, 2)
+	if data, _, _, _ = c.Get(name); string(data) != strings.Repeat("y", 20) {
+		t.Errorf("Get within the window returned %q, expected the cached contents", data)
+	}
+	c.Revalidate(name)
+	if data, _, _, _ = c.Get(name); string(data) != "zzzzz" {
+		t.Errorf("Get after Revalidate returned %q", data)
+	}
+	writeFile(t, dir, "f", 'w', 5)
+	touch(t, name, 3)
+	c.RevalidateAll()
+	if data, _, _, _ = c.Get(name); string(data) != "wwwww" {
+		t.Errorf("Get after RevalidateAll returned %q", data)
+	}
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	sub := filepath.Join(dir, "sub")
+	if err := os.Mkdir(sub, 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	a := writeFile(t, dir, "a", 'a', 10)
+	b := writeFile(t, sub, "b", 'b', 10)
+	d := writeFile(t, sub, "d", 'd', 10)
+	subling := writeFile(t, dir, "sub2", 's', 10)
+
+	c := NewCache()
+	getAll := func() {
+		for _, name := range []string{a, b, d, subling} {
+			if _, _, _, err := c.Get(name); err != nil {
+				t.Fatalf("Get(%s): %s", name, err)
+			}
+		}
+	}
+	getAll()
+	c.Invalidate(a)
+	if s := c.Stats(); s.Files != 3 || s.Bytes != 30 {
+		t.Errorf("after Invalidate: %s", s)
+	}
+	c.InvalidatePrefix(sub)
+	if s := c.Stats(); s.Files != 1 || s.Bytes != 10 {
+		t.Errorf("after InvalidatePrefix: %s; expected only %s cached", s, subling)
+	}
+	getAll()
+	c.Clear()
+	if s := c.Stats(); s.Files != 0 || s.Bytes != 0 {
+		t.Errorf("after Clear: %s", s)
+	}
+
+	// Invalidated files are read again
+	writeFile(t, dir, "a", 'x', 3)
+	if data, _, _, err := c.Get(a); err != nil || string(data) != "xxx" {
+		t.Errorf("Get after Clear returned %q, %v", data, err)
+	}
+}
+
+func TestCacheGzip(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	text := writeFile(t, dir, "text", 't', 1000)
+	short := writeFile(t, dir, "short", 's', 1)
+
+	c := NewCache()
+	c.SetCompress(true)
+	data, _, etag, gzipped, err := c.GetGzip(text)
+	if err != nil || !gzipped {
+		t.Fatalf("GetGzip returned gzipped %v, %v", gzipped, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip reader: %s", err)
+	}
+	if plain, err := ioutil.ReadAll(zr); err != nil || string(plain) != strings.Repeat("t", 1000) {
+		t.Errorf("bad compressed contents (%v)", err)
+	}
+	_, _, plainTag, err := c.Get(text)
+	if err != nil || plainTag == etag {
+		t.Errorf("compressed contents tagged %s, plain %s (%v)", etag, plainTag, err)
+	}
+
+	content, _, etag2, gzipped, err := c.OpenGzip(text)
+	if err != nil || !gzipped || etag2 != etag || content.Size() != int64(len(data)) {
+		t.Errorf("OpenGzip returned gzipped %v, tag %s, %v", gzipped, etag2, err)
+	}
+	if err == nil {
+		content.Close()
+	}
+
+	// Contents that do not shrink are not compressed
+	if data, _, _, gzipped, err = c.GetGzip(short); err != nil || gzipped || string(data) != "s" {
+		t.Errorf("GetGzip of a 1-byte file returned %q, gzipped %v, %v", data, gzipped, err)
+	}
+
+	// Without compression, GetGzip returns the plain contents
+	c.SetCompress(false)
+	c.Clear()
+	if data, _, _, gzipped, err = c.GetGzip(text); err != nil || gzipped || len(data) != 1000 {
+		t.Errorf("GetGzip without compression returned gzipped %v, %v", gzipped, err)
+	}
+}
+
+func TestCacheETag(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	a := writeFile(t, dir, "a", 'x', 10)
+	b := writeFile(t, dir, "b", 'x', 10)
+	d := writeFile(t, dir, "d", 'y', 10)
+
+	c := NewCache()
+	etag := func(name string) string {
+		_, _, etag, err := c.Get(name)
+		if err != nil {
+			t.Fatalf("Get(%s): %s", name, err)
+		}
+		return etag
+	}
+	ta := etag(a)
+	if len(ta) < 3 || ta[0] != '"' || ta[len(ta)-1] != '"' {
+		t.Fatalf("entity tag %s is not quoted", ta)
+	}
+	if etag(a) != ta || etag(b) != ta {
+		t.Errorf("files with the same contents have different entity tags")
+	}
+	if etag(d) == ta {
+		t.Errorf("files with different contents share the entity tag %s", ta)
+	}
+
+	// The tag follows the contents, not the modification time
+	touch(t, a, 1)
+	if etag(a) != ta {
+		t.Errorf("entity tag changed with the modification time only")
+	}
+	writeFile(t, dir, "a", 'z', 10)
+	touch(t, a, 2)
+	if etag(a) == ta {
+		t.Errorf("entity tag unchanged after the contents changed")
+	}
+}
+
+func TestCacheContent(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	name := filepath.Join(dir, "f")
+	if err := ioutil.WriteFile(name, []byte("0123456789"), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+
+	c := NewCache()
+	content, _, _, err := c.Open(name)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer content.Close()
+	if n := content.Size(); n != 10 {
+		t.Errorf("size %d, expected 10", n)
+	}
+	buf := make([]byte, 3)
+	if n, err := content.ReadAt(buf, 4); n != 3 || err != nil || string(buf) != "456" {
+		t.Errorf("ReadAt(4) returned %q, %d, %v", buf[:n], n, err)
+	}
+	if n, _ := content.ReadAt(buf, 8); n != 2 || string(buf[:n]) != "89" {
+		t.Errorf("ReadAt(8) returned %q", buf[:n])
+	}
+	if _, err := content.Seek(-2, os.SEEK_END); err != nil {
+		t.Fatalf("Seek: %s", err)
+	}
+	if rest, err := ioutil.ReadAll(content); err != nil || string(rest) != "89" {
+		t.Errorf("read after Seek returned %q, %v", rest, err)
+	}
+
+	// Contents opened separately have their own offsets
+	other, _, _, err := c.Open(name)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	defer other.Close()
+	if all, _ := ioutil.ReadAll(other); string(all) != "0123456789" {
+		t.Errorf("second Open read %q", all)
+	}
+}
+
+func TestCachePreloadDir(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	writeFile(t, dir, "a.css", 'a', 10)
+	writeFile(t, dir, "b.js", 'b', 20)
+	writeFile(t, dir, "sub/c.css", 'c', 40)
+
+	c := NewCache()
+	if err := c.PreloadDir(dir, []string{"*.css"}); err != nil {
+		t.Fatalf("PreloadDir: %s", err)
+	}
+	if n := c.Size(); n != 50 {
+		t.Errorf("size %d after preloading *.css, expected 50", n)
+	}
+	if err := c.PreloadDir(dir, nil); err != nil {
+		t.Fatalf("PreloadDir: %s", err)
+	}
+	if n := c.Size(); n != 70 {
+		t.Errorf("size %d after preloading all files, expected 70", n)
+	}
+	if err := c.PreloadDir(dir, []string{"["}); err == nil {
+		t.Errorf("PreloadDir with a malformed pattern returned no error")
+	}
+	if err := c.PreloadDir(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("PreloadDir of a missing directory returned no error")
+	}
+}
+
+func TestCacheStream(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	large := writeFile(t, dir, "large", 'l', 100)
+	small := writeFile(t, dir, "small", 's', 10)
+
+	c := NewCache()
+	c.SetCompress(true)
+	c.SetStreamThreshold(50)
+	content, _, etag, gzipped, err := c.OpenGzip(large)
+	if err != nil {
+		t.Fatalf("OpenGzip: %s", err)
+	}
+	sf, ok := content.(interface{ SourceFile() *os.File })
+	if !ok || sf.SourceFile() == nil {
+		t.Errorf("contents over the threshold are not read from the file")
+	}
+	if gzipped || content.Size() != 100 {
+		t.Errorf("streamed contents gzipped %v, size %d", gzipped, content.Size())
+	}
+	if data, err := ioutil.ReadAll(content); err != nil || string(data) != strings.Repeat("l", 100) {
+		t.Errorf("bad streamed contents (%v)", err)
+	}
+	content.Close()
+	if n := c.Size(); n != 0 {
+		t.Errorf("size %d with only a streamed file, expected 0", n)
+	}
+	data, _, etag2, err := c.Get(large)
+	if err != nil || len(data) != 100 || etag2 != etag {
+		t.Errorf("Get of a streamed file returned %d bytes, tag %s, %v", len(data), etag2, err)
+	}
+
+	content, _, _, err = c.Open(small)
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if _, ok := content.(interface{ SourceFile() *os.File }); ok {
+		t.Errorf("contents under the threshold are read from the file")
+	}
+	content.Close()
+	if n := c.Size(); n != 10 {
+		t.Errorf("size %d, expected 10", n)
+	}
+
+	// Raising the threshold stores the large file again
+	c.SetStreamThreshold(0)
+	if _, _, _, err = c.Get(large); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	if n := c.Size(); n < 110 {
+		t.Errorf("size %d without a threshold, expected at least 110", n)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	a := writeFile(t, dir, "a", 'a', 100)
+	b := writeFile(t, dir, "b", 'b', 100)
+
+	c := NewCacheLimit(150, 0)
+	c.Get(a)
+	if s := c.Stats(); s.Misses != 1 || s.Hits != 0 || s.Files != 1 || s.Bytes != 100 {
+		t.Errorf("stats after the first Get: %+v", s)
+	}
+	// Without a revalidation interval, every hit checks the file
+	c.Get(a)
+	if s := c.Stats(); s.Misses != 1 || s.Hits != 1 || s.Revalidations != 1 {
+		t.Errorf("stats after a hit: %+v", s)
+	}
+	c.SetRevalidateInterval(time.Hour)
+	c.Get(a)
+	c.Get(a)
+	if s := c.Stats(); s.Hits != 3 || s.Revalidations != 1 {
+		t.Errorf("stats after hits within the interval: %+v", s)
+	}
+	c.Get(b)
+	s := c.Stats()
+	if s.Misses != 2 || s.Evictions != 1 || s.Files != 1 || s.Bytes != 100 {
+		t.Errorf("stats after an eviction: %+v", s)
+	}
+	want := "3 hit, 2 miss (60.0% hit), 1 revalidate, 1 evict; 1 files, 100 bytes"
+	if str := s.String(); str != want {
+		t.Errorf("String() = %q, want %q", str, want)
+	}
+	if str := (Stats{}).String(); !strings.HasPrefix(str, "0 hit, 0 miss (0.0% hit)") {
+		t.Errorf("String() of empty stats = %q", str)
+	}
+}
+
+func TestCacheSecondChance(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	a := writeFile(t, dir, "a", 'a', 100)
+	b := writeFile(t, dir, "b", 'b', 100)
+	d := writeFile(t, dir, "d", 'd', 100)
+
+	c := NewCacheLimit(250, 0)
+	c.SetRevalidateInterval(time.Hour)
+	c.Get(a)
+	c.Get(b)
+	// A hit marks a as used, so that b is evicted in its place
+	c.Get(a)
+	c.Get(d)
+	if s := c.Stats(); s.Files != 2 || s.Evictions != 1 {
+		t.Fatalf("stats after an eviction: %+v", s)
+	}
+	hits := c.Stats().Hits
+	c.Get(a)
+	if c.Stats().Hits != hits+1 {
+		t.Errorf("the used file was evicted")
+	}
+}
+
+func TestCacheConcurrent(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	var names []string
+	for _, n := range "abcdefgh" {
+		names = append(names, writeFile(t, dir, string(n), byte(n), 100))
+	}
+
+	c := NewCacheLimit(500, 0)
+	c.SetCompress(true)
+	done := make(chan bool)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			for j := 0; j < 200; j++ {
+				name := names[(i+j)%len(names)]
+				switch j % 10 {
+				case 0:
+					c.Invalidate(name)
+				case 1:
+					c.RevalidateAll()
+				default:
+					data, _, _, err := c.Get(name)
+					if err != nil || len(data) != 100 || data[0] != name[len(name)-1] {
+						t.Errorf("Get(%s) returned %d bytes, %v", name, len(data), err)
+					}
+				}
+			}
+			done <- true
+		}(i)
+	}
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+	if n := c.Size(); n > 500 {
+		t.Errorf("size %d over the budget", n)
+	}
+}
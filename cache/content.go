@@ -0,0 +1,74 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// Content is a read-only view of the contents of a cached file. It allows
+// reading parts of the contents, e.g. to serve Range requests, without
+// copying them. Each Content has its own read offset, and must be closed
+// after use, since the contents of large files are read from disk.
+type Content interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Size() int64 // Size of the contents in bytes
+}
+
+// bytesContent is a Content held in memory.
+type bytesContent struct {
+	*bytes.Reader
+	data []byte
+}
+
+func newBytesContent(b []byte) Content {
+	return &bytesContent{bytes.NewReader(b), b}
+}
+
+func (bc *bytesContent) Size() int64  { return int64(len(bc.data)) }
+func (bc *bytesContent) Close() error { return nil }
+
+// fileContent is a Content read from disk.
+type fileContent struct {
+	*os.File
+	size int64
+}
+
+func (fc *fileContent) Size() int64 { return fc.size }
+
+// SourceFile returns the file the contents are read from, so that a
+// server can send them with sendfile.
+func (fc *fileContent) SourceFile() *os.File { return fc.File }
+
+// contentBytes returns all of content and closes it. Contents held in
+// memory are returned without copying.
+func contentBytes(content Content) ([]byte, error) {
+	defer content.Close()
+	if bc, ok := content.(*bytesContent); ok {
+		return bc.data, nil
+	}
+	return ioutil.ReadAll(content)
+}
+
+// Open returns the contents of filename as a Content, along with their
+// MIME type and entity tag, as Get does. The caller must close content.
+// Unlike Get, Open does not read files over the stream threshold into
+// memory.
+func (c *Cache) Open(filename string) (content Content, mimetype, etag string, err error) {
+	content, mimetype, etag, _, err = c.open(filename, false)
+	return content, mimetype, etag, err
+}
+
+// OpenGzip is like Open, but returns the gzip-compressed contents of the
+// file if there is a compressed copy, as GetGzip does.
+func (c *Cache) OpenGzip(filename string) (content Content, mimetype, etag string, gzipped bool, err error) {
+	return c.open(filename, true)
+}
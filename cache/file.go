@@ -5,52 +5,342 @@
 package cache
 
 import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"crypto/sha1"
+	"encoding/hex"
 	"io/ioutil"
 	"os"
 	"sync"
+	"time"
+	"github.com/petar/GoHTTP/util"
 )
 
 // CachedFile is responsible for returning the contents of a single file.
-// It remembers the contents in memory, and updates it as necessary.
+// It remembers the contents in a Store, and updates it as necessary.
+// To spare a stat of the file on every Get, the contents can be trusted
+// for a revalidation interval after the file was last checked.
+// Files larger than the stream threshold are not stored; only their
+// metadata is remembered, and their contents are read from the file
+// when opened.
 type CachedFile struct {
-	sync.Mutex
-	fname string
-	data  []byte
-	mtime int64
+	size int64  // Size of the contents accounted for in the Cache; first, for 64-bit alignment
+	used uint32 // Set on use and cleared by the eviction of the Cache holding the file
+	sync.RWMutex
+	fname    string
+	root     string      // Real path of the directory the file must lie within, if any
+	xform    Transformer // Transformer of the contents, if any
+	mimetype string      // MIME type of the transformed contents, if set
+	store    Store
+	loaded   bool // Whether the file has been read
+	compress bool
+	gzipped  bool   // Whether compressed contents are stored, under the key gzipKey(fname)
+	gzsize   int64  // Size of the compressed contents
+	streamed bool   // Whether the contents are read from the file when opened
+	stream   int64  // Size above which contents are streamed, or zero for no limit
+	hash     string // Hex SHA-1 digest of the contents
+	fsize    int64  // Size of the contents
+	mtime    int64
+	checked  int64     // Time the file was last checked for changes
+	stale    bool      // Whether the file must be checked on the next Get
+	interval int64     // Revalidation interval in nanoseconds
+	counts   *counters // Counts of the Cache holding the file, or nil
+
+	// Fields protected by the lock of the Cache holding the file
+	elem    *list.Element // Position in the LRU list, or nil if evicted
+	watched bool          // Whether the Cache is watching the file for changes
 }
 
+// NewCachedFile returns a CachedFile for filename that holds its contents
+// in memory.
 func NewCachedFile(filename string) *CachedFile {
-	return &CachedFile{fname: filename}
+	return &CachedFile{fname: filename, store: NewMemStore()}
+}
+
+// gzipKey returns the key of the compressed contents of filename in a Store.
+func gzipKey(filename string) string { return filename + "\x00gzip" }
+
+// SetRevalidateInterval sets how long the contents are trusted after the
+// file was last checked for changes. Zero means the file is checked on
+// every Get.
+func (c *CachedFile) SetRevalidateInterval(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.interval = int64(d)
+}
+
+// Revalidate makes the next Get check the file for changes, regardless
+// of the revalidation interval.
+func (c *CachedFile) Revalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.stale = true
+}
+
+// invalidate makes the next Get read the file again.
+func (c *CachedFile) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+	c.loaded = false
 }
 
 func (c *CachedFile) Get() (data []byte, err error) {
+	content, _, _, _, err := c.open(false)
+	if err != nil {
+		return nil, err
+	}
+	return contentBytes(content)
+}
+
+// SetCompress sets whether a gzip-compressed copy of the contents is kept,
+// to be returned by GetGzip. The copy is made whenever the file is read.
+func (c *CachedFile) SetCompress(compress bool) {
+	c.Lock()
+	defer c.Unlock()
+	if c.compress != compress {
+		c.compress = compress
+		c.loaded = false // Read again on the next Get
+	}
+}
+
+// SetStreamThreshold sets the size above which the contents of the file
+// are not stored, but read from the file whenever it is opened. Zero means
+// the contents are always stored.
+func (c *CachedFile) SetStreamThreshold(n int64) {
+	c.Lock()
+	defer c.Unlock()
+	if c.stream != n {
+		c.stream = n
+		c.loaded = false // Read again on the next Get
+	}
+}
+
+// GetGzip returns the gzip-compressed contents of the file if compression
+// is on and makes the contents smaller, and the contents as they are
+// otherwise. The result gzipped tells which.
+func (c *CachedFile) GetGzip() (data []byte, gzipped bool, err error) {
+	content, _, gzipped, _, err := c.open(true)
+	if err != nil {
+		return nil, false, err
+	}
+	data, err = contentBytes(content)
+	return data, gzipped, err
+}
+
+// open returns the contents of the file, compressed if gz is true and a
+// compressed copy is kept, along with their entity tag and the size of
+// all copies in the store.
+func (c *CachedFile) open(gz bool) (content Content, etag string, gzipped bool, size int64, err error) {
+	if content, etag, gzipped, size, ok := c.openFresh(gz); ok {
+		return content, etag, gzipped, size, nil
+	}
 	c.Lock()
 	defer c.Unlock()
 
-	if c.data == nil {
+	if err = c.validate(); err != nil {
+		return nil, "", false, 0, err
+	}
+	if !c.streamed {
+		content, gzipped = c.get(gz)
+		if content == nil {
+			// Dropped by the store, or stored by another CachedFile
+			// for the same file
+			c.counts.miss()
+			if err = c.readFile(); err != nil {
+				return nil, "", false, 0, err
+			}
+			content, gzipped = c.get(gz)
+		}
+	}
+	if content == nil {
+		if content, err = c.openFile(); err != nil {
+			return nil, "", false, 0, err
+		}
+	}
+	return content, c.etag(gzipped), gzipped, c.storedSize(), nil
+}
+
+// openFresh is like open, but only takes the lock of c for reading.
+// It fails if the file may have changed or its contents are not stored.
+func (c *CachedFile) openFresh(gz bool) (content Content, etag string, gzipped bool, size int64, ok bool) {
+	c.RLock()
+	defer c.RUnlock()
+
+	if !c.loaded || c.stale {
+		return nil, "", false, 0, false
+	}
+	if time.Now().UnixNano()-c.checked >= c.interval {
+		// Without an interval, the file can be checked without
+		// recording the time of the check
+		if c.interval > 0 {
+			return nil, "", false, 0, false
+		}
+		fi, err := os.Stat(c.fname)
+		if err != nil || fi.ModTime().UnixNano() > c.mtime {
+			return nil, "", false, 0, false
+		}
+		c.counts.revalidate()
+	}
+	if c.streamed {
+		var err error
+		if content, err = c.openFile(); err != nil {
+			return nil, "", false, 0, false
+		}
+	} else if content, gzipped = c.get(gz); content == nil {
+		return nil, "", false, 0, false
+	}
+	c.counts.hit()
+	return content, c.etag(gzipped), gzipped, c.storedSize(), true
+}
+
+// etag returns the entity tag of the contents, or of the compressed
+// contents if gzipped is true.
+func (c *CachedFile) etag(gzipped bool) string {
+	if gzipped {
+		return `"` + c.hash + `-gzip"`
+	}
+	return `"` + c.hash + `"`
+}
+
+// storedSize returns the size of the contents held in the store.
+func (c *CachedFile) storedSize() int64 {
+	if c.streamed {
+		return 0
+	}
+	return c.fsize + c.gzsize
+}
+
+// get returns the stored contents, compressed if gz is true and compressed
+// contents are stored, or nil if the store does not hold the contents
+// last read.
+func (c *CachedFile) get(gz bool) (content Content, gzipped bool) {
+	key := c.fname
+	if gz && c.gzipped {
+		key, gzipped = gzipKey(c.fname), true
+	}
+	content, meta, ok := c.store.Get(key)
+	if !ok {
+		return nil, false
+	}
+	if meta.Hash != c.hash || meta.ModTime != c.mtime {
+		content.Close()
+		return nil, false
+	}
+	return content, gzipped
+}
+
+// release drops the contents of the file from the store.
+func (c *CachedFile) release() {
+	c.store.Invalidate(c.fname)
+	c.store.Invalidate(gzipKey(c.fname))
+}
+
+// validate reads the file if it has not been read or has changed since.
+func (c *CachedFile) validate() error {
+	if !c.loaded {
+		c.counts.miss()
 		return c.readFile()
 	}
+	now := time.Now().UnixNano()
+	if !c.stale && now-c.checked < c.interval {
+		c.counts.hit()
+		return nil
+	}
+	c.counts.revalidate()
 	fi, err := os.Stat(c.fname)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	c.checked = now
+	c.stale = false
 	if fi.ModTime().UnixNano() > c.mtime {
+		c.counts.miss()
 		return c.readFile()
 	}
-	return c.data, nil
+	c.counts.hit()
+	return nil
 }
 
-func (c *CachedFile) readFile() (data []byte, err error) {
-	fi, err := os.Stat(c.fname)
+func (c *CachedFile) readFile() error {
+	name, err := c.resolve()
 	if err != nil {
-		return nil, err
+		return err
 	}
-	data, err = ioutil.ReadFile(c.fname)
+	fi, err := os.Stat(name)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	c.data = data
+	c.loaded = false
+	c.gzipped, c.gzsize = false, 0
+	h := sha1.New()
+	var data []byte
+	if c.stream > 0 && fi.Size() > c.stream && c.xform == nil {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if _, err = util.Copy(h, f); err != nil {
+			return err
+		}
+		c.streamed = true
+		c.fsize = fi.Size()
+	} else {
+		data, err = ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if c.xform != nil {
+			if data, err = c.xform(data); err != nil {
+				return err
+			}
+		}
+		h.Write(data)
+		c.streamed = false
+		c.fsize = int64(len(data))
+	}
+	c.hash = hex.EncodeToString(h.Sum(nil))
 	c.mtime = fi.ModTime().UnixNano()
+	c.checked = time.Now().UnixNano()
+	c.stale = false
+	c.loaded = true
+	if c.streamed {
+		c.release()
+		return nil
+	}
 
-	return data, nil
+	meta := Meta{ModTime: c.mtime, Hash: c.hash}
+	if err = c.store.Set(c.fname, data, meta); err != nil {
+		if c.xform != nil {
+			c.loaded = false
+			return err
+		}
+		// Serve the contents from the file, if they cannot be stored
+		c.release()
+		c.streamed = true
+		return nil
+	}
+	c.store.Invalidate(gzipKey(c.fname))
+	if c.compress {
+		if gz := compress(data); gz != nil && c.store.Set(gzipKey(c.fname), gz, meta) == nil {
+			c.gzipped, c.gzsize = true, int64(len(gz))
+		}
+	}
+	return nil
+}
+
+// compress returns data compressed with gzip, or nil if that does not
+// make it smaller.
+func compress(data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil
+	}
+	w.Write(data)
+	if w.Close() != nil || buf.Len() >= len(data) {
+		return nil
+	}
+	return buf.Bytes()
 }
@@ -0,0 +1,142 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+)
+
+// RenderCache holds the output of templates, so that pages rendered with
+// the same data need not be executed again. Outputs are keyed by the name
+// of the template and a key chosen by the caller, which must identify the
+// data the template is executed with. An output expires after the TTL of
+// the cache, and whenever the template is parsed again. It is safe for
+// concurrent use.
+type RenderCache struct {
+	sync.Mutex
+	ttl     int64 // Time to live of outputs in nanoseconds, or zero for no limit
+	outputs map[renderKey]*output
+	purged  int // Number of outputs after the last purge of expired outputs
+}
+
+type renderKey struct {
+	name, key string
+}
+
+// output is the output of a template.
+type output struct {
+	data    []byte
+	tmpl    Template // The template that rendered data
+	expires int64    // Time the output expires, or zero if it does not
+}
+
+// NewRenderCache returns a RenderCache whose outputs expire after ttl.
+// Zero means outputs only expire when their templates change.
+func NewRenderCache(ttl time.Duration) *RenderCache {
+	return &RenderCache{ttl: int64(ttl), outputs: make(map[renderKey]*output)}
+}
+
+// Get returns the output of tmpl, the template name, for key. The result
+// ok is false if there is none, or it was rendered by another template.
+func (rc *RenderCache) Get(name, key string, tmpl Template) (data []byte, ok bool) {
+	rc.Lock()
+	defer rc.Unlock()
+	k := renderKey{name, key}
+	o, ok := rc.outputs[k]
+	if !ok {
+		return nil, false
+	}
+	if o.tmpl != tmpl || o.expired(time.Now().UnixNano()) {
+		delete(rc.outputs, k)
+		return nil, false
+	}
+	return o.data, true
+}
+
+// Set remembers data as the output of tmpl, the template name, for key.
+// The caller must not modify data afterwards.
+func (rc *RenderCache) Set(name, key string, tmpl Template, data []byte) {
+	o := &output{data: data, tmpl: tmpl}
+	now := time.Now().UnixNano()
+	if rc.ttl > 0 {
+		o.expires = now + rc.ttl
+	}
+	rc.Lock()
+	defer rc.Unlock()
+	rc.outputs[renderKey{name, key}] = o
+	if len(rc.outputs) >= 2*rc.purged+64 {
+		rc.purge(now)
+	}
+}
+
+// Execute writes the output of tmpl, the template name, for key to w,
+// executing tmpl with the data returned by data if the output is not
+// cached, e.g.
+//
+//	err := rc.Execute(w, "post.html", id, tmpl, func() (interface{}, error) {
+//		return loadPost(id)
+//	})
+//
+// Nothing is written if data or the template fails.
+func (rc *RenderCache) Execute(w io.Writer, name, key string, tmpl Template, data func() (interface{}, error)) error {
+	if out, ok := rc.Get(name, key, tmpl); ok {
+		_, err := w.Write(out)
+		return err
+	}
+	d, err := data()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err = tmpl.Execute(&buf, d); err != nil {
+		return err
+	}
+	rc.Set(name, key, tmpl, buf.Bytes())
+	_, err = w.Write(buf.Bytes())
+	return err
+}
+
+// Invalidate drops the output of the template name for key.
+func (rc *RenderCache) Invalidate(name, key string) {
+	rc.Lock()
+	defer rc.Unlock()
+	delete(rc.outputs, renderKey{name, key})
+}
+
+// InvalidateTemplate drops the outputs of the template name for all keys.
+func (rc *RenderCache) InvalidateTemplate(name string) {
+	rc.Lock()
+	defer rc.Unlock()
+	for k := range rc.outputs {
+		if k.name == name {
+			delete(rc.outputs, k)
+		}
+	}
+}
+
+// Clear drops all outputs.
+func (rc *RenderCache) Clear() {
+	rc.Lock()
+	defer rc.Unlock()
+	rc.outputs = make(map[renderKey]*output)
+	rc.purged = 0
+}
+
+// purge drops the outputs that expired by now.
+func (rc *RenderCache) purge(now int64) {
+	for k, o := range rc.outputs {
+		if o.expired(now) {
+			delete(rc.outputs, k)
+		}
+	}
+	rc.purged = len(rc.outputs)
+}
+
+func (o *output) expired(now int64) bool {
+	return o.expires != 0 && now >= o.expires
+}
@@ -0,0 +1,115 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRenderCache(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", "v1 {{.}}", 0)
+	ct := NewCachedTemplate(filepath.Join(dir, "page.tmpl"), nil)
+
+	rc := NewRenderCache(0)
+	calls := 0
+	render := func(key string) string {
+		tmpl, err := ct.Get()
+		if err != nil {
+			t.Fatalf("Get: %s", err)
+		}
+		var buf bytes.Buffer
+		err = rc.Execute(&buf, "page", key, tmpl, func() (interface{}, error) {
+			calls++
+			return fmt.Sprintf("%s.%d", key, calls), nil
+		})
+		if err != nil {
+			t.Fatalf("Execute: %s", err)
+		}
+		return buf.String()
+	}
+	if out := render("a"); out != "v1 a.1" {
+		t.Errorf("first output %q", out)
+	}
+	if out := render("a"); out != "v1 a.1" || calls != 1 {
+		t.Errorf("cached output %q, %d calls", out, calls)
+	}
+	if out := render("b"); out != "v1 b.2" {
+		t.Errorf("output for another key %q", out)
+	}
+
+	// Outputs of the previous template are not used
+	replaceFile(t, dir, "page.tmpl", "v2 {{.}}", 1)
+	if out := render("a"); out != "v2 a.3" {
+		t.Errorf("output %q after the template changed", out)
+	}
+
+	rc.Invalidate("page", "a")
+	if out := render("a"); out != "v2 a.4" {
+		t.Errorf("output %q after Invalidate", out)
+	}
+	render("b")
+	rc.InvalidateTemplate("page")
+	if out := render("b"); out != "v2 b.6" {
+		t.Errorf("output %q after InvalidateTemplate", out)
+	}
+	rc.Clear()
+	if out := render("b"); out != "v2 b.7" {
+		t.Errorf("output %q after Clear", out)
+	}
+
+	// Failures are returned, and nothing is cached or written
+	tmpl, _ := ct.Get()
+	var buf bytes.Buffer
+	fail := errors.New("no data")
+	err := rc.Execute(&buf, "page", "c", tmpl, func() (interface{}, error) { return nil, fail })
+	if err != fail || buf.Len() != 0 {
+		t.Errorf("Execute with failing data returned %v, wrote %q", err, buf.String())
+	}
+	if _, ok := rc.Get("page", "c", tmpl); ok {
+		t.Errorf("output of a failure cached")
+	}
+}
+
+func TestRenderCacheTTL(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", "{{.}}", 0)
+	tmpl, err := NewCachedTemplate(filepath.Join(dir, "page.tmpl"), nil).Get()
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+
+	rc := NewRenderCache(50 * time.Millisecond)
+	rc.Set("page", "a", tmpl, []byte("out"))
+	if data, ok := rc.Get("page", "a", tmpl); !ok || string(data) != "out" {
+		t.Errorf("Get returned %q, %v", data, ok)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := rc.Get("page", "a", tmpl); ok {
+		t.Errorf("output did not expire")
+	}
+
+	// Expired outputs are purged as outputs are added
+	for i := 0; i < 32; i++ {
+		rc.Set("page", fmt.Sprint("old", i), tmpl, nil)
+	}
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 32; i++ {
+		rc.Set("page", fmt.Sprint("new", i), tmpl, nil)
+	}
+	rc.Lock()
+	n := len(rc.outputs)
+	rc.Unlock()
+	if n != 32 {
+		t.Errorf("%d outputs, expected the 32 unexpired", n)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrOutsideRoot is returned for files that resolve to a path outside the
+// root of a Cache.
+var ErrOutsideRoot = errors.New("cache: file outside root")
+
+// SetRoot binds the cache to the directory root. Files are then only
+// read if their real path, with all symbolic links resolved, lies within
+// the real path of root; Gets of other files fail with ErrOutsideRoot.
+// This keeps links inside a served tree from exposing files outside it.
+// The cache is cleared.
+func (c *Cache) SetRoot(root string) error {
+	real, err := realPath(root)
+	if err != nil {
+		return err
+	}
+	c.Lock()
+	defer c.Unlock()
+	c.clear()
+	c.root = real
+	return nil
+}
+
+// realPath returns the absolute path of name, with symbolic links resolved.
+func realPath(name string) (string, error) {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// within returns true if the clean path name is root or lies under it.
+func within(root, name string) bool {
+	if name == root {
+		return true
+	}
+	if !strings.HasSuffix(root, string(filepath.Separator)) {
+		root += string(filepath.Separator)
+	}
+	return strings.HasPrefix(name, root)
+}
+
+// resolve returns the name under which the file is read: its real path
+// if the file is bound to a root, which it must lie within, and its
+// name otherwise.
+func (c *CachedFile) resolve() (string, error) {
+	if c.root == "" {
+		return c.fname, nil
+	}
+	real, err := realPath(c.fname)
+	if err != nil {
+		return "", err
+	}
+	if !within(c.root, real) {
+		return "", ErrOutsideRoot
+	}
+	return real, nil
+}
+
+// openFile opens the file for streaming its contents.
+func (c *CachedFile) openFile() (Content, error) {
+	name, err := c.resolve()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileContent{f, c.fsize}, nil
+}
@@ -0,0 +1,72 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCacheRoot(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	root := filepath.Join(dir, "root")
+	if err := os.Mkdir(root, 0755); err != nil {
+		t.Fatalf("Mkdir: %s", err)
+	}
+	inside := writeFile(t, root, "inside", 'i', 10)
+	outside := writeFile(t, dir, "outside", 'o', 10)
+	if err := os.Symlink(inside, filepath.Join(root, "in-link")); err != nil {
+		t.Fatalf("Symlink: %s", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "out-link")); err != nil {
+		t.Fatalf("Symlink: %s", err)
+	}
+	if err := os.Symlink(dir, filepath.Join(root, "dir-link")); err != nil {
+		t.Fatalf("Symlink: %s", err)
+	}
+
+	c := NewCache()
+	if err := c.SetRoot(root); err != nil {
+		t.Fatalf("SetRoot: %s", err)
+	}
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{"inside", nil},
+		{"in-link", nil},
+		{"../outside", ErrOutsideRoot},
+		{"out-link", ErrOutsideRoot},
+		{"dir-link/outside", ErrOutsideRoot},
+		{"dir-link/root/inside", nil},
+	}
+	for _, tt := range tests {
+		name := root + string(filepath.Separator) + filepath.FromSlash(tt.name)
+		data, _, _, err := c.Get(name)
+		if err != tt.err {
+			t.Errorf("Get(%s): error %v, expected %v", tt.name, err, tt.err)
+			continue
+		}
+		if err == nil && string(data) != "iiiiiiiiii" {
+			t.Errorf("Get(%s) returned %q", tt.name, data)
+		}
+	}
+	if s := c.Stats(); s.Files != 3 {
+		t.Errorf("%d files cached, expected 3", s.Files)
+	}
+
+	// A link that is changed to point outside the root is not followed
+	link := filepath.Join(root, "in-link")
+	os.Remove(link)
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatalf("Symlink: %s", err)
+	}
+	c.Invalidate(link)
+	if _, _, _, err := c.Get(link); err != ErrOutsideRoot {
+		t.Errorf("Get of relinked file: error %v, expected %v", err, ErrOutsideRoot)
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// Stats holds the statistics of a Cache.
+type Stats struct {
+	Hits          uint64 // Gets served without reading the file
+	Misses        uint64 // Gets that read the file
+	Revalidations uint64 // Checks of files for changes
+	Evictions     uint64 // Files evicted to stay within the byte budget
+	Files         int    // Files currently cached
+	Bytes         int64  // Bytes currently stored
+}
+
+func (s Stats) String() string {
+	ratio := 0.0
+	if s.Hits+s.Misses > 0 {
+		ratio = float64(s.Hits) / float64(s.Hits+s.Misses)
+	}
+	return fmt.Sprintf("%d hit, %d miss (%.1f%% hit), %d revalidate, %d evict; %d files, %d bytes",
+		s.Hits, s.Misses, 100*ratio, s.Revalidations, s.Evictions, s.Files, s.Bytes)
+}
+
+// counters are the counts of a Cache, shared with its files. Their
+// methods do nothing on a nil receiver, for files outside a Cache.
+type counters struct {
+	hits          uint64
+	misses        uint64
+	revalidations uint64
+	evictions     uint64
+}
+
+func (n *counters) hit() {
+	if n != nil {
+		atomic.AddUint64(&n.hits, 1)
+	}
+}
+
+func (n *counters) miss() {
+	if n != nil {
+		atomic.AddUint64(&n.misses, 1)
+	}
+}
+
+func (n *counters) revalidate() {
+	if n != nil {
+		atomic.AddUint64(&n.revalidations, 1)
+	}
+}
+
+func (n *counters) evict() {
+	if n != nil {
+		atomic.AddUint64(&n.evictions, 1)
+	}
+}
+
+// Stats returns the statistics of the cache.
+func (c *Cache) Stats() Stats {
+	c.RLock()
+	defer c.RUnlock()
+	return Stats{
+		Hits:          atomic.LoadUint64(&c.counts.hits),
+		Misses:        atomic.LoadUint64(&c.counts.misses),
+		Revalidations: atomic.LoadUint64(&c.counts.revalidations),
+		Evictions:     atomic.LoadUint64(&c.counts.evictions),
+		Files:         len(c.files),
+		Bytes:         c.size,
+	}
+}
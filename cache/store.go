@@ -0,0 +1,155 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Meta is the metadata stored along with cached contents.
+type Meta struct {
+	ModTime int64  // Modification time of the file, in nanoseconds
+	Hash    string // Hex SHA-1 digest of the contents of the file
+}
+
+// A Store holds the contents of cached files, on behalf of a Cache.
+// The Cache decides what is stored and for how long; a Store merely
+// keeps what it is given until it is invalidated. A Store may drop
+// contents on its own, e.g. on a restart, in which case they are read
+// from their file again.
+type Store interface {
+	// Get returns the contents stored under key and their metadata.
+	// The result ok is false if there are none.
+	Get(key string) (content Content, meta Meta, ok bool)
+
+	// Set stores data and its metadata under key, replacing any
+	// previous contents.
+	Set(key string, data []byte, meta Meta) error
+
+	// Invalidate drops the contents stored under key, if any.
+	Invalidate(key string)
+}
+
+// MemStore is a Store that holds contents in memory. It is the default
+// Store of a Cache.
+type MemStore struct {
+	sync.RWMutex
+	entries map[string]memEntry
+}
+
+type memEntry struct {
+	data []byte
+	meta Meta
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{entries: make(map[string]memEntry)}
+}
+
+func (s *MemStore) Get(key string) (Content, Meta, bool) {
+	s.RLock()
+	defer s.RUnlock()
+	e, ok := s.entries[key]
+	if !ok {
+		return nil, Meta{}, false
+	}
+	return newBytesContent(e.data), e.meta, true
+}
+
+func (s *MemStore) Set(key string, data []byte, meta Meta) error {
+	s.Lock()
+	defer s.Unlock()
+	s.entries[key] = memEntry{data, meta}
+	return nil
+}
+
+func (s *MemStore) Invalidate(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.entries, key)
+}
+
+// DiskStore is a Store that spools contents to files in a directory,
+// for caches too large to be held in memory. The metadata is held in
+// memory, so contents spooled by an earlier DiskStore are not used.
+type DiskStore struct {
+	sync.Mutex
+	dir   string
+	metas map[string]Meta
+}
+
+// NewDiskStore returns a DiskStore that spools contents to dir, creating
+// dir if needed. The directory should not be used for anything else.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir, metas: make(map[string]Meta)}, nil
+}
+
+// spool returns the name of the file holding the contents stored under key.
+func (s *DiskStore) spool(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(h.Sum(nil)))
+}
+
+func (s *DiskStore) Get(key string) (Content, Meta, bool) {
+	s.Lock()
+	defer s.Unlock()
+	meta, ok := s.metas[key]
+	if !ok {
+		return nil, Meta{}, false
+	}
+	f, err := os.Open(s.spool(key))
+	if err != nil {
+		delete(s.metas, key)
+		return nil, Meta{}, false
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, Meta{}, false
+	}
+	return &fileContent{f, fi.Size()}, meta, true
+}
+
+func (s *DiskStore) Set(key string, data []byte, meta Meta) error {
+	// Write to a temporary file first, so that readers of the previous
+	// contents are not disturbed
+	f, err := ioutil.TempFile(s.dir, "tmp")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	s.Lock()
+	defer s.Unlock()
+	if err = os.Rename(f.Name(), s.spool(key)); err != nil {
+		os.Remove(f.Name())
+		delete(s.metas, key)
+		return err
+	}
+	s.metas[key] = meta
+	return nil
+}
+
+func (s *DiskStore) Invalidate(key string) {
+	s.Lock()
+	defer s.Unlock()
+	delete(s.metas, key)
+	os.Remove(s.spool(key))
+}
@@ -0,0 +1,111 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testStore checks the Get, Set and Invalidate methods of s.
+func testStore(t *testing.T, s Store) {
+	if _, _, ok := s.Get("k"); ok {
+		t.Errorf("Get of an empty store succeeded")
+	}
+	meta := Meta{ModTime: 1, Hash: "h1"}
+	if err := s.Set("k", []byte("first"), meta); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	content, m, ok := s.Get("k")
+	if !ok || m != meta {
+		t.Fatalf("Get returned %v, %+v", ok, m)
+	}
+	// Replacing the contents does not disturb readers of the old ones
+	if err := s.Set("k", []byte("second"), Meta{ModTime: 2, Hash: "h2"}); err != nil {
+		t.Fatalf("Set: %s", err)
+	}
+	if data, err := ioutil.ReadAll(content); err != nil || string(data) != "first" {
+		t.Errorf("old contents read %q, %v", data, err)
+	}
+	content.Close()
+	content, m, ok = s.Get("k")
+	if !ok || m.Hash != "h2" || content.Size() != 6 {
+		t.Fatalf("Get after Set returned %v, %+v", ok, m)
+	}
+	if data, err := ioutil.ReadAll(content); err != nil || string(data) != "second" {
+		t.Errorf("new contents read %q, %v", data, err)
+	}
+	content.Close()
+	s.Invalidate("k")
+	if _, _, ok := s.Get("k"); ok {
+		t.Errorf("Get after Invalidate succeeded")
+	}
+	s.Invalidate("k")
+}
+
+func TestMemStore(t *testing.T) {
+	testStore(t, NewMemStore())
+}
+
+func TestDiskStore(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	spool := filepath.Join(dir, "spool")
+	s, err := NewDiskStore(spool)
+	if err != nil {
+		t.Fatalf("NewDiskStore: %s", err)
+	}
+	testStore(t, s)
+	if names, _ := ioutil.ReadDir(spool); len(names) != 0 {
+		t.Errorf("%d files left in the spool directory after Invalidate", len(names))
+	}
+
+	// Contents whose spool file is gone are dropped
+	s.Set("k", []byte("data"), Meta{})
+	os.Remove(s.spool("k"))
+	if _, _, ok := s.Get("k"); ok {
+		t.Errorf("Get of a removed spool file succeeded")
+	}
+
+	if _, err := NewDiskStore(filepath.Join(writeFile(t, dir, "f", 'f', 1), "spool")); err == nil {
+		t.Errorf("NewDiskStore under a file returned no error")
+	}
+}
+
+func TestCacheDiskStore(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	name := writeFile(t, dir, "f", 'x', 1000)
+	s, err := NewDiskStore(filepath.Join(dir, "spool"))
+	if err != nil {
+		t.Fatalf("NewDiskStore: %s", err)
+	}
+
+	c := NewCache()
+	c.SetStore(s)
+	c.SetCompress(true)
+	if data, _, _, err := c.Get(name); err != nil || string(data) != strings.Repeat("x", 1000) {
+		t.Fatalf("Get returned %d bytes, %v", len(data), err)
+	}
+	if _, _, _, gzipped, err := c.GetGzip(name); err != nil || !gzipped {
+		t.Errorf("GetGzip returned gzipped %v, %v", gzipped, err)
+	}
+	if names, _ := ioutil.ReadDir(filepath.Join(dir, "spool")); len(names) != 2 {
+		t.Errorf("%d spool files, expected the contents and their compressed copy", len(names))
+	}
+
+	// Contents the store dropped are read from the file again
+	os.Remove(s.spool(name))
+	if data, _, _, err := c.Get(name); err != nil || len(data) != 1000 {
+		t.Errorf("Get after the store dropped the contents returned %d bytes, %v", len(data), err)
+	}
+	c.Invalidate(name)
+	if names, _ := ioutil.ReadDir(filepath.Join(dir, "spool")); len(names) != 0 {
+		t.Errorf("%d spool files left after Invalidate", len(names))
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	htmltemplate "html/template"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sync"
+	"text/template"
+)
+
+// Template is a parsed template: a *text/template.Template or, for HTML
+// templates, a *html/template.Template, which escapes its output
+// according to the context in the HTML document.
+type Template interface {
+	Execute(w io.Writer, data interface{}) error
+	ExecuteTemplate(w io.Writer, name string, data interface{}) error
+}
+
+// source is the text of a template, along with its name.
+type source struct {
+	name, text string
+}
+
+// parse parses srcs into a set of associated templates, returning the last
+// one, as an HTML template if html is true.
+func parse(html bool, funcs template.FuncMap, srcs []source) (Template, error) {
+	root := srcs[len(srcs)-1]
+	if html {
+		t := htmltemplate.New(root.name).Funcs(htmltemplate.FuncMap(funcs))
+		for _, src := range srcs[:len(srcs)-1] {
+			if _, err := t.New(src.name).Parse(src.text); err != nil {
+				return nil, err
+			}
+		}
+		if _, err := t.Parse(root.text); err != nil {
+			return nil, err
+		}
+		return t, nil
+	}
+	t := template.New(root.name).Funcs(funcs)
+	for _, src := range srcs[:len(srcs)-1] {
+		if _, err := t.New(src.name).Parse(src.text); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := t.Parse(root.text); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CachedTemplate is a template parsed from a file, which is parsed again
+// whenever the file changes. It is safe for concurrent use: a reload
+// parses into a new template, and the templates returned by Get are never
+// modified afterwards, so they can be executed concurrently.
+type CachedTemplate struct {
+	sync.RWMutex
+	file  *CachedFile
+	html  bool // Whether the template is an HTML template
+	funcs template.FuncMap
+	tmpl  Template
+	etag  string   // Entity tag of the contents tmpl was parsed from
+	watch *watcher // Watcher of the directory of the file, if Watch was called
+}
+
+// NewCachedTemplate returns a CachedTemplate for filename, whose templates
+// are text templates parsed with the functions in funcs, which may be nil.
+func NewCachedTemplate(filename string, funcs template.FuncMap) *CachedTemplate {
+	return &CachedTemplate{file: NewCachedFile(filename), funcs: funcs}
+}
+
+// NewCachedHTMLTemplate is like NewCachedTemplate, but its templates are
+// HTML templates, whose output is escaped according to the context.
+func NewCachedHTMLTemplate(filename string, funcs template.FuncMap) *CachedTemplate {
+	return &CachedTemplate{file: NewCachedFile(filename), html: true, funcs: funcs}
+}
+
+// Get returns the template, parsing the file if it changed since the last Get.
+func (t *CachedTemplate) Get() (Template, error) {
+	t.RLock()
+	if t.watch != nil && t.etag != "" {
+		// Kept up to date by the watcher
+		defer t.RUnlock()
+		return t.tmpl, nil
+	}
+	t.RUnlock()
+	return t.load()
+}
+
+// load parses the file, unless it is unchanged since it was last parsed.
+func (t *CachedTemplate) load() (Template, error) {
+	content, etag, _, _, err := t.file.open(false)
+	if err != nil {
+		return nil, err
+	}
+	t.RLock()
+	tmpl := t.tmpl
+	fresh := t.etag == etag
+	t.RUnlock()
+	if fresh {
+		content.Close()
+		return tmpl, nil
+	}
+	data, err := contentBytes(content)
+	if err != nil {
+		return nil, err
+	}
+
+	t.Lock()
+	defer t.Unlock()
+	if t.etag == etag {
+		// Parsed by another Get meanwhile
+		return t.tmpl, nil
+	}
+	tmpl, err = parse(t.html, t.funcs, []source{{filepath.Base(t.file.fname), string(data)}})
+	if err != nil {
+		return nil, err
+	}
+	t.tmpl, t.etag = tmpl, etag
+	return tmpl, nil
+}
+
+// Funcs adds the functions in funcs to those the template is parsed with,
+// replacing functions of the same name. The functions are kept across
+// reloads of the file. The template is parsed again on the next Get.
+func (t *CachedTemplate) Funcs(funcs template.FuncMap) {
+	t.Lock()
+	defer t.Unlock()
+	t.funcs = mergeFuncs(t.funcs, funcs)
+	t.etag = ""
+}
+
+// mergeFuncs returns a new FuncMap holding the functions in a and b, with
+// those in b taking precedence.
+func mergeFuncs(a, b template.FuncMap) template.FuncMap {
+	m := make(template.FuncMap, len(a)+len(b))
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
+
+// Execute applies the template to data and writes the output to w.
+func (t *CachedTemplate) Execute(w io.Writer, data interface{}) error {
+	tmpl, err := t.Get()
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// Watch makes the CachedTemplate listen for changes to the file, as
+// reported by the file system, instead of checking the file on every Get.
+// The file is parsed again as soon as it changes, and the new template
+// replaces the previous one only if it parses; errors are logged.
+func (t *CachedTemplate) Watch() error {
+	w, err := newWatcher(t.changed, t.lost)
+	if err != nil {
+		return err
+	}
+	if err = w.add(filepath.Dir(t.file.fname)); err != nil {
+		w.close()
+		return err
+	}
+	t.Lock()
+	if t.watch != nil {
+		t.watch.close()
+	}
+	t.watch = w
+	t.Unlock()
+	t.file.SetRevalidateInterval(math.MaxInt64)
+	t.recompile()
+	return nil
+}
+
+// Close stops watching the file for changes, if Watch was called. The
+// file is checked on every Get from then on.
+func (t *CachedTemplate) Close() error {
+	t.Lock()
+	defer t.Unlock()
+	if t.watch == nil {
+		return nil
+	}
+	err := t.watch.close()
+	t.watch = nil
+	t.file.SetRevalidateInterval(0)
+	return err
+}
+
+// changed is called by the watcher when the file name has changed.
+func (t *CachedTemplate) changed(name string) {
+	if name == filepath.Clean(t.file.fname) {
+		t.recompile()
+	}
+}
+
+// lost is called by the watcher when changes may have been missed.
+func (t *CachedTemplate) lost(dir string) {
+	t.recompile()
+}
+
+// recompile reads the file again and parses it, keeping the previous
+// template if it fails to parse.
+func (t *CachedTemplate) recompile() {
+	t.file.invalidate()
+	if _, err := t.load(); err != nil {
+		log.Printf("Template %s: %s\n", t.file.fname, err)
+	}
+}
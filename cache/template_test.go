@@ -0,0 +1,153 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+)
+
+// replaceFile replaces the file name in dir with one holding text, in a
+// single rename, and sets its modification time sec seconds from now.
+func replaceFile(t *testing.T, dir, name, text string, sec int) {
+	tmp := filepath.Join(dir, "."+name)
+	if err := ioutil.WriteFile(tmp, []byte(text), 0644); err != nil {
+		t.Fatalf("WriteFile: %s", err)
+	}
+	touch(t, tmp, sec)
+	if err := os.Rename(tmp, filepath.Join(dir, name)); err != nil {
+		t.Fatalf("Rename: %s", err)
+	}
+}
+
+func TestCachedTemplateReload(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", "v0 {{.}}", 0)
+	ct := NewCachedTemplate(filepath.Join(dir, "page.tmpl"), nil)
+
+	const versions = 20
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				tmpl, err := ct.Get()
+				if err != nil {
+					t.Errorf("Get: %s", err)
+					return
+				}
+				var buf bytes.Buffer
+				if err := tmpl.Execute(&buf, "x"); err != nil {
+					t.Errorf("Execute: %s", err)
+					return
+				}
+				var v int
+				if _, err := fmt.Sscanf(buf.String(), "v%d x", &v); err != nil || v < 0 || v >= versions {
+					t.Errorf("template output %q", buf.String())
+					return
+				}
+			}
+		}()
+	}
+	for v := 1; v < versions; v++ {
+		replaceFile(t, dir, "page.tmpl", fmt.Sprintf("v%d {{.}}", v), v)
+	}
+	close(done)
+	wg.Wait()
+
+	var buf bytes.Buffer
+	if err := ct.Execute(&buf, "x"); err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	if want := fmt.Sprintf("v%d x", versions-1); buf.String() != want {
+		t.Errorf("output %q after the last reload, expected %q", buf.String(), want)
+	}
+}
+
+func TestCachedTemplateParseError(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", "ok", 0)
+	ct := NewCachedTemplate(filepath.Join(dir, "page.tmpl"), nil)
+	if _, err := ct.Get(); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	replaceFile(t, dir, "page.tmpl", "{{if}}", 1)
+	if _, err := ct.Get(); err == nil {
+		t.Error("Get of a broken template returned no error")
+	}
+	replaceFile(t, dir, "page.tmpl", "fixed", 2)
+	var buf bytes.Buffer
+	if err := ct.Execute(&buf, nil); err != nil || buf.String() != "fixed" {
+		t.Errorf("Execute after fix returned %q, %v", buf.String(), err)
+	}
+}
+
+func TestCachedHTMLTemplate(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", `<p title="{{.}}">{{.}}</p>`, 0)
+	var buf bytes.Buffer
+	ct := NewCachedHTMLTemplate(filepath.Join(dir, "page.tmpl"), nil)
+	if err := ct.Execute(&buf, `"<x>"`); err != nil {
+		t.Fatalf("Execute: %s", err)
+	}
+	if want := `<p title="&#34;&lt;x&gt;&#34;">&#34;&lt;x&gt;&#34;</p>`; buf.String() != want {
+		t.Errorf("output %q, expected %q", buf.String(), want)
+	}
+	buf.Reset()
+	ct = NewCachedTemplate(filepath.Join(dir, "page.tmpl"), nil)
+	if err := ct.Execute(&buf, `"<x>"`); err != nil || buf.String() != `<p title=""<x>"">"<x>"</p>` {
+		t.Errorf("text template output %q, %v", buf.String(), err)
+	}
+}
+
+func TestCachedTemplateFuncs(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", `{{greet .}}`, 0)
+	ct := NewCachedTemplate(filepath.Join(dir, "page.tmpl"), template.FuncMap{
+		"greet": func(s string) string { return "hello " + s },
+	})
+	var buf bytes.Buffer
+	if err := ct.Execute(&buf, "x"); err != nil || buf.String() != "hello x" {
+		t.Fatalf("Execute returned %q, %v", buf.String(), err)
+	}
+	old, _ := ct.Get()
+
+	// Replacing a function parses the unchanged file again
+	ct.Funcs(template.FuncMap{"greet": func(s string) string { return "bye " + s }})
+	buf.Reset()
+	if err := ct.Execute(&buf, "x"); err != nil || buf.String() != "bye x" {
+		t.Errorf("Execute after Funcs returned %q, %v", buf.String(), err)
+	}
+	buf.Reset()
+	if err := old.Execute(&buf, "x"); err != nil || buf.String() != "hello x" {
+		t.Errorf("template returned before Funcs changed: %q, %v", buf.String(), err)
+	}
+
+	// Added functions are kept across reloads
+	ct.Funcs(template.FuncMap{"shout": strings.ToUpper})
+	replaceFile(t, dir, "page.tmpl", `{{greet .}} {{shout .}}`, 1)
+	buf.Reset()
+	if err := ct.Execute(&buf, "x"); err != nil || buf.String() != "bye x X" {
+		t.Errorf("Execute after a reload returned %q, %v", buf.String(), err)
+	}
+}
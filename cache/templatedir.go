@@ -0,0 +1,336 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// ErrBadTemplateName is returned for template names outside the directory
+// of a TemplateDir.
+var ErrBadTemplateName = errors.New("cache: bad template name")
+
+// TemplateDir is a directory of templates. Files whose base name starts
+// with an underscore, e.g. "_base.tmpl" or "partials/_nav.tmpl", are shared:
+// they are parsed along with every page, so the named templates they
+// {{define}} can be used by all pages, as can the shared files themselves,
+// named by their relative path. A base layout is a shared file that
+// defines, say, "base", and invokes templates such as "content" that each
+// page defines, while the page itself consists of {{template "base" .}}.
+// All other files are pages, named by their slash-separated path relative
+// to the directory. Pages are parsed when first requested, and again when
+// the page or a shared file changes. It is safe for concurrent use.
+type TemplateDir struct {
+	sync.RWMutex
+	dir    string
+	html   bool // Whether the templates are HTML templates
+	funcs  template.FuncMap
+	shared []*CachedFile
+	pages  map[string]*pageTemplate
+	watch  *watcher // Watcher of the directory, if Watch was called
+}
+
+// pageTemplate is a page parsed along with the shared files.
+type pageTemplate struct {
+	file *CachedFile
+	tmpl Template
+	tag  string // Entity tags of the contents tmpl was parsed from
+}
+
+// NewTemplateDir returns a TemplateDir for the text templates in dir,
+// parsed with the functions in funcs, which may be nil.
+func NewTemplateDir(dir string, funcs template.FuncMap) (*TemplateDir, error) {
+	return newTemplateDir(dir, false, funcs)
+}
+
+// NewHTMLTemplateDir is like NewTemplateDir, but its templates are HTML
+// templates, whose output is escaped according to the context.
+func NewHTMLTemplateDir(dir string, funcs template.FuncMap) (*TemplateDir, error) {
+	return newTemplateDir(dir, true, funcs)
+}
+
+func newTemplateDir(dir string, html bool, funcs template.FuncMap) (*TemplateDir, error) {
+	td := &TemplateDir{dir: dir, html: html, funcs: funcs}
+	if err := td.Reload(); err != nil {
+		return nil, err
+	}
+	return td, nil
+}
+
+// Reload scans the directory for shared files again, and drops all parsed
+// pages. Changes to existing files are picked up without Reload, but
+// shared files added to the directory are not.
+func (td *TemplateDir) Reload() error {
+	var shared []string
+	err := filepath.Walk(td.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.Mode().IsRegular() && strings.HasPrefix(fi.Name(), "_") {
+			shared = append(shared, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(shared)
+	files := make([]*CachedFile, len(shared))
+	td.Lock()
+	defer td.Unlock()
+	for i, name := range shared {
+		files[i] = NewCachedFile(name)
+		if td.watch != nil {
+			files[i].SetRevalidateInterval(math.MaxInt64)
+		}
+	}
+	td.shared = files
+	td.pages = make(map[string]*pageTemplate)
+	return nil
+}
+
+// Get returns the template of the page name, e.g. "blog/post.tmpl",
+// parsing it if it or a shared file changed since the last Get.
+func (td *TemplateDir) Get(name string) (Template, error) {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" || strings.HasPrefix(path.Base(name), "_") {
+		return nil, ErrBadTemplateName
+	}
+	td.Lock()
+	p, ok := td.pages[name]
+	if !ok {
+		p = &pageTemplate{file: NewCachedFile(filepath.Join(td.dir, filepath.FromSlash(name)))}
+		if td.watch != nil {
+			p.file.SetRevalidateInterval(math.MaxInt64)
+		}
+		td.pages[name] = p
+	}
+	if td.watch != nil && p.tmpl != nil {
+		// Kept up to date by the watcher
+		tmpl := p.tmpl
+		td.Unlock()
+		return tmpl, nil
+	}
+	files := make([]*CachedFile, 0, len(td.shared)+1)
+	files = append(append(files, td.shared...), p.file)
+	funcs := td.funcs
+	td.Unlock()
+
+	tmpl, err := td.load(name, p, files, funcs)
+	if err != nil && os.IsNotExist(err) {
+		td.forget(name, p)
+	}
+	return tmpl, err
+}
+
+// load parses the page name from files, the shared files followed by the
+// page file, unless they are unchanged since p was last parsed.
+func (td *TemplateDir) load(name string, p *pageTemplate, files []*CachedFile, funcs template.FuncMap) (Template, error) {
+	contents := make([]Content, 0, len(files))
+	defer func() {
+		for _, content := range contents {
+			content.Close()
+		}
+	}()
+	tags := make([]string, 0, len(files))
+	for _, f := range files {
+		content, etag, _, _, err := f.open(false)
+		if err != nil {
+			return nil, err
+		}
+		contents = append(contents, content)
+		tags = append(tags, etag)
+	}
+	tag := strings.Join(tags, ",")
+
+	td.RLock()
+	tmpl, fresh := p.tmpl, p.tag == tag
+	td.RUnlock()
+	if fresh {
+		return tmpl, nil
+	}
+
+	// The shared files are associated templates of the page
+	srcs := make([]source, len(files))
+	for i, f := range files {
+		data, err := contentBytes(contents[i])
+		if err != nil {
+			return nil, err
+		}
+		srcs[i] = source{name, string(data)}
+		if f != p.file {
+			rel, _ := filepath.Rel(td.dir, f.fname)
+			srcs[i].name = filepath.ToSlash(rel)
+		}
+	}
+	tmpl, err := parse(td.html, funcs, srcs)
+	if err != nil {
+		return nil, err
+	}
+	td.Lock()
+	defer td.Unlock()
+	p.tmpl, p.tag = tmpl, tag
+	return tmpl, nil
+}
+
+// Source returns the text of the template name, which may be a page or a
+// shared file, as read from its file.
+func (td *TemplateDir) Source(name string) ([]byte, error) {
+	name = strings.TrimLeft(path.Clean("/"+name), "/")
+	if name == "" {
+		return nil, ErrBadTemplateName
+	}
+	return ioutil.ReadFile(filepath.Join(td.dir, filepath.FromSlash(name)))
+}
+
+// Funcs adds the functions in funcs to those the templates are parsed
+// with, replacing functions of the same name. The functions are kept
+// across reloads of the files. Pages are parsed again on their next Get.
+func (td *TemplateDir) Funcs(funcs template.FuncMap) {
+	td.Lock()
+	defer td.Unlock()
+	td.funcs = mergeFuncs(td.funcs, funcs)
+	td.pages = make(map[string]*pageTemplate)
+}
+
+// forget drops the page name, if it is still p.
+func (td *TemplateDir) forget(name string, p *pageTemplate) {
+	td.Lock()
+	defer td.Unlock()
+	if td.pages[name] == p {
+		delete(td.pages, name)
+	}
+}
+
+// Execute applies the template of the page name to data and writes the
+// output to w.
+func (td *TemplateDir) Execute(w io.Writer, name string, data interface{}) error {
+	tmpl, err := td.Get(name)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// Watch makes the TemplateDir listen for changes to its files, as reported
+// by the file system, instead of checking the files on every Get. Pages
+// are parsed again as soon as they or shared files change, and replace the
+// previous templates only if they parse; errors are logged. Subdirectories
+// created after Watch is called are not watched.
+func (td *TemplateDir) Watch() error {
+	w, err := newWatcher(td.changed, td.lost)
+	if err != nil {
+		return err
+	}
+	err = filepath.Walk(td.dir, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return w.add(name)
+		}
+		return nil
+	})
+	if err != nil {
+		w.close()
+		return err
+	}
+	td.Lock()
+	defer td.Unlock()
+	if td.watch != nil {
+		td.watch.close()
+	}
+	td.watch = w
+	for _, f := range td.shared {
+		f.SetRevalidateInterval(math.MaxInt64)
+	}
+	for _, p := range td.pages {
+		p.file.SetRevalidateInterval(math.MaxInt64)
+	}
+	return nil
+}
+
+// Close stops watching for changes, if Watch was called. Files are
+// checked on every Get from then on.
+func (td *TemplateDir) Close() error {
+	td.Lock()
+	defer td.Unlock()
+	if td.watch == nil {
+		return nil
+	}
+	err := td.watch.close()
+	td.watch = nil
+	for _, f := range td.shared {
+		f.SetRevalidateInterval(0)
+	}
+	for _, p := range td.pages {
+		p.file.SetRevalidateInterval(0)
+	}
+	return err
+}
+
+// changed is called by the watcher when the file name has changed.
+func (td *TemplateDir) changed(name string) {
+	if strings.HasPrefix(filepath.Base(name), "_") && !td.isShared(name) {
+		// A new shared file
+		if err := td.Reload(); err != nil {
+			log.Printf("Template dir %s: %s\n", td.dir, err)
+		}
+		return
+	}
+	td.recompile()
+}
+
+// lost is called by the watcher when changes may have been missed.
+func (td *TemplateDir) lost(dir string) {
+	td.recompile()
+}
+
+func (td *TemplateDir) isShared(name string) bool {
+	td.RLock()
+	defer td.RUnlock()
+	for _, f := range td.shared {
+		if filepath.Clean(f.fname) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// recompile reads all files again and parses the pages that were parsed
+// before, keeping the previous template of pages that fail to parse.
+func (td *TemplateDir) recompile() {
+	td.RLock()
+	shared := td.shared
+	pages := make(map[string]*pageTemplate, len(td.pages))
+	for name, p := range td.pages {
+		pages[name] = p
+	}
+	funcs := td.funcs
+	td.RUnlock()
+
+	for _, f := range shared {
+		f.invalidate()
+	}
+	for name, p := range pages {
+		p.file.invalidate()
+		files := make([]*CachedFile, 0, len(shared)+1)
+		files = append(append(files, shared...), p.file)
+		if _, err := td.load(name, p, files, funcs); err != nil {
+			log.Printf("Template %s: %s\n", name, err)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// newTemplateTestDir returns a directory with a base layout, a navigation
+// partial and a blog post page.
+func newTemplateTestDir(t *testing.T) (string, func()) {
+	dir, remove := tempDir(t)
+	if err := os.MkdirAll(filepath.Join(dir, "partials"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "blog"), 0755); err != nil {
+		t.Fatalf("MkdirAll: %s", err)
+	}
+	replaceFile(t, dir, "_base.tmpl", `{{define "base"}}[{{template "content" .}}]{{end}}`, 0)
+	replaceFile(t, filepath.Join(dir, "partials"), "_nav.tmpl", `nav`, 0)
+	replaceFile(t, filepath.Join(dir, "blog"), "post.tmpl",
+		`{{define "content"}}{{template "partials/_nav.tmpl"}} {{.}}{{end}}{{template "base" .}}`, 0)
+	return dir, remove
+}
+
+// executeString applies the page name of td to data, and returns the output.
+func executeString(t *testing.T, td *TemplateDir, name string, data interface{}) string {
+	var buf bytes.Buffer
+	if err := td.Execute(&buf, name, data); err != nil {
+		t.Errorf("Execute(%s): %s", name, err)
+	}
+	return buf.String()
+}
+
+func TestTemplateDir(t *testing.T) {
+	dir, remove := newTemplateTestDir(t)
+	defer remove()
+	td, err := NewTemplateDir(dir, nil)
+	if err != nil {
+		t.Fatalf("NewTemplateDir: %s", err)
+	}
+	if out := executeString(t, td, "blog/post.tmpl", "x"); out != "[nav x]" {
+		t.Errorf("page output %q, expected %q", out, "[nav x]")
+	}
+	for _, name := range []string{"/blog/post.tmpl", "blog/../blog/post.tmpl", "../blog/post.tmpl"} {
+		if out := executeString(t, td, name, "y"); out != "[nav y]" {
+			t.Errorf("page %q output %q", name, out)
+		}
+	}
+	for _, name := range []string{"", "/", "..", "_base.tmpl", "partials/_nav.tmpl"} {
+		if _, err := td.Get(name); err != ErrBadTemplateName {
+			t.Errorf("Get(%q) returned %v, expected ErrBadTemplateName", name, err)
+		}
+	}
+	if _, err := td.Get("missing.tmpl"); !os.IsNotExist(err) {
+		t.Errorf("Get of a missing page returned %v", err)
+	}
+	if src, err := td.Source("partials/_nav.tmpl"); err != nil || string(src) != "nav" {
+		t.Errorf("Source of a shared file returned %q, %v", src, err)
+	}
+
+	// Changes to shared files are picked up by the pages
+	replaceFile(t, filepath.Join(dir, "partials"), "_nav.tmpl", `menu`, 1)
+	if out := executeString(t, td, "blog/post.tmpl", "x"); out != "[menu x]" {
+		t.Errorf("page output %q after a partial changed", out)
+	}
+
+	// New shared files are picked up by Reload
+	replaceFile(t, dir, "_footer.tmpl", `{{define "footer"}}end{{end}}`, 0)
+	replaceFile(t, dir, "page.tmpl", `{{template "footer"}}`, 0)
+	if err := td.Execute(new(bytes.Buffer), "page.tmpl", nil); err == nil {
+		t.Errorf("page using a new shared file executed before Reload")
+	}
+	if err := td.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if out := executeString(t, td, "page.tmpl", nil); out != "end" {
+		t.Errorf("page output %q after Reload", out)
+	}
+
+	if _, err := NewTemplateDir(filepath.Join(dir, "missing"), nil); err == nil {
+		t.Errorf("NewTemplateDir of a missing directory returned no error")
+	}
+}
+
+func TestHTMLTemplateDir(t *testing.T) {
+	dir, remove := newTemplateTestDir(t)
+	defer remove()
+	replaceFile(t, dir, "link.tmpl", `<a href="/q?x={{.}}">{{.}}</a>`, 0)
+	html, err := NewHTMLTemplateDir(dir, nil)
+	if err != nil {
+		t.Fatalf("NewHTMLTemplateDir: %s", err)
+	}
+	text, err := NewTemplateDir(dir, nil)
+	if err != nil {
+		t.Fatalf("NewTemplateDir: %s", err)
+	}
+	const data = "<b>&"
+	if out := executeString(t, html, "blog/post.tmpl", data); out != "[nav &lt;b&gt;&amp;]" {
+		t.Errorf("HTML page output %q", out)
+	}
+	if out := executeString(t, html, "link.tmpl", data); out != `<a href="/q?x=%3cb%3e%26">&lt;b&gt;&amp;</a>` {
+		t.Errorf("HTML page output %q, expected escaping by context", out)
+	}
+	if out := executeString(t, text, "blog/post.tmpl", data); out != "[nav <b>&]" {
+		t.Errorf("text page output %q", out)
+	}
+}
+
+func TestTemplateDirFuncs(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "_shared.tmpl", `{{define "name"}}{{upper .}}{{end}}`, 0)
+	replaceFile(t, dir, "page.tmpl", `{{template "name" .}}`, 0)
+	td, err := NewTemplateDir(dir, template.FuncMap{"upper": strings.ToUpper})
+	if err != nil {
+		t.Fatalf("NewTemplateDir: %s", err)
+	}
+	if out := executeString(t, td, "page.tmpl", "x"); out != "X" {
+		t.Errorf("page output %q", out)
+	}
+	td.Funcs(template.FuncMap{"upper": func(s string) string { return "<" + s + ">" }})
+	if out := executeString(t, td, "page.tmpl", "x"); out != "<x>" {
+		t.Errorf("page output %q after Funcs", out)
+	}
+	if err := td.Reload(); err != nil {
+		t.Fatalf("Reload: %s", err)
+	}
+	if out := executeString(t, td, "page.tmpl", "x"); out != "<x>" {
+		t.Errorf("page output %q after Reload, expected the added functions kept", out)
+	}
+}
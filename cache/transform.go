@@ -0,0 +1,49 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"path"
+	"strings"
+)
+
+// A Transformer turns the contents of a file into what is cached and
+// served in their place, e.g. minified script or HTML rendered from
+// Markdown.
+type Transformer func(data []byte) ([]byte, error)
+
+// transformer is a Transformer registered for an extension.
+type transformer struct {
+	fn       Transformer
+	mimetype string
+}
+
+// RegisterTransformer has files with the extension ext, e.g. ".md", passed
+// through t whenever they are read. The output of t is what is cached,
+// hashed and returned by Get, so the transformation runs once per change
+// of the file. If mimetype is not empty, it replaces the MIME type of the
+// files. Transformed files are always read into the store, whatever their
+// size. Files with the extension are dropped from the cache.
+func (c *Cache) RegisterTransformer(ext, mimetype string, t Transformer) {
+	ext = normalizeExt(ext)
+	c.Lock()
+	defer c.Unlock()
+	if c.xforms == nil {
+		c.xforms = make(map[string]transformer)
+	}
+	c.xforms[ext] = transformer{t, mimetype}
+	for name, f := range c.files {
+		if normalizeExt(path.Ext(name)) == ext {
+			c.remove(f)
+		}
+	}
+}
+
+func normalizeExt(ext string) string {
+	if ext != "" && ext[0] != '.' {
+		ext = "." + ext
+	}
+	return strings.ToLower(ext)
+}
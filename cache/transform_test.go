@@ -0,0 +1,65 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCacheTransformer(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	md := writeFile(t, dir, "page.md", 'm', 100)
+	txt := writeFile(t, dir, "page.txt", 't', 10)
+
+	c := NewCache()
+	c.SetStreamThreshold(50)
+	c.Get(md)
+	calls := 0
+	c.RegisterTransformer("MD", "text/html", func(data []byte) ([]byte, error) {
+		calls++
+		if len(data) > 0 && data[0] == 'e' {
+			return nil, errors.New("bad input")
+		}
+		return append([]byte("<p>"), bytes.ToUpper(data)...), nil
+	})
+
+	// The file cached before the registration is transformed, and held
+	// in the store despite the stream threshold
+	data, mimetype, _, err := c.Get(md)
+	if err != nil || mimetype != "text/html" || !bytes.HasPrefix(data, []byte("<p>MMM")) || len(data) != 103 {
+		t.Fatalf("Get returned %q, %q, %v", data, mimetype, err)
+	}
+	if n := c.Size(); n != 103 {
+		t.Errorf("size %d, expected the transformed contents", n)
+	}
+	c.Get(md)
+	if calls != 1 {
+		t.Errorf("transformer called %d times for an unchanged file", calls)
+	}
+	writeFile(t, dir, "page.md", 'n', 10)
+	touch(t, md, 1)
+	if data, _, _, _ = c.Get(md); string(data) != "<p>NNNNNNNNNN" || calls != 2 {
+		t.Errorf("Get after a change returned %q, %d calls", data, calls)
+	}
+
+	// Transformer errors are returned, and not cached
+	writeFile(t, dir, "page.md", 'e', 10)
+	touch(t, md, 2)
+	if _, _, _, err = c.Get(md); err == nil {
+		t.Errorf("Get returned no transformer error")
+	}
+	writeFile(t, dir, "page.md", 'o', 10)
+	touch(t, md, 3)
+	if data, _, _, err = c.Get(md); err != nil || string(data) != "<p>OOOOOOOOOO" {
+		t.Errorf("Get after a transformer error returned %q, %v", data, err)
+	}
+
+	if data, mimetype, _, _ = c.Get(txt); string(data) != "tttttttttt" || mimetype == "text/html" {
+		t.Errorf("other extension returned %q, %q", data, mimetype)
+	}
+}
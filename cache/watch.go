@@ -0,0 +1,107 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"errors"
+	"math"
+	"path/filepath"
+)
+
+// ErrWatchUnsupported is returned by Watch on platforms without file
+// system notifications.
+var ErrWatchUnsupported = errors.New("cache: file watching not supported on this platform")
+
+// Watch makes the cache listen for changes to the files it holds, as
+// reported by the file system, instead of checking the files on Get.
+// Changed files are dropped from the cache and read again on their next
+// Get. Files whose directory cannot be watched are checked as usual,
+// according to the revalidation interval.
+func (c *Cache) Watch() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.watch != nil {
+		return nil
+	}
+	w, err := newWatcher(c.changed, c.lost)
+	if err != nil {
+		return err
+	}
+	c.watch = w
+	c.watched = make(map[string]*CachedFile)
+	for _, f := range c.files {
+		c.addWatch(f)
+	}
+	return nil
+}
+
+// Close stops watching for changes to the cached files, if Watch was
+// called. The cached files are checked according to the revalidation
+// interval from then on.
+func (c *Cache) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	if c.watch == nil {
+		return nil
+	}
+	err := c.watch.close()
+	c.watch = nil
+	c.watched = nil
+	for _, f := range c.files {
+		if f.watched {
+			f.watched = false
+			f.SetRevalidateInterval(c.interval)
+			f.Revalidate()
+		}
+	}
+	return err
+}
+
+// addWatch starts watching the directory of f. The cache must be locked
+// and watching.
+func (c *Cache) addWatch(f *CachedFile) {
+	name := filepath.Clean(f.fname)
+	if c.watch.add(filepath.Dir(name)) != nil {
+		return
+	}
+	f.watched = true
+	c.watched[name] = f
+	// Changes made before the watch started are not reported
+	f.Revalidate()
+	f.SetRevalidateInterval(math.MaxInt64)
+}
+
+// removeWatch stops watching the directory of f, unless other cached
+// files are in it. The cache must be locked.
+func (c *Cache) removeWatch(f *CachedFile) {
+	if !f.watched {
+		return
+	}
+	f.watched = false
+	name := filepath.Clean(f.fname)
+	delete(c.watched, name)
+	c.watch.remove(filepath.Dir(name))
+}
+
+// changed is called by the watcher when the file name has changed.
+func (c *Cache) changed(name string) {
+	c.Lock()
+	defer c.Unlock()
+	if f, ok := c.watched[name]; ok {
+		c.remove(f)
+	}
+}
+
+// lost is called by the watcher when the directory dir is no longer
+// watched, or with "" when changes to any file may have been missed.
+func (c *Cache) lost(dir string) {
+	c.Lock()
+	defer c.Unlock()
+	for name, f := range c.watched {
+		if dir == "" || filepath.Dir(name) == dir {
+			c.remove(f)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+const watchMask = syscall.IN_MODIFY | syscall.IN_ATTRIB | syscall.IN_CLOSE_WRITE |
+	syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// watcher reports changes to files in a set of directories, using inotify.
+type watcher struct {
+	sync.Mutex
+	file    *os.File
+	wds     map[string]int32 // Watch descriptor of each directory
+	dirs    map[int32]string // Directory of each watch descriptor
+	refs    map[string]int   // Number of add calls for each directory, less remove calls
+	changed func(name string)
+	lost    func(dir string)
+}
+
+// newWatcher returns a watcher that calls changed with the path of every
+// file that changes, and lost with the path of every directory that can
+// no longer be watched, or with "" if changes may have been missed.
+func newWatcher(changed func(name string), lost func(dir string)) (*watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	w := &watcher{
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		wds:     make(map[string]int32),
+		dirs:    make(map[int32]string),
+		refs:    make(map[string]int),
+		changed: changed,
+		lost:    lost,
+	}
+	go w.loop()
+	return w, nil
+}
+
+// add starts watching dir, if it is not watched already.
+func (w *watcher) add(dir string) error {
+	w.Lock()
+	defer w.Unlock()
+	if w.refs[dir] > 0 {
+		w.refs[dir]++
+		return nil
+	}
+	wd, err := syscall.InotifyAddWatch(int(w.file.Fd()), dir, watchMask)
+	if err != nil {
+		return os.NewSyscallError("inotify_add_watch", err)
+	}
+	w.wds[dir] = int32(wd)
+	w.dirs[int32(wd)] = dir
+	w.refs[dir] = 1
+	return nil
+}
+
+// remove stops watching dir, once it has been removed as many times as
+// it was added.
+func (w *watcher) remove(dir string) {
+	w.Lock()
+	defer w.Unlock()
+	if w.refs[dir] == 0 {
+		return
+	}
+	w.refs[dir]--
+	if w.refs[dir] > 0 {
+		return
+	}
+	wd := w.wds[dir]
+	w.forget(dir)
+	syscall.InotifyRmWatch(int(w.file.Fd()), uint32(wd))
+}
+
+// forget drops dir from the watched directories. The watcher must be locked.
+func (w *watcher) forget(dir string) {
+	delete(w.dirs, w.wds[dir])
+	delete(w.wds, dir)
+	delete(w.refs, dir)
+}
+
+func (w *watcher) close() error {
+	return w.file.Close()
+}
+
+func (w *watcher) loop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for i := 0; i+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[i]))
+			name := string(buf[i+syscall.SizeofInotifyEvent : i+syscall.SizeofInotifyEvent+int(ev.Len)])
+			i += syscall.SizeofInotifyEvent + int(ev.Len)
+			w.handle(ev.Wd, ev.Mask, strings.TrimRight(name, "\x00"))
+		}
+	}
+}
+
+func (w *watcher) handle(wd int32, mask uint32, name string) {
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.lost("")
+		return
+	}
+	gone := mask&(syscall.IN_IGNORED|syscall.IN_MOVE_SELF) != 0
+	w.Lock()
+	dir, ok := w.dirs[wd]
+	if ok && gone {
+		// The watch was removed by the system, as dir was deleted, or
+		// dir was moved and the watch no longer reports on its path
+		w.forget(dir)
+		syscall.InotifyRmWatch(int(w.file.Fd()), uint32(wd))
+	}
+	w.Unlock()
+	switch {
+	case !ok:
+	case gone:
+		w.lost(dir)
+	case name != "":
+		w.changed(filepath.Join(dir, name))
+	}
+}
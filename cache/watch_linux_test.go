@@ -0,0 +1,138 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cache
+
+import (
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCacheWatch(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	name := writeFile(t, dir, "f", 'x', 10)
+
+	c := NewCache()
+	if err := c.Watch(); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	defer c.Close()
+	if _, _, _, err := c.Get(name); err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	writeFile(t, dir, "f", 'y', 20)
+	want := strings.Repeat("y", 20)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _, _, err := c.Get(name)
+		if err != nil {
+			t.Fatalf("Get after change: %s", err)
+		}
+		if string(data) == want {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("change not seen: Get returned %q", data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if s := c.Stats(); s.Revalidations != 0 {
+		t.Errorf("%d revalidations of a watched file", s.Revalidations)
+	}
+}
+
+func TestCachedTemplateWatch(t *testing.T) {
+	dir, remove := tempDir(t)
+	defer remove()
+	replaceFile(t, dir, "page.tmpl", "old", 0)
+	ct := NewCachedTemplate(filepath.Join(dir, "page.tmpl"), nil)
+	if err := ct.Watch(); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	defer ct.Close()
+
+	done := make(chan bool)
+	stopped := make(chan bool)
+	go func() {
+		// Gets run concurrently with the reload by the watcher
+		defer close(stopped)
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			var buf bytes.Buffer
+			if err := ct.Execute(&buf, nil); err != nil {
+				t.Errorf("Execute: %s", err)
+				return
+			}
+		}
+	}()
+	replaceFile(t, dir, "page.tmpl", "new", 1)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var buf bytes.Buffer
+		if err := ct.Execute(&buf, nil); err != nil {
+			t.Errorf("Execute: %s", err)
+			break
+		}
+		if buf.String() == "new" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Errorf("change not seen: output %q", buf.String())
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(done)
+	<-stopped
+}
+
+func TestTemplateDirWatch(t *testing.T) {
+	dir, remove := newTemplateTestDir(t)
+	defer remove()
+	td, err := NewTemplateDir(dir, nil)
+	if err != nil {
+		t.Fatalf("NewTemplateDir: %s", err)
+	}
+	if err := td.Watch(); err != nil {
+		t.Fatalf("Watch: %s", err)
+	}
+	defer td.Close()
+	if out := executeString(t, td, "blog/post.tmpl", "x"); out != "[nav x]" {
+		t.Fatalf("page output %q", out)
+	}
+
+	// await waits for the page output to become want
+	await := func(want string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			out := executeString(t, td, "blog/post.tmpl", "x")
+			if out == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("change not seen: output %q, expected %q", out, want)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	replaceFile(t, filepath.Join(dir, "partials"), "_nav.tmpl", "menu", 1)
+	await("[menu x]")
+
+	// A page that no longer parses keeps its previous template
+	replaceFile(t, filepath.Join(dir, "blog"), "post.tmpl", "{{if}}", 2)
+	time.Sleep(100 * time.Millisecond)
+	if out := executeString(t, td, "blog/post.tmpl", "x"); out != "[menu x]" {
+		t.Errorf("output %q after a broken change, expected the previous template", out)
+	}
+	replaceFile(t, filepath.Join(dir, "blog"), "post.tmpl", `{{define "content"}}fixed{{end}}{{template "base" .}}`, 3)
+	await("[fixed]")
+}
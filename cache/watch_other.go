@@ -0,0 +1,19 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package cache
+
+// watcher is not implemented on this platform.
+type watcher struct{}
+
+func newWatcher(changed func(name string), lost func(dir string)) (*watcher, error) {
+	return nil, ErrWatchUnsupported
+}
+
+func (w *watcher) add(dir string) error { return ErrWatchUnsupported }
+func (w *watcher) remove(dir string)    {}
+func (w *watcher) close() error         { return nil }
@@ -0,0 +1,150 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"json"
+	"os"
+	"xml"
+)
+
+// A ResponseBuilder builds a Response in steps, e.g.
+//
+//	resp, err := http.Respond(req).Status(http.StatusCreated).
+//		Header("Location", u).JSON(v).Cookie(c).Build()
+//
+// Build makes the Proto fields, Content-Length and header of the response
+// consistent with each other and with the status, which responses built
+// by hand easily get wrong. The first error met by a step is returned
+// by Build.
+type ResponseBuilder struct {
+	resp   *Response
+	ctype  string
+	body   []byte    // Body held in memory, if stream is nil
+	stream io.Reader // Body streamed with chunked encoding, or nil
+	err    os.Error
+}
+
+// Respond returns a ResponseBuilder of a 200 response to req, with an
+// empty header and no body.
+func Respond(req *Request) *ResponseBuilder {
+	return &ResponseBuilder{resp: NewResponse200(req)}
+}
+
+// Status sets the status code of the response, and its text.
+func (b *ResponseBuilder) Status(code int) *ResponseBuilder {
+	b.resp.StatusCode = code
+	b.resp.Status = StatusText(code)
+	return b
+}
+
+// Header sets the header entries associated with key to the single
+// element value, as Header.Set does.
+func (b *ResponseBuilder) Header(key, value string) *ResponseBuilder {
+	b.resp.Header.Set(key, value)
+	return b
+}
+
+// AddHeader adds the key, value pair to the header, as Header.Add does.
+func (b *ResponseBuilder) AddHeader(key, value string) *ResponseBuilder {
+	b.resp.Header.Add(key, value)
+	return b
+}
+
+// Cookie adds a Set-Cookie header for c.
+func (b *ResponseBuilder) Cookie(c *Cookie) *ResponseBuilder {
+	b.resp.Header.Add("Set-Cookie", c.String())
+	return b
+}
+
+// Body sets the body of the response, of content type ctype. An empty
+// ctype leaves the Content-Type header alone.
+func (b *ResponseBuilder) Body(ctype string, body []byte) *ResponseBuilder {
+	b.ctype, b.body, b.stream = ctype, body, nil
+	return b
+}
+
+// BodyString is like Body, for a body held in a string.
+func (b *ResponseBuilder) BodyString(ctype string, body string) *ResponseBuilder {
+	return b.Body(ctype, []byte(body))
+}
+
+// JSON sets the body of the response to the JSON encoding of v.
+func (b *ResponseBuilder) JSON(v interface{}) *ResponseBuilder {
+	body, err := json.Marshal(v)
+	if err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b
+	}
+	return b.Body("application/json; charset=utf-8", body)
+}
+
+// XML sets the body of the response to the XML encoding of v, after
+// an XML declaration.
+func (b *ResponseBuilder) XML(v interface{}) *ResponseBuilder {
+	var body bytes.Buffer
+	body.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	if err := xml.Marshal(&body, v); err != nil {
+		if b.err == nil {
+			b.err = err
+		}
+		return b
+	}
+	return b.Body("application/xml; charset=utf-8", body.Bytes())
+}
+
+// HTML sets the body of the response to the HTML page html.
+func (b *ResponseBuilder) HTML(html string) *ResponseBuilder {
+	return b.BodyString("text/html; charset=utf-8", html)
+}
+
+// Stream sets the body of the response to be copied from r with chunked
+// encoding, so that it need not be held in memory. r is closed after it
+// is written, if it is an io.ReadCloser.
+func (b *ResponseBuilder) Stream(ctype string, r io.Reader) *ResponseBuilder {
+	b.ctype, b.body, b.stream = ctype, nil, r
+	return b
+}
+
+// Build returns the response, or the first error met while building it.
+// The body is dropped from responses whose status forbids one, i.e.
+// 1xx, 204 and 304 responses.
+func (b *ResponseBuilder) Build() (*Response, os.Error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	resp := b.resp
+	code := resp.StatusCode
+	if code < 200 || code == StatusNoContent || code == StatusNotModified {
+		if rc, ok := b.stream.(io.ReadCloser); ok {
+			rc.Close()
+		}
+		b.body, b.stream = nil, nil
+	}
+	resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+	resp.Body, resp.ContentLength, resp.TransferEncoding = nil, 0, nil
+	switch {
+	case b.stream != nil:
+		if rc, ok := b.stream.(io.ReadCloser); ok {
+			resp.Body = rc
+		} else {
+			resp.Body = ioutil.NopCloser(b.stream)
+		}
+		resp.ContentLength = -1
+		resp.TransferEncoding = []string{"chunked"}
+	case len(b.body) > 0:
+		resp.Body = ioutil.NopCloser(bytes.NewBuffer(b.body))
+		resp.ContentLength = int64(len(b.body))
+	}
+	if resp.Body != nil && b.ctype != "" {
+		resp.Header.Set("Content-Type", b.ctype)
+	}
+	return resp, nil
+}
@@ -0,0 +1,61 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestResponseBuilder(t *testing.T) {
+	resp, err := Respond(nil).Status(StatusCreated).Header("Location", "/items/1").
+		JSON(map[string]int{"id": 1}).Cookie(&Cookie{Name: "a", Value: "1"}).Build()
+	if err != nil {
+		t.Fatalf("Build: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != StatusCreated || resp.Status != "Created" || resp.Proto != "HTTP/1.1" {
+		t.Errorf("status %d %q, proto %q", resp.StatusCode, resp.Status, resp.Proto)
+	}
+	if string(body) != `{"id":1}` || resp.ContentLength != int64(len(body)) {
+		t.Errorf("body %q, ContentLength %d", body, resp.ContentLength)
+	}
+	if g := resp.Header.Get("Content-Type"); !strings.HasPrefix(g, "application/json") {
+		t.Errorf("Content-Type %q", g)
+	}
+	if resp.Header.Get("Location") != "/items/1" || resp.Header.Get("Set-Cookie") != "a=1" {
+		t.Errorf("header %v", resp.Header)
+	}
+
+	resp, _ = Respond(nil).Status(StatusNoContent).BodyString("text/plain", "dropped").Build()
+	if resp.Body != nil || resp.ContentLength != 0 || resp.Header.Get("Content-Type") != "" {
+		t.Errorf("204 response has a body: %v", resp)
+	}
+
+	resp, _ = Respond(nil).Stream("text/plain", strings.NewReader("streamed")).Build()
+	if resp.ContentLength != -1 || len(resp.TransferEncoding) != 1 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("streamed response: ContentLength %d, TransferEncoding %v", resp.ContentLength, resp.TransferEncoding)
+	}
+
+	if _, err = Respond(nil).JSON(func() {}).Build(); err == nil {
+		t.Errorf("Build succeeded with an unencodable JSON body")
+	}
+}
+
+func TestTypedResponses(t *testing.T) {
+	resp, err := NewResponseJSON(nil, []int{1, 2})
+	if err != nil {
+		t.Fatalf("NewResponseJSON: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if string(body) != "[1,2]" || resp.ContentLength != 5 || resp.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("NewResponseJSON: body %q, ContentLength %d, header %v", body, resp.ContentLength, resp.Header)
+	}
+	resp = NewResponseHTML(nil, "<p>hi</p>")
+	if resp.ContentLength != 9 || resp.Header.Get("Content-Type") != "text/html; charset=utf-8" {
+		t.Errorf("NewResponseHTML: ContentLength %d, header %v", resp.ContentLength, resp.Header)
+	}
+}
@@ -7,6 +7,8 @@ package http
 import (
 	"bytes"
 	"fmt"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -91,8 +93,10 @@ func readSetCookies(h Header) []*Cookie {
 				c.HttpOnly = true
 				continue
 			case "domain":
+				if _, ok := parseCookieDomain(val); !ok {
+					break
+				}
 				c.Domain = val
-				// TODO: Add domain parsing
 				continue
 			case "max-age":
 				secs, err := strconv.Atoi(val)
@@ -107,19 +111,18 @@ func readSetCookies(h Header) []*Cookie {
 				continue
 			case "expires":
 				c.RawExpires = val
-				exptime, err := time.Parse(time.RFC1123, val)
+				exptime, err := ParseTime(val)
 				if err != nil {
-					exptime, err = time.Parse("Mon, 02-Jan-2006 15:04:05 MST", val)
-					if err != nil {
-						c.Expires = time.Time{}
-						break
-					}
+					c.Expires = time.Time{}
+					break
 				}
 				c.Expires = *exptime
 				continue
 			case "path":
+				if !isCookiePath(val) {
+					break
+				}
 				c.Path = val
-				// TODO: Add path parsing
 				continue
 			}
 			c.Unparsed = append(c.Unparsed, parts[i])
@@ -140,14 +143,14 @@ func SetCookie(w ResponseWriter, cookie *Cookie) {
 func (c *Cookie) String() string {
 	var b bytes.Buffer
 	fmt.Fprintf(&b, "%s=%s", sanitizeName(c.Name), sanitizeValue(c.Value))
-	if len(c.Path) > 0 {
-		fmt.Fprintf(&b, "; Path=%s", sanitizeValue(c.Path))
+	if len(c.Path) > 0 && isCookiePath(c.Path) {
+		fmt.Fprintf(&b, "; Path=%s", c.Path)
 	}
-	if len(c.Domain) > 0 {
-		fmt.Fprintf(&b, "; Domain=%s", sanitizeValue(c.Domain))
+	if _, ok := parseCookieDomain(c.Domain); ok {
+		fmt.Fprintf(&b, "; Domain=%s", c.Domain)
 	}
-	if len(c.Expires.Zone) > 0 {
-		fmt.Fprintf(&b, "; Expires=%s", c.Expires.Format(time.RFC1123))
+	if c.Expires.Year != 0 {
+		fmt.Fprintf(&b, "; Expires=%s", time.SecondsToUTC(c.Expires.Seconds()).Format(TimeFormat))
 	}
 	if c.MaxAge > 0 {
 		fmt.Fprintf(&b, "; Max-Age=%d", c.MaxAge)
@@ -160,9 +163,83 @@ func (c *Cookie) String() string {
 	if c.Secure {
 		fmt.Fprintf(&b, "; Secure")
 	}
+	for _, attr := range c.Unparsed {
+		if isExtensionCookieAttr(attr) {
+			fmt.Fprintf(&b, "; %s", attr)
+		}
+	}
 	return b.String()
 }
 
+// Attributes of a cookie that are parsed into the fields of Cookie
+var cookieAttrs = map[string]bool{
+	"domain":   true,
+	"expires":  true,
+	"httponly": true,
+	"max-age":  true,
+	"path":     true,
+	"secure":   true,
+}
+
+// isExtensionCookieAttr reports whether an unparsed attribute of a cookie
+// is an extension, such as SameSite, rather than an invalid value of a
+// known attribute. Extensions are written back by String, so that cookies
+// pass through unchanged.
+func isExtensionCookieAttr(attr string) bool {
+	name := attr
+	if i := strings.Index(attr, "="); i >= 0 {
+		name = attr[:i]
+	}
+	if cookieAttrs[strings.ToLower(strings.TrimSpace(name))] {
+		return false
+	}
+	for i := 0; i < len(attr); i++ {
+		if attr[i] < 0x20 || attr[i] > 0x7e || attr[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// A CookieMap holds cookies by name, for callers that look cookies up
+// rather than iterate over them. Request.Cookies and Response.Cookies
+// return the slices a CookieMap is made from.
+type CookieMap map[string]*Cookie
+
+// NewCookieMap returns a CookieMap of cookies. Where names repeat, the
+// first cookie is kept, as Request.Cookie does.
+func NewCookieMap(cookies []*Cookie) CookieMap {
+	m := make(CookieMap, len(cookies))
+	for _, c := range cookies {
+		if _, ok := m[c.Name]; !ok {
+			m[c.Name] = c
+		}
+	}
+	return m
+}
+
+// Get returns the value of the named cookie, or "" if there is none.
+func (m CookieMap) Get(name string) string {
+	if c, ok := m[name]; ok {
+		return c.Value
+	}
+	return ""
+}
+
+// Cookies returns the cookies of m, sorted by name.
+func (m CookieMap) Cookies() []*Cookie {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	cookies := make([]*Cookie, len(names))
+	for i, name := range names {
+		cookies[i] = m[name]
+	}
+	return cookies
+}
+
 // readCookies parses all "Cookie" values from the header h and
 // returns the successfully parsed Cookies.
 //
@@ -257,6 +334,45 @@ func parseCookieValueUsing(raw string, validByte func(byte) bool) (string, bool)
 	return raw, true
 }
 
+// parseCookieDomain returns the domain of a Domain attribute v, without
+// its leading dots and in lower case, and whether v is a valid domain
+// name or IP address. Public suffixes are left to the CookieJar.
+func parseCookieDomain(v string) (string, bool) {
+	d := strings.ToLower(strings.TrimLeft(v, "."))
+	if len(d) == 0 || len(d) > 255 {
+		return "", false
+	}
+	if net.ParseIP(d) != nil {
+		return d, true
+	}
+	for _, label := range strings.Split(d, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return "", false
+		}
+		for i := 0; i < len(label); i++ {
+			c := label[i]
+			if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+				return "", false
+			}
+		}
+	}
+	return d, true
+}
+
+// isCookiePath reports whether p is a valid Path attribute: an absolute
+// path of printable characters other than ';' (RFC 6265, 4.1.1).
+func isCookiePath(p string) bool {
+	if len(p) == 0 || p[0] != '/' {
+		return false
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] < 0x20 || p[i] > 0x7e || p[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
 func isCookieNameValid(raw string) bool {
 	for _, c := range raw {
 		if !isToken(byte(c)) {
@@ -33,6 +33,14 @@ var writeSetCookiesTests = []struct {
 		&Cookie{Name: "cookie-4", Value: "four", Path: "/restricted/"},
 		"cookie-4=four; Path=/restricted/",
 	},
+	{
+		&Cookie{Name: "cookie-5", Value: "five", Expires: time.Time{Year: 2011, Month: 11, Day: 23, Hour: 1, Minute: 5, Second: 3}},
+		"cookie-5=five; Expires=Wed, 23 Nov 2011 01:05:03 GMT",
+	},
+	{
+		&Cookie{Name: "cookie-6", Value: "six", Path: "restricted", Domain: "example.com;path=/"},
+		"cookie-6=six",
+	},
 }
 
 func TestWriteSetCookies(t *testing.T) {
@@ -129,6 +137,25 @@ var readSetCookiesTests = []struct {
 			Raw:        "NID=99=YsDT5i3E-CXax-; expires=Wed, 23-Nov-2011 01:05:03 GMT; path=/; domain=.google.ch; HttpOnly",
 		}},
 	},
+	{
+		Header{"Set-Cookie": {"c=1; domain=exa$mple.com; path=relative"}},
+		[]*Cookie{&Cookie{
+			Name:     "c",
+			Value:    "1",
+			Raw:      "c=1; domain=exa$mple.com; path=relative",
+			Unparsed: []string{"domain=exa$mple.com", "path=relative"},
+		}},
+	},
+	{
+		Header{"Set-Cookie": {"c=1; domain=..example.com; path=/a/b"}},
+		[]*Cookie{&Cookie{
+			Name:   "c",
+			Value:  "1",
+			Path:   "/a/b",
+			Domain: "..example.com",
+			Raw:    "c=1; domain=..example.com; path=/a/b",
+		}},
+	},
 }
 
 func toJSON(v interface{}) string {
@@ -151,6 +178,33 @@ func TestReadSetCookies(t *testing.T) {
 	}
 }
 
+var parseTimeTests = []string{
+	"Sun, 06 Nov 1994 08:49:37 GMT",
+	"Sunday, 06-Nov-94 08:49:37 GMT",
+	"Sun Nov  6 08:49:37 1994",
+	"Sun, 06-Nov-1994 08:49:37 GMT",
+	"Sun, 06-Nov-94 08:49:37 GMT",
+	"Sun, 06 Nov 1994 09:49:37 +0100",
+	" Sun, 06 Nov 1994 08:49:37 GMT ",
+}
+
+func TestParseTime(t *testing.T) {
+	const want = 784111777
+	for _, text := range parseTimeTests {
+		tm, err := ParseTime(text)
+		if err != nil {
+			t.Errorf("ParseTime(%q): %s", text, err)
+			continue
+		}
+		if tm.Seconds() != want {
+			t.Errorf("ParseTime(%q) = %d, want %d", text, tm.Seconds(), want)
+		}
+	}
+	if _, err := ParseTime("yesterday"); err == nil {
+		t.Errorf("ParseTime(%q) succeeded", "yesterday")
+	}
+}
+
 var readCookiesTests = []struct {
 	Header  Header
 	Filter  string
@@ -199,3 +253,50 @@ func TestReadCookies(t *testing.T) {
 		}
 	}
 }
+
+var cookieRoundTripTests = []struct {
+	Raw, Want string
+}{
+	{
+		"a=1; Path=/x; Domain=.example.com; Max-Age=60; HttpOnly; Secure; SameSite=Lax",
+		"a=1; Path=/x; Domain=.example.com; Max-Age=60; HttpOnly; Secure; SameSite=Lax",
+	},
+	{
+		"b=2; expires=Wed, 23-Nov-2011 01:05:03 GMT; path=/",
+		"b=2; Path=/; Expires=Wed, 23 Nov 2011 01:05:03 GMT",
+	},
+	{
+		"c=3; domain=exa$mple.com; Priority=High; max-age=x",
+		"c=3; Priority=High",
+	},
+}
+
+func TestCookieRoundTrip(t *testing.T) {
+	for i, tt := range cookieRoundTripTests {
+		cookies := readSetCookies(Header{"Set-Cookie": {tt.Raw}})
+		if len(cookies) != 1 {
+			t.Errorf("#%d: parsed %d cookies, want 1", i, len(cookies))
+			continue
+		}
+		got := cookies[0].String()
+		if got != tt.Want {
+			t.Errorf("#%d: got %q, want %q", i, got, tt.Want)
+			continue
+		}
+		again := readSetCookies(Header{"Set-Cookie": {got}})
+		if len(again) != 1 || again[0].String() != got {
+			t.Errorf("#%d: %q does not round-trip", i, got)
+		}
+	}
+}
+
+func TestCookieMap(t *testing.T) {
+	m := NewCookieMap(readCookies(Header{"Cookie": {"b=2; a=1; b=3"}}, ""))
+	if len(m) != 2 || m.Get("a") != "1" || m.Get("b") != "2" || m.Get("c") != "" {
+		t.Errorf("NewCookieMap = %s", toJSON(m))
+	}
+	cookies := m.Cookies()
+	if len(cookies) != 2 || cookies[0].Name != "a" || cookies[1].Name != "b" {
+		t.Errorf("CookieMap.Cookies = %s", toJSON(cookies))
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// MaxCookieSize is the practical limit on the size of a cookie, name,
+// value and attributes included, that browsers store (RFC 6265, 6.1).
+const MaxCookieSize = 4096
+
+var ErrCookieTooLarge = os.NewError("http: cookie too large")
+
+// CheckCookieSize returns ErrCookieTooLarge if c, as sent in a Set-Cookie
+// header, exceeds MaxCookieSize.
+func CheckCookieSize(c *Cookie) os.Error {
+	if len(c.String()) > MaxCookieSize {
+		return ErrCookieTooLarge
+	}
+	return nil
+}
+
+// SplitCookie splits a cookie too large to store into cookies of at most
+// MaxCookieSize, named name.0, name.1, and so on, with the attributes of
+// c. The value of name.0 starts with the number of cookies, so that
+// JoinCookies ignores the chunks of an earlier, longer value. A cookie
+// that fits is returned as is. SplitCookie fails with ErrCookieTooLarge
+// if the name and attributes of c leave no room for its value.
+//
+// A cookie that changes between being split and not should have its
+// other form deleted, with a MaxAge of -1.
+func SplitCookie(c *Cookie) ([]*Cookie, os.Error) {
+	if CheckCookieSize(c) == nil {
+		return []*Cookie{c}, nil
+	}
+	for n := 2; ; n++ {
+		// Room for the value in each cookie, the longest name being the last
+		last := *c
+		last.Name, last.Value = c.Name+"."+strconv.Itoa(n-1), ""
+		room := MaxCookieSize - len(last.String()) - len(strconv.Itoa(n)+"|")
+		if room <= 0 {
+			return nil, ErrCookieTooLarge
+		}
+		if n*room < len(c.Value) {
+			continue
+		}
+		cookies := make([]*Cookie, n)
+		value := c.Value
+		for i := range cookies {
+			chunk := value
+			if len(chunk) > room {
+				chunk = chunk[:room]
+			}
+			value = value[len(chunk):]
+			cc := *c
+			cc.Name, cc.Value = c.Name+"."+strconv.Itoa(i), chunk
+			cookies[i] = &cc
+		}
+		cookies[0].Value = strconv.Itoa(n) + "|" + cookies[0].Value
+		return cookies, nil
+	}
+	panic("http: SplitCookie unreachable")
+}
+
+// JoinCookies returns the value of the cookie called name among cookies,
+// reassembling it from the chunks made by SplitCookie if it was split,
+// and whether it is present and complete.
+func JoinCookies(cookies []*Cookie, name string) (string, bool) {
+	values := make(map[string]string)
+	for _, c := range cookies {
+		if _, ok := values[c.Name]; !ok {
+			values[c.Name] = c.Value
+		}
+	}
+	if v, ok := values[name]; ok {
+		return v, true
+	}
+	first, ok := values[name+".0"]
+	if !ok {
+		return "", false
+	}
+	i := strings.Index(first, "|")
+	if i < 0 {
+		return "", false
+	}
+	n, err := strconv.Atoi(first[:i])
+	if err != nil || n < 1 {
+		return "", false
+	}
+	parts := []string{first[i+1:]}
+	for j := 1; j < n; j++ {
+		v, ok := values[name+"."+strconv.Itoa(j)]
+		if !ok {
+			return "", false
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, ""), true
+}
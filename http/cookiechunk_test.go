@@ -0,0 +1,58 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitCookie(t *testing.T) {
+	small := &Cookie{Name: "s", Value: "v", Path: "/"}
+	if err := CheckCookieSize(small); err != nil {
+		t.Errorf("CheckCookieSize of a small cookie: %v", err)
+	}
+	if cookies, err := SplitCookie(small); err != nil || len(cookies) != 1 || cookies[0] != small {
+		t.Errorf("SplitCookie of a small cookie = %v, %v", cookies, err)
+	}
+
+	value := strings.Repeat("0123456789", 1000)
+	big := &Cookie{Name: "big", Value: value, Path: "/app", HttpOnly: true}
+	if err := CheckCookieSize(big); err != ErrCookieTooLarge {
+		t.Errorf("CheckCookieSize of a big cookie: got %v, want %v", err, ErrCookieTooLarge)
+	}
+	cookies, err := SplitCookie(big)
+	if err != nil {
+		t.Fatalf("SplitCookie: %v", err)
+	}
+	if len(cookies) != 3 {
+		t.Errorf("SplitCookie made %d cookies, want 3", len(cookies))
+	}
+	for _, c := range cookies {
+		if err := CheckCookieSize(c); err != nil {
+			t.Errorf("cookie %s: %v", c.Name, err)
+		}
+		if c.Path != "/app" || !c.HttpOnly {
+			t.Errorf("cookie %s lost its attributes: %s", c.Name, c)
+		}
+	}
+	// The browser sends the chunks in any order, with those of an older value
+	stale := &Cookie{Name: "big.3", Value: "stale"}
+	received := []*Cookie{cookies[2], stale, cookies[0], cookies[1]}
+	if got, ok := JoinCookies(received, "big"); !ok || got != value {
+		t.Errorf("JoinCookies = %d bytes, %v; want %d bytes", len(got), ok, len(value))
+	}
+	if _, ok := JoinCookies(received[:2], "big"); ok {
+		t.Errorf("JoinCookies of incomplete chunks succeeded")
+	}
+	if got, ok := JoinCookies([]*Cookie{small}, "s"); !ok || got != "v" {
+		t.Errorf("JoinCookies of an unsplit cookie = %q, %v", got, ok)
+	}
+
+	huge := &Cookie{Name: "huge", Value: "v", Path: "/" + strings.Repeat("p", MaxCookieSize)}
+	if _, err := SplitCookie(huge); err != ErrCookieTooLarge {
+		t.Errorf("SplitCookie with huge attributes: got %v, want %v", err, ErrCookieTooLarge)
+	}
+}
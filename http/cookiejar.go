@@ -0,0 +1,314 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"io/ioutil"
+	"json"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"url"
+)
+
+// A CookieJar stores the cookies set by servers, and returns those that
+// should be sent with a request, following the rules of RFC 6265 for
+// domains, paths, expiry and secure cookies. Unless given a
+// PublicSuffixList, the jar only knows top-level domains to be public,
+// so a server may set cookies for any parent domain of its host that
+// contains a dot. A CookieJar may persist its cookies to a file, in JSON.
+// It is safe for concurrent use.
+type CookieJar struct {
+	lk      sync.Mutex
+	entries map[string]*jarEntry // By the key of the entry
+	seq     int64                // Creation sequence number of the next entry
+	file    string               // File the cookies persist to, or "" if none
+	psl     PublicSuffixList     // Public suffixes, or nil for top-level domains only
+}
+
+// A PublicSuffixList tells which domains are public suffixes, under
+// which anyone may register names, e.g. "com" or "co.uk". A CookieJar
+// refuses cookies for public suffixes, which would be sent to every site
+// under them.
+type PublicSuffixList interface {
+	// PublicSuffix returns the public suffix of domain, which is in
+	// lower case and has no leading dot.
+	PublicSuffix(domain string) string
+}
+
+// jarEntry is a cookie in a jar.
+type jarEntry struct {
+	Name       string
+	Value      string
+	Domain     string
+	Path       string
+	HostOnly   bool // Whether the cookie is only sent to Domain, not its subdomains
+	Secure     bool
+	HttpOnly   bool
+	Persistent bool  // Whether the cookie outlives the session
+	Expires    int64 // Expiry time in seconds, if Persistent
+	Seq        int64 // Creation sequence number, which orders cookies
+}
+
+func (e *jarEntry) key() string {
+	return e.Domain + ";" + e.Path + ";" + e.Name
+}
+
+func (e *jarEntry) expired(now int64) bool {
+	return e.Persistent && e.Expires <= now
+}
+
+// NewCookieJar returns an empty CookieJar, which is not persisted.
+func NewCookieJar() *CookieJar {
+	return &CookieJar{entries: make(map[string]*jarEntry)}
+}
+
+// NewPersistentCookieJar returns a CookieJar that persists its cookies
+// to filename, holding the cookies saved there if the file exists. Only
+// persistent cookies, those with an expiry time, are saved, by Save.
+func NewPersistentCookieJar(filename string) (*CookieJar, os.Error) {
+	jar := NewCookieJar()
+	jar.file = filename
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok && pe.Error == os.ENOENT {
+			return jar, nil
+		}
+		return nil, err
+	}
+	var entries []*jarEntry
+	if err = json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	now := time.Seconds()
+	for _, e := range entries {
+		if !e.expired(now) {
+			jar.entries[e.key()] = e
+			if e.Seq >= jar.seq {
+				jar.seq = e.Seq + 1
+			}
+		}
+	}
+	return jar, nil
+}
+
+// Save writes the persistent cookies of the jar to its file, replacing
+// the file atomically. It does nothing if the jar is not persisted.
+func (jar *CookieJar) Save() os.Error {
+	if jar.file == "" {
+		return nil
+	}
+	jar.lk.Lock()
+	now := time.Seconds()
+	entries := make([]*jarEntry, 0, len(jar.entries))
+	for _, e := range jar.entries {
+		if e.Persistent && !e.expired(now) {
+			entries = append(entries, e)
+		}
+	}
+	data, err := json.Marshal(entries)
+	jar.lk.Unlock()
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(jar.file), "cookies")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), jar.file)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// SetPublicSuffixList makes the jar refuse cookies for the public
+// suffixes in psl, rather than only for top-level domains.
+func (jar *CookieJar) SetPublicSuffixList(psl PublicSuffixList) {
+	jar.lk.Lock()
+	defer jar.lk.Unlock()
+	jar.psl = psl
+}
+
+// isPublicSuffix reports whether domain is a public suffix.
+func (jar *CookieJar) isPublicSuffix(domain string) bool {
+	if jar.psl != nil {
+		return jar.psl.PublicSuffix(domain) == domain
+	}
+	return strings.Index(domain, ".") < 0
+}
+
+// SetCookies stores the cookies set by a response to a request for u.
+// Cookies with invalid domains, for domains that u does not belong to,
+// or for public suffixes other than the host of u, are ignored. Cookies
+// that have expired are removed from the jar.
+func (jar *CookieJar) SetCookies(u *url.URL, cookies []*Cookie) {
+	host := canonicalHost(u.Host)
+	if host == "" {
+		return
+	}
+	jar.lk.Lock()
+	defer jar.lk.Unlock()
+	now := time.Seconds()
+	for _, c := range cookies {
+		e := &jarEntry{
+			Name:     c.Name,
+			Value:    c.Value,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+		}
+		if c.Domain == "" {
+			e.Domain, e.HostOnly = host, true
+		} else {
+			d, ok := parseCookieDomain(c.Domain)
+			if !ok || !domainMatch(host, d) {
+				continue
+			}
+			switch {
+			case d == host:
+				e.Domain, e.HostOnly = d, jar.isPublicSuffix(d)
+			case jar.isPublicSuffix(d):
+				continue
+			default:
+				e.Domain = d
+			}
+		}
+		e.Path = c.Path
+		if !isCookiePath(e.Path) {
+			e.Path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge < 0:
+			e.Persistent, e.Expires = true, now
+		case c.MaxAge > 0:
+			e.Persistent, e.Expires = true, now+int64(c.MaxAge)
+		case c.RawExpires != "" && c.Expires.Year != 0:
+			e.Persistent, e.Expires = true, c.Expires.Seconds()
+		}
+		k := e.key()
+		if e.expired(now) {
+			jar.entries[k] = nil, false
+			continue
+		}
+		if old, ok := jar.entries[k]; ok {
+			e.Seq = old.Seq
+		} else {
+			e.Seq = jar.seq
+			jar.seq++
+		}
+		jar.entries[k] = e
+	}
+}
+
+// SetResponseCookies stores the cookies set by resp, a response to a
+// request for u.
+func (jar *CookieJar) SetResponseCookies(u *url.URL, resp *Response) {
+	jar.SetCookies(u, resp.Cookies())
+}
+
+// Cookies returns the cookies to send with a request for u, those with
+// longer paths first, as RFC 6265 recommends.
+func (jar *CookieJar) Cookies(u *url.URL) []*Cookie {
+	host := canonicalHost(u.Host)
+	if host == "" {
+		return nil
+	}
+	p := u.Path
+	if p == "" {
+		p = "/"
+	}
+	secure := u.Scheme == "https"
+	jar.lk.Lock()
+	defer jar.lk.Unlock()
+	now := time.Seconds()
+	var selected jarEntries
+	for k, e := range jar.entries {
+		if e.expired(now) {
+			jar.entries[k] = nil, false
+			continue
+		}
+		if e.Secure && !secure {
+			continue
+		}
+		if e.HostOnly && host != e.Domain || !e.HostOnly && !domainMatch(host, e.Domain) {
+			continue
+		}
+		if !pathMatch(p, e.Path) {
+			continue
+		}
+		selected = append(selected, e)
+	}
+	sort.Sort(selected)
+	cookies := make([]*Cookie, len(selected))
+	for i, e := range selected {
+		cookies[i] = &Cookie{Name: e.Name, Value: e.Value}
+	}
+	return cookies
+}
+
+// AddCookies adds the cookies to send with req to its Cookie header.
+func (jar *CookieJar) AddCookies(req *Request) {
+	for _, c := range jar.Cookies(req.URL) {
+		req.AddCookie(c)
+	}
+}
+
+// jarEntries sorts entries by decreasing path length, then by creation.
+type jarEntries []*jarEntry
+
+func (s jarEntries) Len() int      { return len(s) }
+func (s jarEntries) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+
+func (s jarEntries) Less(i, j int) bool {
+	if len(s[i].Path) != len(s[j].Path) {
+		return len(s[i].Path) > len(s[j].Path)
+	}
+	return s[i].Seq < s[j].Seq
+}
+
+// canonicalHost returns host without its port, in lower case.
+func canonicalHost(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// domainMatch reports whether host belongs to domain (RFC 6265, 5.1.3).
+func domainMatch(host, domain string) bool {
+	if host == domain {
+		return true
+	}
+	return strings.HasSuffix(host, "."+domain) && net.ParseIP(host) == nil
+}
+
+// pathMatch reports whether the request path p falls under the cookie
+// path cp (RFC 6265, 5.1.4).
+func pathMatch(p, cp string) bool {
+	if !strings.HasPrefix(p, cp) {
+		return false
+	}
+	return len(p) == len(cp) || cp[len(cp)-1] == '/' || p[len(cp)] == '/'
+}
+
+// defaultCookiePath returns the default path of a cookie set by a response
+// to a request for the path p (RFC 6265, 5.1.4).
+func defaultCookiePath(p string) string {
+	i := strings.LastIndex(p, "/")
+	if i <= 0 {
+		return "/"
+	}
+	return p[:i]
+}
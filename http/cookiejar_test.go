@@ -0,0 +1,150 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"url"
+)
+
+func jarCookies(jar *CookieJar, rawurl string) string {
+	u, _ := url.Parse(rawurl)
+	var s []string
+	for _, c := range jar.Cookies(u) {
+		s = append(s, c.Name+"="+c.Value)
+	}
+	return strings.Join(s, " ")
+}
+
+func jarSet(jar *CookieJar, rawurl string, cookies ...*Cookie) {
+	u, _ := url.Parse(rawurl)
+	jar.SetCookies(u, cookies)
+}
+
+var cookieJarTests = []struct {
+	URL  string
+	Want string
+}{
+	{"http://www.example.com/", "host=1 domain=1"},
+	{"http://WWW.example.com:8080/", "host=1 domain=1"},
+	{"http://sub.www.example.com/", "domain=1"},
+	{"http://example.com/", ""},
+	{"http://www.example.com/a", "path=1 host=1 domain=1"},
+	{"http://www.example.com/a/b", "path=1 host=1 domain=1"},
+	{"http://www.example.com/ab", "host=1 domain=1"},
+	{"https://www.example.com/a", "path=1 secure=1 host=1 domain=1"},
+	{"http://other.com/", ""},
+}
+
+func TestCookieJar(t *testing.T) {
+	jar := NewCookieJar()
+	jarSet(jar, "http://www.example.com/",
+		&Cookie{Name: "host", Value: "1"},
+		&Cookie{Name: "domain", Value: "1", Domain: ".www.example.com"},
+		&Cookie{Name: "path", Value: "1", Path: "/a"},
+		&Cookie{Name: "secure", Value: "1", Path: "/a", Secure: true},
+		&Cookie{Name: "foreign", Value: "1", Domain: "other.com"},
+		&Cookie{Name: "tld", Value: "1", Domain: "com"},
+		&Cookie{Name: "expired", Value: "1", MaxAge: -1},
+	)
+	for _, tt := range cookieJarTests {
+		if got := jarCookies(jar, tt.URL); got != tt.Want {
+			t.Errorf("%s: got %q, want %q", tt.URL, got, tt.Want)
+		}
+	}
+
+	jarSet(jar, "http://www.example.com/", &Cookie{Name: "host", Value: "2"})
+	if got, want := jarCookies(jar, "http://www.example.com/"), "host=2 domain=1"; got != want {
+		t.Errorf("after replace: got %q, want %q", got, want)
+	}
+	jarSet(jar, "http://www.example.com/", &Cookie{Name: "host", Value: "", MaxAge: -1})
+	if got, want := jarCookies(jar, "http://www.example.com/"), "domain=1"; got != want {
+		t.Errorf("after delete: got %q, want %q", got, want)
+	}
+}
+
+func TestDefaultCookiePath(t *testing.T) {
+	jar := NewCookieJar()
+	jarSet(jar, "http://example.com/a/b/c", &Cookie{Name: "c", Value: "1"})
+	for rawurl, want := range map[string]string{
+		"http://example.com/a/b":   "c=1",
+		"http://example.com/a/b/d": "c=1",
+		"http://example.com/a":     "",
+	} {
+		if got := jarCookies(jar, rawurl); got != want {
+			t.Errorf("%s: got %q, want %q", rawurl, got, want)
+		}
+	}
+}
+
+func TestPersistentCookieJar(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "cookies")
+
+	jar, err := NewPersistentCookieJar(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jarSet(jar, "http://example.com/",
+		&Cookie{Name: "session", Value: "1"},
+		&Cookie{Name: "persistent", Value: "1", MaxAge: 3600},
+	)
+	if err = jar.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	jar, err = NewPersistentCookieJar(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jarCookies(jar, "http://example.com/"), "persistent=1"; got != want {
+		t.Errorf("reloaded jar: got %q, want %q", got, want)
+	}
+}
+
+type suffixList map[string]bool
+
+func (l suffixList) PublicSuffix(domain string) string {
+	for d := domain; ; {
+		if l[d] {
+			return d
+		}
+		i := strings.Index(d, ".")
+		if i < 0 {
+			return d
+		}
+		d = d[i+1:]
+	}
+	panic("unreachable")
+}
+
+func TestCookieJarPublicSuffix(t *testing.T) {
+	jar := NewCookieJar()
+	jar.SetPublicSuffixList(suffixList{"co.uk": true})
+	jarSet(jar, "http://www.example.co.uk/",
+		&Cookie{Name: "public", Value: "1", Domain: "co.uk"},
+		&Cookie{Name: "site", Value: "1", Domain: "example.co.uk"},
+		&Cookie{Name: "invalid", Value: "1", Domain: "example.co.uk."},
+	)
+	jarSet(jar, "http://co.uk/", &Cookie{Name: "own", Value: "1", Domain: "co.uk"})
+	for rawurl, want := range map[string]string{
+		"http://www.example.co.uk/": "site=1",
+		"http://other.co.uk/":       "",
+		"http://co.uk/":             "own=1",
+		"http://www.co.uk/":         "",
+	} {
+		if got := jarCookies(jar, rawurl); got != want {
+			t.Errorf("%s: got %q, want %q", rawurl, got, want)
+		}
+	}
+}
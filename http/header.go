@@ -5,7 +5,6 @@
 package http
 
 import (
-	"fmt"
 	"io"
 	"net/textproto"
 	"os"
@@ -17,7 +16,8 @@ import (
 type Header map[string][]string
 
 // Add adds the key, value pair to the header.
-// It appends to any existing values associated with key.
+// It appends to any existing values associated with key, as a
+// separate value; use Combine to join the values of list-valued fields.
 func (h Header) Add(key, value string) {
 	textproto.MIMEHeader(h).Add(key, value)
 }
@@ -37,6 +37,70 @@ func (h Header) Get(key string) string {
 	return textproto.MIMEHeader(h).Get(key)
 }
 
+// Values returns all values associated with the given key,
+// in the order they were added or received.
+func (h Header) Values(key string) []string {
+	return h[CanonicalHeaderKey(key)]
+}
+
+// Has reports whether the header has any values associated with key.
+func (h Header) Has(key string) bool {
+	_, ok := h[CanonicalHeaderKey(key)]
+	return ok
+}
+
+// Clone returns a copy of h, which shares no memory with it, so that it
+// can be changed without affecting h.
+func (h Header) Clone() Header {
+	if h == nil {
+		return nil
+	}
+	h2 := make(Header, len(h))
+	for k, v := range h {
+		v2 := make([]string, len(v))
+		copy(v2, v)
+		h2[k] = v2
+	}
+	return h2
+}
+
+// Header fields whose values must not be combined into a single
+// comma-separated value, since their values may contain commas
+// themselves (RFC 2616, section 4.2; RFC 6265, section 3).
+var uncombinable = map[string]bool{
+	"Set-Cookie":        true,
+	"Set-Cookie2":       true,
+	"Www-Authenticate":  true,
+	"Date":              true,
+	"Expires":           true,
+	"Last-Modified":     true,
+	"If-Modified-Since": true,
+}
+
+// Combine returns the values associated with key joined into a single
+// comma-separated value, as RFC 2616 permits for list-valued fields.
+// For fields whose values cannot be combined, such as Set-Cookie,
+// Combine returns the first value, as Get does.
+func (h Header) Combine(key string) string {
+	key = CanonicalHeaderKey(key)
+	if uncombinable[key] {
+		return h.Get(key)
+	}
+	return strings.Join(h[key], ", ")
+}
+
+// Flatten replaces the values of every combinable field that has more
+// than one value with their combination, as returned by Combine, for
+// peers that mishandle repeated fields. Fields such as Set-Cookie keep
+// their separate values.
+func (h Header) Flatten() {
+	for k, v := range h {
+		if len(v) > 1 && !uncombinable[k] {
+			h[k] = []string{strings.Join(v, ", ")}
+		}
+	}
+}
+
 // Del deletes the values associated with key.
 func (h Header) Del(key string) {
 	textproto.MIMEHeader(h).Del(key)
@@ -59,13 +123,44 @@ func (h Header) WriteSubset(w io.Writer, exclude map[string]bool) os.Error {
 		}
 	}
 	sort.Strings(keys)
+	return WriteMIMEHeader(w, h, keys)
+}
+
+// WriteMIMEHeader writes the fields of h named by keys to w in wire
+// format, in the order of keys, or in sorted order if keys is nil. Values
+// are written in the order they were added, each on a line of its own
+// terminated by CRLF. Line breaks within keys and values are replaced by
+// spaces, so that they cannot end the field, or the header, early. The
+// output depends on nothing but h and keys.
+func WriteMIMEHeader(w io.Writer, h Header, keys []string) os.Error {
+	return writeHeader(w, h, keys, func(line string) ([]string, os.Error) {
+		return []string{line}, nil
+	})
+}
+
+// writeHeader is WriteMIMEHeader, with the lines of the fields broken up
+// by fold.
+func writeHeader(w io.Writer, h Header, keys []string, fold func(string) ([]string, os.Error)) os.Error {
+	if keys == nil {
+		keys = make([]string, 0, len(h))
+		for k := range h {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+	}
 	for _, k := range keys {
 		for _, v := range h[k] {
 			v = headerNewlineToSpace.Replace(v)
 			v = strings.TrimSpace(v)
-			if _, err := fmt.Fprintf(w, "%s: %s\r\n", k, v); err != nil {
+			lines, err := fold(headerNewlineToSpace.Replace(k) + ": " + v)
+			if err != nil {
 				return err
 			}
+			for _, line := range lines {
+				if _, err = io.WriteString(w, line+"\r\n"); err != nil {
+					return err
+				}
+			}
 		}
 	}
 	return nil
@@ -77,3 +172,176 @@ func (h Header) WriteSubset(w io.Writer, exclude map[string]bool) os.Error {
 // the rest are converted to lowercase.  For example, the
 // canonical key for "accept-encoding" is "Accept-Encoding".
 func CanonicalHeaderKey(s string) string { return textproto.CanonicalMIMEHeaderKey(s) }
+
+// An OrderedHeader is a Header that remembers the order in which its
+// keys were first added, for writers that must reproduce it, e.g.
+// proxies. Write writes the keys in that order, rather than sorted.
+// It also remembers the spelling of each key as first added, which Write
+// uses instead of the canonical key if PreserveCase is set, for peers
+// that require exact casing. Keys are looked up regardless of case.
+// Changes made to the Header directly are not tracked.
+type OrderedHeader struct {
+	Header
+	PreserveCase bool
+	keys         []string          // Canonical keys, in the order first added
+	names        map[string]string // Spelling of canonical keys as first added
+}
+
+// NewOrderedHeader returns an empty OrderedHeader.
+func NewOrderedHeader() *OrderedHeader {
+	return &OrderedHeader{Header: make(Header)}
+}
+
+func (h *OrderedHeader) remember(key string) {
+	h.rememberCanonical(CanonicalHeaderKey(key), key)
+}
+
+// rememberCanonical is like remember, for the key ckey, canonical already,
+// and spelled key. Spellings that are canonical are not stored.
+func (h *OrderedHeader) rememberCanonical(ckey, key string) {
+	if _, ok := h.Header[ckey]; !ok {
+		h.keys = append(h.keys, ckey)
+		if key == ckey {
+			return
+		}
+		if h.names == nil {
+			h.names = make(map[string]string)
+		}
+		h.names[ckey] = key
+	}
+}
+
+// Name returns the spelling of key as it was first added.
+func (h *OrderedHeader) Name(key string) string {
+	key = CanonicalHeaderKey(key)
+	if name, ok := h.names[key]; ok {
+		return name
+	}
+	return key
+}
+
+// Add adds the key, value pair to the header.
+// It appends to any existing values associated with key.
+func (h *OrderedHeader) Add(key, value string) {
+	h.remember(key)
+	h.Header.Add(key, value)
+}
+
+// Set sets the header entries associated with key to the single element
+// value. A key already present keeps its position.
+func (h *OrderedHeader) Set(key, value string) {
+	h.remember(key)
+	h.Header.Set(key, value)
+}
+
+// Del deletes the values associated with key.
+func (h *OrderedHeader) Del(key string) {
+	key = CanonicalHeaderKey(key)
+	h.Header.Del(key)
+	if h.names != nil {
+		h.names[key] = "", false
+	}
+	for i, k := range h.keys {
+		if k == key {
+			h.keys = append(h.keys[:i], h.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys of the header in the order they were first added.
+func (h *OrderedHeader) Keys() []string {
+	keys := make([]string, 0, len(h.Header))
+	for _, k := range h.keys {
+		if _, ok := h.Header[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// Write writes the header in wire format, with keys in the order they
+// were first added.
+func (h *OrderedHeader) Write(w io.Writer) os.Error {
+	if !h.PreserveCase {
+		return WriteMIMEHeader(w, h.Header, h.Keys())
+	}
+	keys := h.Keys()
+	named := make(Header, len(keys))
+	for i, k := range keys {
+		keys[i] = h.Name(k)
+		named[keys[i]] = h.Header[k]
+	}
+	return WriteMIMEHeader(w, named, keys)
+}
+
+// commonHeader interns the keys of common header fields, so that reading
+// them in their canonical spelling does not allocate a string per key.
+var commonHeader = make(map[string]string)
+
+func init() {
+	for _, k := range []string{
+		"Accept",
+		"Accept-Charset",
+		"Accept-Encoding",
+		"Accept-Language",
+		"Accept-Ranges",
+		"Authorization",
+		"Cache-Control",
+		"Connection",
+		"Content-Disposition",
+		"Content-Encoding",
+		"Content-Language",
+		"Content-Length",
+		"Content-Location",
+		"Content-Range",
+		"Content-Type",
+		"Cookie",
+		"Date",
+		"Dnt",
+		"Etag",
+		"Expect",
+		"Expires",
+		"From",
+		"Host",
+		"If-Match",
+		"If-Modified-Since",
+		"If-None-Match",
+		"If-Range",
+		"If-Unmodified-Since",
+		"Keep-Alive",
+		"Last-Modified",
+		"Location",
+		"Max-Forwards",
+		"Origin",
+		"Pragma",
+		"Proxy-Authorization",
+		"Proxy-Connection",
+		"Range",
+		"Referer",
+		"Server",
+		"Set-Cookie",
+		"Te",
+		"Trailer",
+		"Transfer-Encoding",
+		"Upgrade",
+		"User-Agent",
+		"Via",
+		"Warning",
+		"X-Forwarded-For",
+		"X-Forwarded-Proto",
+		"X-Requested-With",
+	} {
+		commonHeader[k] = k
+	}
+}
+
+// headerKey returns the key b of a header field as a string, along with
+// its canonical form. Common keys spelled canonically are interned.
+func headerKey(b []byte) (key, ckey string) {
+	if k, ok := commonHeader[string(b)]; ok {
+		return k, k
+	}
+	key = string(b)
+	return key, CanonicalHeaderKey(key)
+}
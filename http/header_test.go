@@ -5,7 +5,10 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -79,3 +82,219 @@ func TestHeaderWrite(t *testing.T) {
 		buf.Reset()
 	}
 }
+
+func TestOrderedHeader(t *testing.T) {
+	h := NewOrderedHeader()
+	h.Add("x-b", "1")
+	h.Add("X-A", "2")
+	h.Add("X-B", "3")
+	h.Set("x-c", "4")
+	h.Del("X-A")
+	h.Set("X-A", "5")
+	if v := h.Values("x-b"); len(v) != 2 || v[0] != "1" || v[1] != "3" {
+		t.Errorf("Values = %q, want [1 3]", v)
+	}
+	var buf bytes.Buffer
+	h.Write(&buf)
+	if s, want := buf.String(), "X-B: 1\r\nX-B: 3\r\nX-C: 4\r\nX-A: 5\r\n"; s != want {
+		t.Errorf("Write wrote %q, want %q", s, want)
+	}
+}
+
+func TestHeaderCombine(t *testing.T) {
+	h := Header{
+		"Accept":     {"text/html", "text/plain"},
+		"Set-Cookie": {"a=1; Expires=Wed, 09 Jun 2021 10:18:14 GMT", "b=2"},
+	}
+	if s := h.Combine("accept"); s != "text/html, text/plain" {
+		t.Errorf("Combine(Accept) = %q", s)
+	}
+	if s := h.Combine("Set-Cookie"); s != h["Set-Cookie"][0] {
+		t.Errorf("Combine(Set-Cookie) = %q", s)
+	}
+	h.Flatten()
+	if len(h["Accept"]) != 1 || len(h["Set-Cookie"]) != 2 {
+		t.Errorf("Flatten left %v", h)
+	}
+}
+
+func TestHeaderWriterFold(t *testing.T) {
+	h := Header{"X-Long": {"aaa bbb ccc ddd eee fff ggg"}}
+	var buf bytes.Buffer
+	hw := &HeaderWriter{MaxLine: 20, Fold: true}
+	if err := hw.Write(&buf, h); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if s, want := buf.String(), "X-Long: aaa bbb ccc\r\n ddd eee fff ggg\r\n"; s != want {
+		t.Errorf("folded %q, want %q", s, want)
+	}
+	hw.Fold = false
+	if err := hw.Write(&buf, h); err != ErrLineTooLong {
+		t.Errorf("Write without folding returned %v, want ErrLineTooLong", err)
+	}
+}
+
+func TestWriteMIMEHeader(t *testing.T) {
+	h := Header{"B": {"2"}, "A": {"1\r\nX-Injected: 1", "3"}}
+	var buf bytes.Buffer
+	WriteMIMEHeader(&buf, h, nil)
+	if s, want := buf.String(), "A: 1  X-Injected: 1\r\nA: 3\r\nB: 2\r\n"; s != want {
+		t.Errorf("sorted %q, want %q", s, want)
+	}
+	buf.Reset()
+	WriteMIMEHeader(&buf, h, []string{"B", "A"})
+	if s, want := buf.String(), "B: 2\r\nA: 1  X-Injected: 1\r\nA: 3\r\n"; s != want {
+		t.Errorf("ordered %q, want %q", s, want)
+	}
+}
+
+func TestOrderedHeaderPreserveCase(t *testing.T) {
+	raw := "x-lower: 1\r\nCONTENT-TYPE: text/plain\r\nX-Lower: 2\r\n\r\n"
+	h, err := ReadHeader(NewLineReader(bufio.NewReader(strings.NewReader(raw)), 0), 0, 0)
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	if v := h.Get("Content-Type"); v != "text/plain" {
+		t.Errorf("Get(Content-Type) = %q", v)
+	}
+	h.PreserveCase = true
+	var buf bytes.Buffer
+	h.Write(&buf)
+	if s, want := buf.String(), "x-lower: 1\r\nx-lower: 2\r\nCONTENT-TYPE: text/plain\r\n"; s != want {
+		t.Errorf("wrote %q, want %q", s, want)
+	}
+}
+
+func TestOrderedHeaderDelName(t *testing.T) {
+	h := NewOrderedHeader()
+	h.PreserveCase = true
+	h.Add("x-trace", "1")
+	h.Del("X-Trace")
+	h.Add("X-Trace", "2")
+	if name := h.Name("x-trace"); name != "X-Trace" {
+		t.Errorf("Name(x-trace) = %q after re-adding, want %q", name, "X-Trace")
+	}
+	var buf bytes.Buffer
+	h.Write(&buf)
+	if s, want := buf.String(), "X-Trace: 2\r\n"; s != want {
+		t.Errorf("wrote %q, want %q", s, want)
+	}
+}
+
+func TestReadHeaderSmallBuffer(t *testing.T) {
+	// Lines span buffer fills, so values must not alias the buffer
+	raw := "Host: example.com\r\nX-Long: " + strings.Repeat("x", 40) + "\r\n" +
+		"x-folded: a\r\n b\r\nAccept: */*\r\n\r\n"
+	h, err := ReadHeader(NewLineReader(bufio.NewReaderSize(strings.NewReader(raw), 16), 0), 0, 0)
+	if err != nil {
+		t.Fatalf("ReadHeader: %v", err)
+	}
+	want := Header{
+		"Host":     {"example.com"},
+		"X-Long":   {strings.Repeat("x", 40)},
+		"X-Folded": {"a b"},
+		"Accept":   {"*/*"},
+	}
+	if !reflect.DeepEqual(h.Header, want) {
+		t.Errorf("header %v, want %v", h.Header, want)
+	}
+	if keys := h.Keys(); !reflect.DeepEqual(keys, []string{"Host", "X-Long", "X-Folded", "Accept"}) {
+		t.Errorf("keys %v", keys)
+	}
+	if name := h.Name("X-Folded"); name != "x-folded" {
+		t.Errorf("Name(X-Folded) = %q, want %q", name, "x-folded")
+	}
+}
+
+const benchHeader = "Host: www.example.com\r\n" +
+	"User-Agent: Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/20.0 Safari/537.36\r\n" +
+	"Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8\r\n" +
+	"Accept-Language: en-US,en;q=0.8\r\n" +
+	"Accept-Encoding: gzip,deflate,sdch\r\n" +
+	"Accept-Charset: ISO-8859-1,utf-8;q=0.7,*;q=0.3\r\n" +
+	"Cookie: session=abc123; theme=dark\r\n" +
+	"Connection: keep-alive\r\n" +
+	"Cache-Control: max-age=0\r\n\r\n"
+
+func benchmarkReadHeader(b *testing.B, raw string) {
+	for i := 0; i < b.N; i++ {
+		br := bufio.NewReader(strings.NewReader(raw))
+		if _, err := ReadHeader(NewLineReader(br, 0), 0, 0); err != nil {
+			b.Fatalf("ReadHeader: %v", err)
+		}
+	}
+}
+
+func BenchmarkReadHeader(b *testing.B) { benchmarkReadHeader(b, benchHeader) }
+
+func BenchmarkReadHeaderLower(b *testing.B) { benchmarkReadHeader(b, strings.ToLower(benchHeader)) }
+
+func TestHeaderClone(t *testing.T) {
+	h := Header{"Accept": {"text/html"}}
+	h2 := h.Clone()
+	h2.Add("Accept", "text/plain")
+	h2.Set("X-New", "1")
+	if len(h["Accept"]) != 1 || h.Has("X-New") || !h2.Has("x-new") {
+		t.Errorf("Clone shares memory: %v, %v", h, h2)
+	}
+	if Header(nil).Clone() != nil {
+		t.Errorf("Clone of a nil Header is not nil")
+	}
+}
+
+func TestHeaderList(t *testing.T) {
+	h := Header{"Cache-Control": {`private="Set-Cookie, X-Foo", max-age=60`, " ,no-store"}}
+	list := h.List("cache-control")
+	want := []string{`private="Set-Cookie, X-Foo"`, "max-age=60", "no-store"}
+	if strings.Join(list, "|") != strings.Join(want, "|") {
+		t.Errorf("List = %q, want %q", list, want)
+	}
+	cc := ParseCacheControl(list)
+	if cc["private"] != "Set-Cookie, X-Foo" || cc["max-age"] != "60" || !cc.Has("No-Store") || cc.Has("no-cache") {
+		t.Errorf("ParseCacheControl = %v", cc)
+	}
+	if s, want := cc.String(), `max-age=60, no-store, private="Set-Cookie, X-Foo"`; s != want {
+		t.Errorf("CacheControl.String = %q, want %q", s, want)
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	specs := ParseAccept(SplitList("text/plain; q=0.5, application/JSON, text/html;level=1, text/x-c;q=2, */*;q=0"))
+	want := []AcceptSpec{
+		{Value: "application/json", Q: 1},
+		{Value: "text/html", Params: map[string]string{"level": "1"}, Q: 1},
+		{Value: "text/plain", Q: 0.5},
+		{Value: "*/*", Q: 0},
+	}
+	if !reflect.DeepEqual(specs, want) {
+		t.Errorf("ParseAccept = %v, want %v", specs, want)
+	}
+}
+
+var negotiateTests = []struct {
+	key, value string
+	offers     []string
+	want       string
+}{
+	{"Accept", "", []string{"text/html", "application/json"}, "text/html"},
+	{"Accept", "application/json, text/html;q=0.9", []string{"text/html", "application/json"}, "application/json"},
+	{"Accept", "text/*;q=0.5, */*;q=0.1, text/html;q=0", []string{"text/html", "application/xml", "text/plain"}, "text/plain"},
+	{"Accept", "image/png", []string{"text/html"}, ""},
+	{"Accept-Encoding", "gzip;q=0.5, deflate", []string{"gzip", "identity"}, "gzip"},
+	{"Accept-Encoding", "compress", []string{"gzip", "identity"}, "identity"},
+	{"Accept-Encoding", "*;q=0", []string{"gzip", "identity"}, ""},
+	{"Accept-Language", "en;q=0.8, de-CH, de;q=0.5", []string{"de-DE", "en-US", "de-CH"}, "de-CH"},
+	{"Accept-Language", "en;q=0.8, de-CH, de;q=0.5", []string{"de-DE", "en-US"}, "en-US"},
+}
+
+func TestNegotiate(t *testing.T) {
+	for _, tt := range negotiateTests {
+		h := Header{}
+		if tt.value != "" {
+			h.Set(tt.key, tt.value)
+		}
+		if got := h.Negotiate(tt.key, tt.offers...); got != tt.want {
+			t.Errorf("%s: %s, offers %v: got %q, want %q", tt.key, tt.value, tt.offers, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SplitList splits the value of a list-valued header field, such as
+// Accept or Cache-Control, into its elements (RFC 2616, section 2.1).
+// Commas within quoted strings do not separate elements. Elements are
+// trimmed of surrounding space, and empty ones are dropped.
+func SplitList(value string) []string {
+	var list []string
+	quoted, escaped, start := false, false, 0
+	add := func(end int) {
+		if e := strings.TrimSpace(value[start:end]); e != "" {
+			list = append(list, e)
+		}
+		start = end + 1
+	}
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case escaped:
+			escaped = false
+		case quoted && c == '\\':
+			escaped = true
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			add(i)
+		}
+	}
+	add(len(value))
+	return list
+}
+
+// List returns the elements of all values associated with key, as
+// split by SplitList.
+func (h Header) List(key string) []string {
+	var list []string
+	for _, v := range h.Values(key) {
+		list = append(list, SplitList(v)...)
+	}
+	return list
+}
+
+// An AcceptSpec is an element of an Accept, Accept-Charset,
+// Accept-Encoding or Accept-Language header field.
+type AcceptSpec struct {
+	Value  string            // Media range, charset, coding or language, in lower case
+	Params map[string]string // Parameters other than q, by lower-case name
+	Q      float64           // Quality value, from 0 to 1
+}
+
+type acceptSpecs []AcceptSpec
+
+func (s acceptSpecs) Len() int           { return len(s) }
+func (s acceptSpecs) Less(i, j int) bool { return s[i].Q > s[j].Q }
+func (s acceptSpecs) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// ParseAccept parses the values of an Accept-style header field, such as
+// h.List("Accept"), and returns them in order of decreasing quality,
+// keeping the order of elements of equal quality. Elements with an
+// invalid quality value are dropped.
+func ParseAccept(list []string) []AcceptSpec {
+	specs := make(acceptSpecs, 0, len(list))
+	for _, e := range list {
+		parts := strings.Split(e, ";")
+		spec := AcceptSpec{Value: strings.ToLower(strings.TrimSpace(parts[0])), Q: 1}
+		valid := true
+		for _, p := range parts[1:] {
+			name, value := splitParam(p)
+			if name != "q" {
+				if spec.Params == nil {
+					spec.Params = make(map[string]string)
+				}
+				spec.Params[name] = value
+				continue
+			}
+			q, err := strconv.Atof64(value)
+			if err != nil || q < 0 || q > 1 {
+				valid = false
+				break
+			}
+			spec.Q = q
+		}
+		if valid && spec.Value != "" {
+			specs = append(specs, spec)
+		}
+	}
+	// Stable insertion sort, since sort.Sort is not stable
+	for i := 1; i < len(specs); i++ {
+		for j := i; j > 0 && specs.Less(j, j-1); j-- {
+			specs.Swap(j, j-1)
+		}
+	}
+	return specs
+}
+
+// splitParam splits a parameter of the form name=value, returning the
+// name in lower case and the value unquoted.
+func splitParam(p string) (name, value string) {
+	name = p
+	if i := strings.Index(p, "="); i >= 0 {
+		name, value = p[:i], strings.TrimSpace(p[i+1:])
+	}
+	name = strings.ToLower(strings.TrimSpace(name))
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = unquoteParam(value[1 : len(value)-1])
+	}
+	return name, value
+}
+
+// unquoteParam returns the contents of a quoted string, s, with its
+// quoted pairs replaced by the characters they quote.
+func unquoteParam(s string) string {
+	if strings.Index(s, `\`) < 0 {
+		return s
+	}
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b = append(b, s[i])
+	}
+	return string(b)
+}
+
+// A CacheControl holds the directives of a Cache-Control header field,
+// by lower-case name. Directives without a value, such as no-cache, map
+// to "".
+type CacheControl map[string]string
+
+// ParseCacheControl parses the values of a Cache-Control header field,
+// such as h.List("Cache-Control").
+func ParseCacheControl(list []string) CacheControl {
+	cc := make(CacheControl)
+	for _, e := range list {
+		name, value := splitParam(e)
+		cc[name] = value
+	}
+	return cc
+}
+
+// Has reports whether the directive name is present.
+func (cc CacheControl) Has(name string) bool {
+	_, ok := cc[strings.ToLower(name)]
+	return ok
+}
+
+// String formats the directives for a Cache-Control header field, in
+// sorted order. Values that are not tokens are quoted.
+func (cc CacheControl) String() string {
+	names := make([]string, 0, len(cc))
+	for name := range cc {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		if v := cc[name]; v != "" {
+			names[i] = name + "=" + quoteParam(v)
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+var paramQuoter = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// quoteParam returns v, quoted if it is not a token.
+func quoteParam(v string) string {
+	for i := 0; i < len(v); i++ {
+		if !isToken(v[i]) {
+			return `"` + paramQuoter.Replace(v) + `"`
+		}
+	}
+	return v
+}
+
+// Negotiate returns the offer that best matches the Accept-style header
+// field key of h, e.g. "Accept" with offers of media types, or
+// "Accept-Language" with offers of language tags. The quality of an
+// offer is that of the most specific element matching it: media ranges
+// match by type and subtype, language ranges by prefix, and other
+// values exactly, with "*" matching anything. The offer of the highest
+// quality wins, and earlier offers win ties. If h has no field key, the
+// first offer is returned; if no offer is acceptable, "" is.
+// As RFC 2616 specifies, the identity content coding is acceptable
+// unless excluded, though it is preferred least.
+func (h Header) Negotiate(key string, offers ...string) string {
+	if len(offers) == 0 {
+		return ""
+	}
+	key = CanonicalHeaderKey(key)
+	if !h.Has(key) {
+		return offers[0]
+	}
+	specs := ParseAccept(h.List(key))
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		q, specificity := 0.0, -1
+		if key == "Accept-Encoding" && strings.ToLower(offer) == "identity" {
+			q = 0.001
+		}
+		for _, spec := range specs {
+			if s := acceptMatch(key, spec.Value, strings.ToLower(offer)); s > specificity {
+				q, specificity = spec.Q, s
+			}
+		}
+		if q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	return best
+}
+
+// acceptMatch returns how specifically the element spec of the header
+// field key matches offer, or -1 if it does not.
+func acceptMatch(key, spec, offer string) int {
+	switch {
+	case spec == offer:
+		return len(spec) + 1
+	case spec == "*" || spec == "*/*":
+		return 0
+	case key == "Accept" && strings.HasSuffix(spec, "/*"):
+		if strings.HasPrefix(offer, spec[:len(spec)-1]) {
+			return 1
+		}
+	case key == "Accept-Language":
+		if strings.HasPrefix(offer, spec+"-") {
+			return len(spec)
+		}
+	}
+	return -1
+}
@@ -0,0 +1,58 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// A HeaderWriter writes headers in wire format, like Header.Write, but
+// bounds the length of the lines it emits, for peers that cannot handle
+// long lines. Lines longer than MaxLine are folded into continuation
+// lines, starting with a space, if Fold is set, and refused otherwise.
+// Folding is deprecated by RFC 7230, but some older MIME peers, e.g. of
+// SMTP or NNTP, still require it.
+type HeaderWriter struct {
+	MaxLine int  // Maximum length of a line, excluding CRLF; zero means no limit
+	Fold    bool // Whether long lines are folded, rather than refused
+}
+
+// Write writes h to w, with keys in sorted order. It returns
+// ErrLineTooLong if a line is too long and cannot be folded, in which
+// case part of the header may have been written.
+func (hw *HeaderWriter) Write(w io.Writer, h Header) os.Error {
+	return writeHeader(w, h, nil, func(line string) ([]string, os.Error) {
+		return hw.fold(line)
+	})
+}
+
+// fold breaks line into lines of at most hw.MaxLine bytes, at spaces.
+func (hw *HeaderWriter) fold(line string) ([]string, os.Error) {
+	if hw.MaxLine <= 0 || len(line) <= hw.MaxLine {
+		return []string{line}, nil
+	}
+	if !hw.Fold {
+		return nil, ErrLineTooLong
+	}
+	var lines []string
+	for len(line) > hw.MaxLine {
+		// Break at the last space that fits, keeping it at the start of
+		// the continuation line; the first line must keep its key
+		i := strings.LastIndex(line[:hw.MaxLine+1], " ")
+		if len(lines) == 0 {
+			if j := strings.Index(line, ": "); i <= j+1 {
+				i = -1
+			}
+		}
+		if i <= 0 {
+			return nil, ErrLineTooLong
+		}
+		lines = append(lines, line[:i])
+		line = line[i:]
+	}
+	return append(lines, line), nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"os"
+)
+
+// A LineReader reads the CRLF-terminated lines of a protocol header, as
+// net/textproto's Reader does, but fails with ErrLineTooLong instead of
+// buffering lines longer than MaxLine, so that a peer cannot force huge
+// allocations with one endless line.
+type LineReader struct {
+	R       *bufio.Reader
+	MaxLine int // Maximum length of a line, excluding CRLF; zero means no limit
+}
+
+// NewLineReader returns a LineReader reading from r, with lines of at
+// most maxLine bytes.
+func NewLineReader(r *bufio.Reader, maxLine int) *LineReader {
+	return &LineReader{r, maxLine}
+}
+
+// ReadLine reads a single line, without the final \n or \r\n.
+func (lr *LineReader) ReadLine() (string, os.Error) {
+	line, err := lr.readLineSlice(0)
+	return string(line), err
+}
+
+// readLineSlice reads a line of at most lr.MaxLine-n bytes, n being the
+// length of the line read so far. A line read in one piece is returned
+// as a slice of the buffer of lr.R, which the next read may overwrite.
+func (lr *LineReader) readLineSlice(n int) ([]byte, os.Error) {
+	var line []byte
+	for {
+		frag, err := lr.R.ReadSlice('\n')
+		if line == nil && err != bufio.ErrBufferFull {
+			line = frag
+		} else {
+			line = append(line, frag...)
+		}
+		if lr.MaxLine > 0 && n+len(trimCRLF(line)) > lr.MaxLine {
+			return nil, ErrLineTooLong
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return line, err
+		}
+		break
+	}
+	return trimCRLF(line), nil
+}
+
+// trimCRLF returns line without a final \n or \r\n.
+func trimCRLF(line []byte) []byte {
+	if len(line) > 0 && line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+		if len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+	}
+	return line
+}
+
+// ReadContinuedLine reads a possibly continued line, as
+// net/textproto's Reader does: lines starting with a space or tab
+// continue the previous line, and are joined to it with a single space.
+// MaxLine bounds the length of the joined line.
+func (lr *LineReader) ReadContinuedLine() (string, os.Error) {
+	line, err := lr.readContinuedLineSlice()
+	return string(line), err
+}
+
+// readContinuedLineSlice is like ReadContinuedLine, but returns the line
+// as a slice, which may be one of the buffer of lr.R, as readLineSlice
+// does.
+func (lr *LineReader) readContinuedLineSlice() ([]byte, os.Error) {
+	line, err := lr.readLineSlice(0)
+	if err != nil || len(line) == 0 {
+		return line, err
+	}
+	// Without a continuation in the buffer, the line is complete. Peek
+	// must not refill the buffer otherwise, as line may be a slice of it.
+	if lr.R.Buffered() > 0 {
+		if c, _ := lr.R.Peek(1); c[0] != ' ' && c[0] != '\t' {
+			return bytes.TrimRight(line, " \t"), nil
+		}
+	}
+	line = append([]byte(nil), line...)
+	for {
+		c, err := lr.R.Peek(1)
+		if err != nil || (c[0] != ' ' && c[0] != '\t') {
+			break
+		}
+		cont, err := lr.readLineSlice(len(line) + 1)
+		if err != nil {
+			return nil, err
+		}
+		line = append(append(line, ' '), bytes.TrimLeft(cont, " \t")...)
+	}
+	return bytes.TrimRight(line, " \t"), nil
+}
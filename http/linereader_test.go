@@ -0,0 +1,22 @@
+// Copyright 2011 The Go Authors.  All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	raw := "A: 1\r\n  2\r\n\tthree  \r\nB: " + strings.Repeat("x", 100) + "\r\n\r\n"
+	lr := NewLineReader(bufio.NewReaderSize(strings.NewReader(raw), 16), 50)
+	if s, err := lr.ReadContinuedLine(); s != "A: 1 2 three" || err != nil {
+		t.Errorf("continued line %q, %v", s, err)
+	}
+	if _, err := lr.ReadContinuedLine(); err != ErrLineTooLong {
+		t.Errorf("long line returned %v, want ErrLineTooLong", err)
+	}
+}
@@ -4,143 +4,113 @@
 
 package http
 
-func NewResponse200(req *Request) *Response {
-	return &Response{
-		Status:        "OK",
-		StatusCode:    200,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Close:         false,
-		ContentLength: 0,
+import (
+	"os"
+	"strconv"
+)
+
+// NewResponse returns an HTTP/1.1 response to req with the given status.
+// Responses with an error status, 400 or above, carry a default HTML
+// page naming the status; others have no body. The header is empty.
+func NewResponse(req *Request, status int) *Response {
+	resp := &Response{
+		Status:     StatusText(status),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    req,
+		Header:     make(Header),
+		Close:      false,
 	}
+	if status >= 400 {
+		title := strconv.Itoa(status) + " " + resp.Status
+		setBodyString(resp, "<html>"+
+			"<head><title>"+title+"</title></head>\n"+
+			"<body bgcolor=\"white\">\n"+
+			"<center><h1>"+title+"</h1></center>\n"+
+			"<hr><center>Go HTTP package</center>\n"+
+			"</body></html>")
+	}
+	return resp
+}
+
+// NewResponseString returns a response to req with the given status and
+// body.
+func NewResponseString(req *Request, status int, body string) *Response {
+	resp := NewResponse(req, status)
+	setBodyString(resp, body)
+	return resp
+}
+
+// NewResponseJSON returns a 200 response to req, whose body is the JSON
+// encoding of v, with its Content-Type and Content-Length set.
+func NewResponseJSON(req *Request, v interface{}) (*Response, os.Error) {
+	return Respond(req).JSON(v).Build()
+}
+
+// NewResponseXML returns a 200 response to req, whose body is the XML
+// encoding of v, with its Content-Type and Content-Length set.
+func NewResponseXML(req *Request, v interface{}) (*Response, os.Error) {
+	return Respond(req).XML(v).Build()
+}
+
+// NewResponseHTML returns a 200 response to req, whose body is the HTML
+// page html, with its Content-Type and Content-Length set.
+func NewResponseHTML(req *Request, html string) *Response {
+	resp, _ := Respond(req).HTML(html).Build()
+	return resp
+}
+
+func setBodyString(resp *Response, s string) {
+	resp.Body = NewBodyString(s)
+	resp.ContentLength = int64(len(s))
+}
+
+func NewResponse200(req *Request) *Response {
+	return NewResponse(req, StatusOK)
 }
 
 func NewResponse200Bytes(req *Request, b []byte) *Response {
-	if len(b) == 0 {
-		return NewResponse200(req)
-	}
-	return &Response{
-		Status:        "OK",
-		StatusCode:    200,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Body:          NewBodyBytes(b),
-		ContentLength: int64(len(b)),
-		Close:         false,
+	resp := NewResponse(req, StatusOK)
+	if len(b) > 0 {
+		resp.Body = NewBodyBytes(b)
+		resp.ContentLength = int64(len(b))
 	}
+	return resp
 }
 
 func NewResponse200CONNECT(req *Request) *Response {
-	return &Response{
-		Status:        "Connection Established",
-		StatusCode:    200,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Close:         false,
-		Header:        Header{"Proxy-Agent": []string{"Go-HTTP-package"}},
-	}
+	resp := NewResponse(req, StatusOK)
+	resp.Status = "Connection Established"
+	resp.Header.Set("Proxy-Agent", "Go-HTTP-package")
+	return resp
+}
+
+func NewResponse304(req *Request) *Response {
+	return NewResponse(req, StatusNotModified)
 }
 
 func NewResponse500(req *Request) *Response {
-	html := "<html>" +
-		"<head><title>500 Internal Server Error</title></head>\n" +
-		"<body bgcolor=\"white\">\n" +
-		"<center><h1>500 Internal Server Error</h1></center>\n" +
-		"<hr><center>Go HTTP package</center>\n" +
-		"</body></html>"
-	return &Response{
-		Status:        "Internal Server Error",
-		StatusCode:    500,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Body:          NewBodyString(html),
-		ContentLength: int64(len(html)),
-		Close:         false,
-	}
+	return NewResponse(req, StatusInternalServerError)
 }
 
 func NewResponse503(req *Request) *Response {
-	html := "<html>" +
-		"<head><title>503 Service Unavailable</title></head>\n" +
-		"<body bgcolor=\"white\">\n" +
-		"<center><h1>503 Service Unavailable</h1></center>\n" +
-		"<hr><center>Go HTTP package</center>\n" +
-		"</body></html>"
-	return &Response{
-		Status:        "Service Unavailable",
-		StatusCode:    503,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Body:          NewBodyString(html),
-		ContentLength: int64(len(html)),
-		Close:         false,
-	}
+	return NewResponse(req, StatusServiceUnavailable)
 }
 
 func NewResponse400(req *Request) *Response {
-	html := "<html>" +
-		"<head><title>400 Bad Request</title></head>\n" +
-		"<body bgcolor=\"white\">\n" +
-		"<center><h1>400 Bad Request</h1></center>\n" +
-		"<hr><center>Go HTTP package</center>\n" +
-		"</body></html>"
-	return &Response{
-		Status:        "Bad Request",
-		StatusCode:    400,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Body:          NewBodyString(html),
-		ContentLength: int64(len(html)),
-		Close:         false,
-	}
+	return NewResponse(req, StatusBadRequest)
 }
 
 func NewResponse400String(req *Request, body string) *Response {
-	return &Response{
-		Status:        "Bad Request",
-		StatusCode:    400,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Body:          NewBodyString(body),
-		ContentLength: int64(len(body)),
-		Close:         false,
-	}
+	return NewResponseString(req, StatusBadRequest, body)
 }
 
 func NewResponse404(req *Request) *Response {
-	html := "<html>" +
-		"<head><title>404 Not found</title></head>\n" +
-		"<body bgcolor=\"white\">\n" +
-		"<center><h1>404 Not found</h1></center>\n" +
-		"<hr><center>Go HTTP package</center>\n" +
-		"</body></html>"
-	return NewResponse404String(req, html)
+	return NewResponse(req, StatusNotFound)
 }
 
 func NewResponse404String(req *Request, s string) *Response {
-	return &Response{
-		Status:        "Not found",
-		StatusCode:    404,
-		Proto:         "HTTP/1.1",
-		ProtoMajor:    1,
-		ProtoMinor:    1,
-		Request:       req,
-		Body:          NewBodyString(s),
-		ContentLength: int64(len(s)),
-		Close:         false,
-	}
+	return NewResponseString(req, StatusNotFound, s)
 }
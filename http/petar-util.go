@@ -9,7 +9,7 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"github.com/petar/GoGauge/unclosed"
+	"github.com/petar/GoHTTP/util"
 )
 
 // NewBodyString converts a string to an io.ReadCloser.
@@ -23,7 +23,7 @@ func NewBodyFile(filename string) (io.ReadCloser, os.Error) {
 	if err != nil {
 		return nil, err
 	}
-	return unclosed.NewReadCloserTracker(ioutil.NopCloser(bytes.NewBuffer(f))), nil
+	return util.TrackBody(ioutil.NopCloser(bytes.NewBuffer(f))), nil
 }
 
 func NewResponseFile(req *Request, filename string) (*Response, os.Error) {
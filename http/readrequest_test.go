@@ -9,6 +9,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"os"
+	"strings"
 	"testing"
 	"url"
 )
@@ -178,3 +180,26 @@ func TestReadRequest(t *testing.T) {
 		}
 	}
 }
+
+func TestReadRequestLimit(t *testing.T) {
+	raw := "GET / HTTP/1.1\r\nHost: a\r\nX-A: 1\r\nX-B: 2\r\n\r\n"
+	tests := []struct {
+		maxBytes, maxFields int
+		err                 os.Error
+	}{
+		{0, 0, nil},
+		{100, 3, nil},
+		{100, 2, ErrHeaderFieldsTooLarge},
+		{20, 0, ErrHeaderFieldsTooLarge},
+	}
+	for i, tt := range tests {
+		req, err := ReadRequestLimit(bufio.NewReader(strings.NewReader(raw)), tt.maxBytes, tt.maxFields)
+		if err != tt.err {
+			t.Errorf("#%d: error %v, want %v", i, err, tt.err)
+			continue
+		}
+		if err == nil && req.Header.Get("X-B") != "2" {
+			t.Errorf("#%d: header %v", i, req.Header)
+		}
+	}
+}
@@ -47,6 +47,7 @@ func (err *ProtocolError) String() string { return err.ErrorString }
 var (
 	ErrLineTooLong          = &ProtocolError{"header line too long"}
 	ErrHeaderTooLong        = &ProtocolError{"header too long"}
+	ErrHeaderFieldsTooLarge = &ProtocolError{"request header fields too large"}
 	ErrShortBody            = &ProtocolError{"entity body too short"}
 	ErrNotSupported         = &ProtocolError{"feature not supported"}
 	ErrUnexpectedTrailer    = &ProtocolError{"trailer header without chunked transfer encoding"}
@@ -579,15 +580,71 @@ func (r *Request) SetBasicAuth(username, password string) {
 	r.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(s)))
 }
 
+// ReadHeader reads a MIME-style header from lr, as ReadMIMEHeader
+// does, but fails with ErrHeaderFieldsTooLarge if its lines take more than
+// maxBytes bytes, or there are more than maxFields of them. A zero limit
+// means no limit. The header remembers the order and the spelling of the
+// keys as received, for proxies that must reproduce them.
+func ReadHeader(lr *LineReader, maxBytes, maxFields int) (*OrderedHeader, os.Error) {
+	h := &OrderedHeader{Header: make(Header), keys: make([]string, 0, 16)}
+	n, fields := 0, 0
+	for {
+		// The line may be a slice of the buffer of lr, valid until the
+		// next read, so only the value is copied, and common keys are not
+		kv, err := lr.readContinuedLineSlice()
+		if err == ErrLineTooLong {
+			return h, ErrHeaderFieldsTooLarge
+		}
+		if len(kv) == 0 {
+			return h, err
+		}
+		n += len(kv) + 2 // Plus CRLF
+		fields++
+		if (maxBytes > 0 && n > maxBytes) || (maxFields > 0 && fields > maxFields) {
+			return h, ErrHeaderFieldsTooLarge
+		}
+
+		// Key ends at first colon; must not have spaces.
+		i := bytes.IndexByte(kv, ':')
+		if i < 0 || bytes.IndexByte(kv[0:i], ' ') >= 0 {
+			return h, textproto.ProtocolError("malformed MIME header line: " + string(kv))
+		}
+		key, ckey := headerKey(kv[0:i])
+
+		// Skip initial spaces in value.
+		i++ // skip colon
+		for i < len(kv) && (kv[i] == ' ' || kv[i] == '\t') {
+			i++
+		}
+		h.rememberCanonical(ckey, key)
+		h.Header[ckey] = append(h.Header[ckey], string(kv[i:]))
+
+		if err != nil {
+			return h, err
+		}
+	}
+	panic("unreachable")
+}
+
 // ReadRequest reads and parses a request from b.
 func ReadRequest(b *bufio.Reader) (req *Request, err os.Error) {
+	return ReadRequestLimit(b, 0, 0)
+}
+
+// ReadRequestLimit is like ReadRequest, but fails with
+// ErrHeaderFieldsTooLarge if the header lines of the request take more
+// than maxBytes bytes, or there are more than maxFields of them, and with
+// ErrLineTooLong if the request line is longer than maxBytes. A zero
+// limit means no limit.
+func ReadRequestLimit(b *bufio.Reader, maxBytes, maxFields int) (req *Request, err os.Error) {
 
-	tp := textproto.NewReader(b)
+	// No line can be longer than the whole header
+	lr := NewLineReader(b, maxBytes)
 	req = new(Request)
 
 	// First line: GET /index.html HTTP/1.0
 	var s string
-	if s, err = tp.ReadLine(); err != nil {
+	if s, err = lr.ReadLine(); err != nil {
 		if err == os.EOF {
 			err = io.ErrUnexpectedEOF
 		}
@@ -610,11 +667,11 @@ func ReadRequest(b *bufio.Reader) (req *Request, err os.Error) {
 	}
 
 	// Subsequent lines: Key: value.
-	mimeHeader, err := tp.ReadMIMEHeader()
+	h, err := ReadHeader(lr, maxBytes, maxFields)
 	if err != nil {
 		return nil, err
 	}
-	req.Header = Header(mimeHeader)
+	req.Header = h.Header
 
 	// RFC2616: Must treat
 	//	GET /index.html HTTP/1.1
@@ -445,3 +445,25 @@ func TestLocationResponse(t *testing.T) {
 		}
 	}
 }
+
+func TestNewResponse(t *testing.T) {
+	for _, status := range []int{StatusCreated, StatusNoContent, StatusMovedPermanently, StatusNotModified} {
+		resp := NewResponse(nil, status)
+		if resp.StatusCode != status || resp.Status != StatusText(status) || resp.Body != nil || resp.ContentLength != 0 {
+			t.Errorf("NewResponse(%d) = %d %q, body %v", status, resp.StatusCode, resp.Status, resp.Body)
+		}
+	}
+	resp := NewResponse(nil, StatusTooManyRequests)
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !bytes.Contains(body, []byte("<h1>429 Too Many Requests</h1>")) || resp.ContentLength != int64(len(body)) {
+		t.Errorf("NewResponse(429) body %q, ContentLength %d", body, resp.ContentLength)
+	}
+	resp = NewResponse404String(nil, "gone")
+	body, _ = ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != StatusNotFound || string(body) != "gone" || resp.ContentLength != 4 {
+		t.Errorf("NewResponse404String = %d, body %q", resp.StatusCode, body)
+	}
+	if resp = NewResponse200CONNECT(nil); resp.Status != "Connection Established" || resp.Header.Get("Proxy-Agent") == "" {
+		t.Errorf("NewResponse200CONNECT = %q, header %v", resp.Status, resp.Header)
+	}
+}
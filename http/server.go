@@ -193,6 +193,18 @@ func (srv *Server) maxHeaderBytes() int {
 	return DefaultMaxHeaderBytes
 }
 
+// DefaultMaxHeaderFields is the maximum permitted number of header
+// fields in an HTTP request.
+// This can be overridden by setting Server.MaxHeaderFields.
+const DefaultMaxHeaderFields = maxHeaderLines
+
+func (srv *Server) maxHeaderFields() int {
+	if srv.MaxHeaderFields > 0 {
+		return srv.MaxHeaderFields
+	}
+	return DefaultMaxHeaderFields
+}
+
 // wrapper around io.ReaderCloser which on first read, sends an
 // HTTP/1.1 100 Continue header
 type expectContinueReader struct {
@@ -224,6 +236,35 @@ func (ecr *expectContinueReader) Close() os.Error {
 // It is like time.RFC1123 but hard codes GMT as the time zone.
 const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
 
+// timeFormats are the date formats accepted by ParseTime: those of
+// RFC 2616, section 3.3.1, followed by the legacy formats that browsers
+// accept in the Expires attribute of cookies.
+var timeFormats = []string{
+	TimeFormat,
+	time.RFC850,
+	time.ANSIC,
+	time.RFC1123,
+	time.RFC1123Z,
+	"Mon, 02-Jan-2006 15:04:05 MST",
+	"Mon, 02-Jan-06 15:04:05 MST",
+	"Mon, 02 Jan 06 15:04:05 MST",
+	"Monday, 02-Jan-2006 15:04:05 MST",
+	"Mon Jan _2 15:04:05 MST 2006",
+}
+
+// ParseTime parses a date in any of the formats of RFC 2616, or the legacy
+// formats found in real-world Set-Cookie headers. Surrounding space is
+// ignored.
+func ParseTime(text string) (t *time.Time, err os.Error) {
+	text = strings.TrimSpace(text)
+	for _, layout := range timeFormats {
+		if t, err = time.Parse(layout, text); err == nil {
+			return t, nil
+		}
+	}
+	return nil, err
+}
+
 var errTooLarge = os.NewError("http: request too large")
 
 // Read next request from connection.
@@ -233,7 +274,7 @@ func (c *conn) readRequest() (w *response, err os.Error) {
 	}
 	c.lr.N = int64(c.server.maxHeaderBytes()) + 4096 /* bufio slop */
 	var req *Request
-	if req, err = ReadRequest(c.buf.Reader); err != nil {
+	if req, err = ReadRequestLimit(c.buf.Reader, c.server.maxHeaderBytes(), c.server.maxHeaderFields()); err != nil {
 		if c.lr.N == 0 {
 			return nil, errTooLarge
 		}
@@ -572,6 +613,10 @@ func (c *conn) serve() {
 				// while they're still writing their
 				// request.  Undefined behavior.
 				msg = "413 Request Entity Too Large"
+			} else if err == ErrHeaderFieldsTooLarge {
+				msg = "431 Request Header Fields Too Large"
+			} else if err == ErrLineTooLong {
+				msg = "414 Request URI Too Long"
 			} else if err == io.ErrUnexpectedEOF {
 				break // Don't reply
 			} else if neterr, ok := err.(net.Error); ok && neterr.Timeout() {
@@ -925,11 +970,12 @@ func Serve(l net.Listener, handler Handler) os.Error {
 
 // A Server defines parameters for running an HTTP server.
 type Server struct {
-	Addr           string  // TCP address to listen on, ":http" if empty
-	Handler        Handler // handler to invoke, http.DefaultServeMux if nil
-	ReadTimeout    int64   // the net.Conn.SetReadTimeout value for new connections
-	WriteTimeout   int64   // the net.Conn.SetWriteTimeout value for new connections
-	MaxHeaderBytes int     // maximum size of request headers, DefaultMaxHeaderBytes if 0
+	Addr            string  // TCP address to listen on, ":http" if empty
+	Handler         Handler // handler to invoke, http.DefaultServeMux if nil
+	ReadTimeout     int64   // the net.Conn.SetReadTimeout value for new connections
+	WriteTimeout    int64   // the net.Conn.SetWriteTimeout value for new connections
+	MaxHeaderBytes  int     // maximum size of request headers, DefaultMaxHeaderBytes if 0
+	MaxHeaderFields int     // maximum number of request header fields, DefaultMaxHeaderFields if 0
 }
 
 // ListenAndServe listens on the TCP network address srv.Addr and then
@@ -0,0 +1,77 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	"encoding/base64"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrCookieSignature = os.NewError("http: invalid cookie signature")
+	ErrCookieExpired   = os.NewError("http: signed cookie expired")
+)
+
+// SignCookie makes c tamper-evident: it replaces the value of c with the
+// value followed by its expiry time and an HMAC-SHA256, under key, of the
+// name, value and expiry. The expiry time is taken from MaxAge or
+// Expires; a cookie with neither never expires. SignCookie must be called
+// after the value and expiry of c are set.
+func SignCookie(c *Cookie, key []byte) {
+	var expires int64
+	switch {
+	case c.MaxAge > 0:
+		expires = time.Seconds() + int64(c.MaxAge)
+	case c.Expires.Year != 0:
+		expires = c.Expires.Seconds()
+	}
+	exp := strconv.Itoa64(expires)
+	c.Value = c.Value + "|" + exp + "|" + cookieMAC(key, c.Name, c.Value, exp)
+}
+
+// VerifyCookie returns the original value of a cookie signed by
+// SignCookie. The signature is checked against each of keys in turn, so
+// that keys can be rotated: the current key first, followed by those
+// that cookies still in use may have been signed with. VerifyCookie
+// returns ErrCookieSignature if no key matches, and ErrCookieExpired if
+// the cookie has expired.
+func VerifyCookie(c *Cookie, keys ...[]byte) (string, os.Error) {
+	i := strings.LastIndex(c.Value, "|")
+	if i < 0 {
+		return "", ErrCookieSignature
+	}
+	j := strings.LastIndex(c.Value[:i], "|")
+	if j < 0 {
+		return "", ErrCookieSignature
+	}
+	value, exp, sig := c.Value[:j], c.Value[j+1:i], c.Value[i+1:]
+	expires, err := strconv.Atoi64(exp)
+	if err != nil {
+		return "", ErrCookieSignature
+	}
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(sig), []byte(cookieMAC(key, c.Name, value, exp))) != 1 {
+			continue
+		}
+		if expires != 0 && expires <= time.Seconds() {
+			return "", ErrCookieExpired
+		}
+		return value, nil
+	}
+	return "", ErrCookieSignature
+}
+
+// cookieMAC returns the signature of a cookie, in URL-safe base64.
+// Newlines separate the fields, since they cannot occur in any of them.
+func cookieMAC(key []byte, name, value, exp string) string {
+	mac := hmac.NewSHA256(key)
+	mac.Write([]byte(name + "\n" + value + "\n" + exp))
+	return base64.URLEncoding.EncodeToString(mac.Sum())
+}
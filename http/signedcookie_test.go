@@ -0,0 +1,46 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"testing"
+)
+
+func TestSignedCookie(t *testing.T) {
+	oldKey, key := []byte("old key"), []byte("current key")
+
+	c := &Cookie{Name: "user", Value: "gopher|admin", MaxAge: 3600}
+	SignCookie(c, oldKey)
+	if _, ok := parseCookieValue(c.Value); !ok {
+		t.Errorf("signed value %q is not a valid cookie value", c.Value)
+	}
+	if v, err := VerifyCookie(c, key, oldKey); err != nil || v != "gopher|admin" {
+		t.Errorf("VerifyCookie = %q, %v; want %q", v, err, "gopher|admin")
+	}
+	if _, err := VerifyCookie(c, key); err != ErrCookieSignature {
+		t.Errorf("VerifyCookie with a retired key: got %v, want %v", err, ErrCookieSignature)
+	}
+
+	forged := &Cookie{Name: "user", Value: "gopher|root" + c.Value[len("gopher|admin"):]}
+	if _, err := VerifyCookie(forged, key, oldKey); err != ErrCookieSignature {
+		t.Errorf("VerifyCookie of a forged value: got %v, want %v", err, ErrCookieSignature)
+	}
+	renamed := &Cookie{Name: "admin", Value: c.Value}
+	if _, err := VerifyCookie(renamed, key, oldKey); err != ErrCookieSignature {
+		t.Errorf("VerifyCookie of a renamed cookie: got %v, want %v", err, ErrCookieSignature)
+	}
+
+	expired := &Cookie{Name: "user", Value: "gopher"}
+	expired.Value += "|1|" + cookieMAC(key, "user", "gopher", "1")
+	if _, err := VerifyCookie(expired, key); err != ErrCookieExpired {
+		t.Errorf("VerifyCookie of an expired cookie: got %v, want %v", err, ErrCookieExpired)
+	}
+
+	session := &Cookie{Name: "session", Value: "abc"}
+	SignCookie(session, key)
+	if v, err := VerifyCookie(session, key); err != nil || v != "abc" {
+		t.Errorf("VerifyCookie of a session cookie = %q, %v; want %q", v, err, "abc")
+	}
+}
@@ -4,10 +4,12 @@
 
 package http
 
-// HTTP status codes, defined in RFC 2616.
+// HTTP status codes, defined in RFC 2616, and the extensions of
+// RFC 2518, 2774, 2817, 4918, 5842, 6585 and 7538.
 const (
 	StatusContinue           = 100
 	StatusSwitchingProtocols = 101
+	StatusProcessing         = 102
 
 	StatusOK                   = 200
 	StatusCreated              = 201
@@ -16,6 +18,8 @@ const (
 	StatusNoContent            = 204
 	StatusResetContent         = 205
 	StatusPartialContent       = 206
+	StatusMultiStatus          = 207
+	StatusAlreadyReported      = 208
 
 	StatusMultipleChoices   = 300
 	StatusMovedPermanently  = 301
@@ -24,6 +28,7 @@ const (
 	StatusNotModified       = 304
 	StatusUseProxy          = 305
 	StatusTemporaryRedirect = 307
+	StatusPermanentRedirect = 308
 
 	StatusBadRequest                   = 400
 	StatusUnauthorized                 = 401
@@ -43,6 +48,13 @@ const (
 	StatusUnsupportedMediaType         = 415
 	StatusRequestedRangeNotSatisfiable = 416
 	StatusExpectationFailed            = 417
+	StatusUnprocessableEntity          = 422
+	StatusLocked                       = 423
+	StatusFailedDependency             = 424
+	StatusUpgradeRequired              = 426
+	StatusPreconditionRequired         = 428
+	StatusTooManyRequests              = 429
+	StatusRequestHeaderFieldsTooLarge  = 431
 
 	StatusInternalServerError     = 500
 	StatusNotImplemented          = 501
@@ -50,11 +62,16 @@ const (
 	StatusServiceUnavailable      = 503
 	StatusGatewayTimeout          = 504
 	StatusHTTPVersionNotSupported = 505
+	StatusInsufficientStorage     = 507
+	StatusLoopDetected            = 508
+	StatusNotExtended             = 510
+	StatusNetworkAuthRequired     = 511
 )
 
 var statusText = map[int]string{
 	StatusContinue:           "Continue",
 	StatusSwitchingProtocols: "Switching Protocols",
+	StatusProcessing:         "Processing",
 
 	StatusOK:                   "OK",
 	StatusCreated:              "Created",
@@ -63,6 +80,8 @@ var statusText = map[int]string{
 	StatusNoContent:            "No Content",
 	StatusResetContent:         "Reset Content",
 	StatusPartialContent:       "Partial Content",
+	StatusMultiStatus:          "Multi-Status",
+	StatusAlreadyReported:      "Already Reported",
 
 	StatusMultipleChoices:   "Multiple Choices",
 	StatusMovedPermanently:  "Moved Permanently",
@@ -71,6 +90,7 @@ var statusText = map[int]string{
 	StatusNotModified:       "Not Modified",
 	StatusUseProxy:          "Use Proxy",
 	StatusTemporaryRedirect: "Temporary Redirect",
+	StatusPermanentRedirect: "Permanent Redirect",
 
 	StatusBadRequest:                   "Bad Request",
 	StatusUnauthorized:                 "Unauthorized",
@@ -90,6 +110,13 @@ var statusText = map[int]string{
 	StatusUnsupportedMediaType:         "Unsupported Media Type",
 	StatusRequestedRangeNotSatisfiable: "Requested Range Not Satisfiable",
 	StatusExpectationFailed:            "Expectation Failed",
+	StatusUnprocessableEntity:          "Unprocessable Entity",
+	StatusLocked:                       "Locked",
+	StatusFailedDependency:             "Failed Dependency",
+	StatusUpgradeRequired:              "Upgrade Required",
+	StatusPreconditionRequired:         "Precondition Required",
+	StatusTooManyRequests:              "Too Many Requests",
+	StatusRequestHeaderFieldsTooLarge:  "Request Header Fields Too Large",
 
 	StatusInternalServerError:     "Internal Server Error",
 	StatusNotImplemented:          "Not Implemented",
@@ -97,6 +124,10 @@ var statusText = map[int]string{
 	StatusServiceUnavailable:      "Service Unavailable",
 	StatusGatewayTimeout:          "Gateway Timeout",
 	StatusHTTPVersionNotSupported: "HTTP Version Not Supported",
+	StatusInsufficientStorage:     "Insufficient Storage",
+	StatusLoopDetected:            "Loop Detected",
+	StatusNotExtended:             "Not Extended",
+	StatusNetworkAuthRequired:     "Network Authentication Required",
 }
 
 // StatusText returns a text for the HTTP status code. It returns the empty
@@ -0,0 +1,231 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package loadtest drives an HTTP server with a configurable load, and
+// reports its throughput and latency, so that changes to the performance
+// of GoHTTP can be measured reproducibly. The server may be remote, or a
+// Server started in process with LocalServer.
+package loadtest
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"rand"
+	"strings"
+	"sync"
+	"time"
+	"url"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+// A Request is one kind of request of a load.
+type Request struct {
+	Method string // Defaults to GET
+	URL    string // Absolute http URL
+	Body   []byte
+	Weight int // Relative frequency of the request in the load; zero counts as 1
+}
+
+// Config describes a load.
+type Config struct {
+	Conns    int       // Number of concurrent connections; defaults to 1
+	Depth    int       // Number of requests in flight on each connection; defaults to 1
+	Requests int       // Number of requests to send; if zero, requests are sent for Duration
+	Duration int64     // Nanoseconds to send requests for, if Requests is zero
+	Mix      []Request // Requests to choose from, by weight
+	Seed     int64     // Seed of the choice of requests, which is repeatable
+}
+
+var (
+	ErrNoRequests = os.NewError("loadtest: no requests in the mix, or no requests or duration")
+	ErrHosts      = os.NewError("loadtest: requests of the mix are for different hosts")
+	ErrScheme     = os.NewError("loadtest: only http URLs are supported")
+)
+
+// load is the state of a Run shared by its connections.
+type load struct {
+	config   *Config
+	addr     string // Host and port of the server
+	weights  []int  // Cumulative weights of the requests of the mix
+	urls     []*url.URL
+	lk       sync.Mutex
+	sent     int   // Number of requests sent
+	deadline int64 // Time at which to stop sending requests, if config.Requests is zero
+}
+
+// Run sends the load described by config, and reports how the server
+// answered it. Each connection pipelines up to config.Depth requests,
+// reading their responses in order; a connection that fails is dialed
+// again, and its requests in flight are counted as errors.
+func Run(config *Config) (*Result, os.Error) {
+	if len(config.Mix) == 0 || (config.Requests <= 0 && config.Duration <= 0) {
+		return nil, ErrNoRequests
+	}
+	l := &load{config: config}
+	total := 0
+	for _, r := range config.Mix {
+		u, err := url.Parse(r.URL)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" {
+			return nil, ErrScheme
+		}
+		addr := u.Host
+		if strings.LastIndex(addr, ":") <= strings.LastIndex(addr, "]") {
+			addr += ":80"
+		}
+		if l.addr != "" && addr != l.addr {
+			return nil, ErrHosts
+		}
+		l.addr = addr
+		l.urls = append(l.urls, u)
+		if r.Weight > 0 {
+			total += r.Weight
+		} else {
+			total++
+		}
+		l.weights = append(l.weights, total)
+	}
+	conns, depth := config.Conns, config.Depth
+	if conns < 1 {
+		conns = 1
+	}
+	if depth < 1 {
+		depth = 1
+	}
+
+	t0 := time.Nanoseconds()
+	l.deadline = t0 + config.Duration
+	results := make(chan *Result)
+	for i := 0; i < conns; i++ {
+		go func(i int) {
+			results <- l.drive(rand.New(rand.NewSource(config.Seed+int64(i))), depth)
+		}(i)
+	}
+	res := newResult()
+	for i := 0; i < conns; i++ {
+		res.add(<-results)
+	}
+	res.Elapsed = time.Nanoseconds() - t0
+	res.sort()
+	return res, nil
+}
+
+// take reports whether another request is to be sent, and counts it.
+func (l *load) take() bool {
+	l.lk.Lock()
+	defer l.lk.Unlock()
+	if l.config.Requests > 0 {
+		if l.sent >= l.config.Requests {
+			return false
+		}
+	} else if time.Nanoseconds() >= l.deadline {
+		return false
+	}
+	l.sent++
+	return true
+}
+
+// choose returns the index of a request of the mix, chosen by weight.
+func (l *load) choose(rnd *rand.Rand) int {
+	w := rnd.Intn(l.weights[len(l.weights)-1])
+	for i, cw := range l.weights {
+		if w < cw {
+			return i
+		}
+	}
+	panic("unreachable")
+}
+
+func (l *load) newRequest(i int) *http.Request {
+	r := &l.config.Mix[i]
+	method := r.Method
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if r.Body != nil {
+		body = bytes.NewBuffer(r.Body)
+	}
+	req, err := http.NewRequest(method, r.URL, body)
+	if err != nil {
+		// The URL was parsed by Run
+		panic(err)
+	}
+	return req
+}
+
+type flight struct {
+	req *http.Request
+	t0  int64
+}
+
+// drive sends requests on one connection until the load is complete.
+func (l *load) drive(rnd *rand.Rand, depth int) *Result {
+	res := newResult()
+	var cc *http.ClientConn
+	var inflight []flight
+	fail := func() {
+		res.Errors += len(inflight)
+		inflight = inflight[:0]
+		if cc != nil {
+			cc.Close()
+			cc = nil
+		}
+	}
+	for {
+		for len(inflight) < depth && l.take() {
+			if cc == nil {
+				c, err := net.Dial("tcp", l.addr)
+				if err != nil {
+					res.Errors++
+					continue
+				}
+				cc = http.NewClientConn(c, nil)
+			}
+			req := l.newRequest(l.choose(rnd))
+			t0 := time.Nanoseconds()
+			if err := cc.Write(req); err != nil {
+				res.Errors++
+				fail()
+				continue
+			}
+			inflight = append(inflight, flight{req, t0})
+		}
+		if len(inflight) == 0 {
+			break
+		}
+		f := inflight[0]
+		resp, err := cc.Read(f.req)
+		if err == nil {
+			_, err = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		if err != nil {
+			fail()
+			continue
+		}
+		res.record(resp.StatusCode, time.Nanoseconds()-f.t0)
+		inflight = inflight[1:]
+	}
+	if cc != nil {
+		cc.Close()
+	}
+	return res
+}
+
+// LocalServer starts a Server on a local port, and returns it along with
+// the URL of its root, e.g. to Run a load against subs added to it.
+func LocalServer(config server.Config, fdlim int) (*server.Server, string, os.Error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, "", err
+	}
+	return server.NewServer(l, config, fdlim), "http://" + l.Addr().String() + "/", nil
+}
@@ -0,0 +1,98 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadtest
+
+import (
+	"os"
+	"testing"
+	"github.com/petar/GoHTTP/server"
+)
+
+// startServer starts a local Server that answers every request with 404,
+// and returns the URL of its root.
+func startServer() (string, os.Error) {
+	srv, root, err := LocalServer(server.Config{Timeout: 5e9}, 50)
+	if err != nil {
+		return "", err
+	}
+	srv.Launch(4)
+	return root, nil
+}
+
+func TestRun(t *testing.T) {
+	root, err := startServer()
+	if err != nil {
+		t.Fatalf("LocalServer: %s", err)
+	}
+	res, err := Run(&Config{
+		Conns:    4,
+		Depth:    8,
+		Requests: 200,
+		Mix:      []Request{{URL: root + "a"}, {URL: root + "b", Weight: 3}},
+	})
+	if err != nil {
+		t.Fatalf("Run: %s", err)
+	}
+	if res.Requests != 200 || res.Errors != 0 {
+		t.Errorf("got %d requests and %d errors, want 200 and 0", res.Requests, res.Errors)
+	}
+	if n := res.Status[404]; n != 200 {
+		t.Errorf("got %d responses with status 404, want 200", n)
+	}
+	if len(res.Latencies) != 200 {
+		t.Fatalf("got %d latencies, want 200", len(res.Latencies))
+	}
+	if res.Percentile(50) > res.Percentile(99) || res.Percentile(99) > res.Percentile(100) {
+		t.Errorf("percentiles out of order: %s", res)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	tests := []struct {
+		config Config
+		err    os.Error
+	}{
+		{Config{Requests: 1}, ErrNoRequests},
+		{Config{Mix: []Request{{URL: "http://localhost/"}}}, ErrNoRequests},
+		{Config{Requests: 1, Mix: []Request{{URL: "https://localhost/"}}}, ErrScheme},
+		{Config{Requests: 1, Mix: []Request{{URL: "http://a/"}, {URL: "http://b/"}}}, ErrHosts},
+	}
+	for i, test := range tests {
+		if _, err := Run(&test.config); err != test.err {
+			t.Errorf("#%d: got %v, want %v", i, err, test.err)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	r := newResult()
+	for i := 100; i > 0; i-- {
+		r.record(200, int64(i))
+	}
+	r.sort()
+	for _, test := range []struct {
+		p    float64
+		want int64
+	}{{0, 1}, {50, 50}, {90, 90}, {99, 99}, {100, 100}} {
+		if got := r.Percentile(test.p); got != test.want {
+			t.Errorf("Percentile(%g): got %d, want %d", test.p, got, test.want)
+		}
+	}
+}
+
+func benchmarkDepth(b *testing.B, depth int) {
+	b.StopTimer()
+	root, err := startServer()
+	if err != nil {
+		b.Fatalf("LocalServer: %s", err)
+	}
+	b.StartTimer()
+	if _, err := Run(&Config{Conns: 4, Depth: depth, Requests: b.N, Mix: []Request{{URL: root}}}); err != nil {
+		b.Fatalf("Run: %s", err)
+	}
+}
+
+func BenchmarkDepth1(b *testing.B) { benchmarkDepth(b, 1) }
+func BenchmarkDepth8(b *testing.B) { benchmarkDepth(b, 8) }
@@ -0,0 +1,79 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package loadtest
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Result reports how a server answered a load.
+type Result struct {
+	Requests  int         // Number of requests answered
+	Errors    int         // Number of requests that failed, or could not be sent
+	Status    map[int]int // Number of responses with each status code
+	Elapsed   int64       // Nanoseconds the load took
+	Latencies []int64     // Nanoseconds from sending each request to reading its response, in increasing order
+}
+
+func newResult() *Result {
+	return &Result{Status: make(map[int]int)}
+}
+
+func (r *Result) record(status int, latency int64) {
+	r.Requests++
+	r.Status[status]++
+	r.Latencies = append(r.Latencies, latency)
+}
+
+func (r *Result) add(s *Result) {
+	r.Requests += s.Requests
+	r.Errors += s.Errors
+	for code, n := range s.Status {
+		r.Status[code] += n
+	}
+	r.Latencies = append(r.Latencies, s.Latencies...)
+}
+
+type latencies []int64
+
+func (l latencies) Len() int           { return len(l) }
+func (l latencies) Less(i, j int) bool { return l[i] < l[j] }
+func (l latencies) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+func (r *Result) sort() { sort.Sort(latencies(r.Latencies)) }
+
+// Throughput returns the number of requests answered per second.
+func (r *Result) Throughput() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Requests) * 1e9 / float64(r.Elapsed)
+}
+
+// Percentile returns the latency in nanoseconds that p percent of the
+// requests answered did not exceed, or zero if none were answered.
+func (r *Result) Percentile(p float64) int64 {
+	n := len(r.Latencies)
+	if n == 0 {
+		return 0
+	}
+	i := int(p/100*float64(n)+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= n {
+		i = n - 1
+	}
+	return r.Latencies[i]
+}
+
+// String summarizes the result on one line, in the manner of the
+// statistics logged by a Server.
+func (r *Result) String() string {
+	return fmt.Sprintf("%d req, %d err in %dms: %.0f req/s; latency p50 %dµs, p90 %dµs, p99 %dµs, max %dµs",
+		r.Requests, r.Errors, r.Elapsed/1e6, r.Throughput(),
+		r.Percentile(50)/1e3, r.Percentile(90)/1e3, r.Percentile(99)/1e3, r.Percentile(100)/1e3)
+}
@@ -0,0 +1,34 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+)
+
+var ErrUnauthorized = NewError(CodeUnauthorized, "rpc: unauthorized")
+
+// An Authorizer decides whether the caller described by ctx may call
+// serviceMethod. A non-nil error refuses the call and is sent back to the
+// client in place of the reply.
+type Authorizer func(serviceMethod string, ctx *Context) os.Error
+
+// SetAuthorizer installs auth to be consulted before every call is
+// dispatched. A nil auth allows all calls.
+func (server *Server) SetAuthorizer(auth Authorizer) {
+	server.Lock()
+	defer server.Unlock()
+	server.authorize = auth
+}
+
+func (server *Server) authorizeCall(serviceMethod string, ctx *Context) os.Error {
+	server.Lock()
+	auth := server.authorize
+	server.Unlock()
+	if auth == nil {
+		return nil
+	}
+	return auth(serviceMethod, ctx)
+}
@@ -0,0 +1,65 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrTimeout = NewError(CodeTimeout, "rpc: call deadline exceeded")
+
+// Context carries per-call state to methods that ask for it. A method
+// receives a Context if it is declared with one as its first argument:
+//
+//	func (t *T) MethodName(ctx *rpc.Context, argType T1, replyType *T2) os.Error
+//
+// Long-running methods should watch Done and return early once it fires,
+// since the server has already answered the call with an error by then.
+type Context struct {
+	lk       sync.Mutex
+	caller   map[string]interface{}
+	deadline int64 // Absolute deadline in nanoseconds, or zero for none
+	done     chan int
+	err      os.Error
+}
+
+func newContext(req *Request) *Context {
+	ctx := &Context{caller: req.Caller, done: make(chan int)}
+	if req.Timeout > 0 {
+		ctx.deadline = time.Nanoseconds() + req.Timeout
+	}
+	return ctx
+}
+
+// Caller returns the caller data attached to the request by the codec
+// under key, or nil if there is none. The keys in use depend on the codec.
+func (ctx *Context) Caller(key string) interface{} { return ctx.caller[key] }
+
+// Deadline returns the time, in nanoseconds since the epoch, by which the
+// call must complete, or zero if the call has no deadline.
+func (ctx *Context) Deadline() int64 { return ctx.deadline }
+
+// Done returns a channel that is closed when the call is abandoned.
+func (ctx *Context) Done() <-chan int { return ctx.done }
+
+// Err returns the reason the call was abandoned, or nil if it was not.
+func (ctx *Context) Err() os.Error {
+	ctx.lk.Lock()
+	defer ctx.lk.Unlock()
+	return ctx.err
+}
+
+// cancel abandons the call with err. Only the first call has any effect.
+func (ctx *Context) cancel(err os.Error) {
+	ctx.lk.Lock()
+	defer ctx.lk.Unlock()
+	if ctx.err != nil {
+		return
+	}
+	ctx.err = err
+	close(ctx.done)
+}
@@ -0,0 +1,124 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// MethodInfo describes a registered method.
+type MethodInfo struct {
+	Name      string // name of the method
+	ArgType   string // type of the argument, e.g. "*arith.Args"
+	ReplyType string // type of the reply, e.g. "*arith.Reply"
+	Context   bool   // whether the method takes a *Context
+	Stream    bool   // whether the method replies through a *Stream
+}
+
+// ServiceInfo describes a registered service and its methods.
+type ServiceInfo struct {
+	Name    string
+	Methods []MethodInfo // sorted by name
+}
+
+type serviceInfos []ServiceInfo
+
+func (s serviceInfos) Len() int           { return len(s) }
+func (s serviceInfos) Less(i, j int) bool { return s[i].Name < s[j].Name }
+func (s serviceInfos) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+type methodInfos []MethodInfo
+
+func (m methodInfos) Len() int           { return len(m) }
+func (m methodInfos) Less(i, j int) bool { return m[i].Name < m[j].Name }
+func (m methodInfos) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+func (s *service) info() ServiceInfo {
+	si := ServiceInfo{Name: s.name}
+	for mname, mtype := range s.method {
+		si.Methods = append(si.Methods, MethodInfo{
+			Name:      mname,
+			ArgType:   mtype.ArgType.String(),
+			ReplyType: mtype.ReplyType.String(),
+			Context:   mtype.hasCtx,
+			Stream:    mtype.stream,
+		})
+	}
+	sort.Sort(methodInfos(si.Methods))
+	return si
+}
+
+// Services returns a description of the registered services, sorted by name.
+func (server *Server) Services() []ServiceInfo {
+	server.Lock()
+	defer server.Unlock()
+	var ss []ServiceInfo
+	for _, s := range server.serviceMap {
+		ss = append(ss, s.info())
+	}
+	sort.Sort(serviceInfos(ss))
+	return ss
+}
+
+// HasMethod returns true if serviceMethod, of the form "Service.Method",
+// names a registered method.
+func (server *Server) HasMethod(serviceMethod string) bool {
+	return server.lookupMethod(serviceMethod) != nil
+}
+
+// MethodType returns the argument and reply types of the registered
+// method serviceMethod, of the form "Service.Method".
+func (server *Server) MethodType(serviceMethod string) (argType, replyType reflect.Type, ok bool) {
+	mtype := server.lookupMethod(serviceMethod)
+	if mtype == nil {
+		return nil, nil, false
+	}
+	return mtype.ArgType, mtype.ReplyType, true
+}
+
+func (server *Server) lookupMethod(serviceMethod string) *methodType {
+	sm := strings.Split(serviceMethod, ".")
+	if len(sm) != 2 {
+		return nil
+	}
+	server.Lock()
+	defer server.Unlock()
+	s := server.serviceMap[sm[0]]
+	if s == nil {
+		return nil
+	}
+	return s.method[sm[1]]
+}
+
+// DescribeArgs is the argument of the built-in rpc.Describe method.
+type DescribeArgs struct {
+	Service string // If non-empty, only this service is described
+}
+
+type describer struct {
+	server *Server
+}
+
+func (d *describer) Describe(args *DescribeArgs, reply *[]ServiceInfo) os.Error {
+	for _, si := range d.server.Services() {
+		if args.Service == "" || args.Service == si.Name {
+			*reply = append(*reply, si)
+		}
+	}
+	return nil
+}
+
+// DescribeName is the name of the built-in service installed by EnableDescribe.
+const DescribeName = "rpc"
+
+// EnableDescribe registers the built-in method "rpc.Describe", which
+// replies with the ServiceInfo of every registered service, so that
+// clients and tooling can discover the server's API.
+func (server *Server) EnableDescribe() os.Error {
+	return server.RegisterName(DescribeName, &describer{server})
+}
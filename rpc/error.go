@@ -0,0 +1,75 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"os"
+)
+
+// ErrorCode classifies the errors returned to clients, so that they can
+// tell failures apart without parsing messages. Codecs for transports with
+// their own error vocabulary, such as HTTP, map codes to it.
+type ErrorCode int
+
+const (
+	CodeUnknown         ErrorCode = iota // error returned by a method without a code
+	CodeInvalidArgument                  // the arguments of the call are not acceptable
+	CodeNotFound                         // an entity the call refers to does not exist
+	CodeUnauthorized                     // the caller may not make the call
+	CodeTimeout                          // the call did not complete before its deadline
+	CodeOverload                         // the server refused the call to protect itself
+	CodeInternal                         // the server failed while carrying out the call
+	CodeNoMethod                         // the service or method does not exist
+	CodeBadRequest                       // the request could not be decoded
+)
+
+var codeNames = map[ErrorCode]string{
+	CodeUnknown:         "unknown",
+	CodeInvalidArgument: "invalid argument",
+	CodeNotFound:        "not found",
+	CodeUnauthorized:    "unauthorized",
+	CodeTimeout:         "timeout",
+	CodeOverload:        "overload",
+	CodeInternal:        "internal",
+	CodeNoMethod:        "no method",
+	CodeBadRequest:      "bad request",
+}
+
+func (code ErrorCode) String() string {
+	if s, ok := codeNames[code]; ok {
+		return s
+	}
+	return fmt.Sprintf("code %d", int(code))
+}
+
+// Error is an error carrying an ErrorCode. Methods return an *Error to let
+// the client know the kind of failure; any other error is reported to the
+// client with CodeUnknown.
+type Error struct {
+	Code    ErrorCode
+	Message string
+}
+
+// NewError returns an *Error with the given code and message.
+func NewError(code ErrorCode, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Errorf returns an *Error with the given code and a message formatted
+// in the manner of fmt.Sprintf.
+func Errorf(code ErrorCode, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+func (e *Error) String() string { return e.Message }
+
+// CodeOf returns the code of err, which is CodeUnknown unless err is an *Error.
+func CodeOf(err os.Error) ErrorCode {
+	if e, ok := err.(*Error); ok {
+		return e.Code
+	}
+	return CodeUnknown
+}
@@ -0,0 +1,57 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+)
+
+// Call describes a method invocation in progress. It is handed to
+// interceptors, which may inspect it, or modify Args before the call
+// and Reply after it. Args and Reply may also be replaced by values of
+// the same type: the method is called with those in the Call when the
+// innermost interceptor invokes it, and the Reply left in the Call is
+// sent. The reply of a streaming method cannot be replaced.
+type Call struct {
+	ServiceMethod string   // format: "Service.Method"
+	Service       string   // name of the service
+	Method        string   // name of the method
+	Context       *Context // context of the call
+	Args          interface{}
+	Reply         interface{}
+}
+
+// An Invoker carries out a call and returns the error of the method.
+type Invoker func(call *Call) os.Error
+
+// An Interceptor wraps the invocation of registered methods. It should
+// call invoke to proceed with the call, and return its error, possibly
+// translated. An interceptor may also refuse the call by returning an
+// error without calling invoke.
+type Interceptor func(call *Call, invoke Invoker) os.Error
+
+// Use appends ic to the chain of interceptors wrapped around every
+// method invocation. Interceptors run in the order they were added,
+// the first one being the outermost.
+func (server *Server) Use(ic Interceptor) {
+	server.Lock()
+	defer server.Unlock()
+	chain := make([]Interceptor, len(server.interceptors)+1)
+	copy(chain, server.interceptors)
+	chain[len(chain)-1] = ic
+	server.interceptors = chain
+}
+
+// intercept wraps invoke in the current chain of interceptors.
+func (server *Server) intercept(invoke Invoker) Invoker {
+	server.Lock()
+	chain := server.interceptors
+	server.Unlock()
+	for i := len(chain) - 1; i >= 0; i-- {
+		ic, inner := chain[i], invoke
+		invoke = func(call *Call) os.Error { return ic(call, inner) }
+	}
+	return invoke
+}
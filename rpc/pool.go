@@ -0,0 +1,75 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"sync"
+)
+
+var ErrOverload = NewError(CodeOverload, "rpc: server overloaded")
+
+// callPool bounds the number of calls that run at once, as well as the
+// number of calls waiting for their turn.
+type callPool struct {
+	lk       sync.Mutex // protects pending
+	pending  int        // calls admitted, running or waiting
+	maxQueue int
+	slots    chan int // holds a token for every running call
+}
+
+func newCallPool(maxCalls, maxQueue int) *callPool {
+	return &callPool{
+		maxQueue: maxQueue,
+		slots:    make(chan int, maxCalls),
+	}
+}
+
+// admit reserves room for a call, or returns ErrOverload if the pool and
+// its queue are full. It does not block.
+func (p *callPool) admit() os.Error {
+	p.lk.Lock()
+	defer p.lk.Unlock()
+	if p.pending >= cap(p.slots)+p.maxQueue {
+		return ErrOverload
+	}
+	p.pending++
+	return nil
+}
+
+// start blocks until an admitted call may run.
+func (p *callPool) start() { p.slots <- 1 }
+
+// end releases the slot of a call that has finished running.
+func (p *callPool) end() {
+	<-p.slots
+	p.lk.Lock()
+	p.pending--
+	p.lk.Unlock()
+}
+
+// SetMaxCalls limits the number of calls that run at once to maxCalls.
+// Up to maxQueue further calls wait for a free slot, while calls beyond
+// that are refused with ErrOverload. A slot is held until the method
+// returns, even if the call has timed out. SetMaxCalls must be called
+// before the server starts serving. A zero maxCalls removes the limit.
+func (server *Server) SetMaxCalls(maxCalls, maxQueue int) {
+	if maxCalls < 0 || maxQueue < 0 {
+		panic("rpc: bad call limits")
+	}
+	server.Lock()
+	defer server.Unlock()
+	if maxCalls == 0 {
+		server.pool = nil
+		return
+	}
+	server.pool = newCallPool(maxCalls, maxQueue)
+}
+
+func (server *Server) getPool() *callPool {
+	server.Lock()
+	defer server.Unlock()
+	return server.pool
+}
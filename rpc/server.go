@@ -0,0 +1,790 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+/*
+	Package rpc is the server side of Go's rpc package, forked so that
+	the GoHTTP RPC subs can extend the dispatch path.
+
+	Methods are published exactly as with Go's rpc package. They must look
+	schematically like
+
+		func (t *T) MethodName(argType T1, replyType *T2) os.Error
+
+	or, if the method wants to observe the deadline of the call,
+
+		func (t *T) MethodName(ctx *rpc.Context, argType T1, replyType *T2) os.Error
+
+	Besides the service method and the sequence number, the Request header
+	carries an optional Timeout. When a call outlives its timeout, the server
+	cancels the call's Context and answers the client with ErrTimeout, without
+	waiting for the method to return.
+*/
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"gob"
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"utf8"
+)
+
+var ErrPanic = NewError(CodeInternal, "rpc: internal error")
+
+// ErrInterceptedType is returned for calls whose interceptors replaced
+// the arguments or the reply with a value of the wrong type.
+var ErrInterceptedType = NewError(CodeInternal, "rpc: interceptor changed the type of arguments or reply")
+
+// Precompute the reflect types for os.Error, *Context and *Stream. Can't use os.Error directly
+// because Typeof takes an empty interface value.  This is annoying.
+var unusedError *os.Error
+var typeOfOsError = reflect.TypeOf(unusedError).Elem()
+var typeOfContext = reflect.TypeOf((*Context)(nil))
+var typeOfStream = reflect.TypeOf((*Stream)(nil))
+
+type methodType struct {
+	sync.Mutex   // protects counters
+	method       reflect.Method
+	hasCtx       bool // whether the method takes a *Context first argument
+	stream       bool // whether the method replies through a *Stream
+	ArgType      reflect.Type
+	ReplyType    reflect.Type
+	numCalls     uint
+	numCompleted uint
+	numErrors    uint
+	totalTime    int64 // nanoseconds spent in completed calls
+	maxTime      int64 // duration of the longest call
+}
+
+type service struct {
+	name   string                 // name of service
+	rcvr   reflect.Value          // receiver of methods for the service
+	typ    reflect.Type           // type of the receiver
+	method map[string]*methodType // registered methods
+	calls  sync.WaitGroup         // calls in flight
+}
+
+// Request is a header written before every RPC call.  It is used internally
+// but documented here as an aid to debugging, such as when analyzing
+// network traffic.
+type Request struct {
+	ServiceMethod string   // format: "Service.Method"
+	Seq           uint64   // sequence number chosen by client
+	Timeout       int64    // nanoseconds the call may take; zero means no deadline
+	next          *Request // for free list in Server
+
+	// Caller holds data identifying the caller, which codecs extract from
+	// the underlying transport (e.g. HTTP cookies) for the Authorizer and
+	// for methods that take a *Context. It is never sent on the wire.
+	Caller map[string]interface{}
+}
+
+// Response is a header written before every RPC return.  It is used internally
+// but documented here as an aid to debugging, such as when analyzing
+// network traffic.
+type Response struct {
+	ServiceMethod string    // echoes that of the Request
+	Seq           uint64    // echoes that of the request
+	Error         string    // error, if any.
+	Code          ErrorCode // kind of error, if Error is set
+	next          *Response // for free list in Server
+}
+
+// Server represents an RPC Server.
+type Server struct {
+	sync.Mutex   // protects the serviceMap and interceptors
+	serviceMap   map[string]*service
+	interceptors []Interceptor
+	authorize    Authorizer
+	pool         *callPool // bounds concurrent calls, if non-nil
+	streamWindow int
+	reqLock      sync.Mutex // protects freeReq
+	freeReq      *Request
+	respLock     sync.Mutex // protects freeResp
+	freeResp     *Response
+}
+
+// NewServer returns a new Server.
+func NewServer() *Server {
+	return &Server{serviceMap: make(map[string]*service)}
+}
+
+// DefaultServer is the default instance of *Server.
+var DefaultServer = NewServer()
+
+// Is this an exported - upper case - name?
+func isExported(name string) bool {
+	rune, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(rune)
+}
+
+// Is this type exported or a builtin?
+func isExportedOrBuiltinType(t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	// PkgPath will be non-empty even for an exported type,
+	// so we need to check the type name as well.
+	return isExported(t.Name()) || t.PkgPath() == ""
+}
+
+// Register publishes in the server the set of methods of the
+// receiver value that satisfy the following conditions: the method is
+// exported, it has two arguments, both pointers to exported structs and
+// optionally preceded by a *Context, and it has one return value, of type
+// os.Error.
+// It returns an error if the receiver is not an exported type or has no
+// suitable methods.
+// The client accesses each method using a string of the form "Type.Method",
+// where Type is the receiver's concrete type.
+func (server *Server) Register(rcvr interface{}) os.Error {
+	return server.register(rcvr, "", false)
+}
+
+// RegisterName is like Register but uses the provided name for the type
+// instead of the receiver's concrete type.
+func (server *Server) RegisterName(name string, rcvr interface{}) os.Error {
+	return server.register(rcvr, name, true)
+}
+
+// UnregisterName removes the named service from the server. Calls already
+// dispatched to the service are allowed to complete: UnregisterName waits
+// for them before returning, so the caller may then dispose of the receiver.
+func (server *Server) UnregisterName(name string) os.Error {
+	server.Lock()
+	s, present := server.serviceMap[name]
+	if !present {
+		server.Unlock()
+		return os.NewError("rpc: service not defined: " + name)
+	}
+	server.serviceMap[name] = nil, false
+	server.Unlock()
+	s.calls.Wait()
+	return nil
+}
+
+// ReplaceName atomically replaces the receiver of the named service with
+// rcvr, or registers it anew if there is no such service. New calls are
+// dispatched to rcvr right away, while ReplaceName waits for the calls in
+// flight on the old receiver to complete. The name must not be empty.
+func (server *Server) ReplaceName(name string, rcvr interface{}) os.Error {
+	if name == "" {
+		return os.NewError("rpc: no service name")
+	}
+	s, err := newService(rcvr, name, true)
+	if err != nil {
+		return err
+	}
+	server.Lock()
+	if server.serviceMap == nil {
+		server.serviceMap = make(map[string]*service)
+	}
+	old := server.serviceMap[name]
+	server.serviceMap[name] = s
+	server.Unlock()
+	if old != nil {
+		old.calls.Wait()
+	}
+	return nil
+}
+
+func (server *Server) register(rcvr interface{}, name string, useName bool) os.Error {
+	s, err := newService(rcvr, name, useName)
+	if err != nil {
+		return err
+	}
+	server.Lock()
+	defer server.Unlock()
+	if server.serviceMap == nil {
+		server.serviceMap = make(map[string]*service)
+	}
+	if _, present := server.serviceMap[s.name]; present {
+		return os.NewError("rpc: service already defined: " + s.name)
+	}
+	server.serviceMap[s.name] = s
+	return nil
+}
+
+func newService(rcvr interface{}, name string, useName bool) (*service, os.Error) {
+	s := new(service)
+	s.typ = reflect.TypeOf(rcvr)
+	s.rcvr = reflect.ValueOf(rcvr)
+	sname := reflect.Indirect(s.rcvr).Type().Name()
+	if useName {
+		sname = name
+	}
+	if sname == "" {
+		log.Fatal("rpc: no service name for type", s.typ.String())
+	}
+	if !isExported(sname) && !useName {
+		s := "rpc Register: type " + sname + " is not exported"
+		log.Print(s)
+		return nil, os.NewError(s)
+	}
+	s.name = sname
+	s.method = make(map[string]*methodType)
+
+	// Install the methods
+	for m := 0; m < s.typ.NumMethod(); m++ {
+		method := s.typ.Method(m)
+		mtype := method.Type
+		mname := method.Name
+		if method.PkgPath != "" {
+			continue
+		}
+		// Method needs three ins: receiver, *args, *reply,
+		// or four if the first argument is a *Context.
+		hasCtx := mtype.NumIn() == 4 && mtype.In(1) == typeOfContext
+		if mtype.NumIn() != 3 && !hasCtx {
+			log.Println("method", mname, "has wrong number of ins:", mtype.NumIn())
+			continue
+		}
+		first := 1
+		if hasCtx {
+			first = 2
+		}
+		// First arg need not be a pointer.
+		argType := mtype.In(first)
+		if !isExportedOrBuiltinType(argType) {
+			log.Println(mname, "argument type not exported or local:", argType)
+			continue
+		}
+		// Second arg must be a pointer.
+		replyType := mtype.In(first + 1)
+		if replyType.Kind() != reflect.Ptr {
+			log.Println("method", mname, "reply type not a pointer:", replyType)
+			continue
+		}
+		if !isExportedOrBuiltinType(replyType) {
+			log.Println("method", mname, "reply type not exported or local:", replyType)
+			continue
+		}
+		// Method needs one out: os.Error.
+		if mtype.NumOut() != 1 {
+			log.Println("method", mname, "has wrong number of outs:", mtype.NumOut())
+			continue
+		}
+		if returnType := mtype.Out(0); returnType != typeOfOsError {
+			log.Println("method", mname, "returns", returnType.String(), "not os.Error")
+			continue
+		}
+		s.method[mname] = &methodType{
+			method:    method,
+			hasCtx:    hasCtx,
+			stream:    replyType == typeOfStream,
+			ArgType:   argType,
+			ReplyType: replyType,
+		}
+	}
+
+	if len(s.method) == 0 {
+		s := "rpc Register: type " + sname + " has no exported methods of suitable type"
+		log.Print(s)
+		return nil, os.NewError(s)
+	}
+	return s, nil
+}
+
+// A value sent as a placeholder for the response when the server receives an invalid request.
+type InvalidRequest struct{}
+
+var invalidRequest = InvalidRequest{}
+
+func (server *Server) sendResponse(sending *sync.Mutex, req *Request, reply interface{}, codec ServerCodec, callErr os.Error) {
+	resp := server.getResponse()
+	// Encode the response header
+	resp.ServiceMethod = req.ServiceMethod
+	if callErr != nil {
+		resp.Error = callErr.String()
+		resp.Code = CodeOf(callErr)
+		reply = invalidRequest
+	}
+	resp.Seq = req.Seq
+	sending.Lock()
+	err := codec.WriteResponse(resp, reply)
+	if err != nil {
+		log.Println("rpc: writing response:", err)
+	}
+	sending.Unlock()
+	server.freeResponse(resp)
+}
+
+func (m *methodType) NumCalls() (n uint) {
+	m.Lock()
+	n = m.numCalls
+	m.Unlock()
+	return n
+}
+
+// record accounts for a completed call that took d nanoseconds.
+func (m *methodType) record(d int64, failed bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.numCompleted++
+	if failed {
+		m.numErrors++
+	}
+	m.totalTime += d
+	if d > m.maxTime {
+		m.maxTime = d
+	}
+}
+
+// invoke calls the method through the server's interceptors, with the
+// arguments and reply of the call as the interceptors leave them, and
+// returns the reply to send and the error of the method, if any.
+func (s *service) invoke(server *Server, mtype *methodType, ctx *Context, argv, replyv reflect.Value) (interface{}, os.Error) {
+	call := &Call{
+		ServiceMethod: s.name + "." + mtype.method.Name,
+		Service:       s.name,
+		Method:        mtype.method.Name,
+		Context:       ctx,
+		Args:          argv.Interface(),
+		Reply:         replyv.Interface(),
+	}
+	invoke := server.intercept(func(call *Call) os.Error {
+		argv := reflect.ValueOf(call.Args)
+		if !argv.IsValid() || argv.Type() != mtype.ArgType {
+			return ErrInterceptedType
+		}
+		if !mtype.stream {
+			// The stream of a call has been started; it cannot be replaced
+			replyv = reflect.ValueOf(call.Reply)
+			if !replyv.IsValid() || replyv.Type() != mtype.ReplyType {
+				return ErrInterceptedType
+			}
+		}
+		return s.callMethod(mtype, ctx, argv, replyv)
+	})
+	err := invoke(call)
+	return call.Reply, err
+}
+
+// callMethod calls the method. A panic inside the method is logged
+// and reported to the client as ErrPanic.
+func (s *service) callMethod(mtype *methodType, ctx *Context, argv, replyv reflect.Value) (err os.Error) {
+	defer func() {
+		e := recover()
+		if e == nil {
+			return
+		}
+		var buf bytes.Buffer
+		fmt.Fprintf(&buf, "rpc: panic serving %s.%s: %v\n", s.name, mtype.method.Name, e)
+		buf.Write(debug.Stack())
+		log.Print(buf.String())
+		err = ErrPanic
+	}()
+	function := mtype.method.Func
+	var in []reflect.Value
+	if mtype.hasCtx {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	} else {
+		in = []reflect.Value{s.rcvr, argv, replyv}
+	}
+	returnValues := function.Call(in)
+	// The return value for the method is an os.Error.
+	errInter := returnValues[0].Interface()
+	if errInter != nil {
+		return errInter.(os.Error)
+	}
+	return nil
+}
+
+func (s *service) call(server *Server, pool *callPool, sending *sync.Mutex, mtype *methodType, req *Request, argv, replyv reflect.Value, codec ServerCodec) {
+	if pool != nil {
+		pool.start()
+	}
+	done := func() {
+		s.calls.Done()
+		if pool != nil {
+			pool.end()
+		}
+	}
+	ctx := newContext(req)
+	if err := server.authorizeCall(req.ServiceMethod, ctx); err != nil {
+		done()
+		server.sendResponse(sending, req, invalidRequest, codec, err)
+		server.releaseRequest(codec, req)
+		return
+	}
+	mtype.Lock()
+	mtype.numCalls++
+	mtype.Unlock()
+	reply := replyv.Interface()
+	var st *Stream
+	if mtype.stream {
+		sc, ok := codec.(StreamServerCodec)
+		if !ok {
+			done()
+			server.sendResponse(sending, req, invalidRequest, codec, ErrNoStream)
+			server.releaseRequest(codec, req)
+			return
+		}
+		st = reply.(*Stream)
+		st.start(sc, sending, req, ctx, server.getStreamWindow())
+		// The items have been sent by the time the call concludes
+		reply = nil
+	}
+	run := func() os.Error {
+		defer done()
+		t0 := time.Nanoseconds()
+		r, err := s.invoke(server, mtype, ctx, argv, replyv)
+		mtype.record(time.Nanoseconds()-t0, err != nil)
+		if st != nil {
+			st.finish()
+		} else {
+			reply = r
+		}
+		return err
+	}
+
+	if req.Timeout <= 0 {
+		err := run()
+		server.sendResponse(sending, req, reply, codec, err)
+		server.releaseRequest(codec, req)
+		return
+	}
+
+	// Run the method on the side, so that we can answer the
+	// client as soon as the deadline expires.
+	result := make(chan os.Error, 1)
+	go func() {
+		result <- run()
+	}()
+	timer := time.NewTimer(req.Timeout)
+	select {
+	case err := <-result:
+		timer.Stop()
+		server.sendResponse(sending, req, reply, codec, err)
+		server.releaseRequest(codec, req)
+	case <-timer.C:
+		ctx.cancel(ErrTimeout)
+		server.sendResponse(sending, req, invalidRequest, codec, ErrTimeout)
+		// The method may still be using the request and its resources
+		go func() {
+			<-result
+			server.releaseRequest(codec, req)
+		}()
+	}
+}
+
+type gobServerCodec struct {
+	rwc    io.ReadWriteCloser
+	dec    *gob.Decoder
+	enc    *gob.Encoder
+	encBuf *bufio.Writer
+}
+
+func (c *gobServerCodec) ReadRequestHeader(r *Request) os.Error {
+	return c.dec.Decode(r)
+}
+
+func (c *gobServerCodec) ReadRequestBody(body interface{}) os.Error {
+	return c.dec.Decode(body)
+}
+
+func (c *gobServerCodec) WriteResponse(r *Response, body interface{}) (err os.Error) {
+	if err = c.enc.Encode(r); err != nil {
+		return
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return
+	}
+	return c.encBuf.Flush()
+}
+
+func (c *gobServerCodec) Close() os.Error {
+	return c.rwc.Close()
+}
+
+// ServeConn runs the server on a single connection.
+// ServeConn blocks, serving the connection until the client hangs up.
+// The caller typically invokes ServeConn in a go statement.
+// ServeConn uses the gob wire format (see package gob) on the
+// connection.  To use an alternate codec, use ServeCodec.
+func (server *Server) ServeConn(conn io.ReadWriteCloser) {
+	buf := bufio.NewWriter(conn)
+	srv := &gobServerCodec{conn, gob.NewDecoder(conn), gob.NewEncoder(buf), buf}
+	server.ServeCodec(srv)
+}
+
+// ServeCodec is like ServeConn but uses the specified codec to
+// decode requests and encode responses.
+func (server *Server) ServeCodec(codec ServerCodec) {
+	sending := new(sync.Mutex)
+	for {
+		service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
+		if err != nil {
+			if err != os.EOF {
+				log.Println("rpc:", err)
+			}
+			if !keepReading {
+				break
+			}
+			// send a response if we actually managed to read a header.
+			if req != nil {
+				server.sendResponse(sending, req, invalidRequest, codec, err)
+				server.releaseRequest(codec, req)
+			}
+			continue
+		}
+		pool, err := server.admitCall(service)
+		if err != nil {
+			server.sendResponse(sending, req, invalidRequest, codec, err)
+			server.releaseRequest(codec, req)
+			continue
+		}
+		go service.call(server, pool, sending, mtype, req, argv, replyv, codec)
+	}
+	codec.Close()
+}
+
+// admitCall reserves room for a call in the server's pool, if any.
+// If the pool is full, the call is dropped from the service's accounting.
+func (server *Server) admitCall(s *service) (*callPool, os.Error) {
+	pool := server.getPool()
+	if pool == nil {
+		return nil, nil
+	}
+	if err := pool.admit(); err != nil {
+		s.calls.Done()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// ServeRequest is like ServeCodec but synchronously serves a single request.
+// It does not close the codec upon completion.
+func (server *Server) ServeRequest(codec ServerCodec) os.Error {
+	sending := new(sync.Mutex)
+	service, mtype, req, argv, replyv, keepReading, err := server.readRequest(codec)
+	if err != nil {
+		if !keepReading {
+			return err
+		}
+		// send a response if we actually managed to read a header.
+		if req != nil {
+			server.sendResponse(sending, req, invalidRequest, codec, err)
+			server.releaseRequest(codec, req)
+		}
+		return err
+	}
+	pool, err := server.admitCall(service)
+	if err != nil {
+		server.sendResponse(sending, req, invalidRequest, codec, err)
+		server.releaseRequest(codec, req)
+		return err
+	}
+	service.call(server, pool, sending, mtype, req, argv, replyv, codec)
+	return nil
+}
+
+func (server *Server) getRequest() *Request {
+	server.reqLock.Lock()
+	req := server.freeReq
+	if req == nil {
+		req = new(Request)
+	} else {
+		server.freeReq = req.next
+		*req = Request{}
+	}
+	server.reqLock.Unlock()
+	return req
+}
+
+// releaseRequest releases the resources codec holds for req, if any, and
+// frees req.
+func (server *Server) releaseRequest(codec ServerCodec, req *Request) {
+	if cr, ok := codec.(CallReleaser); ok {
+		cr.ReleaseCall(req)
+	}
+	server.freeRequest(req)
+}
+
+func (server *Server) freeRequest(req *Request) {
+	server.reqLock.Lock()
+	req.next = server.freeReq
+	server.freeReq = req
+	server.reqLock.Unlock()
+}
+
+func (server *Server) getResponse() *Response {
+	server.respLock.Lock()
+	resp := server.freeResp
+	if resp == nil {
+		resp = new(Response)
+	} else {
+		server.freeResp = resp.next
+		*resp = Response{}
+	}
+	server.respLock.Unlock()
+	return resp
+}
+
+func (server *Server) freeResponse(resp *Response) {
+	server.respLock.Lock()
+	resp.next = server.freeResp
+	server.freeResp = resp
+	server.respLock.Unlock()
+}
+
+func (server *Server) readRequest(codec ServerCodec) (service *service, mtype *methodType, req *Request, argv, replyv reflect.Value, keepReading bool, err os.Error) {
+	service, mtype, req, keepReading, err = server.readRequestHeader(codec)
+	if err != nil {
+		if !keepReading {
+			return
+		}
+		// discard body
+		codec.ReadRequestBody(nil)
+		return
+	}
+
+	// Decode the argument value.
+	argIsValue := false // if true, need to indirect before calling.
+	if mtype.ArgType.Kind() == reflect.Ptr {
+		argv = reflect.New(mtype.ArgType.Elem())
+	} else {
+		argv = reflect.New(mtype.ArgType)
+		argIsValue = true
+	}
+	// argv guaranteed to be a pointer now.
+	if err = codec.ReadRequestBody(argv.Interface()); err != nil {
+		service.calls.Done()
+		if _, ok := err.(*Error); !ok {
+			err = NewError(CodeBadRequest, "rpc: server cannot decode arguments: "+err.String())
+		}
+		return
+	}
+	if argIsValue {
+		argv = argv.Elem()
+	}
+
+	replyv = reflect.New(mtype.ReplyType.Elem())
+	return
+}
+
+func (server *Server) readRequestHeader(codec ServerCodec) (service *service, mtype *methodType, req *Request, keepReading bool, err os.Error) {
+	// Grab the request header.
+	req = server.getRequest()
+	err = codec.ReadRequestHeader(req)
+	if err != nil {
+		req = nil
+		if err == os.EOF || err == io.ErrUnexpectedEOF {
+			return
+		}
+		err = NewError(CodeBadRequest, "rpc: server cannot decode request: "+err.String())
+		return
+	}
+
+	// We read the header successfully.  If we see an error now,
+	// we can still recover and move on to the next request.
+	keepReading = true
+
+	serviceMethod := strings.Split(req.ServiceMethod, ".")
+	if len(serviceMethod) != 2 {
+		err = NewError(CodeNoMethod, "rpc: service/method request ill-formed: "+req.ServiceMethod)
+		return
+	}
+	// Look up the request. The call is accounted for while the server is
+	// locked, so that UnregisterName and ReplaceName cannot miss it.
+	server.Lock()
+	defer server.Unlock()
+	service = server.serviceMap[serviceMethod[0]]
+	if service == nil {
+		err = NewError(CodeNoMethod, "rpc: can't find service "+req.ServiceMethod)
+		return
+	}
+	mtype = service.method[serviceMethod[1]]
+	if mtype == nil {
+		err = NewError(CodeNoMethod, "rpc: can't find method "+req.ServiceMethod)
+		return
+	}
+	service.calls.Add(1)
+	return
+}
+
+// Accept accepts connections on the listener and serves requests
+// for each incoming connection.  Accept blocks; the caller typically
+// invokes it in a go statement.
+func (server *Server) Accept(lis net.Listener) {
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			log.Fatal("rpc.Serve: accept:", err.String()) // TODO(r): exit?
+		}
+		go server.ServeConn(conn)
+	}
+}
+
+// Register publishes the receiver's methods in the DefaultServer.
+func Register(rcvr interface{}) os.Error { return DefaultServer.Register(rcvr) }
+
+// RegisterName is like Register but uses the provided name for the type
+// instead of the receiver's concrete type.
+func RegisterName(name string, rcvr interface{}) os.Error {
+	return DefaultServer.RegisterName(name, rcvr)
+}
+
+// A ServerCodec implements reading of RPC requests and writing of
+// RPC responses for the server side of an RPC session.
+// The server calls ReadRequestHeader and ReadRequestBody in pairs
+// to read requests from the connection, and it calls WriteResponse to
+// write a response back.  The server calls Close when finished with the
+// connection. ReadRequestBody may be called with a nil
+// argument to force the body of the request to be read and discarded.
+// WriteResponse may be called concurrently with reads, and even after
+// ServeCodec has returned, if a call completes late.
+type ServerCodec interface {
+	ReadRequestHeader(*Request) os.Error
+	ReadRequestBody(interface{}) os.Error
+	WriteResponse(*Response, interface{}) os.Error
+
+	Close() os.Error
+}
+
+// A CallReleaser is a ServerCodec that holds resources for the calls it
+// reads, such as uploaded files, which methods may use until they return.
+// The server calls ReleaseCall once it is done with req and its method has
+// returned, which is after the response is written, unless the call timed
+// out, in which case the method may outlive the response.
+type CallReleaser interface {
+	ServerCodec
+	ReleaseCall(req *Request)
+}
+
+// ServeConn runs the DefaultServer on a single connection.
+// ServeConn blocks, serving the connection until the client hangs up.
+// The caller typically invokes ServeConn in a go statement.
+// ServeConn uses the gob wire format (see package gob) on the
+// connection.  To use an alternate codec, use ServeCodec.
+func ServeConn(conn io.ReadWriteCloser) {
+	DefaultServer.ServeConn(conn)
+}
+
+// ServeCodec is like ServeConn but uses the specified codec to
+// decode requests and encode responses.
+func ServeCodec(codec ServerCodec) {
+	DefaultServer.ServeCodec(codec)
+}
+
+// ServeRequest is like ServeCodec but synchronously serves a single request.
+// It does not close the codec upon completion.
+func ServeRequest(codec ServerCodec) os.Error {
+	return DefaultServer.ServeRequest(codec)
+}
+
+// Accept accepts connections on the listener and serves requests
+// to DefaultServer for each incoming connection.
+// Accept blocks; the caller typically invokes it in a go statement.
+func Accept(lis net.Listener) { DefaultServer.Accept(lis) }
@@ -0,0 +1,388 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+type Args struct {
+	A, B int
+}
+
+type Reply struct {
+	C int
+}
+
+type Arith int
+
+func (t *Arith) Add(args *Args, reply *Reply) os.Error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (t *Arith) Count(args *Args, stream *Stream) os.Error {
+	for i := args.A; i < args.B; i++ {
+		if err := stream.Send(&Reply{i}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *Arith) Panic(args *Args, reply *Reply) os.Error {
+	panic("boom")
+}
+
+func (t *Arith) Sleep(ctx *Context, args *Args, reply *Reply) os.Error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// testCodec serves a single request and records the response.
+type testCodec struct {
+	req     Request
+	args    Args
+	done    bool
+	resp    chan *Response
+	replies chan interface{}
+}
+
+func newTestCodec(serviceMethod string, timeout int64, args Args) *testCodec {
+	return &testCodec{
+		req:     Request{ServiceMethod: serviceMethod, Seq: 1, Timeout: timeout},
+		args:    args,
+		resp:    make(chan *Response, 1),
+		replies: make(chan interface{}, 1),
+	}
+}
+
+func (c *testCodec) ReadRequestHeader(r *Request) os.Error {
+	if c.done {
+		return os.EOF
+	}
+	c.done = true
+	*r = c.req
+	return nil
+}
+
+func (c *testCodec) ReadRequestBody(body interface{}) os.Error {
+	if body != nil {
+		*body.(*Args) = c.args
+	}
+	return nil
+}
+
+func (c *testCodec) WriteResponse(r *Response, body interface{}) os.Error {
+	resp := *r
+	c.resp <- &resp
+	c.replies <- body
+	return nil
+}
+
+func (c *testCodec) Close() os.Error { return nil }
+
+func newTestServer(t *testing.T) *Server {
+	server := NewServer()
+	if err := server.Register(new(Arith)); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	return server
+}
+
+func TestServeRequest(t *testing.T) {
+	server := newTestServer(t)
+	codec := newTestCodec("Arith.Add", 0, Args{7, 8})
+	if err := server.ServeRequest(codec); err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+	resp := <-codec.resp
+	reply := (<-codec.replies).(*Reply)
+	if resp.Error != "" || reply.C != 15 {
+		t.Errorf("expected 15, got %d (%q)", reply.C, resp.Error)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	server := newTestServer(t)
+	codec := newTestCodec("Arith.Sleep", 1e7, Args{})
+	t0 := time.Nanoseconds()
+	server.ServeCodec(codec)
+	resp := <-codec.resp
+	if resp.Error != ErrTimeout.String() {
+		t.Errorf("expected timeout, got %q", resp.Error)
+	}
+	if time.Nanoseconds()-t0 > 1e9 {
+		t.Errorf("timeout took too long")
+	}
+}
+
+// releaseCodec is a testCodec that reports the release of its call.
+type releaseCodec struct {
+	*testCodec
+	released chan bool
+}
+
+func (c *releaseCodec) ReleaseCall(req *Request) { c.released <- true }
+
+// Slow serves calls that outlive their deadline.
+type Slow struct {
+	unlinger chan bool
+}
+
+// Linger does not return before unlinger is closed, whatever its deadline.
+func (t *Slow) Linger(args *Args, reply *Reply) os.Error {
+	<-t.unlinger
+	return nil
+}
+
+func TestReleaseAfterTimeout(t *testing.T) {
+	server := NewServer()
+	slow := &Slow{make(chan bool)}
+	if err := server.Register(slow); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	codec := &releaseCodec{newTestCodec("Slow.Linger", 1e7, Args{}), make(chan bool, 1)}
+	server.ServeCodec(codec)
+	if resp := <-codec.resp; resp.Error != ErrTimeout.String() {
+		t.Errorf("expected timeout, got %q", resp.Error)
+	}
+	select {
+	case <-codec.released:
+		t.Errorf("call released while its method runs")
+	default:
+	}
+	close(slow.unlinger)
+	select {
+	case <-codec.released:
+	case <-time.After(1e9):
+		t.Errorf("call not released after its method returned")
+	}
+}
+
+func TestInterceptors(t *testing.T) {
+	server := newTestServer(t)
+	var trace []string
+	server.Use(func(call *Call, invoke Invoker) os.Error {
+		trace = append(trace, "outer:"+call.ServiceMethod)
+		return invoke(call)
+	})
+	server.Use(func(call *Call, invoke Invoker) os.Error {
+		trace = append(trace, "inner:"+call.Method)
+		if call.Args.(*Args).A < 0 {
+			return os.NewError("negative")
+		}
+		return invoke(call)
+	})
+	codec := newTestCodec("Arith.Add", 0, Args{-1, 2})
+	server.ServeRequest(codec)
+	resp := <-codec.resp
+	if resp.Error != "negative" {
+		t.Errorf("expected interceptor error, got %q", resp.Error)
+	}
+	if len(trace) != 2 || trace[0] != "outer:Arith.Add" || trace[1] != "inner:Add" {
+		t.Errorf("bad interceptor trace %v", trace)
+	}
+}
+
+func TestInterceptorReplace(t *testing.T) {
+	server := newTestServer(t)
+	server.Use(func(call *Call, invoke Invoker) os.Error {
+		call.Args = &Args{call.Args.(*Args).A * 10, 1}
+		if err := invoke(call); err != nil {
+			return err
+		}
+		call.Reply = &Reply{call.Reply.(*Reply).C + 1}
+		return nil
+	})
+	codec := newTestCodec("Arith.Add", 0, Args{4, 2})
+	server.ServeRequest(codec)
+	resp := <-codec.resp
+	reply := (<-codec.replies).(*Reply)
+	if resp.Error != "" || reply.C != 42 {
+		t.Errorf("expected 42, got %d (%q)", reply.C, resp.Error)
+	}
+
+	server = newTestServer(t)
+	server.Use(func(call *Call, invoke Invoker) os.Error {
+		call.Args = Args{1, 2}
+		return invoke(call)
+	})
+	codec = newTestCodec("Arith.Add", 0, Args{1, 2})
+	server.ServeRequest(codec)
+	if resp := <-codec.resp; resp.Error != ErrInterceptedType.String() {
+		t.Errorf("expected %q, got %q", ErrInterceptedType, resp.Error)
+	}
+}
+
+// streamCodec is a testCodec that also accepts streamed items.
+type streamCodec struct {
+	*testCodec
+	items []int
+}
+
+func (c *streamCodec) WriteStreamResponse(r *Response, body interface{}) os.Error {
+	c.items = append(c.items, body.(*Reply).C)
+	return nil
+}
+
+func TestStream(t *testing.T) {
+	server := newTestServer(t)
+	server.SetStreamWindow(2)
+	codec := &streamCodec{testCodec: newTestCodec("Arith.Count", 0, Args{3, 8})}
+	if err := server.ServeRequest(codec); err != nil {
+		t.Fatalf("serve: %s", err)
+	}
+	if resp := <-codec.resp; resp.Error != "" {
+		t.Fatalf("stream: %s", resp.Error)
+	}
+	if len(codec.items) != 5 || codec.items[0] != 3 || codec.items[4] != 7 {
+		t.Errorf("bad stream items %v", codec.items)
+	}
+
+	// A codec without streaming support rejects the call
+	plain := newTestCodec("Arith.Count", 0, Args{3, 8})
+	server.ServeRequest(plain)
+	if resp := <-plain.resp; resp.Error != ErrNoStream.String() {
+		t.Errorf("expected %q, got %q", ErrNoStream, resp.Error)
+	}
+}
+
+func TestServices(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.EnableDescribe(); err != nil {
+		t.Fatalf("describe: %s", err)
+	}
+	ss := server.Services()
+	if len(ss) != 2 || ss[0].Name != "Arith" || ss[1].Name != DescribeName {
+		t.Fatalf("bad services %v", ss)
+	}
+	m := ss[0].Methods
+	if len(m) != 5 || m[0].Name != "Add" || m[0].ArgType != "*rpc.Args" || !m[1].Stream || !m[4].Context {
+		t.Errorf("bad methods %v", m)
+	}
+}
+
+type Arith2 int
+
+func (t *Arith2) Add(args *Args, reply *Reply) os.Error {
+	reply.C = 2 * (args.A + args.B)
+	return nil
+}
+
+func TestReplaceName(t *testing.T) {
+	server := newTestServer(t)
+	if err := server.ReplaceName("Arith", new(Arith2)); err != nil {
+		t.Fatalf("replace: %s", err)
+	}
+	codec := newTestCodec("Arith.Add", 0, Args{1, 2})
+	server.ServeRequest(codec)
+	<-codec.resp
+	if reply := (<-codec.replies).(*Reply); reply.C != 6 {
+		t.Errorf("expected replaced service, got %d", reply.C)
+	}
+	if err := server.UnregisterName("Arith"); err != nil {
+		t.Fatalf("unregister: %s", err)
+	}
+	if err := server.UnregisterName("Arith"); err == nil {
+		t.Errorf("expected error unregistering twice")
+	}
+	codec = newTestCodec("Arith.Add", 0, Args{1, 2})
+	server.ServeRequest(codec)
+	if resp := <-codec.resp; resp.Error == "" {
+		t.Errorf("expected error calling unregistered service")
+	}
+	if err := server.ReplaceName("", new(Arith)); err == nil {
+		t.Errorf("expected error replacing a service without a name")
+	}
+}
+
+func TestMethodStats(t *testing.T) {
+	server := newTestServer(t)
+	for _, a := range []int{1, 2, 3} {
+		codec := newTestCodec("Arith.Add", 0, Args{a, a})
+		server.ServeRequest(codec)
+		<-codec.resp
+	}
+	for _, ms := range server.MethodStats() {
+		if ms.ServiceMethod != "Arith.Add" {
+			continue
+		}
+		if ms.Calls != 3 || ms.Errors != 0 || ms.MaxTime < ms.AvgTime() {
+			t.Errorf("bad stats %v", ms)
+		}
+		return
+	}
+	t.Errorf("no stats for Arith.Add")
+}
+
+func TestAuthorizer(t *testing.T) {
+	server := newTestServer(t)
+	server.SetAuthorizer(func(serviceMethod string, ctx *Context) os.Error {
+		if ctx.Caller("user") != "root" {
+			return ErrUnauthorized
+		}
+		return nil
+	})
+	codec := newTestCodec("Arith.Add", 0, Args{1, 2})
+	server.ServeRequest(codec)
+	if resp := <-codec.resp; resp.Error != ErrUnauthorized.String() {
+		t.Errorf("expected unauthorized, got %q", resp.Error)
+	}
+	codec = newTestCodec("Arith.Add", 0, Args{1, 2})
+	codec.req.Caller = map[string]interface{}{"user": "root"}
+	server.ServeRequest(codec)
+	if resp := <-codec.resp; resp.Error != "" {
+		t.Errorf("expected authorized call, got %q", resp.Error)
+	}
+}
+
+func TestCallPool(t *testing.T) {
+	p := newCallPool(1, 1)
+	if p.admit() != nil || p.admit() != nil {
+		t.Fatalf("expected two admissions")
+	}
+	if p.admit() != ErrOverload {
+		t.Errorf("expected overload")
+	}
+	p.start()
+	p.end()
+	if p.admit() != nil {
+		t.Errorf("expected admission after a call ended")
+	}
+}
+
+func TestPanic(t *testing.T) {
+	server := newTestServer(t)
+	codec := newTestCodec("Arith.Panic", 0, Args{})
+	server.ServeCodec(codec)
+	if resp := <-codec.resp; resp.Error != ErrPanic.String() {
+		t.Errorf("expected %q, got %q", ErrPanic, resp.Error)
+	}
+}
+
+func (t *Arith) Div(args *Args, reply *Reply) os.Error {
+	if args.B == 0 {
+		return NewError(CodeInvalidArgument, "divide by zero")
+	}
+	reply.C = args.A / args.B
+	return nil
+}
+
+func TestErrorCode(t *testing.T) {
+	server := newTestServer(t)
+	codec := newTestCodec("Arith.Div", 0, Args{1, 0})
+	server.ServeRequest(codec)
+	if resp := <-codec.resp; resp.Code != CodeInvalidArgument || resp.Error != "divide by zero" {
+		t.Errorf("bad error response %v", resp)
+	}
+	codec = newTestCodec("Arith.Mul", 0, Args{1, 0})
+	server.ServeRequest(codec)
+	if resp := <-codec.resp; resp.Code != CodeNoMethod {
+		t.Errorf("expected %s, got %s", CodeNoMethod, resp.Code)
+	}
+}
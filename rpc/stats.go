@@ -0,0 +1,65 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"sort"
+)
+
+// MethodStats holds the call statistics of a registered method.
+// Calls counts calls dispatched to the method, while the remaining
+// fields cover only calls that have completed.
+type MethodStats struct {
+	ServiceMethod string // format: "Service.Method"
+	Calls         uint   // Number of calls dispatched
+	Errors        uint   // Number of calls that returned an error
+	TotalTime     int64  // Time spent in completed calls, in nanoseconds
+	MaxTime       int64  // Duration of the longest call, in nanoseconds
+	completed     uint
+}
+
+// AvgTime returns the average duration of completed calls in nanoseconds.
+func (ms *MethodStats) AvgTime() int64 {
+	if ms.completed == 0 {
+		return 0
+	}
+	return ms.TotalTime / int64(ms.completed)
+}
+
+func (ms *MethodStats) String() string {
+	return fmt.Sprintf("%s: %d calls, %d errors, avg %dms, max %dms",
+		ms.ServiceMethod, ms.Calls, ms.Errors, ms.AvgTime()/1e6, ms.MaxTime/1e6)
+}
+
+type methodStats []MethodStats
+
+func (m methodStats) Len() int           { return len(m) }
+func (m methodStats) Less(i, j int) bool { return m[i].ServiceMethod < m[j].ServiceMethod }
+func (m methodStats) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+
+// MethodStats returns the call statistics of every registered method,
+// sorted by name.
+func (server *Server) MethodStats() []MethodStats {
+	server.Lock()
+	defer server.Unlock()
+	var ms []MethodStats
+	for _, s := range server.serviceMap {
+		for mname, mtype := range s.method {
+			mtype.Lock()
+			ms = append(ms, MethodStats{
+				ServiceMethod: s.name + "." + mname,
+				Calls:         mtype.numCalls,
+				Errors:        mtype.numErrors,
+				TotalTime:     mtype.totalTime,
+				MaxTime:       mtype.maxTime,
+				completed:     mtype.numCompleted,
+			})
+			mtype.Unlock()
+		}
+	}
+	sort.Sort(methodStats(ms))
+	return ms
+}
@@ -0,0 +1,131 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"sync"
+)
+
+var ErrNoStream = NewError(CodeBadRequest, "rpc: codec does not support streaming")
+
+// A StreamServerCodec is a ServerCodec that can deliver several responses
+// for a single request. The server calls WriteStreamResponse once for every
+// item sent by a streaming method, and then WriteResponse once with a nil
+// body to conclude the call.
+type StreamServerCodec interface {
+	ServerCodec
+	WriteStreamResponse(*Response, interface{}) os.Error
+}
+
+// DefaultStreamWindow is the number of items a streaming method may send
+// ahead of the connection, before Send blocks.
+const DefaultStreamWindow = 16
+
+// Stream is the reply argument of streaming methods. A method is streaming
+// if it is declared as
+//
+//	func (t *T) MethodName(argType T1, stream *rpc.Stream) os.Error
+//
+// (optionally with a leading *Context). Instead of filling in a reply, the
+// method calls Send for every item of the result. Items are written to the
+// connection in order, each in a response with the same sequence number.
+// Send blocks while the window of unwritten items is full, so a slow client
+// throttles the method instead of forcing the server to buffer.
+type Stream struct {
+	codec         StreamServerCodec
+	sending       *sync.Mutex
+	ctx           *Context
+	serviceMethod string
+	seq           uint64
+	items         chan interface{}
+	done          chan int
+
+	lk  sync.Mutex // protects err
+	err os.Error   // first write error
+}
+
+func (st *Stream) start(codec StreamServerCodec, sending *sync.Mutex, req *Request, ctx *Context, window int) {
+	st.codec = codec
+	st.sending = sending
+	st.ctx = ctx
+	st.serviceMethod = req.ServiceMethod
+	st.seq = req.Seq
+	st.items = make(chan interface{}, window)
+	st.done = make(chan int)
+	go st.writeLoop()
+}
+
+// Send queues v for delivery to the client. It returns an error if the
+// call has been abandoned or the connection has failed, in which case the
+// method should stop producing items and return.
+func (st *Stream) Send(v interface{}) os.Error {
+	if err := st.getErr(); err != nil {
+		return err
+	}
+	select {
+	case st.items <- v:
+		return nil
+	case <-st.ctx.Done():
+	}
+	return st.ctx.Err()
+}
+
+func (st *Stream) getErr() os.Error {
+	st.lk.Lock()
+	defer st.lk.Unlock()
+	return st.err
+}
+
+func (st *Stream) writeLoop() {
+	defer close(st.done)
+	for v := range st.items {
+		if st.ctx.Err() != nil || st.getErr() != nil {
+			// Drain the remaining items, so that Send does not block
+			continue
+		}
+		resp := &Response{ServiceMethod: st.serviceMethod, Seq: st.seq}
+		st.sending.Lock()
+		if st.ctx.Err() != nil {
+			// The call timed out while we waited, and its final response
+			// may have been written already
+			st.sending.Unlock()
+			continue
+		}
+		err := st.codec.WriteStreamResponse(resp, v)
+		st.sending.Unlock()
+		if err != nil {
+			st.lk.Lock()
+			st.err = err
+			st.lk.Unlock()
+		}
+	}
+}
+
+// finish waits until all items sent by the method have been written.
+func (st *Stream) finish() {
+	close(st.items)
+	<-st.done
+}
+
+// SetStreamWindow sets the number of items streaming methods may send
+// ahead of the connection. It affects calls started after it returns.
+func (server *Server) SetStreamWindow(window int) {
+	if window < 1 {
+		panic("rpc: bad stream window")
+	}
+	server.Lock()
+	defer server.Unlock()
+	server.streamWindow = window
+}
+
+func (server *Server) getStreamWindow() int {
+	server.Lock()
+	defer server.Unlock()
+	if server.streamWindow == 0 {
+		return DefaultStreamWindow
+	}
+	return server.streamWindow
+}
@@ -0,0 +1,256 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	ErrNotHijackable     = errors.New("server: response writer does not support hijacking")
+	ErrUnsupportedScheme = errors.New("server: unsupported URL scheme")
+)
+
+type serverHandler struct {
+	srv *Server
+}
+
+// Handler returns an http.Handler that serves requests with the
+// extensions and subs of srv, so that they can be mounted on another
+// http server, e.g. while an application moves over to this package.
+// Requests no sub serves are answered with a 404 response; they are not
+// returned by Read. A Server that is only used through its Handler can
+// be created with a nil listener.
+//
+// Each request is served as a Query, which is answered by writing the
+// response to the ResponseWriter of the request. The handler returns
+// once the query is answered, hijacked or buried. Hijacking, and
+// burying, which closes the connection, require the ResponseWriter to
+// implement http.Hijacker.
+func (srv *Server) Handler() http.Handler {
+	return &serverHandler{srv}
+}
+
+func (h *serverHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	srv := h.srv
+	srv.Lock()
+	srv.pending++
+	srv.Unlock()
+	srv.stats.IncRequest()
+	done := make(chan bool)
+	q := srv.pool.get()
+	q.Req = req
+	q.srv = srv
+	q.rw = w
+	q.done = done
+	q.origPath = req.URL.Path
+	q.t0 = time.Now().UnixNano()
+	if addr, err := net.ResolveTCPAddr("tcp", req.RemoteAddr); err == nil {
+		q.remote = addr
+	}
+	q.tls = req.TLS
+	q.hold = req.Method == "CONNECT"
+	if q = srv.process(q); q != nil {
+		q.ContinueAndWrite(http.NewResponse404(q.Req))
+		q.Done()
+	}
+	<-done
+}
+
+// writeResponse writes resp to w, as an http.Handler would.
+func writeResponse(w http.ResponseWriter, resp *http.Response) error {
+	h := w.Header()
+	for k, vv := range resp.Header {
+		h[k] = vv
+	}
+	if resp.ContentLength >= 0 && len(resp.TransferEncoding) == 0 {
+		h.Set("Content-Length", strconv.FormatInt(resp.ContentLength, 10))
+	}
+	if resp.Close {
+		h.Set("Connection", "close")
+	}
+	w.WriteHeader(resp.StatusCode)
+	if resp.Body == nil {
+		return nil
+	}
+	_, err := io.Copy(w, resp.Body)
+	return err
+}
+
+// hijackWriter hijacks the connection of w, and returns it along with a
+// reader of the data buffered from it.
+func hijackWriter(w http.ResponseWriter) (net.Conn, *bufio.Reader, error) {
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+	c, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, rw.Reader, nil
+}
+
+// closeWriter closes the connection of w, if w can be hijacked. Otherwise
+// the http server that called the handler finishes the response.
+func closeWriter(w http.ResponseWriter) {
+	if c, _, err := hijackWriter(w); err == nil {
+		c.Close()
+	}
+}
+
+// maxIdlePerHost is the number of idle connections a Transport keeps
+// open to each host.
+const maxIdlePerHost = 2
+
+// Transport is an http.RoundTripper that sends requests over
+// StampedClientConns, the client side of this package, so that code
+// written against http.Client can use them, e.g. while an application
+// moves over to this package. It is the counterpart of Handler.
+// Connections are kept open for reuse once the body of their response
+// has been read and closed. Only http URLs are supported. A Transport is
+// safe for concurrent use.
+type Transport struct {
+	// Dial connects to addr, of the form "host:port", on the network.
+	// If nil, net.Dial is used.
+	Dial func(network, addr string) (net.Conn, error)
+
+	lk   sync.Mutex
+	idle map[string][]*StampedClientConn // Idle connections, by address
+}
+
+func NewTransport() *Transport {
+	return &Transport{idle: make(map[string][]*StampedClientConn)}
+}
+
+// RoundTrip sends req on an idle connection to its host, or on a new one,
+// and returns the response. A request without a body that fails on an
+// idle connection, which the host may have closed meanwhile, is sent
+// again on a new one.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL == nil || req.URL.Scheme != "http" {
+		return nil, ErrUnsupportedScheme
+	}
+	addr := req.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "80")
+	}
+	if scc := t.getIdle(addr); scc != nil {
+		resp, err := t.send(addr, scc, req)
+		if err == nil || req.Body != nil {
+			return resp, err
+		}
+	}
+	scc, err := t.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	return t.send(addr, scc, req)
+}
+
+func (t *Transport) dial(addr string) (*StampedClientConn, error) {
+	dial := t.Dial
+	if dial == nil {
+		dial = net.Dial
+	}
+	c, err := dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewStampedClientConn(c, nil), nil
+}
+
+// send writes req to scc and reads the response. The connection is
+// handed back to t when the body of the response is closed, unless
+// either side asked for it to be closed.
+func (t *Transport) send(addr string, scc *StampedClientConn, req *http.Request) (*http.Response, error) {
+	if err := scc.Write(req); err != nil {
+		scc.Close()
+		return nil, err
+	}
+	resp, err := scc.Read(req)
+	keep := err == nil && !req.Close
+	if err != nil && (err != http.ErrPersistEOF || resp == nil) {
+		scc.Close()
+		return nil, err
+	}
+	if resp.Body == nil {
+		t.release(addr, scc, keep)
+		return resp, nil
+	}
+	resp.Body = &connBody{ReadCloser: resp.Body, t: t, addr: addr, scc: scc, keep: keep}
+	return resp, nil
+}
+
+// getIdle returns an idle connection to addr, or nil if there is none.
+func (t *Transport) getIdle(addr string) *StampedClientConn {
+	t.lk.Lock()
+	defer t.lk.Unlock()
+	conns := t.idle[addr]
+	if len(conns) == 0 {
+		return nil
+	}
+	scc := conns[len(conns)-1]
+	t.idle[addr] = conns[:len(conns)-1]
+	return scc
+}
+
+// release keeps scc as an idle connection to addr if keep is set and
+// there is room, and closes it otherwise.
+func (t *Transport) release(addr string, scc *StampedClientConn, keep bool) {
+	t.lk.Lock()
+	if keep && len(t.idle[addr]) < maxIdlePerHost {
+		if t.idle == nil {
+			t.idle = make(map[string][]*StampedClientConn)
+		}
+		t.idle[addr] = append(t.idle[addr], scc)
+		scc = nil
+	}
+	t.lk.Unlock()
+	if scc != nil {
+		scc.Close()
+	}
+}
+
+// CloseIdleConnections closes the connections that are not in use.
+func (t *Transport) CloseIdleConnections() {
+	t.lk.Lock()
+	idle := t.idle
+	t.idle = nil
+	t.lk.Unlock()
+	for _, conns := range idle {
+		for _, scc := range conns {
+			scc.Close()
+		}
+	}
+}
+
+// connBody is the body of a response read by a Transport, which releases
+// the connection of the response once it is closed.
+type connBody struct {
+	io.ReadCloser
+	t      *Transport
+	addr   string
+	scc    *StampedClientConn
+	keep   bool
+	closed bool
+}
+
+func (b *connBody) Close() error {
+	if b.closed {
+		return nil
+	}
+	b.closed = true
+	err := b.ReadCloser.Close()
+	b.t.release(b.addr, b.scc, b.keep && err == nil)
+	return err
+}
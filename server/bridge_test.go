@@ -0,0 +1,134 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	srv := NewServer(nil, Config{Timeout: 5e9}, 10)
+	srv.AddSub("/h", HandlerSub(testHandler{}))
+	srv.AddExt("fail", "/fail", failingExt{})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+	go http.Serve(l, srv.Handler())
+
+	// Requests go through the stock server, the Server and HandlerSub
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for _, tt := range handlerSubTests {
+		sendRequest(t, c, "GET", tt.path)
+		resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("%s: read response: %s", tt.path, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: read body: %s", tt.path, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.status == 200 && string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.path, body, tt.body)
+		}
+	}
+
+	c, err = net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br = bufio.NewReader(c)
+	sendRequest(t, c, "GET", "/none")
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("request served by no sub: got %v, %v, want a 404 response", resp, err)
+	}
+	ioutil.ReadAll(resp.Body)
+	sendRequest(t, c, "GET", "/fail")
+	if _, err := br.ReadByte(); err != io.EOF {
+		t.Errorf("read after failed extension: got %v, want %v", err, io.EOF)
+	}
+	if n := srv.Pending(); n != 0 {
+		t.Errorf("pending: got %d, want 0", n)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+	srv.AddSub("/h", HandlerSub(testHandler{}))
+	srv.Launch(2)
+	addr := c.RemoteAddr().String()
+
+	tr := NewTransport()
+	defer tr.CloseIdleConnections()
+	dials := 0
+	tr.Dial = func(network, addr string) (net.Conn, error) {
+		dials++
+		return net.Dial(network, addr)
+	}
+	client := &http.Client{Transport: tr}
+	for _, tt := range handlerSubTests {
+		resp, err := client.Get("http://" + addr + tt.path)
+		if err != nil {
+			t.Fatalf("%s: get: %s", tt.path, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: read body: %s", tt.path, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.status == 200 && string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.path, body, tt.body)
+		}
+	}
+	if dials >= len(handlerSubTests) {
+		t.Errorf("%d dials for %d requests, connections not reused", dials, len(handlerSubTests))
+	}
+
+	// Idle connections closed by the server, as by the hijacking
+	// handler, are replaced
+	for i := 0; i < 3; i++ {
+		sendTransport(t, tr, "http://"+addr+"/h/hijack")
+		sendTransport(t, tr, "http://"+addr+"/h/short")
+	}
+
+	req, _ := http.NewRequest("GET", "https://"+addr+"/h/short", nil)
+	if _, err := tr.RoundTrip(req); err != ErrUnsupportedScheme {
+		t.Errorf("https request: got %v, want %v", err, ErrUnsupportedScheme)
+	}
+}
+
+// sendTransport sends a GET request for url with tr, and discards the response.
+func sendTransport(t *testing.T, tr *Transport, url string) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("new request: %s", err)
+	}
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("%s: %s", url, err)
+	}
+	ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+}
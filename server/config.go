@@ -5,5 +5,9 @@
 package server
 
 type Config struct {
-	Timeout int64 // Keep-alive timeout in nanoseconds
+	Timeout     int64 // Keep-alive timeout in nanoseconds
+	Watchdog    int64 // Time in nanoseconds after which queries neither continued nor hijacked are logged; zero disables
+	ParkIdle    bool  // If true, idle keep-alive connections wait for requests without a goroutine, where supported
+	PoolQueries bool  // If true, queries released by Query.Done are reused for later requests
+	DebugPool   bool  // If true, released queries are not reused, and panic if used again
 }
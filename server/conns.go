@@ -0,0 +1,146 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// connShards is the number of shards of a connSet.
+const connShards = 16
+
+// wheelTicks is the number of ticks of a connSet per timeout.
+const wheelTicks = 8
+
+// connSet is a set of connections, split into shards with locks of their
+// own, so that connections accepted and closed at high rates contend on
+// different locks. Connections are assigned to shards in turn.
+//
+// Each shard schedules the expiration of its connections on a timer
+// wheel: a ring of slots, one per tick, holding the connections due to
+// expire in that tick. Connections are scheduled by their last activity
+// when added, and rescheduled when their slot comes due if they have
+// been active since, so each tick only visits the connections of one
+// slot, rather than all of them.
+type connSet struct {
+	timeout int64  // Nanoseconds of inactivity after which connections expire
+	tick    int64  // Nanoseconds per slot
+	done    int64  // Last tick expired, or zero before the first
+	next    uint32 // Number of connections added so far
+	shards  [connShards]connShard
+}
+
+type connShard struct {
+	sync.Mutex
+	conns map[*StampedServerConn]int // Slot of each connection
+	slots []map[*StampedServerConn]bool
+}
+
+func (s *connSet) init(timeout int64) {
+	s.timeout = timeout
+	s.tick = timeout / wheelTicks
+	if s.tick < 1 {
+		s.tick = 1
+	}
+	// A deadline is at most a timeout ahead, so the ring covers
+	// all of them without wrapping onto the tick being expired
+	n := int(timeout/s.tick) + 2
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.conns = make(map[*StampedServerConn]int)
+		sh.slots = make([]map[*StampedServerConn]bool, n)
+		for j := range sh.slots {
+			sh.slots[j] = make(map[*StampedServerConn]bool)
+		}
+	}
+}
+
+// add adds ssc to the set, which must not hold it already.
+func (s *connSet) add(ssc *StampedServerConn) {
+	ssc.shard = int(atomic.AddUint32(&s.next, 1) % connShards)
+	sh := &s.shards[ssc.shard]
+	sh.Lock()
+	defer sh.Unlock()
+	if _, present := sh.conns[ssc]; present {
+		panic("register twice")
+	}
+	s.schedule(sh, ssc, 0)
+}
+
+// schedule places ssc in the slot of the tick in which it expires, if it
+// remains inactive, or of tick after, if that is later. The shard must be
+// locked.
+func (s *connSet) schedule(sh *connShard, ssc *StampedServerConn, after int64) {
+	t := (ssc.GetStamp() + s.timeout) / s.tick
+	if t < after {
+		t = after
+	}
+	slot := int(t % int64(len(sh.slots)))
+	sh.conns[ssc] = slot
+	sh.slots[slot][ssc] = true
+}
+
+func (s *connSet) remove(ssc *StampedServerConn) {
+	sh := &s.shards[ssc.shard]
+	sh.Lock()
+	defer sh.Unlock()
+	if slot, present := sh.conns[ssc]; present {
+		delete(sh.slots[slot], ssc)
+		delete(sh.conns, ssc)
+	}
+}
+
+// expire removes the connections that have been inactive for a timeout
+// at time now, and returns them. Only one goroutine may call expire.
+func (s *connSet) expire(now int64) []*StampedServerConn {
+	last := now / s.tick
+	first := s.done + 1
+	if s.done == 0 || last-first >= int64(len(s.shards[0].slots)) {
+		// Every slot is due
+		first = last - int64(len(s.shards[0].slots)) + 1
+		if first < 0 {
+			first = 0
+		}
+	}
+	s.done = last
+	var kills []*StampedServerConn
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for t := first; t <= last; t++ {
+			due := sh.slots[int(t%int64(len(sh.slots)))]
+			for ssc := range due {
+				delete(due, ssc)
+				if now-ssc.GetStamp() >= s.timeout {
+					delete(sh.conns, ssc)
+					kills = append(kills, ssc)
+				} else {
+					s.schedule(sh, ssc, last+1)
+				}
+			}
+		}
+		sh.Unlock()
+	}
+	return kills
+}
+
+// removeAll empties the set, and returns the connections it held.
+func (s *connSet) removeAll() []*StampedServerConn {
+	var all []*StampedServerConn
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for ssc := range sh.conns {
+			all = append(all, ssc)
+		}
+		sh.conns = make(map[*StampedServerConn]int)
+		for j := range sh.slots {
+			sh.slots[j] = make(map[*StampedServerConn]bool)
+		}
+		sh.Unlock()
+	}
+	return all
+}
@@ -0,0 +1,70 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"testing"
+)
+
+func TestConnSet(t *testing.T) {
+	var s connSet
+	s.init(80)
+	conns := make([]*StampedServerConn, 2*connShards)
+	for i := range conns {
+		conns[i] = &StampedServerConn{stamp: int64(i)}
+		s.add(conns[i])
+	}
+	for i := 0; i < connShards; i++ {
+		s.remove(conns[i])
+	}
+	// Connections expire within a tick of their timeout
+	if n := len(s.expire(95)); n != 0 {
+		t.Errorf("expired early or removed connections: got %d, want 0", n)
+	}
+	kills := s.expire(100)
+	if len(kills) != 5 {
+		t.Fatalf("expire: got %d, want 5", len(kills))
+	}
+	for _, ssc := range kills {
+		if ssc.stamp > 20 {
+			t.Errorf("expired connection with stamp %d", ssc.stamp)
+		}
+	}
+	if n := len(s.removeAll()); n != connShards-5 {
+		t.Errorf("removeAll: got %d, want %d", n, connShards-5)
+	}
+	if n := len(s.removeAll()); n != 0 {
+		t.Errorf("removeAll of empty set: got %d, want 0", n)
+	}
+}
+
+func TestConnSetReschedule(t *testing.T) {
+	var s connSet
+	s.init(80)
+	idle := &StampedServerConn{stamp: 0}
+	busy := &StampedServerConn{stamp: 0}
+	s.add(idle)
+	s.add(busy)
+	if n := len(s.expire(70)); n != 0 {
+		t.Errorf("expired before timeout: got %d, want 0", n)
+	}
+	busy.stamp = 70
+	kills := s.expire(90)
+	if len(kills) != 1 || kills[0] != idle {
+		t.Errorf("expire: got %v, want the idle connection", kills)
+	}
+	if n := len(s.expire(140)); n != 0 {
+		t.Errorf("expired active connection early: got %d, want 0", n)
+	}
+	kills = s.expire(160)
+	if len(kills) != 1 || kills[0] != busy {
+		t.Errorf("expire: got %v, want the formerly active connection", kills)
+	}
+	// A late call catches up with every slot
+	s.add(&StampedServerConn{stamp: 200})
+	if n := len(s.expire(10000)); n != 1 {
+		t.Errorf("late expire: got %d, want 1", n)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+)
+
+// ErrorPage is the data error pages are rendered with.
+type ErrorPage struct {
+	StatusCode int
+	Status     string // Text of the status code, e.g. "Not Found"
+	Err        error  // Cause of the error, which may be nil
+	RequestID  string // ID of the request, or "" if none
+}
+
+// The built-in error page, in the style of the boilerplate responses
+var defaultErrorPage = template.Must(template.New("error").Parse(`<html>` +
+	`<head><title>{{.StatusCode}} {{.Status}}</title></head>
+<body bgcolor="white">
+<center><h1>{{.StatusCode}} {{.Status}}</h1></center>
+{{if .RequestID}}<center>Request ID: {{.RequestID}}</center>
+{{end}}<hr><center>Go HTTP package</center>
+</body></html>`))
+
+// SetErrorTemplate sets the template that Query.WriteError renders error
+// pages with, which is executed with an *ErrorPage. A nil template
+// restores the built-in page. Note that Err may reveal internals of the
+// application to clients.
+func (srv *Server) SetErrorTemplate(t *template.Template) {
+	srv.cfg.Lock()
+	defer srv.cfg.Unlock()
+	srv.errorTmpl = t
+}
+
+// SetRequestIDHeader names the request header, e.g. X-Request-Id, that
+// holds the ID of a request, as assigned by a proxy or load balancer in
+// front of the server. The ID is shown on error pages, and echoed in the
+// header of their responses.
+func (srv *Server) SetRequestIDHeader(name string) {
+	srv.cfg.Lock()
+	defer srv.cfg.Unlock()
+	srv.requestID = name
+}
+
+func (srv *Server) errorConfig() (*template.Template, string) {
+	srv.cfg.RLock()
+	defer srv.cfg.RUnlock()
+	return srv.errorTmpl, srv.requestID
+}
+
+// WriteError sends an error page with the given status, reporting err,
+// on the connection that produced the request, as Write does. The page
+// is rendered with the template set by SetErrorTemplate, or the built-in
+// page if there is none or it fails.
+func (q *Query) WriteError(status int, err error) error {
+	q.checkReleased()
+	if q.hijacked {
+		return ErrHijacked
+	}
+	if q.srv == nil {
+		return ErrClosed
+	}
+	// The request is gone once the query is answered
+	q.lk.Lock()
+	settled := q.settled
+	q.lk.Unlock()
+	if settled {
+		return ErrAnswered
+	}
+	tmpl, idHeader := q.srv.errorConfig()
+	page := &ErrorPage{StatusCode: status, Status: http.StatusText(status), Err: err}
+	if idHeader != "" {
+		page.RequestID = q.Req.Header.Get(idHeader)
+	}
+	var body bytes.Buffer
+	if tmpl != nil {
+		if terr := tmpl.Execute(&body, page); terr != nil {
+			log.Printf("Error page: %s\n", terr)
+			tmpl = nil
+			body.Reset()
+		}
+	}
+	if tmpl == nil {
+		defaultErrorPage.Execute(&body, page)
+	}
+	resp := http.NewResponseWithBytes(q.Req, body.Bytes())
+	resp.Status, resp.StatusCode = page.Status, status
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set("Cache-Control", "no-cache")
+	if page.RequestID != "" {
+		resp.Header.Set(idHeader, page.RequestID)
+	}
+	return q.Write(resp)
+}
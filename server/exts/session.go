@@ -5,19 +5,159 @@
 package exts
 
 import (
-	"path"
+	"crypto/rand"
+	"encoding/hex"
+	"os"
+	"sync"
+	"time"
 	"github.com/petar/GoHTTP/http"
-	"github.com/petar/GoHTTP/server"
 )
 
+const (
+	// SessionCookie is the name of the cookie that holds the session ID
+	SessionCookie = "session"
+
+	// SessionExt is the key under which the Session extension stores the
+	// *SessionData of a request, in the extension map of its Query
+	SessionExt = "session"
+
+	// DefaultSessionIdle is the idle timeout of sessions, in nanoseconds,
+	// unless set otherwise with SetIdleTimeout
+	DefaultSessionIdle = 24 * 3600e9
+)
+
+// SessionData holds the state of a single session.
+type SessionData struct {
+	ID     string // Session ID, as sent in the session cookie
+	UserID string // ID of the user the session belongs to, if any
+
+	lk     sync.Mutex
+	values map[string]interface{}
+	used   int64 // Time of the last lookup, in nanoseconds, guarded by the Session
+}
+
+// Get returns the session value stored under key, or nil.
+func (d *SessionData) Get(key string) interface{} {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	return d.values[key]
+}
+
+// Set stores value under key in the session.
+func (d *SessionData) Set(key string, value interface{}) {
+	d.lk.Lock()
+	defer d.lk.Unlock()
+	d.values[key] = value
+}
+
+// Cookie returns the cookie that binds a client to the session.
+func (d *SessionData) Cookie() *http.Cookie {
+	return &http.Cookie{Name: SessionCookie, Value: d.ID, Path: "/", HttpOnly: true}
+}
+
+// Session is an extension that recognizes the sessions of incoming
+// requests by their session cookie. Requests with a valid session
+// cookie have their *SessionData stored in the extension map under
+// SessionExt. Sessions are held in memory, until they are ended or
+// go unused for longer than the idle timeout.
 type Session struct {
+	sync.Mutex
+	sessions map[string]*SessionData
+	idle     int64 // Idle timeout in nanoseconds, or zero for none
+	swept    int64 // Time of the last sweep of idle sessions
 }
 
 func NewSession() *Session {
+	return &Session{
+		sessions: make(map[string]*SessionData),
+		idle:     DefaultSessionIdle,
+		swept:    time.Nanoseconds(),
+	}
+}
+
+// SetIdleTimeout sets how long, in nanoseconds, a session may go without
+// being looked up before it is discarded. Zero means sessions are kept
+// until End is called for them.
+func (s *Session) SetIdleTimeout(ns int64) {
+	s.Lock()
+	defer s.Unlock()
+	s.idle = ns
+}
+
+// New starts a session for userID. The caller is responsible for
+// sending the session cookie to the client.
+func (s *Session) New(userID string) (*SessionData, os.Error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	d := &SessionData{
+		ID:     hex.EncodeToString(b),
+		UserID: userID,
+		values: make(map[string]interface{}),
+	}
+	s.Lock()
+	defer s.Unlock()
+	now := time.Nanoseconds()
+	s.sweep(now)
+	d.used = now
+	s.sessions[d.ID] = d
+	return d, nil
+}
+
+// sweep discards the idle sessions, at most once per idle timeout, so
+// that sessions which are never looked up again do not pile up. The
+// Session must be locked.
+func (s *Session) sweep(now int64) {
+	if s.idle <= 0 || now-s.swept < s.idle {
+		return
+	}
+	s.swept = now
+	for id, d := range s.sessions {
+		if now-d.used > s.idle {
+			s.sessions[id] = nil, false
+		}
+	}
+}
+
+// Lookup returns the session with the given ID, or nil if there is no
+// such session or it has been idle for too long.
+func (s *Session) Lookup(id string) *SessionData {
+	s.Lock()
+	defer s.Unlock()
+	d := s.sessions[id]
+	if d == nil {
+		return nil
+	}
+	now := time.Nanoseconds()
+	if s.idle > 0 && now-d.used > s.idle {
+		s.sessions[id] = nil, false
+		return nil
+	}
+	d.used = now
+	return d
+}
+
+// End discards the session with the given ID.
+func (s *Session) End(id string) {
+	s.Lock()
+	defer s.Unlock()
+	s.sessions[id] = nil, false
 }
 
 func (s *Session) ReadRequest(req *http.Request, ext map[string]interface{}) os.Error {
+	for _, c := range req.Cookies() {
+		if c.Name != SessionCookie {
+			continue
+		}
+		if d := s.Lookup(c.Value); d != nil {
+			ext[SessionExt] = d
+			break
+		}
+	}
+	return nil
 }
 
 func (s *Session) WriteResponse(resp *http.Response, ext map[string]interface{}) os.Error {
+	return nil
 }
@@ -0,0 +1,201 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// handlerBufSize is the number of bytes a handler may write before its
+// response is streamed, rather than sent whole with a Content-Length.
+const handlerBufSize = 32 << 10
+
+var errHandlerPanic = errors.New("server: handler panicked")
+
+type handlerSub struct {
+	h http.Handler
+}
+
+// HandlerSub returns a Sub that serves queries with h, e.g. a ServeMux
+// or the handler of a web framework. The handler is given the request
+// with the path of the sub stripped, as other subs are, and a
+// ResponseWriter that writes to the query. Output is buffered, so that
+// short responses are sent with a Content-Length, until it grows past
+// 32KB or the handler flushes it; the response is then streamed with
+// chunked encoding, unless the handler set a Content-Length. The
+// ResponseWriter also implements Flusher and Hijacker.
+func HandlerSub(h http.Handler) Sub {
+	return &handlerSub{h}
+}
+
+func (hs *handlerSub) Serve(q *Query) {
+	w := &queryWriter{q: q, req: q.Req, header: make(http.Header)}
+	if w.req.RemoteAddr == "" && q.RemoteAddr() != nil {
+		w.req.RemoteAddr = q.RemoteAddr().String()
+	}
+	if w.req.TLS == nil {
+		w.req.TLS = q.TLS()
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			log.Printf("Handler %s: panic: %v\n", q.OrigPath(), err)
+			w.abort()
+			q.Done()
+		}
+	}()
+	hs.h.ServeHTTP(w, w.req)
+	w.finish()
+	q.Done()
+}
+
+// queryWriter is the ResponseWriter of a handler run by a HandlerSub.
+type queryWriter struct {
+	q        *Query
+	req      *http.Request
+	header   http.Header
+	status   int            // Status code, or zero before WriteHeader
+	buf      bytes.Buffer   // Output not sent yet
+	pw       *io.PipeWriter // Body of the response, once it is streamed
+	sent     chan error     // Result of writing the streamed response
+	hijacked bool
+}
+
+func (w *queryWriter) Header() http.Header { return w.header }
+
+func (w *queryWriter) WriteHeader(code int) {
+	if w.status != 0 {
+		log.Printf("Handler %s: multiple WriteHeader calls\n", w.q.OrigPath())
+		return
+	}
+	w.status = code
+}
+
+func (w *queryWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pw == nil && w.buf.Len()+len(p) > handlerBufSize {
+		w.stream()
+	}
+	if w.pw != nil {
+		return w.pw.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// Flush sends the response header and the output written so far, and
+// streams the rest of the response.
+func (w *queryWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.pw == nil {
+		w.stream()
+	}
+}
+
+// Hijack hijacks the query, unless its response has started streaming.
+func (w *queryWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.pw != nil {
+		return nil, nil, ErrAnswered
+	}
+	sc, err := w.q.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	c, r := sc.Hijack()
+	return c, bufio.NewReadWriter(r, bufio.NewWriter(c)), nil
+}
+
+// response returns a response with the status and header set by the
+// handler, and no body. The header is copied, since the response may be
+// written while the handler goes on changing its own.
+func (w *queryWriter) response() *http.Response {
+	if w.header.Get("Content-Type") == "" && w.buf.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.buf.Bytes()))
+	}
+	header := make(http.Header, len(w.header))
+	for k, vv := range w.header {
+		header[k] = append([]string(nil), vv...)
+	}
+	return &http.Response{
+		Status:     http.StatusText(w.status),
+		StatusCode: w.status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Request:    w.req,
+		Header:     header,
+	}
+}
+
+// stream writes the response with a body read from a pipe, in a
+// goroutine of its own, and sends the buffered output down the pipe.
+func (w *queryWriter) stream() {
+	resp := w.response()
+	pr, pw := io.Pipe()
+	resp.Body = pr
+	n, err := strconv.ParseInt(w.header.Get("Content-Length"), 10, 64)
+	if err == nil && n >= 0 {
+		resp.ContentLength = n
+	} else {
+		resp.ContentLength = -1
+		resp.TransferEncoding = []string{"chunked"}
+	}
+	w.pw = pw
+	w.sent = make(chan error, 1)
+	go func() {
+		w.sent <- w.q.ContinueAndWrite(resp)
+	}()
+	if w.buf.Len() > 0 {
+		pw.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+}
+
+// finish sends the response, once the handler has returned.
+func (w *queryWriter) finish() {
+	if w.hijacked {
+		return
+	}
+	if w.pw != nil {
+		w.pw.Close()
+		<-w.sent
+		return
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	resp := w.response()
+	resp.ContentLength = int64(w.buf.Len())
+	if w.buf.Len() > 0 {
+		resp.Body = ioutil.NopCloser(&w.buf)
+	}
+	w.q.ContinueAndWrite(resp)
+}
+
+// abort cuts a streamed response short, after the handler has panicked.
+// A response that has not been sent is left to Done.
+func (w *queryWriter) abort() {
+	if w.pw != nil {
+		w.pw.CloseWithError(errHandlerPanic)
+		<-w.sent
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+type testHandler struct{}
+
+func (testHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.URL.Path {
+	case "/short":
+		w.Header().Set("X-Path", req.URL.Path)
+		w.Write([]byte("hello"))
+	case "/missing":
+		w.WriteHeader(http.StatusNotFound)
+	case "/long":
+		for i := 0; i < 10; i++ {
+			w.Write([]byte(strings.Repeat("x", handlerBufSize/4)))
+		}
+	case "/flush":
+		w.Write([]byte("a"))
+		w.(http.Flusher).Flush()
+		// Changes to the header after a flush are not sent
+		w.Header().Set("X-Late", "1")
+		w.Write([]byte("b"))
+	case "/hijack":
+		c, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			panic(err)
+		}
+		rw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\n\r\nhijacked")
+		rw.Flush()
+		c.Close()
+	case "/panic":
+		panic("handler failed")
+	}
+}
+
+var handlerSubTests = []struct {
+	path    string
+	status  int
+	body    string
+	chunked bool
+}{
+	{"/h/short", 200, "hello", false},
+	{"/h/missing", 404, "", false},
+	{"/h/long", 200, strings.Repeat("x", 10*(handlerBufSize/4)), true},
+	{"/h/flush", 200, "ab", true},
+	{"/h/panic", 500, "", false},
+	{"/h/hijack", 200, "hijacked", false},
+}
+
+func TestHandlerSub(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+	srv.AddSub("/h", HandlerSub(testHandler{}))
+	srv.Launch(1)
+
+	br := bufio.NewReader(c)
+	for _, tt := range handlerSubTests {
+		sendRequest(t, c, "GET", tt.path)
+		req := &http.Request{Method: "GET"}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("%s: read response: %s", tt.path, err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: read body: %s", tt.path, err)
+		}
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.path, resp.StatusCode, tt.status)
+		}
+		if tt.status == 200 && string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.path, body, tt.body)
+		}
+		if v := resp.Header.Get("X-Late"); v != "" {
+			t.Errorf("%s: header changed after flush was sent", tt.path)
+		}
+		if chunked := len(resp.TransferEncoding) > 0; chunked != tt.chunked {
+			t.Errorf("%s: chunked %v, want %v", tt.path, chunked, tt.chunked)
+		}
+	}
+}
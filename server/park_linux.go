@@ -0,0 +1,143 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// parker waits for idle connections to become readable, using epoll, so
+// that a single goroutine waits for all of them.
+type parker struct {
+	sync.Mutex
+	epfd   int
+	fds    map[*StampedServerConn]int // File descriptor of each parked connection
+	conns  map[int]*StampedServerConn // Parked connection of each file descriptor
+	wake   func(ssc *StampedServerConn)
+	closed bool
+}
+
+// newParker returns a parker that calls wake with each parked connection
+// that becomes readable or is closed by its client.
+func newParker(wake func(ssc *StampedServerConn)) (*parker, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("epoll_create1", err)
+	}
+	p := &parker{
+		epfd:  epfd,
+		fds:   make(map[*StampedServerConn]int),
+		conns: make(map[int]*StampedServerConn),
+		wake:  wake,
+	}
+	go p.loop()
+	return p, nil
+}
+
+// park adds ssc to the connections waited for. The connection must
+// expose its file descriptor through syscall.Conn.
+func (p *parker) park(ssc *StampedServerConn) error {
+	sc, ok := ssc.conn.(syscall.Conn)
+	if !ok {
+		return errParkUnsupported
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.closed {
+		return errParkUnsupported
+	}
+	var cerr error
+	err = rc.Control(func(fd uintptr) {
+		ev := syscall.EpollEvent{
+			Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT,
+			Fd:     int32(fd),
+		}
+		if cerr = syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_ADD, int(fd), &ev); cerr != nil {
+			return
+		}
+		p.fds[ssc] = int(fd)
+		p.conns[int(fd)] = ssc
+	})
+	if err != nil {
+		return err
+	}
+	if cerr != nil {
+		return os.NewSyscallError("epoll_ctl", cerr)
+	}
+	return nil
+}
+
+// forget stops waiting for ssc, if it is parked. It must be called before
+// the connection is closed, since its file descriptor may be reused.
+func (p *parker) forget(ssc *StampedServerConn) {
+	p.Lock()
+	defer p.Unlock()
+	if fd, ok := p.fds[ssc]; ok {
+		p.remove(fd)
+	}
+}
+
+// remove stops waiting for the connection with file descriptor fd, and
+// returns it. The parker must be locked.
+func (p *parker) remove(fd int) *StampedServerConn {
+	ssc, ok := p.conns[fd]
+	if !ok {
+		return nil
+	}
+	syscall.EpollCtl(p.epfd, syscall.EPOLL_CTL_DEL, fd, nil)
+	delete(p.conns, fd)
+	delete(p.fds, ssc)
+	return ssc
+}
+
+// close stops the parker. Connections still parked are not woken.
+func (p *parker) close() {
+	p.Lock()
+	defer p.Unlock()
+	p.closed = true
+}
+
+// parkPollMs is how often, in milliseconds, the loop checks whether the
+// parker has been closed, since closing the epoll instance does not
+// interrupt a wait on it.
+const parkPollMs = 1000
+
+func (p *parker) loop() {
+	events := make([]syscall.EpollEvent, 128)
+	for {
+		n, err := syscall.EpollWait(p.epfd, events, parkPollMs)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			break
+		}
+		p.Lock()
+		if p.closed {
+			p.Unlock()
+			break
+		}
+		woken := make([]*StampedServerConn, 0, n)
+		for i := 0; i < n; i++ {
+			if ssc := p.remove(int(events[i].Fd)); ssc != nil {
+				woken = append(woken, ssc)
+			}
+		}
+		p.Unlock()
+		for _, ssc := range woken {
+			p.wake(ssc)
+		}
+	}
+	p.Lock()
+	p.closed = true
+	p.Unlock()
+	syscall.Close(p.epfd)
+}
@@ -0,0 +1,20 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package server
+
+// parker is not implemented on this platform, so idle connections keep
+// their goroutines.
+type parker struct{}
+
+func newParker(wake func(ssc *StampedServerConn)) (*parker, error) {
+	return nil, errParkUnsupported
+}
+
+func (p *parker) park(ssc *StampedServerConn) error { return errParkUnsupported }
+func (p *parker) forget(ssc *StampedServerConn)     {}
+func (p *parker) close()                            {}
@@ -0,0 +1,52 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+// queryPoolSize is the number of free Queries a queryPool keeps.
+const queryPoolSize = 1024
+
+// queryPool keeps Queries released by Done, along with their Ext maps,
+// so that they can be reused instead of allocated afresh for every
+// request. Queries released to a full pool are left to the garbage
+// collector. It is safe for concurrent use.
+type queryPool struct {
+	free  chan *Query
+	debug bool // If true, released queries are poisoned instead of kept
+}
+
+func newQueryPool(debug bool) *queryPool {
+	return &queryPool{free: make(chan *Query, queryPoolSize), debug: debug}
+}
+
+// get returns a Query, zeroed but for the Ext map it may hold for reuse.
+// A nil pool returns a new Query.
+func (p *queryPool) get() *Query {
+	if p != nil {
+		select {
+		case q := <-p.free:
+			return q
+		default:
+		}
+	}
+	return &Query{pool: p}
+}
+
+// put returns q to the pool, or poisons it in debug mode, so that any
+// later use of it panics.
+func (p *queryPool) put(q *Query) {
+	if p.debug {
+		q.released = true
+		return
+	}
+	m := q.extBuf
+	for k := range m {
+		delete(m, k)
+	}
+	*q = Query{pool: p, extBuf: m}
+	select {
+	case p.free <- q:
+	default:
+	}
+}
@@ -5,80 +5,307 @@
 package server
 
 import (
+	"crypto/tls"
+	"errors"
 	"io"
 	"log"
+	"net"
 	"strings"
+	"sync"
 	"time"
 	"net/http"
 	"net/http/httputil"
 )
 
+var (
+	ErrForwarded = errors.New("server: query already continued or hijacked")
+	ErrHijacked  = errors.New("server: connection hijacked")
+	ErrClosed    = errors.New("server: connection closed")
+	ErrAnswered  = errors.New("server: query already answered")
+)
+
 // Incoming requests are presented to the user as a Query object.
 // Query allows users to respond to a request or to hijack the
 // underlying ServerConn, which is typically needed for CONNECT
-// requests.
+// requests. Every query must end up answered, hijacked or with its
+// connection closed; Done ensures this for queries the user gives up on.
 type Query struct {
-	Req *http.Request
-	Ext map[string]interface{} // Extension-specific structures
+	Req  *http.Request
+	Ext  map[string]interface{} // Extension-specific structures
+	Vars map[string]string      // Variable segments of the URL path, as extracted by a router
 
 	origPath string
 	srv      *Server
 	ssc      *StampedServerConn
+	rw       http.ResponseWriter // Writer of the response, if the query is served by a Handler
+	done     chan bool           // Closed when the query settles, if it is served by a Handler
 	err      error
-	fwd      bool // If true, the user has already called either Continue() or Hijack()
+	lk       sync.Mutex // protects fwd, settled, watchdog and barked
+	fwd      bool       // If true, the user has already called either Continue() or Hijack()
 	hijacked bool
+	settled  bool        // If true, the query has been answered, hijacked or buried
+	hold     bool        // If true, Write does not imply Continue
+	watchdog *time.Timer // Reports the query if it is not forwarded in time
+	barked   bool        // If true, the watchdog fired, and may still be using the query
 
 	t0       int64 // Time request was received
+
+	pool     *queryPool             // Pool the query is released to by Done, or nil
+	extBuf   map[string]interface{} // Ext map kept for reuse by the pool
+	released bool                   // If true, the query has been released in debug mode
+
+	remote, local net.Addr
+	tls           *tls.ConnectionState
 }
 
 func newQueryErr(err error) *Query { return &Query{err: err} }
 
+// checkReleased panics if the query is used after it has been released
+// to a pool in debug mode.
+func (q *Query) checkReleased() {
+	if q.released {
+		panic("server: query used after release")
+	}
+}
+
+// OrigPath returns the URL path of the request as it was received,
+// before the prefix of the sub serving it was stripped.
+func (q *Query) OrigPath() string { return q.origPath }
+
 func (q *Query) getError() error { return q.err }
 
-// Continue() indicates to the Server that it can continue
+// RemoteAddr returns the network address of the client that sent the
+// request. It remains available after the connection is hijacked.
+func (q *Query) RemoteAddr() net.Addr { return q.remote }
+
+// LocalAddr returns the network address the request was received on.
+func (q *Query) LocalAddr() net.Addr { return q.local }
+
+// TLS returns the state of the TLS connection the request was received
+// on, or nil if the connection does not use TLS.
+func (q *Query) TLS() *tls.ConnectionState { return q.tls }
+
+// Negotiate returns the media type, among offers, that best matches the
+// Accept header of the request, or "" if none is acceptable. Offers are
+// listed in order of the preference of the server, which decides ties.
+func (q *Query) Negotiate(offers ...string) string {
+	return q.Req.Header.Negotiate("Accept", offers...)
+}
+
+// NegotiateEncoding is like Negotiate, for the content codings in the
+// Accept-Encoding header, e.g. "gzip" or "identity".
+func (q *Query) NegotiateEncoding(offers ...string) string {
+	return q.Req.Header.Negotiate("Accept-Encoding", offers...)
+}
+
+// NegotiateLanguage is like Negotiate, for the language tags in the
+// Accept-Language header, e.g. "en-US".
+func (q *Query) NegotiateLanguage(offers ...string) string {
+	return q.Req.Header.Negotiate("Accept-Language", offers...)
+}
+
+// Continue indicates to the Server that it can continue
 // listening for incoming requests on the ServerConn that
 // delivered the request underlying this Query object.
-// For every query returned by Server.Read(), the user must
-// call either Continue() or Hijack(), but not both, exactly once.
-func (q *Query) Continue() {
+// Write implies Continue, unless the query is held, so Continue need
+// only be called explicitly to read the next request before responding,
+// e.g. while a response is being computed.
+// Continue returns ErrForwarded if the query has already been continued
+// or hijacked, and ErrClosed if its connection has been closed.
+func (q *Query) Continue() error {
+	q.checkReleased()
+	q.lk.Lock()
+	defer q.lk.Unlock()
 	if q.fwd {
-		panic("continue/hijack")
+		return ErrForwarded
 	}
-	q.fwd = true
 	if q.srv == nil {
-		panic("query zombie") // XXX: To be removed when issue 1563 fixed
+		return ErrClosed
+	}
+	q.fwd = true
+	q.stopWatchdog()
+	if q.rw == nil {
+		q.srv.readNext(q.ssc)
 	}
-	go q.srv.read(q.ssc)
+	return nil
 }
 
-// Hijack() instructs the Server to stop managing the ServerConn
+// Hijack instructs the Server to stop managing the ServerConn
 // that delivered the request underlying this Query. The connection is returned
 // and the user becomes responsible for it.
-// For every query returned by Server.Read(), the user must
-// call either Continue() or Hijack(), but not both, and only once.
-func (q *Query) Hijack() *httputil.ServerConn {
+// Hijack returns ErrForwarded if the query has already been continued
+// or hijacked, and ErrClosed if its connection has been closed.
+func (q *Query) Hijack() (*httputil.ServerConn, error) {
+	q.checkReleased()
+	q.lk.Lock()
+	defer q.lk.Unlock()
 	if q.fwd {
-		panic("continue and hijack")
+		return nil, ErrForwarded
+	}
+	if q.srv == nil {
+		return nil, ErrClosed
+	}
+	var sc *httputil.ServerConn
+	if q.rw != nil {
+		c, r, err := hijackWriter(q.rw)
+		if err != nil {
+			return nil, err
+		}
+		sc = http.NewServerConn(c, r)
+	} else {
+		sc = q.ssc.ServerConn
+		q.srv.unregister(q.ssc)
 	}
 	q.fwd = true
 	q.hijacked = true
-	srv := q.srv
+	q.settleLocked()
 	q.srv = nil
-	ssc := q.ssc
 	q.ssc = nil
-	srv.unregister(ssc)
-	return ssc.ServerConn
+	return sc, nil
 }
 
-// Write sends resp back on the connection that produced the request.
+// Done declares that the user is finished with the query. If the query
+// has been neither answered nor hijacked by then, Done answers it with
+// a 500 response or, if it is held and not continued, closes its
+// connection, since the connection would otherwise stall for good.
+// Done is safe to call more than once, so handlers can simply defer it,
+// unless the server pools queries (see Config.PoolQueries). Done then
+// releases the query for reuse, and the query must not be used
+// afterwards, not even by another call to Done.
+func (q *Query) Done() {
+	q.checkReleased()
+	q.lk.Lock()
+	settled, stalled := q.settled, q.hold && !q.fwd
+	q.lk.Unlock()
+	switch {
+	case settled || q.srv == nil:
+	case stalled:
+		q.bury()
+	default:
+		log.Printf("Query %s done without a response\n", q.origPath)
+		q.Write(http.NewResponse500(q.Req))
+	}
+	q.release()
+}
+
+// release returns the query to its pool, if any.
+func (q *Query) release() {
+	if q.pool == nil {
+		return
+	}
+	q.lk.Lock()
+	q.stopWatchdog()
+	barked := q.barked
+	q.lk.Unlock()
+	if barked {
+		// The watchdog callback cannot be waited for, and locks q.lk,
+		// so the query is left to the garbage collector
+		return
+	}
+	q.pool.put(q)
+}
+
+// settle records that the query has been answered, hijacked or buried,
+// and so no longer holds up its connection.
+func (q *Query) settle() {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	q.settleLocked()
+}
+
+// settleLocked is like settle, for a caller holding q.lk.
+func (q *Query) settleLocked() {
+	if q.settled {
+		return
+	}
+	q.settled = true
+	q.stopWatchdog()
+	q.srv.settle()
+	if q.done != nil {
+		close(q.done)
+	}
+}
+
+// bury closes the connection of the query, which nobody will answer.
+func (q *Query) bury() {
+	// The connection is closed before the query settles, since the
+	// ResponseWriter of a Handler is only valid until then
+	if q.rw != nil {
+		closeWriter(q.rw)
+	} else {
+		q.srv.bury(q.ssc)
+	}
+	q.settle()
+	q.ssc = nil
+	q.srv = nil
+}
+
+// Hold makes Write leave the connection to the user, who must then call
+// Continue or Hijack, e.g. to tunnel a connection after responding to
+// a CONNECT request. Queries of CONNECT requests are held from the start.
+func (q *Query) Hold() {
+	q.checkReleased()
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	q.hold = true
+}
+
+// watch starts a watchdog that logs the query, if it is neither
+// continued nor hijacked within timeout nanoseconds, since its
+// connection stalls until it is.
+func (q *Query) watch(timeout int64) {
+	q.lk.Lock()
+	defer q.lk.Unlock()
+	srv, path, remote := q.srv, q.origPath, q.remote
+	q.watchdog = time.AfterFunc(time.Duration(timeout), func() {
+		q.lk.Lock()
+		fwd := q.fwd
+		q.lk.Unlock()
+		if !fwd {
+			log.Printf("Query %s from %s neither continued nor hijacked after %dms\n", path, remote, timeout/1e6)
+			srv.stats.IncUnanswered()
+		}
+	})
+}
+
+// stopWatchdog stops the watchdog of the query, if any. The caller must
+// hold q.lk.
+func (q *Query) stopWatchdog() {
+	if q.watchdog != nil {
+		if !q.watchdog.Stop() {
+			q.barked = true
+		}
+		q.watchdog = nil
+	}
+}
+
+// Write sends resp back on the connection that produced the request,
+// after continuing the query, unless it is held.
 // Any non-nil error returned pertains to the ServerConn and not
-// to the Server as a whole.
+// to the Server as a whole. Write returns ErrAnswered if the query has
+// already been answered.
 func (q *Query) Write(resp *http.Response) (err error) {
+	q.checkReleased()
 	if resp.Body != nil {
 		defer func(b io.ReadCloser) { 
 			b.Close() 
 		}(resp.Body)
 	}
+	if q.hijacked {
+		return ErrHijacked
+	}
+	if q.srv == nil {
+		return ErrClosed
+	}
+	q.lk.Lock()
+	settled, auto := q.settled, !q.fwd && !q.hold
+	q.lk.Unlock()
+	if settled {
+		return ErrAnswered
+	}
+	if auto {
+		q.Continue()
+	}
 
 	req := q.Req
 	q.Req = nil
@@ -92,27 +319,34 @@ func (q *Query) Write(resp *http.Response) (err error) {
 	for _, ec := range revexts {
 		if strings.HasPrefix(p, ec.SubURL) {
 			if err := ec.Ext.WriteResponse(resp, ext); err != nil {
-				q.srv.bury(q.ssc)
-				q.ssc = nil
-				q.srv = nil
+				q.bury()
 				return err
 			}
 		}
 	}
 
-	err = q.ssc.Write(req, resp)
+	// The body is closed on return, even if the file is sent in its place
+	if f := fileBody(resp); f != nil {
+		resp.Body = f
+	}
+	if q.rw != nil {
+		err = writeResponse(q.rw, resp)
+	} else {
+		err = q.ssc.Write(req, resp)
+	}
 	if err != nil {
 		log.Printf("Response Write: %s\n", err)
-		q.srv.bury(q.ssc)
-		q.ssc = nil
-		q.srv = nil
+		q.bury()
 		return
 	}
+	q.settle()
 	q.srv.stats.AddReqRespTime(time.Now().UnixNano() - q.t0)
 	q.srv.stats.IncResponse()
 	return
 }
 
+// ContinueAndWrite continues the query, even if it is held, and writes
+// resp.
 func (q *Query) ContinueAndWrite(resp *http.Response) (err error) {
 	q.Continue()
 	return q.Write(resp)
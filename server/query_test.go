@@ -0,0 +1,508 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestServer starts a Server on a local port and returns it along
+// with a client connection to it. Closing the connection also closes
+// the listener of the server. Shutdown is not used, since acceptLoop
+// reports the closed listener on the query channel, which Shutdown closes.
+func newTestServer(t *testing.T) (*Server, net.Conn) {
+	return newTestServerConfig(t, Config{Timeout: 5e9})
+}
+
+func newTestServerConfig(t *testing.T, config Config) (*Server, net.Conn) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := NewServer(l, config, 10)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatalf("dial: %s", err)
+	}
+	return srv, &closingConn{c, l}
+}
+
+// closingConn closes a listener along with its connection.
+type closingConn struct {
+	net.Conn
+	l net.Listener
+}
+
+func (c *closingConn) Close() error {
+	c.l.Close()
+	return c.Conn.Close()
+}
+
+func sendRequest(t *testing.T, c net.Conn, method, uri string) {
+	if _, err := fmt.Fprintf(c, "%s %s HTTP/1.1\r\nHost: localhost\r\n\r\n", method, uri); err != nil {
+		t.Fatalf("send request: %s", err)
+	}
+}
+
+func TestQueryAddrs(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	sendRequest(t, c, "GET", "/")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if a := q.RemoteAddr(); a == nil || a.String() != c.LocalAddr().String() {
+		t.Errorf("RemoteAddr: got %v, want %v", a, c.LocalAddr())
+	}
+	if a := q.LocalAddr(); a == nil || a.String() != c.RemoteAddr().String() {
+		t.Errorf("LocalAddr: got %v, want %v", a, c.RemoteAddr())
+	}
+	if q.TLS() != nil {
+		t.Errorf("TLS: got %v for a plain connection", q.TLS())
+	}
+	q.Write(http.NewResponse200(q.Req))
+}
+
+func TestQueryDone(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	sendRequest(t, c, "GET", "/unanswered")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if n := srv.Pending(); n != 1 {
+		t.Errorf("pending before Done: got %d, want 1", n)
+	}
+	req := q.Req
+	q.Done()
+	q.Done()
+	line, err := bufio.NewReader(c).ReadString('\n')
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	if !strings.HasPrefix(line, "HTTP/1.1 500 ") {
+		t.Errorf("status line: got %q, want a 500 response", line)
+	}
+	if n := srv.Pending(); n != 0 {
+		t.Errorf("pending after Done: got %d, want 0", n)
+	}
+	if err := q.Write(http.NewResponse200(req)); err != ErrAnswered {
+		t.Errorf("Write after Done: got %v, want %v", err, ErrAnswered)
+	}
+}
+
+func TestQueryDoneHeld(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	sendRequest(t, c, "CONNECT", "localhost:443")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	q.Done()
+	if _, err := bufio.NewReader(c).ReadByte(); err != io.EOF {
+		t.Errorf("read after Done: got %v, want %v", err, io.EOF)
+	}
+	if n := srv.Pending(); n != 0 {
+		t.Errorf("pending after Done: got %d, want 0", n)
+	}
+	if err := q.Continue(); err != ErrClosed {
+		t.Errorf("Continue after Done: got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestQueryHijack(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	sendRequest(t, c, "GET", "/")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	sc, err := q.Hijack()
+	if err != nil {
+		t.Fatalf("Hijack: %s", err)
+	}
+	defer sc.Close()
+	if n := srv.Pending(); n != 0 {
+		t.Errorf("pending after Hijack: got %d, want 0", n)
+	}
+	q.Done()
+}
+
+func TestQueryMisuse(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	// Write implies Continue, so the next request is read without it
+	sendRequest(t, c, "GET", "/first")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if err := q.Write(http.NewResponse200(q.Req)); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := q.Continue(); err != ErrForwarded {
+		t.Errorf("Continue after Write: got %v, want %v", err, ErrForwarded)
+	}
+	if _, err := q.Hijack(); err != ErrForwarded {
+		t.Errorf("Hijack after Write: got %v, want %v", err, ErrForwarded)
+	}
+
+	sendRequest(t, c, "GET", "/second")
+	q, err = srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if err := q.Continue(); err != nil {
+		t.Fatalf("Continue: %s", err)
+	}
+	if err := q.Continue(); err != ErrForwarded {
+		t.Errorf("second Continue: got %v, want %v", err, ErrForwarded)
+	}
+	if _, err := q.Hijack(); err != ErrForwarded {
+		t.Errorf("Hijack after Continue: got %v, want %v", err, ErrForwarded)
+	}
+	if err := q.Write(http.NewResponse200(q.Req)); err != nil {
+		t.Fatalf("Write after Continue: %s", err)
+	}
+
+	// A held query is left to be continued after Write
+	sendRequest(t, c, "GET", "/held")
+	q, err = srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	q.Hold()
+	if err := q.Write(http.NewResponse200(q.Req)); err != nil {
+		t.Fatalf("Write held: %s", err)
+	}
+	if err := q.Continue(); err != nil {
+		t.Errorf("Continue after Write held: %s", err)
+	}
+
+	// A query whose connection was closed reports it
+	srv.AddExt("fail", "/fail", failingWriteExt{})
+	sendRequest(t, c, "GET", "/fail")
+	q, err = srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	req := q.Req
+	q.Hold()
+	if err := q.Write(http.NewResponse200(req)); err == nil {
+		t.Fatalf("Write with failing extension succeeded")
+	}
+	if err := q.Continue(); err != ErrClosed {
+		t.Errorf("Continue after burial: got %v, want %v", err, ErrClosed)
+	}
+	if _, err := q.Hijack(); err != ErrClosed {
+		t.Errorf("Hijack after burial: got %v, want %v", err, ErrClosed)
+	}
+	if err := q.Write(http.NewResponse200(req)); err != ErrClosed {
+		t.Errorf("Write after burial: got %v, want %v", err, ErrClosed)
+	}
+
+	// A hijacked query is left to the user
+	srv, c = newTestServer(t)
+	defer c.Close()
+	sendRequest(t, c, "GET", "/")
+	q, err = srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	req = q.Req
+	sc, err := q.Hijack()
+	if err != nil {
+		t.Fatalf("Hijack: %s", err)
+	}
+	defer sc.Close()
+	if _, err := q.Hijack(); err != ErrForwarded {
+		t.Errorf("second Hijack: got %v, want %v", err, ErrForwarded)
+	}
+	if err := q.Continue(); err != ErrForwarded {
+		t.Errorf("Continue after Hijack: got %v, want %v", err, ErrForwarded)
+	}
+	if err := q.Write(http.NewResponse200(req)); err != ErrHijacked {
+		t.Errorf("Write after Hijack: got %v, want %v", err, ErrHijacked)
+	}
+}
+
+func TestWriteErrorMisuse(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	sendRequest(t, c, "GET", "/")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if err := q.Write(http.NewResponse200(q.Req)); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	if err := q.WriteError(404, nil); err != ErrAnswered {
+		t.Errorf("WriteError after Write: got %v, want %v", err, ErrAnswered)
+	}
+	q.Done()
+
+	// A query whose connection was closed reports it
+	sendRequest(t, c, "CONNECT", "localhost:443")
+	q, err = srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	q.Done()
+	if err := q.WriteError(404, nil); err != ErrClosed {
+		t.Errorf("WriteError after Done: got %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+	srv.SetRequestIDHeader("X-Request-Id")
+
+	if _, err := fmt.Fprintf(c, "GET /missing HTTP/1.1\r\nHost: localhost\r\nX-Request-Id: abc123\r\n\r\n"); err != nil {
+		t.Fatalf("send request: %s", err)
+	}
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	req := q.Req
+	if err := q.WriteError(404, errors.New("no such page")); err != nil {
+		t.Fatalf("WriteError: %s", err)
+	}
+	if err := q.WriteError(500, nil); err != ErrAnswered {
+		t.Errorf("second WriteError: got %v, want %v", err, ErrAnswered)
+	}
+	q.Done()
+	resp, err := http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 404 || !strings.Contains(string(body), "404 Not Found") {
+		t.Errorf("response: got %d, %q, want a 404 page", resp.StatusCode, body)
+	}
+	if id := resp.Header.Get("X-Request-Id"); id != "abc123" || !strings.Contains(string(body), "abc123") {
+		t.Errorf("request ID: got %q in the header, and body %q", id, body)
+	}
+}
+
+type failingExt struct{}
+
+func (failingExt) ReadRequest(req *http.Request, ext map[string]interface{}) error {
+	return errors.New("rejected")
+}
+
+func (failingExt) WriteResponse(resp *http.Response, ext map[string]interface{}) error {
+	return nil
+}
+
+// failingWriteExt is an extension that rejects every response.
+type failingWriteExt struct{}
+
+func (failingWriteExt) ReadRequest(req *http.Request, ext map[string]interface{}) error {
+	return nil
+}
+
+func (failingWriteExt) WriteResponse(resp *http.Response, ext map[string]interface{}) error {
+	return errors.New("rejected")
+}
+
+func TestExtensionFailure(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	srv.AddExt("fail", "/", failingExt{})
+	go srv.Read()
+	sendRequest(t, c, "GET", "/")
+	if _, err := bufio.NewReader(c).ReadByte(); err != io.EOF {
+		t.Errorf("read after failed extension: got %v, want %v", err, io.EOF)
+	}
+	if n := srv.Pending(); n != 0 {
+		t.Errorf("pending after failed extension: got %d, want 0", n)
+	}
+}
+
+func tempFile(t *testing.T, contents string) *os.File {
+	f, err := ioutil.TempFile("", "query_test")
+	if err != nil {
+		t.Fatalf("temp file: %s", err)
+	}
+	os.Remove(f.Name())
+	if _, err = f.WriteString(contents); err != nil {
+		t.Fatalf("write temp file: %s", err)
+	}
+	if _, err = f.Seek(0, os.SEEK_SET); err != nil {
+		t.Fatalf("seek temp file: %s", err)
+	}
+	return f
+}
+
+func TestFileBody(t *testing.T) {
+	f := tempFile(t, "0123456789")
+	defer f.Close()
+	resp := &http.Response{Body: f, ContentLength: 10}
+	if fileBody(resp) != f {
+		t.Errorf("whole file not sent as a file")
+	}
+	f.Seek(3, os.SEEK_SET)
+	if fileBody(resp) != nil {
+		t.Errorf("file sent past the end of the body")
+	}
+	resp.ContentLength = 7
+	if fileBody(resp) != f {
+		t.Errorf("rest of file not sent as a file")
+	}
+	resp.Body = ioutil.NopCloser(f)
+	if fileBody(resp) != nil {
+		t.Errorf("body not read from a file sent as a file")
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	srv, c := newTestServer(t)
+	defer c.Close()
+
+	sendRequest(t, c, "GET", "/file")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	req := q.Req
+	resp := http.NewResponse200(req)
+	resp.Body = tempFile(t, "0123456789")
+	resp.ContentLength = 10
+	if err = q.Write(resp); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	resp, err = http.ReadResponse(bufio.NewReader(c), req)
+	if err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil || string(body) != "0123456789" {
+		t.Errorf("body: got %q, %v, want %q", body, err, "0123456789")
+	}
+}
+
+func TestQueryPool(t *testing.T) {
+	srv, c := newTestServerConfig(t, Config{Timeout: 5e9, PoolQueries: true})
+	defer c.Close()
+	br := bufio.NewReader(c)
+
+	sendRequest(t, c, "GET", "/first")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	q.Ext["x"] = 1
+	q.Vars = map[string]string{"x": "1"}
+	req := q.Req
+	if err = q.Write(http.NewResponse200(req)); err != nil {
+		t.Fatalf("Write: %s", err)
+	}
+	q.Done()
+	if _, err = http.ReadResponse(br, req); err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+
+	sendRequest(t, c, "GET", "/second")
+	q2, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if q2 != q {
+		t.Errorf("released query not reused")
+	}
+	if q2.OrigPath() != "/second" || len(q2.Ext) != 0 || q2.Vars != nil {
+		t.Errorf("reused query not reset: path %q, Ext %v, Vars %v", q2.OrigPath(), q2.Ext, q2.Vars)
+	}
+	q2.Done()
+}
+
+func TestQueryPoolDebug(t *testing.T) {
+	srv, c := newTestServerConfig(t, Config{Timeout: 5e9, PoolQueries: true, DebugPool: true})
+	defer c.Close()
+
+	sendRequest(t, c, "GET", "/")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	q.Done()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("use of a released query did not panic")
+		}
+	}()
+	q.Done()
+}
+
+// TestQueryPoolWatchdog checks, under the race detector, that queries
+// are not reset for reuse while their watchdog is running.
+func TestQueryPoolWatchdog(t *testing.T) {
+	srv, c := newTestServerConfig(t, Config{Timeout: 5e9, PoolQueries: true, Watchdog: 1e5})
+	defer c.Close()
+	br := bufio.NewReader(c)
+
+	for i := 0; i < 50; i++ {
+		sendRequest(t, c, "GET", "/")
+		q, err := srv.Read()
+		if err != nil {
+			t.Fatalf("read query: %s", err)
+		}
+		// Done races the watchdog, which fires around now
+		req := q.Req
+		q.Write(http.NewResponse200(req))
+		q.Done()
+		if _, err = http.ReadResponse(br, req); err != nil {
+			t.Fatalf("read response: %s", err)
+		}
+	}
+
+	// A query whose watchdog fired is not reused
+	sendRequest(t, c, "GET", "/")
+	q, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	time.Sleep(10e6)
+	req := q.Req
+	q.Write(http.NewResponse200(req))
+	q.Done()
+	if _, err = http.ReadResponse(br, req); err != nil {
+		t.Fatalf("read response: %s", err)
+	}
+	sendRequest(t, c, "GET", "/")
+	q2, err := srv.Read()
+	if err != nil {
+		t.Fatalf("read query: %s", err)
+	}
+	if q2 == q {
+		t.Errorf("query reused after its watchdog fired")
+	}
+	q2.Done()
+}
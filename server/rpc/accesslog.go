@@ -0,0 +1,84 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"github.com/petar/GoHTTP/rpc"
+	"github.com/petar/GoHTTP/server/exts"
+)
+
+// AccessEntry records the outcome of a single call.
+type AccessEntry struct {
+	ServiceMethod string
+	Duration      int64         // Nanoseconds from the arrival of the request to the reply
+	Code          rpc.ErrorCode // Code of the failure, if the call failed
+	Error         string        // Error message, or "" if the call succeeded
+	Caller        string        // Identity of the caller, see callerID
+}
+
+func (e *AccessEntry) String() string {
+	outcome := "ok"
+	if e.Error != "" {
+		outcome = fmt.Sprintf("error (%s): %s", e.Code, e.Error)
+	}
+	return fmt.Sprintf("RPC %s by %s in %dms: %s",
+		e.ServiceMethod, e.Caller, e.Duration/1e6, outcome)
+}
+
+// An AccessLogger receives an AccessEntry for every call of an RPC sub.
+// It is called from the goroutine serving the call, so it must not block.
+type AccessLogger func(e *AccessEntry)
+
+// LogAccess is an AccessLogger that writes entries to the standard
+// logger, the same sink the server logs its own events to.
+func LogAccess(e *AccessEntry) {
+	log.Println(e.String())
+}
+
+// SetAccessLog installs logger to receive an entry for every call served
+// over HTTP, whether it succeeds or fails. A nil logger, the default,
+// disables access logging.
+func (rpcsub *RPC) SetAccessLog(logger AccessLogger) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	rpcsub.logger = logger
+}
+
+// logAccess passes an entry for the call answered by resp to logger.
+func logAccess(logger AccessLogger, t0 int64, resp *rpc.Response, caller map[string]interface{}) {
+	if logger == nil {
+		return
+	}
+	logger(&AccessEntry{
+		ServiceMethod: resp.ServiceMethod,
+		Duration:      time.Nanoseconds() - t0,
+		Code:          resp.Code,
+		Error:         resp.Error,
+		Caller:        callerID(caller),
+	})
+}
+
+// callerID identifies a caller for the purpose of logging, by the user
+// of its session if any, and its remote address otherwise. Bearer tokens
+// are secrets, so only their presence is recorded.
+func callerID(caller map[string]interface{}) string {
+	var id []string
+	if d, ok := caller[CallerSession].(*exts.SessionData); ok && d != nil && d.UserID != "" {
+		id = append(id, "user "+d.UserID)
+	}
+	if token, _ := caller[CallerToken].(string); token != "" {
+		id = append(id, "token")
+	}
+	addr, _ := caller[CallerAddr].(string)
+	if addr == "" {
+		addr = "unknown"
+	}
+	id = append(id, addr)
+	return strings.Join(id, ", ")
+}
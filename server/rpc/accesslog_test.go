@@ -0,0 +1,39 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"github.com/petar/GoHTTP/rpc"
+	"github.com/petar/GoHTTP/server/exts"
+)
+
+func TestAccessLog(t *testing.T) {
+	var entries []*AccessEntry
+	logger := func(e *AccessEntry) { entries = append(entries, e) }
+
+	caller := map[string]interface{}{
+		CallerToken: "secret",
+		CallerAddr:  "10.0.0.1:4321",
+	}
+	resp := &rpc.Response{ServiceMethod: "Users.Get", Code: rpc.CodeNotFound, Error: "no such user"}
+	logAccess(logger, 0, resp, caller)
+	logAccess(nil, 0, resp, caller)
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.ServiceMethod != "Users.Get" || e.Code != rpc.CodeNotFound || e.Error != "no such user" {
+		t.Errorf("bad entry %#v", e)
+	}
+	if e.Caller != "token, 10.0.0.1:4321" {
+		t.Errorf("bad caller %q", e.Caller)
+	}
+
+	caller[CallerSession] = &exts.SessionData{UserID: "bob"}
+	if id := callerID(caller); id != "user bob, token, 10.0.0.1:4321" {
+		t.Errorf("bad caller %q", id)
+	}
+}
@@ -5,8 +5,11 @@
 package rpc
 
 import (
+	"io"
+	"mime/multipart"
 	"os"
 	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server/exts"
 )
 
 var (
@@ -21,11 +24,39 @@ type Args struct {
 	// Cookies holds the cookies included in the request
 	Cookies []*http.Cookie
 
-	// Query holds the decoded arguments from the request's URL
+	// Query holds the decoded arguments from the request's URL, merged with
+	// the fields of a form-encoded body, whose values come first
 	Query   map[string][]string
 
 	// Body is the generic JSON-decoded version of the request body, or an empty map otherwise
 	Body    map[string]interface{}
+
+	// Files holds the files uploaded with a multipart/form-data request
+	Files   []*File
+
+	// Session is the session of the request, as recognized by
+	// the Session extension, or nil if there is none
+	Session *exts.SessionData
+}
+
+// File is a file uploaded with a multipart/form-data request.
+// The contents are only available until the method returns.
+type File struct {
+	Name        string         // Name of the form field
+	Filename    string         // Name of the file on the client
+	ContentType string         // Content-Type of the part, if specified
+	Size        int64          // Size of the contents in bytes
+	Content     multipart.File // Contents of the file
+}
+
+// File returns the first file uploaded under the form field name, or nil.
+func (a *Args) File(name string) *File {
+	for _, f := range a.Files {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
 }
 
 func (a *Args) QueryBool(key string) (bool, os.Error) {
@@ -60,6 +91,28 @@ func (a *Args) QueryString(key string) (string, os.Error) {
 type Ret struct {
 	SetCookies []*http.Cookie
 	Value      map[string]interface{}
+
+	// StatusCode is the HTTP status of the response. Zero means 200 OK.
+	StatusCode int
+
+	// Header holds additional HTTP headers of the response, e.g. Cache-Control
+	Header     http.Header
+
+	// Redirect, if non-empty, is the URL the client is redirected to.
+	// The status code defaults to 302 Found in this case.
+	Redirect   string
+
+	// Body, if non-nil, is streamed to the client with chunked encoding in
+	// place of the JSON encoding of Value, so that large exports need not
+	// be held in memory. It is closed after it is written, if it is an
+	// io.ReadCloser. Methods should set a Content-Type header to match.
+	Body       io.Reader
+
+	// Items, if non-nil, is streamed to the client with chunked encoding as
+	// a JSON array, holding the items received until the channel is closed,
+	// in place of Value. The codec drains the channel if the client goes
+	// away, so the sender never blocks forever.
+	Items      <-chan interface{}
 }
 
 func (r *Ret) initIfZero() {
@@ -92,6 +145,39 @@ func (r *Ret) SetInterface(key string, value interface{}) {
 	r.Value[key] = value
 }
 
+// SetStatus sets the HTTP status code of the response, e.g. 201 or 204.
+func (r *Ret) SetStatus(code int) {
+	r.StatusCode = code
+}
+
+// SetHeader sets an HTTP header of the response, replacing any previous values.
+func (r *Ret) SetHeader(key, value string) {
+	if r.Header == nil {
+		r.Header = make(http.Header)
+	}
+	r.Header.Set(key, value)
+}
+
+// SetRedirect redirects the client to url with the given status code,
+// which should be one of the 3xx codes. A zero code means 302 Found.
+func (r *Ret) SetRedirect(url string, code int) {
+	r.Redirect = url
+	r.StatusCode = code
+}
+
+// SetBody streams body to the client as the response, with the given
+// Content-Type, instead of the JSON encoding of Value.
+func (r *Ret) SetBody(contentType string, body io.Reader) {
+	r.SetHeader("Content-Type", contentType)
+	r.Body = body
+}
+
+// SetItems streams the items received from items to the client as a
+// JSON array. The method must close items after sending the last one.
+func (r *Ret) SetItems(items <-chan interface{}) {
+	r.Items = items
+}
+
 func (r *Ret) AddSetCookie(setCookie *http.Cookie) {
 	r.initIfZero()
 	r.SetCookies = append(r.SetCookies, setCookie)
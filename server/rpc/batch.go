@@ -0,0 +1,189 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"io"
+	"json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"url"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+	"github.com/petar/GoHTTP/server/exts"
+)
+
+// A batch is a POST request to the root of the RPC sub, whose JSON body
+// is an array of calls:
+//
+//	[{"Method": "Arith.Add", "Params": {"A": 1, "B": 2}}, ...]
+//
+// The calls are served one after the other, or all at once if the URL
+// has the parameter parallel=1. The response is an array holding the
+// outcome of every call, in the order of the calls:
+//
+//	[{"Result": {"C": 3}, "Error": "", "Code": 0}, ...]
+//
+// Error and Code are those of a failed call, as in rpc.Response. Calls
+// over the rate limit of their method fail with rpc.CodeOverload.
+// Methods that take *Args receive the params of their call in Args.Body.
+// Methods that reply with *Ret have their Value returned as the result;
+// the HTTP-specific fields of Ret are ignored.
+
+// maxBatchCalls bounds the number of calls in a batch.
+const maxBatchCalls = 100
+
+// BatchCall is a single call of a batch.
+type BatchCall struct {
+	Method string
+	Params json.RawMessage
+}
+
+// BatchReply is the outcome of a single call of a batch.
+type BatchReply struct {
+	Result interface{}
+	Error  string
+	Code   rpc.ErrorCode
+}
+
+// isBatch returns true if req is a batch request.
+func isBatch(req *http.Request) bool {
+	return req.Method == "POST" && hasJSONBody(req) && strings.Trim(req.URL.Path, "/") == ""
+}
+
+// serveBatch serves the batch request of qx with rpcs and writes the response.
+func serveBatch(rpcs *rpc.Server, qx *queryCodec) os.Error {
+	req := qx.Query.Req
+	var calls []BatchCall
+	err := json.NewDecoder(io.LimitReader(req.Body, maxFormSize)).Decode(&calls)
+	req.Body.Close()
+	if err != nil {
+		return qx.write(newErrorResponse(req, rpc.CodeBadRequest, "rpc: malformed batch: "+err.String()))
+	}
+	if len(calls) > maxBatchCalls {
+		return qx.write(newErrorResponse(req, rpc.CodeBadRequest, "rpc: too many calls in batch"))
+	}
+	timeout := parseTimeout(req.Header.Get("X-RPC-Timeout"))
+	caller, cookies := qx.caller(), req.Cookies()
+	key := qx.limits.clientKey(req)
+	replies := make([]BatchReply, len(calls))
+	codecs := make([]*batchCodec, 0, len(calls))
+	for i, _ := range calls {
+		if qx.limits.take(calls[i].Method, key) > 0 {
+			replies[i] = BatchReply{Error: ErrRateLimited.Message, Code: ErrRateLimited.Code}
+			resp := &rpc.Response{ServiceMethod: calls[i].Method, Code: ErrRateLimited.Code, Error: ErrRateLimited.Message}
+			logAccess(qx.logger, qx.t0, resp, caller)
+			continue
+		}
+		codecs = append(codecs, &batchCodec{
+			call:    &calls[i],
+			reply:   &replies[i],
+			timeout: timeout,
+			caller:  caller,
+			cookies: cookies,
+			logger:  qx.logger,
+		})
+	}
+	if readParam(req, "parallel") == "1" {
+		var wg sync.WaitGroup
+		wg.Add(len(codecs))
+		for _, bc := range codecs {
+			go func(bc *batchCodec) {
+				rpcs.ServeRequest(bc)
+				wg.Done()
+			}(bc)
+		}
+		wg.Wait()
+	} else {
+		for _, bc := range codecs {
+			rpcs.ServeRequest(bc)
+		}
+	}
+
+	body, err := json.Marshal(replies)
+	if err != nil {
+		qx.write(http.NewResponse500(req))
+		return err
+	}
+	return qx.write(newBodyResponse(req, http.StatusOK, JSONContentType, body))
+}
+
+// readParam returns the first value of the URL parameter key of req, or "".
+func readParam(req *http.Request, key string) string {
+	query, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil || len(query[key]) == 0 {
+		return ""
+	}
+	return query[key][0]
+}
+
+// batchCodec is an rpc.ServerCodec that serves a single call of a batch,
+// storing the outcome in reply.
+type batchCodec struct {
+	call    *BatchCall
+	reply   *BatchReply
+	timeout int64
+	caller  map[string]interface{}
+	cookies []*http.Cookie
+	logger  AccessLogger
+	t0      int64
+}
+
+func (bc *batchCodec) ReadRequestHeader(req *rpc.Request) os.Error {
+	bc.t0 = time.Nanoseconds()
+	req.ServiceMethod = bc.call.Method
+	req.Timeout = bc.timeout
+	req.Caller = bc.caller
+	return nil
+}
+
+func (bc *batchCodec) ReadRequestBody(args interface{}) os.Error {
+	if args == nil {
+		return nil
+	}
+	if a, ok := args.(*Args); ok {
+		a.Method = "POST"
+		a.Cookies = bc.cookies
+		a.Session, _ = bc.caller[CallerSession].(*exts.SessionData)
+		a.Body = make(map[string]interface{})
+		if len(bc.call.Params) > 0 {
+			// As with readArgs, malformed params are left to the method
+			json.Unmarshal(bc.call.Params, &a.Body)
+		}
+		return nil
+	}
+	if len(bc.call.Params) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(bc.call.Params, args); err != nil {
+		return rpc.NewError(rpc.CodeBadRequest, "rpc: malformed JSON arguments: "+err.String())
+	}
+	return nil
+}
+
+func (bc *batchCodec) WriteResponse(resp *rpc.Response, ret interface{}) os.Error {
+	logAccess(bc.logger, bc.t0, resp, bc.caller)
+	if resp.Error != "" {
+		bc.reply.Error = resp.Error
+		bc.reply.Code = resp.Code
+		return nil
+	}
+	if r, ok := ret.(*Ret); ok {
+		if r.Body != nil {
+			closeReader(r.Body)
+		}
+		if r.Items != nil {
+			go drainItems(r.Items)
+		}
+		bc.reply.Result = r.Value
+		return nil
+	}
+	bc.reply.Result = ret
+	return nil
+}
+
+func (bc *batchCodec) Close() os.Error { return nil }
@@ -0,0 +1,61 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"json"
+	"os"
+	"testing"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+type sumArgs struct {
+	A, B int
+}
+
+type sumReply struct {
+	C int
+}
+
+type Summer int
+
+func (t *Summer) Add(args *sumArgs, reply *sumReply) os.Error {
+	reply.C = args.A + args.B
+	return nil
+}
+
+func (t *Summer) Echo(args *Args, ret *Ret) os.Error {
+	ret.SetInterface("x", args.Body["x"])
+	return nil
+}
+
+func TestBatchCodec(t *testing.T) {
+	rpcs := rpc.NewServer()
+	rpcs.Register(new(Summer))
+
+	var calls []BatchCall
+	err := json.Unmarshal([]byte(`[
+		{"method": "Summer.Add", "params": {"A": 1, "B": 2}},
+		{"method": "Summer.Echo", "params": {"x": "y"}},
+		{"method": "Summer.Nope"}
+	]`), &calls)
+	if err != nil {
+		t.Fatalf("unmarshal: %s", err)
+	}
+	replies := make([]BatchReply, len(calls))
+	for i, _ := range calls {
+		rpcs.ServeRequest(&batchCodec{call: &calls[i], reply: &replies[i]})
+	}
+
+	if r, ok := replies[0].Result.(*sumReply); !ok || r.C != 3 || replies[0].Error != "" {
+		t.Errorf("bad Add reply %#v", replies[0])
+	}
+	if v, ok := replies[1].Result.(map[string]interface{}); !ok || v["x"] != "y" {
+		t.Errorf("bad Echo reply %#v", replies[1])
+	}
+	if replies[2].Error == "" || replies[2].Code != rpc.CodeNoMethod {
+		t.Errorf("expected no-method error, got %#v", replies[2])
+	}
+}
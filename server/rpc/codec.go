@@ -6,14 +6,21 @@ package rpc
 
 import (
 	//"log"
+	"bufio"
+	"gob"
+	"io"
+	"io/ioutil"
 	"json"
+	"mime/multipart"
 	"os"
 	"path"
-	"rpc"
+	"strconv"
 	"strings"
 	"url"
 	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
 	"github.com/petar/GoHTTP/server"
+	"github.com/petar/GoHTTP/server/exts"
 )
 
 
@@ -27,6 +34,35 @@ type queryCodec struct {
 	// the read methods, which are guaranteed to be called sequentially
 	// by rpc.Server
 	seq uint64
+
+	// files holds uploaded files, which are released once the call returns
+	files []*File
+
+	// form is the parsed multipart body of the call, if any, whose
+	// temporary files are removed once the call returns
+	form *multipart.Form
+
+	// cors is the cross-origin configuration of the RPC sub, or nil
+	cors *CORS
+
+	// version is the API version of the call, or nil if unversioned
+	version *version
+
+	// limits holds the rate limits of the RPC sub
+	limits *rateLimits
+
+	// gob is set if the call is gob-encoded, see GobContentType
+	gob bool
+
+	// marshalers holds the reply formats of the RPC sub, by content type
+	marshalers map[string]Marshaler
+
+	// params holds the path arguments of the call, if its path was mapped by a route
+	params map[string][]string
+
+	// logger receives the access log entry of the call, if non-nil
+	logger AccessLogger
+	t0     int64 // Time the request was received
 }
 
 var ErrCodec = os.NewError("http/rpc codec")
@@ -35,8 +71,10 @@ var ErrCodec = os.NewError("http/rpc codec")
 // synchronous sequence. If ReadRequestHeader returns an error,
 // then ReadRequestBody is either not called (if err == os.EOF or 
 // err == io.ErrUnexpectedEOF), or called with a nil argument 
-// (for any other err). WriteResponse is called out of sync, and
-// only if ReadRequestBody returns no error.
+// (for any other err). WriteResponse is called out of sync, once for
+// every request whose header was read: with the reply of the call, or
+// with an error if ReadRequestBody failed or the call was refused, in
+// which case the client receives the JSON error envelope.
 
 func (qx *queryCodec) ReadRequestHeader(req *rpc.Request) os.Error {
 	if qx.seq == 0 {
@@ -44,9 +82,61 @@ func (qx *queryCodec) ReadRequestHeader(req *rpc.Request) os.Error {
 	}
 	req.Seq = qx.seq
 	req.ServiceMethod = pathToServiceMethod(qx.Req.URL.Path)
+	req.Timeout = parseTimeout(qx.Req.Header.Get("X-RPC-Timeout"))
+	req.Caller = qx.caller()
 	return nil
 }
 
+// caller returns the caller data of the request, keyed by the Caller constants.
+func (qx *queryCodec) caller() map[string]interface{} {
+	return map[string]interface{}{
+		CallerCookies: qx.Req.Cookies(),
+		CallerToken:   bearerToken(qx.Req.Header.Get("Authorization")),
+		CallerExt:     qx.Query.Ext,
+		CallerSession: qx.session(),
+		CallerAddr:    qx.Req.RemoteAddr,
+	}
+}
+
+// session returns the session of the request, or nil if there is none.
+func (qx *queryCodec) session() *exts.SessionData {
+	d, _ := qx.Query.Ext[exts.SessionExt].(*exts.SessionData)
+	return d
+}
+
+// Keys of the caller data that queryCodec attaches to requests.
+// Authorizers and methods retrieve them with rpc.Context.Caller.
+const (
+	CallerCookies = "cookies" // []*http.Cookie sent with the request
+	CallerToken   = "token"   // bearer token from the Authorization header, or ""
+	CallerExt     = "ext"     // map[string]interface{} filled in by server extensions
+	CallerSession = "session" // *exts.SessionData of the request, or nil
+	CallerAddr    = "addr"    // remote address of the client, as "IP:port"
+)
+
+// bearerToken extracts the token from an "Authorization: Bearer" header value.
+func bearerToken(v string) string {
+	const prefix = "Bearer "
+	if len(v) < len(prefix) || strings.ToLower(v[:len(prefix)]) != "bearer " {
+		return ""
+	}
+	return strings.TrimSpace(v[len(prefix):])
+}
+
+// parseTimeout interprets the value of the X-RPC-Timeout request header,
+// which holds the call deadline in milliseconds. Missing or malformed
+// values mean no deadline, as do values too large to count in nanoseconds.
+func parseTimeout(v string) int64 {
+	if v == "" {
+		return 0
+	}
+	ms, err := strconv.Atoi64(v)
+	if err != nil || ms <= 0 || ms > (1<<63-1)/1000000 {
+		return 0
+	}
+	return ms * 1e6
+}
+
 func pathToServiceMethod(p string) string {
 	p = path.Clean(p)
 	if p != "" && p[0] == '/' {
@@ -55,68 +145,317 @@ func pathToServiceMethod(p string) string {
 	return strings.Replace(p, "/", ".", -1)
 }
 
-// ReadRequestBody parses the URL for the AJAX parameters
+// ReadRequestBody decodes the arguments of the call. Methods that take
+// *Args receive the request in the generic form described by Args.
+// Otherwise, the arguments are decoded directly into the method's argument
+// struct: from the JSON body of POST and PUT requests with Content-Type
+// application/json, which is what AJAX clients send, and from the URL
+// query parameters and any form-encoded body in all other cases.
 func (qx *queryCodec) ReadRequestBody(args interface{}) (err os.Error) {
 	defer func() {
 		qx.seq = 0
 	}()
+	req := qx.Query.Req
 	if args == nil {
-		if qx.Query.Req.Body != nil {
-			qx.Query.Req.Body.Close()
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil
+	}
+	if hasGobBody(req) {
+		defer req.Body.Close()
+		qx.gob = true
+		if err = gob.NewDecoder(req.Body).Decode(args); err != nil {
+			return rpc.NewError(rpc.CodeBadRequest, "rpc: malformed gob arguments: "+err.String())
+		}
+		return nil
+	}
+	if a, ok := args.(*Args); ok {
+		return qx.readArgs(a)
+	}
+	if req.Body != nil {
+		defer req.Body.Close()
+	}
+
+	if hasJSONBody(req) {
+		if err = json.NewDecoder(req.Body).Decode(args); err != nil {
+			return rpc.NewError(rpc.CodeBadRequest, "rpc: malformed JSON arguments: "+err.String())
+		}
+		if qx.params != nil {
+			return decodeMapToStruct(qx.params, args)
 		}
 		return nil
 	}
+	query, err := qx.readValues()
+	if err != nil {
+		return err
+	}
+	return decodeMapToStruct(query, args)
+}
+
+// maxFormSize bounds the size of form-encoded request bodies.
+const maxFormSize = 10 << 20
+
+// maxUploadMemory is the number of bytes of multipart bodies kept in
+// memory. Larger uploads are spilled to temporary files.
+const maxUploadMemory = 32 << 20
+
+// readValues returns the URL query parameters of the request merged with
+// the fields of its body, if the latter is form-encoded, as is the case
+// with plain HTML forms, and the path arguments of its route. Path
+// arguments take precedence over form fields, which in turn take
+// precedence over URL parameters of the same name: their values come first.
+func (qx *queryCodec) readValues() (map[string][]string, os.Error) {
+	values, err := qx.readBodyValues()
+	if err != nil || qx.params == nil {
+		return values, err
+	}
+	merged := make(map[string][]string)
+	for k, vv := range qx.params {
+		merged[k] = append(merged[k], vv...)
+	}
+	for k, vv := range values {
+		merged[k] = append(merged[k], vv...)
+	}
+	return merged, nil
+}
+
+// readBodyValues returns the URL query parameters of the request merged
+// with the fields of its form-encoded body, if any, which come first.
+func (qx *queryCodec) readBodyValues() (map[string][]string, os.Error) {
+	req := qx.Query.Req
+	query, err := url.ParseQuery(req.URL.RawQuery)
+	if err != nil {
+		return nil, rpc.NewError(rpc.CodeBadRequest, "rpc: malformed query: "+err.String())
+	}
+	if hasMultipartBody(req) {
+		if err = req.ParseMultipartForm(maxUploadMemory); err != nil {
+			return nil, rpc.NewError(rpc.CodeBadRequest, "rpc: malformed multipart body: "+err.String())
+		}
+		qx.form = req.MultipartForm
+		form := make(map[string][]string)
+		for k, vv := range req.MultipartForm.Value {
+			form[k] = append(form[k], vv...)
+		}
+		for k, vv := range query {
+			form[k] = append(form[k], vv...)
+		}
+		return form, nil
+	}
+	if !hasFormBody(req) {
+		return query, nil
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(req.Body, maxFormSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxFormSize {
+		return nil, rpc.NewError(rpc.CodeBadRequest, "rpc: form body too large")
+	}
+	form, err := url.ParseQuery(string(b))
+	if err != nil {
+		return nil, rpc.NewError(rpc.CodeBadRequest, "rpc: malformed form body: "+err.String())
+	}
+	for k, vv := range query {
+		form[k] = append(form[k], vv...)
+	}
+	return form, nil
+}
+
+// hasMultipartBody returns true if req is a POST or PUT request with a multipart/form-data body.
+func hasMultipartBody(req *http.Request) bool {
+	if req.Body == nil || (req.Method != "POST" && req.Method != "PUT") {
+		return false
+	}
+	return mediaType(req.Header.Get("Content-Type")) == "multipart/form-data"
+}
+
+// openFiles opens the files uploaded with a multipart/form-data request.
+// They are remembered, so that they can be released after the call.
+func (qx *queryCodec) openFiles() ([]*File, os.Error) {
+	mf := qx.Query.Req.MultipartForm
+	if mf == nil {
+		return nil, nil
+	}
+	for name, fhs := range mf.File {
+		for _, fh := range fhs {
+			content, err := fh.Open()
+			if err != nil {
+				qx.releaseFiles()
+				return nil, err
+			}
+			size, err := content.Seek(0, os.SEEK_END)
+			if err == nil {
+				_, err = content.Seek(0, os.SEEK_SET)
+			}
+			if err != nil {
+				content.Close()
+				qx.releaseFiles()
+				return nil, err
+			}
+			qx.files = append(qx.files, &File{
+				Name:        name,
+				Filename:    fh.Filename,
+				ContentType: fh.Header.Get("Content-Type"),
+				Size:        size,
+				Content:     content,
+			})
+		}
+	}
+	return qx.files, nil
+}
+
+// releaseFiles closes the uploaded files, and removes any
+// temporary files that hold their contents.
+func (qx *queryCodec) releaseFiles() {
+	for _, f := range qx.files {
+		f.Content.Close()
+		if osf, ok := f.Content.(*os.File); ok {
+			os.Remove(osf.Name())
+		}
+	}
+	qx.files = nil
+}
+
+// hasFormBody returns true if req is a POST or PUT request with a form-encoded body.
+func hasFormBody(req *http.Request) bool {
+	if req.Body == nil || (req.Method != "POST" && req.Method != "PUT") {
+		return false
+	}
+	return mediaType(req.Header.Get("Content-Type")) == "application/x-www-form-urlencoded"
+}
+
+// hasJSONBody returns true if req is a POST or PUT request with a JSON body.
+func hasJSONBody(req *http.Request) bool {
+	if req.Body == nil || (req.Method != "POST" && req.Method != "PUT") {
+		return false
+	}
+	return mediaType(req.Header.Get("Content-Type")) == "application/json"
+}
+
+// mediaType returns the lower-case media type of a Content-Type
+// header value, without parameters.
+func mediaType(contentType string) string {
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType))
+}
 
-	a := args.(*Args)
+// readArgs fills in a generic Args structure from the request.
+func (qx *queryCodec) readArgs(a *Args) (err os.Error) {
+	if qx.Query.Req.Body != nil {
+		defer qx.Query.Req.Body.Close()
+	}
 
 	// Save request method (GET, POST, PUT, UPDATE, etc.)
 	a.Method = qx.Query.Req.Method
 
-	// Decode URL arguments
-	a.Query, err = url.ParseQuery(qx.Query.Req.URL.RawQuery)
+	// Decode URL arguments and form fields
+	a.Query, err = qx.readValues()
+	if err != nil {
+		return err
+	}
+
+	// Open uploaded files
+	a.Files, err = qx.openFiles()
 	if err != nil {
 		return err
 	}
 
 	// Decode JSON body
 	a.Body = make(map[string]interface{})
-	if qx.Query.Req.Body != nil {
+	if qx.Query.Req.Body != nil && !hasFormBody(qx.Query.Req) && !hasMultipartBody(qx.Query.Req) {
 		dec := json.NewDecoder(qx.Query.Req.Body)
 		// We don't care if the decode is successful.
 		// The user will do their own complaining if they are missing expected arguments.
 		dec.Decode(a.Body)
-		qx.Query.Req.Body.Close()
 	}
 
 	// Read the cookies associated with the request
 	a.Cookies = qx.Query.Req.Cookies()
 
+	// Attach the session, if the Session extension recognized one
+	a.Session = qx.session()
+
 	return nil
 }
 
+// ReleaseCall releases the uploaded files of the call, once its method
+// has returned, and removes the temporary files of its multipart body,
+// whether or not the method took the files as Args.
+func (qx *queryCodec) ReleaseCall(req *rpc.Request) {
+	qx.releaseFiles()
+	if qx.form != nil {
+		qx.form.RemoveAll()
+		qx.form = nil
+	}
+}
+
 func (qx *queryCodec) WriteResponse(resp *rpc.Response, ret interface{}) (err os.Error) {
+	if qx.logger != nil {
+		logAccess(qx.logger, qx.t0, resp, qx.caller())
+	}
 
 	if resp.Error != "" {
-		return qx.Query.Write(http.NewResponse400String(qx.Query.Req, resp.Error))
+		return qx.write(newErrorResponse(qx.Query.Req, resp.Code, resp.Error))
 	}
 
 	if ret == nil {
-		return qx.Query.Write(http.NewResponse200(qx.Query.Req))
+		return qx.write(http.NewResponse200(qx.Query.Req))
 	}
 
-	r := ret.(*Ret)
-
-	var body []byte
-	if r.Value != nil {
-		body, err = json.Marshal(r.Value)
+	r, ok := ret.(*Ret)
+	if !ok {
+		// The method replies with a struct of its own
+		body, ctype, err := qx.encode(ret)
 		if err != nil {
-			qx.Query.Write(http.NewResponse500(qx.Query.Req))
+			qx.write(http.NewResponse500(qx.Query.Req))
 			return err
 		}
+		return qx.write(newBodyResponse(qx.Query.Req, http.StatusOK, ctype, body))
 	}
 
-	httpResp := http.NewResponse200Bytes(qx.Query.Req, body)
-	httpResp.Header = make(http.Header)
+	code := r.StatusCode
+	if code == 0 {
+		code = http.StatusOK
+		if r.Redirect != "" {
+			code = http.StatusFound
+		}
+	}
+
+	var httpResp *http.Response
+	if r.Items != nil && bodyAllowed(code) {
+		httpResp = newStreamResponse(qx.Query.Req, newItemsReader(r.Items))
+		httpResp.StatusCode = code
+		httpResp.Status = http.StatusText(code)
+		httpResp.Header.Set("Content-Type", headerType(JSONContentType))
+	} else if r.Body != nil && bodyAllowed(code) {
+		httpResp = newStreamResponse(qx.Query.Req, r.Body)
+		httpResp.StatusCode = code
+		httpResp.Status = http.StatusText(code)
+	} else {
+		if r.Body != nil {
+			closeReader(r.Body)
+		}
+		if r.Items != nil {
+			go drainItems(r.Items)
+		}
+		var body []byte
+		var ctype string
+		if r.Value != nil && bodyAllowed(code) {
+			body, ctype, err = qx.encode(r.Value)
+			if err != nil {
+				qx.write(http.NewResponse500(qx.Query.Req))
+				return err
+			}
+		}
+		httpResp = newBodyResponse(qx.Query.Req, code, ctype, body)
+	}
+	mergeHeader(httpResp.Header, r.Header)
+	if r.Redirect != "" {
+		httpResp.Header.Set("Location", r.Redirect)
+	}
 	for _, setCookie := range r.SetCookies {
 		httpResp.Header.Add("Set-Cookie", setCookie.String())
 	}
@@ -124,7 +463,179 @@ func (qx *queryCodec) WriteResponse(resp *rpc.Response, ret interface{}) (err os
 	//dump, _ := http.DumpResponse(httpResp, true)
 	//log.Printf("RPC-Resp:\n%s\n", string(dump))
 
-	return qx.Query.Write(httpResp)
+	return qx.write(httpResp)
+}
+
+// newErrorResponse returns the response to a failed call. Its body is a
+// JSON envelope describing the failure, whatever the format of replies:
+//
+//	{"error": {"code": 2, "name": "not found", "message": "no such user"}}
+//
+// where code is the rpc.ErrorCode of the failure and name its description.
+func newErrorResponse(req *http.Request, code rpc.ErrorCode, msg string) *http.Response {
+	resp, _ := http.Respond(req).Status(errorStatus(code)).JSON(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    int(code),
+			"name":    code.String(),
+			"message": msg,
+		},
+	}).Build()
+	return resp
+}
+
+// newBodyResponse returns a response with the given status code, whose
+// body is of content type ctype. Responses with an empty body get no
+// Content-Type.
+func newBodyResponse(req *http.Request, code int, ctype string, body []byte) *http.Response {
+	if ctype != "" {
+		ctype = headerType(ctype)
+	}
+	resp, _ := http.Respond(req).Status(code).Body(ctype, body).Build()
+	return resp
+}
+
+// headerType returns the Content-Type header value for content type
+// ctype. JSON is always encoded in UTF-8, which is made explicit.
+func headerType(ctype string) string {
+	if ctype == JSONContentType {
+		return JSONContentType + "; charset=utf-8"
+	}
+	return ctype
+}
+
+// mergeHeader copies the headers in src to dst, replacing
+// the values of dst for the keys present in src.
+func mergeHeader(dst, src http.Header) {
+	for key, values := range src {
+		dst.Del(key)
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+// errorStatus returns the HTTP status of failures with the given code.
+// Errors returned by methods without a code are server errors.
+func errorStatus(code rpc.ErrorCode) int {
+	switch code {
+	case rpc.CodeInvalidArgument, rpc.CodeBadRequest:
+		return http.StatusBadRequest
+	case rpc.CodeNotFound, rpc.CodeNoMethod:
+		return http.StatusNotFound
+	case rpc.CodeUnauthorized:
+		return http.StatusForbidden
+	case rpc.CodeTimeout:
+		return http.StatusGatewayTimeout
+	case rpc.CodeOverload:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// allowedVerbs lists the HTTP methods accepted for calls.
+const allowedVerbs = "GET, HEAD, POST, PUT, DELETE"
+
+// verbAllowed returns true if calls may be made with the HTTP method verb.
+func verbAllowed(verb string) bool {
+	switch verb {
+	case "GET", "HEAD", "POST", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
+// newVerbResponse returns the 405 response to calls made with an HTTP
+// method that is not allowed.
+func newVerbResponse(req *http.Request) *http.Response {
+	resp := newErrorResponse(req, rpc.CodeBadRequest, "rpc: method "+req.Method+" not allowed")
+	resp.StatusCode = http.StatusMethodNotAllowed
+	resp.Status = http.StatusText(http.StatusMethodNotAllowed)
+	resp.Header.Set("Allow", allowedVerbs)
+	return resp
+}
+
+// encode returns the encoding of the reply value v, and its content type.
+// Gob-encoded calls get gob replies, while other calls get the format
+// negotiated with the Accept header, JSON by default.
+func (qx *queryCodec) encode(v interface{}) ([]byte, string, os.Error) {
+	if qx.gob {
+		body, err := gobEncode(v)
+		return body, GobContentType, err
+	}
+	ctype, marshal := negotiate(qx.Query.Req, qx.marshalers)
+	body, err := marshal(v)
+	return body, ctype, err
+}
+
+// newStreamResponse returns a 200 response whose body is copied from
+// body with chunked encoding, so that it need not be held in memory.
+// The body is closed after it is written, if it is an io.ReadCloser.
+func newStreamResponse(req *http.Request, body io.Reader) *http.Response {
+	resp, _ := http.Respond(req).Stream("", body).Build()
+	return resp
+}
+
+// newItemsReader returns a reader of the JSON array of the items received
+// from items. Closing the reader makes the remaining items be discarded.
+func newItemsReader(items <-chan interface{}) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go writeItems(pw, items)
+	return pr
+}
+
+func writeItems(pw *io.PipeWriter, items <-chan interface{}) {
+	defer drainItems(items)
+	// Buffer the items, so that each chunk holds more than one of them
+	w := bufio.NewWriter(pw)
+	w.WriteByte('[')
+	n := 0
+	for item := range items {
+		b, err := json.Marshal(item)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if n > 0 {
+			w.WriteByte(',')
+		}
+		if _, err = w.Write(b); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		n++
+	}
+	w.WriteByte(']')
+	pw.CloseWithError(w.Flush())
+}
+
+// drainItems discards the remaining items, so that the sender does not block.
+func drainItems(items <-chan interface{}) {
+	for _ = range items {
+	}
+}
+
+func closeReader(r io.Reader) {
+	if rc, ok := r.(io.ReadCloser); ok {
+		rc.Close()
+	}
+}
+
+// bodyAllowed returns false for the status codes whose responses
+// must not include a body.
+func bodyAllowed(code int) bool {
+	return code >= 200 && code != http.StatusNoContent && code != http.StatusNotModified
+}
+
+// write sends resp to the client, adding the CORS and
+// deprecation headers as needed.
+func (qx *queryCodec) write(resp *http.Response) os.Error {
+	if qx.cors != nil {
+		qx.cors.setHeaders(qx.Query.Req, resp)
+	}
+	if qx.version != nil {
+		qx.version.setHeaders(resp)
+	}
+	return qx.Query.Write(resp)
 }
 
 func (qx *queryCodec) Close() os.Error { return nil }
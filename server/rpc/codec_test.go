@@ -0,0 +1,379 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"json"
+	"mime/multipart"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+	"url"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+	"github.com/petar/GoHTTP/server"
+	"github.com/petar/GoHTTP/server/exts"
+)
+
+func TestErrorResponse(t *testing.T) {
+	resp := newErrorResponse(nil, rpc.CodeNotFound, "no such user")
+	if resp.StatusCode != 404 {
+		t.Errorf("bad status %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("bad Content-Type %q", ct)
+	}
+	b, _ := ioutil.ReadAll(resp.Body)
+	var envelope map[string]map[string]interface{}
+	if err := json.Unmarshal(b, &envelope); err != nil {
+		t.Fatalf("bad envelope %s: %s", b, err)
+	}
+	e := envelope["error"]
+	if e["code"] != float64(rpc.CodeNotFound) || e["name"] != "not found" || e["message"] != "no such user" {
+		t.Errorf("bad envelope %s", b)
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		code   rpc.ErrorCode
+		status int
+	}{
+		{rpc.CodeUnknown, 500},
+		{rpc.CodeInvalidArgument, 400},
+		{rpc.CodeNotFound, 404},
+		{rpc.CodeUnauthorized, 403},
+		{rpc.CodeTimeout, 504},
+		{rpc.CodeOverload, 503},
+		{rpc.CodeInternal, 500},
+		{rpc.CodeNoMethod, 404},
+		{rpc.CodeBadRequest, 400},
+	}
+	for _, tt := range tests {
+		if status := errorStatus(tt.code); status != tt.status {
+			t.Errorf("%s: expected %d, got %d", tt.code, tt.status, status)
+		}
+	}
+
+	resp := newVerbResponse(&http.Request{Method: "PATCH"})
+	if resp.StatusCode != 405 || resp.Header.Get("Allow") != allowedVerbs {
+		t.Errorf("bad 405 response %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestBodyResponse(t *testing.T) {
+	resp := newBodyResponse(nil, 201, JSONContentType, []byte("{}"))
+	if resp.StatusCode != 201 || resp.Header.Get("Content-Type") != "application/json; charset=utf-8" {
+		t.Errorf("bad response %d %v", resp.StatusCode, resp.Header)
+	}
+	mergeHeader(resp.Header, http.Header{
+		"Content-Type":  []string{"text/csv"},
+		"Cache-Control": []string{"no-cache"},
+	})
+	if resp.Header.Get("Content-Type") != "text/csv" || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("bad merged header %v", resp.Header)
+	}
+	if resp = newBodyResponse(nil, 204, JSONContentType, nil); resp.Header.Get("Content-Type") != "" {
+		t.Errorf("empty body with Content-Type %q", resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestItemsReader(t *testing.T) {
+	items := make(chan interface{})
+	go func() {
+		for i := 0; i < 3; i++ {
+			items <- &sumReply{C: i}
+		}
+		close(items)
+	}()
+	b, err := ioutil.ReadAll(newItemsReader(items))
+	if err != nil {
+		t.Fatalf("read: %s", err)
+	}
+	if string(b) != `[{"C":0},{"C":1},{"C":2}]` {
+		t.Errorf("bad array %s", b)
+	}
+
+	empty := make(chan interface{})
+	close(empty)
+	if b, _ = ioutil.ReadAll(newItemsReader(empty)); string(b) != "[]" {
+		t.Errorf("bad empty array %s", b)
+	}
+
+	// Closing the reader must not block the sender
+	items = make(chan interface{})
+	r := newItemsReader(items)
+	r.Close()
+	for i := 0; i < 10; i++ {
+		items <- i
+	}
+	close(items)
+}
+
+func TestParseTimeout(t *testing.T) {
+	tests := []struct {
+		v  string
+		ns int64
+	}{
+		{"", 0},
+		{"x", 0},
+		{"-5", 0},
+		{"250", 250e6},
+		{"9223372036854", 9223372036854e6},
+		{"9223372036855", 0},
+		{"9223372036854775807", 0},
+	}
+	for _, tt := range tests {
+		if ns := parseTimeout(tt.v); ns != tt.ns {
+			t.Errorf("parseTimeout(%q) = %d, want %d", tt.v, ns, tt.ns)
+		}
+	}
+}
+
+// newCodecRequest returns a request for uri with the given body, of
+// content type ctype.
+func newCodecRequest(t *testing.T, method, uri, ctype string, body []byte) *http.Request {
+	u, err := url.Parse(uri)
+	if err != nil {
+		t.Fatalf("parse %s: %s", uri, err)
+	}
+	req := &http.Request{Method: method, URL: u, Header: make(http.Header)}
+	if body != nil {
+		req.Header.Set("Content-Type", ctype)
+		req.Body = http.NewBodyBytes(body)
+		req.ContentLength = int64(len(body))
+	}
+	return req
+}
+
+func TestReadValues(t *testing.T) {
+	req := newCodecRequest(t, "POST", "/Users/Get?name=url&u=1",
+		"application/x-www-form-urlencoded", []byte("name=form&f=1"))
+	qx := &queryCodec{
+		Query:  &server.Query{Req: req},
+		params: map[string][]string{"name": []string{"path"}},
+	}
+	values, err := qx.readValues()
+	if err != nil {
+		t.Fatalf("readValues: %s", err)
+	}
+	if v := values["name"]; len(v) != 3 || v[0] != "path" || v[1] != "form" || v[2] != "url" {
+		t.Errorf("bad precedence %v", v)
+	}
+	if len(values["f"]) != 1 || len(values["u"]) != 1 {
+		t.Errorf("missing form or URL values in %v", values)
+	}
+
+	// A struct argument takes the first value of each field
+	var args struct{ Name string }
+	qx.Query.Req = newCodecRequest(t, "POST", "/Users/Get?name=url",
+		"application/x-www-form-urlencoded", []byte("name=form"))
+	if err = qx.ReadRequestBody(&args); err != nil || args.Name != "path" {
+		t.Errorf("decoded %q (%v), expected the path argument", args.Name, err)
+	}
+
+	big := bytes.Repeat([]byte("x"), maxFormSize+1)
+	qx = &queryCodec{Query: &server.Query{Req: newCodecRequest(t, "POST", "/Users/Get",
+		"application/x-www-form-urlencoded", big)}}
+	if _, err = qx.readValues(); err == nil {
+		t.Errorf("expected error for oversized form body")
+	}
+}
+
+func TestReadArgsFiles(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	mw.WriteField("title", "hello")
+	fw, err := mw.CreateFormFile("upload", "a.txt")
+	if err != nil {
+		t.Fatalf("create form file: %s", err)
+	}
+	fw.Write([]byte("data"))
+	mw.Close()
+	req := newCodecRequest(t, "POST", "/Files/Put?x=1", mw.FormDataContentType(), body.Bytes())
+	qx := &queryCodec{Query: &server.Query{Req: req}}
+
+	var a Args
+	if err = qx.readArgs(&a); err != nil {
+		t.Fatalf("readArgs: %s", err)
+	}
+	if v, _ := a.QueryString("title"); v != "hello" {
+		t.Errorf("bad form field %q", v)
+	}
+	if v, _ := a.QueryString("x"); v != "1" {
+		t.Errorf("bad URL parameter %q", v)
+	}
+	f := a.File("upload")
+	if f == nil || len(a.Files) != 1 {
+		t.Fatalf("expected one uploaded file, got %v", a.Files)
+	}
+	if f.Filename != "a.txt" || f.Size != 4 {
+		t.Errorf("bad file %q of size %d", f.Filename, f.Size)
+	}
+	if b, err := ioutil.ReadAll(f.Content); err != nil || string(b) != "data" {
+		t.Errorf("bad file contents %q (%v)", b, err)
+	}
+	if a.File("other") != nil {
+		t.Errorf("found a file that was not uploaded")
+	}
+
+	qx.ReleaseCall(nil)
+	if qx.files != nil || qx.form != nil {
+		t.Errorf("uploaded files not released")
+	}
+}
+
+func TestReadArgsSession(t *testing.T) {
+	sessions := exts.NewSession()
+	d, err := sessions.New("u1")
+	if err != nil {
+		t.Fatalf("new session: %s", err)
+	}
+	// readArgs sees the session the extension found for the request
+	readSession := func() *exts.SessionData {
+		req := newCodecRequest(t, "GET", "/Users/Me", "", nil)
+		req.Header.Set("Cookie", exts.SessionCookie+"="+d.ID)
+		ext := make(map[string]interface{})
+		if err := sessions.ReadRequest(req, ext); err != nil {
+			t.Fatalf("read session: %s", err)
+		}
+		qx := &queryCodec{Query: &server.Query{Req: req, Ext: ext}}
+		var a Args
+		if err := qx.readArgs(&a); err != nil {
+			t.Fatalf("readArgs: %s", err)
+		}
+		return a.Session
+	}
+	if s := readSession(); s != d || s.UserID != "u1" {
+		t.Errorf("expected session of u1, got %v", s)
+	}
+
+	sessions.SetIdleTimeout(1e6)
+	time.Sleep(2e6)
+	if s := readSession(); s != nil {
+		t.Errorf("idle session not expired")
+	}
+	if sessions.Lookup(d.ID) != nil {
+		t.Errorf("expired session still held")
+	}
+}
+
+// Rets is a service whose methods shape their HTTP responses with Ret.
+type Rets struct {
+	closed chan bool
+}
+
+func (s *Rets) Created(args *Args, ret *Ret) os.Error {
+	ret.SetStatus(201)
+	ret.SetHeader("Cache-Control", "no-cache")
+	ret.SetString("id", "7")
+	return nil
+}
+
+func (s *Rets) Redirect(args *Args, ret *Ret) os.Error {
+	ret.SetRedirect("/elsewhere", 0)
+	return nil
+}
+
+func (s *Rets) Export(args *Args, ret *Ret) os.Error {
+	body := &closeReader{strings.NewReader(strings.Repeat("a,b\n", 10000)), s.closed}
+	ret.SetBody("text/csv", body)
+	return nil
+}
+
+func (s *Rets) Empty(args *Args, ret *Ret) os.Error {
+	ret.SetStatus(204)
+	ret.SetBody("text/plain", &closeReader{strings.NewReader("dropped"), s.closed})
+	return nil
+}
+
+// closeReader reports its closing on closed.
+type closeReader struct {
+	io.Reader
+	closed chan bool
+}
+
+func (r *closeReader) Close() os.Error {
+	r.closed <- true
+	return nil
+}
+
+// newTestRPC starts a server with an RPC sub at /api serving rcvr, and
+// returns a connection to it.
+func newTestRPC(t *testing.T, rcvr interface{}) net.Conn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 10)
+	rpcsub := NewRPC()
+	if err = rpcsub.Register(rcvr); err != nil {
+		t.Fatalf("register: %s", err)
+	}
+	srv.AddSub("/api", rpcsub)
+	srv.Launch(1)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	return c
+}
+
+func TestRetResponse(t *testing.T) {
+	rets := &Rets{closed: make(chan bool, 1)}
+	c := newTestRPC(t, rets)
+	defer c.Close()
+	br := bufio.NewReader(c)
+	call := func(method string) (*http.Response, []byte) {
+		fmt.Fprintf(c, "GET /api/Rets/%s HTTP/1.1\r\nHost: localhost\r\n\r\n", method)
+		resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+		if err != nil {
+			t.Fatalf("%s: read response: %s", method, err)
+		}
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("%s: read body: %s", method, err)
+		}
+		return resp, b
+	}
+
+	resp, b := call("Created")
+	if resp.StatusCode != 201 || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Errorf("Created: bad response %d %v", resp.StatusCode, resp.Header)
+	}
+	if string(b) != `{"id":"7"}` {
+		t.Errorf("Created: bad body %s", b)
+	}
+
+	resp, _ = call("Redirect")
+	if resp.StatusCode != 302 || resp.Header.Get("Location") != "/elsewhere" {
+		t.Errorf("Redirect: bad response %d %v", resp.StatusCode, resp.Header)
+	}
+
+	resp, b = call("Export")
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "text/csv" {
+		t.Errorf("Export: bad response %d %v", resp.StatusCode, resp.Header)
+	}
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("Export: body not streamed, transfer encoding %v", resp.TransferEncoding)
+	}
+	if string(b) != strings.Repeat("a,b\n", 10000) {
+		t.Errorf("Export: bad body of %d bytes", len(b))
+	}
+	<-rets.closed
+
+	resp, b = call("Empty")
+	if resp.StatusCode != 204 || len(b) != 0 {
+		t.Errorf("Empty: bad response %d with body %q", resp.StatusCode, b)
+	}
+	<-rets.closed
+}
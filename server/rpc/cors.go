@@ -0,0 +1,96 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"strconv"
+	"strings"
+	"github.com/petar/GoHTTP/http"
+)
+
+// CORS configures the cross-origin access that an RPC sub grants to
+// browser clients. When it is set, the sub answers OPTIONS preflight
+// requests for registered methods itself, and marks the responses to
+// calls from allowed origins as readable by them.
+type CORS struct {
+	Origins []string // Allowed origins, e.g. "http://example.com"; "*" allows any origin
+	Headers []string // Request headers clients may send; nil means DefaultCORSHeaders
+	MaxAge  int      // Seconds browsers may cache a preflight answer, or zero
+}
+
+// DefaultCORSHeaders are the request headers allowed when CORS.Headers is nil.
+// They cover JSON bodies, bearer tokens and call deadlines.
+var DefaultCORSHeaders = []string{"Content-Type", "Authorization", "X-RPC-Timeout"}
+
+// corsMethods lists the HTTP methods preflights may ask for: those
+// accepted for calls, less HEAD, which browsers never preflight.
+var corsMethods = corsVerbs()
+
+func corsVerbs() string {
+	var verbs []string
+	for _, verb := range strings.Split(allowedVerbs, ", ") {
+		if verb != "HEAD" {
+			verbs = append(verbs, verb)
+		}
+	}
+	return strings.Join(verbs, ", ")
+}
+
+// allowOrigin returns the value of the Access-Control-Allow-Origin header
+// for requests from origin, or "" if origin is not allowed.
+func (c *CORS) allowOrigin(origin string) string {
+	if origin == "" {
+		return ""
+	}
+	for _, o := range c.Origins {
+		if o == "*" || o == origin {
+			return origin
+		}
+	}
+	return ""
+}
+
+// setHeaders marks resp as readable by the origin of req, if it is allowed.
+func (c *CORS) setHeaders(req *http.Request, resp *http.Response) {
+	origin := c.allowOrigin(req.Header.Get("Origin"))
+	if origin == "" {
+		return
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Access-Control-Allow-Origin", origin)
+	resp.Header.Add("Vary", "Origin")
+}
+
+// preflight answers the OPTIONS request req. Preflights for methods
+// that are not registered get a 404, and those from origins or for
+// HTTP methods that are not allowed get a 403.
+func (c *CORS) preflight(req *http.Request, registered bool) *http.Response {
+	if !registered {
+		return http.NewResponse404(req)
+	}
+	method := req.Header.Get("Access-Control-Request-Method")
+	if c.allowOrigin(req.Header.Get("Origin")) == "" || method == "HEAD" || !verbAllowed(method) {
+		resp := http.NewResponse200(req)
+		resp.StatusCode = http.StatusForbidden
+		resp.Status = http.StatusText(http.StatusForbidden)
+		return resp
+	}
+	resp := http.NewResponse200(req)
+	c.setHeaders(req, resp)
+	resp.Header.Set("Access-Control-Allow-Methods", corsMethods)
+	headers := c.Headers
+	if headers == nil {
+		headers = DefaultCORSHeaders
+	}
+	if len(headers) > 0 {
+		resp.Header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if c.MaxAge > 0 {
+		resp.Header.Set("Access-Control-Max-Age", strconv.Itoa(c.MaxAge))
+	}
+	return resp
+}
@@ -0,0 +1,66 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"github.com/petar/GoHTTP/http"
+)
+
+func newPreflight(origin, method string) *http.Request {
+	return &http.Request{
+		Method: "OPTIONS",
+		Header: http.Header{
+			"Origin":                        []string{origin},
+			"Access-Control-Request-Method": []string{method},
+		},
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	c := &CORS{Origins: []string{"http://a.com"}, MaxAge: 60}
+
+	resp := c.preflight(newPreflight("http://a.com", "POST"), true)
+	if resp.StatusCode != 200 {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "http://a.com" {
+		t.Errorf("bad Allow-Origin %q", v)
+	}
+	if v := resp.Header.Get("Access-Control-Allow-Headers"); v != "Content-Type, Authorization, X-RPC-Timeout" {
+		t.Errorf("bad Allow-Headers %q", v)
+	}
+	if v := resp.Header.Get("Access-Control-Max-Age"); v != "60" {
+		t.Errorf("bad Max-Age %q", v)
+	}
+
+	if resp = c.preflight(newPreflight("http://b.com", "POST"), true); resp.StatusCode != 403 {
+		t.Errorf("expected 403 for foreign origin, got %d", resp.StatusCode)
+	}
+	resp = c.preflight(newPreflight("http://a.com", "DELETE"), true)
+	if resp.StatusCode != 200 {
+		t.Errorf("expected 200 for DELETE, got %d", resp.StatusCode)
+	}
+	if v := resp.Header.Get("Access-Control-Allow-Methods"); v != "GET, POST, PUT, DELETE" {
+		t.Errorf("bad Allow-Methods %q", v)
+	}
+	for _, method := range []string{"PATCH", "HEAD", ""} {
+		if resp = c.preflight(newPreflight("http://a.com", method), true); resp.StatusCode != 403 {
+			t.Errorf("expected 403 for %q, got %d", method, resp.StatusCode)
+		}
+	}
+	if resp = c.preflight(newPreflight("http://a.com", "GET"), false); resp.StatusCode != 404 {
+		t.Errorf("expected 404 for unknown method, got %d", resp.StatusCode)
+	}
+
+	any := &CORS{Origins: []string{"*"}, Headers: []string{}}
+	resp = any.preflight(newPreflight("http://b.com", "GET"), true)
+	if v := resp.Header.Get("Access-Control-Allow-Origin"); v != "http://b.com" {
+		t.Errorf("bad Allow-Origin %q", v)
+	}
+	if v := resp.Header.Get("Access-Control-Allow-Headers"); v != "" {
+		t.Errorf("expected no Allow-Headers, got %q", v)
+	}
+}
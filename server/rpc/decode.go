@@ -0,0 +1,274 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+// decodeMapToStruct fills in the fields of the struct pointed to by v from
+// the values in m. Keys are matched to field names without regard to case,
+// and only the first value of each key is used, except for slice fields,
+// which collect all values of their key. Each value of a slice field may
+// also hold several comma-separated elements. Fields of nested structs
+// are addressed with dotted keys, as in "Addr.City", and nil pointers to
+// structs or basic types are allocated as needed. Fields of unsupported
+// types, as well as keys that match no field, are skipped.
+//
+// Fields of type time.Time accept RFC 3339 timestamps or Unix seconds.
+// Fields of type Duration accept nanoseconds or a sequence of numbers
+// with unit suffixes, as in "1h30m" or "250ms".
+func decodeMapToStruct(m map[string][]string, v interface{}) os.Error {
+	pv := reflect.ValueOf(v)
+	if pv.Kind() != reflect.Ptr || pv.Elem().Kind() != reflect.Struct {
+		return rpc.NewError(rpc.CodeInternal, "rpc: arguments must be a pointer to a struct")
+	}
+	return decodeStruct(m, "", pv.Elem())
+}
+
+func decodeStruct(m map[string][]string, prefix string, sv reflect.Value) os.Error {
+	st := sv.Type()
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		if err := decodeField(m, prefix+field.Name, sv.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeField(m map[string][]string, key string, fv reflect.Value) os.Error {
+	if fv.Kind() == reflect.Ptr {
+		elem := fv.Type().Elem()
+		if !isDecodable(elem) || !hasKey(m, key, isNested(elem)) {
+			return nil
+		}
+		if fv.IsNil() {
+			fv.Set(reflect.New(elem))
+		}
+		fv = fv.Elem()
+	}
+	if isNested(fv.Type()) {
+		return decodeStruct(m, key+".", fv)
+	}
+	if fv.Kind() == reflect.Slice {
+		return decodeSlice(lookupFold(m, key), key, fv)
+	}
+	vals := lookupFold(m, key)
+	if len(vals) == 0 {
+		return nil
+	}
+	if err := setBasic(fv, vals[0]); err != nil {
+		return rpc.Errorf(rpc.CodeInvalidArgument, "rpc: bad value for %s: %s", key, err)
+	}
+	return nil
+}
+
+// decodeSlice sets fv to the elements of vals, splitting each value at commas.
+// Slices of unsupported element types are skipped.
+func decodeSlice(vals []string, key string, fv reflect.Value) os.Error {
+	et := fv.Type().Elem()
+	if len(vals) == 0 || isNested(et) || !isDecodable(et) {
+		return nil
+	}
+	var elems []string
+	for _, v := range vals {
+		elems = append(elems, strings.Split(v, ",")...)
+	}
+	sv := reflect.MakeSlice(fv.Type(), len(elems), len(elems))
+	for i, e := range elems {
+		if err := setBasic(sv.Index(i), strings.TrimSpace(e)); err != nil {
+			return rpc.Errorf(rpc.CodeInvalidArgument, "rpc: bad value for %s: %s", key, err)
+		}
+	}
+	fv.Set(sv)
+	return nil
+}
+
+// isDecodable returns true if values of type t can be decoded from a map.
+func isDecodable(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isNested returns true if values of type t are decoded field by field.
+func isNested(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && t != typeOfTime
+}
+
+// hasKey returns true if m holds key or, if nested is set, any key
+// of the form key.field.
+func hasKey(m map[string][]string, key string, nested bool) bool {
+	if !nested {
+		return len(lookupFold(m, key)) > 0
+	}
+	prefix := strings.ToLower(key) + "."
+	for k, _ := range m {
+		if strings.HasPrefix(strings.ToLower(k), prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupFold returns the values of key in m, matching keys without regard to case.
+func lookupFold(m map[string][]string, key string) []string {
+	if vals, ok := m[key]; ok {
+		return vals
+	}
+	key = strings.ToLower(key)
+	for k, vals := range m {
+		if strings.ToLower(k) == key {
+			return vals
+		}
+	}
+	return nil
+}
+
+var (
+	errRange    = os.NewError("value out of range")
+	errDuration = os.NewError("invalid duration")
+)
+
+// Duration is a span of time in nanoseconds. Fields of arguments of this
+// type are decoded from durations with units, as well as from plain numbers.
+type Duration int64
+
+var (
+	typeOfTime     = reflect.TypeOf(time.Time{})
+	typeOfDuration = reflect.TypeOf(Duration(0))
+)
+
+// setBasic parses s into fv, if fv is of a supported basic kind.
+func setBasic(fv reflect.Value, s string) os.Error {
+	switch fv.Type() {
+	case typeOfTime:
+		t, err := parseTime(s)
+		if err != nil {
+			return err
+		}
+		fv.Set(reflect.ValueOf(*t))
+		return nil
+	case typeOfDuration:
+		d, err := parseDuration(s)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(d)
+		return nil
+	}
+	switch fv.Kind() {
+	case reflect.Bool:
+		b, err := strconv.Atob(s)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.Atoi64(s)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowInt(n) {
+			return errRange
+		}
+		fv.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.Atoui64(s)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowUint(n) {
+			return errRange
+		}
+		fv.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.Atof64(s)
+		if err != nil {
+			return err
+		}
+		if fv.OverflowFloat(f) {
+			return errRange
+		}
+		fv.SetFloat(f)
+	case reflect.String:
+		fv.SetString(s)
+	}
+	return nil
+}
+
+// parseTime parses an RFC 3339 timestamp, or a number of seconds since the epoch.
+func parseTime(s string) (*time.Time, os.Error) {
+	if sec, err := strconv.Atoi64(s); err == nil {
+		return time.SecondsToUTC(sec), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+var durationUnits = map[string]float64{
+	"ns": 1,
+	"us": 1e3,
+	"ms": 1e6,
+	"s":  1e9,
+	"m":  60e9,
+	"h":  3600e9,
+}
+
+// parseDuration parses a number of nanoseconds, or a sequence of decimal
+// numbers each followed by one of the units in durationUnits.
+func parseDuration(s string) (int64, os.Error) {
+	if ns, err := strconv.Atoi64(s); err == nil {
+		return ns, nil
+	}
+	neg := false
+	if s != "" && s[0] == '-' {
+		neg, s = true, s[1:]
+	}
+	if s == "" {
+		return 0, errDuration
+	}
+	var d float64
+	for s != "" {
+		i := 0
+		for i < len(s) && (s[i] == '.' || '0' <= s[i] && s[i] <= '9') {
+			i++
+		}
+		j := i
+		for j < len(s) && 'a' <= s[j] && s[j] <= 'z' {
+			j++
+		}
+		unit, ok := durationUnits[s[i:j]]
+		if i == 0 || !ok {
+			return 0, errDuration
+		}
+		f, err := strconv.Atof64(s[:i])
+		if err != nil {
+			return 0, errDuration
+		}
+		d += f * unit
+		s = s[j:]
+	}
+	if d > 1<<63-1 {
+		return 0, errRange
+	}
+	if neg {
+		d = -d
+	}
+	return int64(d), nil
+}
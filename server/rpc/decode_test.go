@@ -0,0 +1,158 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"time"
+)
+
+type flatArgs struct {
+	Name    string
+	Count   int
+	Ratio   float64
+	Verbose bool
+	hidden  string
+}
+
+func TestDecodeFlat(t *testing.T) {
+	m := map[string][]string{
+		"name":    []string{"gopher", "ignored"},
+		"Count":   []string{"42"},
+		"ratio":   []string{"0.5"},
+		"verbose": []string{"1"},
+		"hidden":  []string{"x"},
+	}
+	var a flatArgs
+	if err := decodeMapToStruct(m, &a); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if a.Name != "gopher" || a.Count != 42 || a.Ratio != 0.5 || !a.Verbose || a.hidden != "" {
+		t.Errorf("bad decode %#v", a)
+	}
+	if err := decodeMapToStruct(map[string][]string{"count": []string{"x"}}, &a); err == nil {
+		t.Errorf("expected error decoding bad int")
+	}
+}
+
+type address struct {
+	City string
+	Zip  *uint16
+}
+
+type nestedArgs struct {
+	Name  string
+	Age   uint8
+	Delta int32
+	Score float32
+	Addr  address
+	Work  *address
+	Home  *address
+}
+
+func TestDecodeNested(t *testing.T) {
+	m := map[string][]string{
+		"name":      []string{"gopher"},
+		"age":       []string{"7"},
+		"delta":     []string{"-3"},
+		"score":     []string{"1.5"},
+		"Addr.City": []string{"Sofia"},
+		"addr.zip":  []string{"1000"},
+		"work.city": []string{"Boston"},
+	}
+	var a nestedArgs
+	if err := decodeMapToStruct(m, &a); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if a.Name != "gopher" || a.Age != 7 || a.Delta != -3 || a.Score != 1.5 {
+		t.Errorf("bad decode %#v", a)
+	}
+	if a.Addr.City != "Sofia" || a.Addr.Zip == nil || *a.Addr.Zip != 1000 {
+		t.Errorf("bad nested struct %#v", a.Addr)
+	}
+	if a.Work == nil || a.Work.City != "Boston" || a.Work.Zip != nil {
+		t.Errorf("bad nested pointer %#v", a.Work)
+	}
+	if a.Home != nil {
+		t.Errorf("expected nil Home, got %#v", a.Home)
+	}
+	if err := decodeMapToStruct(map[string][]string{"age": []string{"300"}}, &a); err == nil {
+		t.Errorf("expected overflow error")
+	}
+	if err := decodeMapToStruct(map[string][]string{"age": []string{"-1"}}, &a); err == nil {
+		t.Errorf("expected error decoding negative uint")
+	}
+}
+
+type sliceArgs struct {
+	Tag    []string
+	ID     []int
+	Weight []float64
+	Empty  []string
+}
+
+func TestDecodeSlice(t *testing.T) {
+	m := map[string][]string{
+		"tag":    []string{"a", "b"},
+		"id":     []string{"1,2", "3"},
+		"weight": []string{"0.5, 1.5"},
+	}
+	var a sliceArgs
+	if err := decodeMapToStruct(m, &a); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if len(a.Tag) != 2 || a.Tag[0] != "a" || a.Tag[1] != "b" {
+		t.Errorf("bad Tag %v", a.Tag)
+	}
+	if len(a.ID) != 3 || a.ID[0] != 1 || a.ID[1] != 2 || a.ID[2] != 3 {
+		t.Errorf("bad ID %v", a.ID)
+	}
+	if len(a.Weight) != 2 || a.Weight[0] != 0.5 || a.Weight[1] != 1.5 {
+		t.Errorf("bad Weight %v", a.Weight)
+	}
+	if a.Empty != nil {
+		t.Errorf("expected nil Empty, got %v", a.Empty)
+	}
+	if err := decodeMapToStruct(map[string][]string{"id": []string{"1,x"}}, &a); err == nil {
+		t.Errorf("expected error decoding bad element")
+	}
+}
+
+type timeArgs struct {
+	From   time.Time
+	To     *time.Time
+	Window Duration
+	Steps  []Duration
+}
+
+func TestDecodeTime(t *testing.T) {
+	m := map[string][]string{
+		"from":   []string{"2011-06-01T10:00:00Z"},
+		"to":     []string{"1306922400"},
+		"window": []string{"1h30m"},
+		"steps":  []string{"250ms,2s", "100"},
+	}
+	var a timeArgs
+	if err := decodeMapToStruct(m, &a); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if a.From.Seconds() != 1306922400 {
+		t.Errorf("bad From %s", a.From.String())
+	}
+	if a.To == nil || a.To.Seconds() != 1306922400 {
+		t.Errorf("bad To %v", a.To)
+	}
+	if a.Window != 90*60e9 {
+		t.Errorf("bad Window %d", a.Window)
+	}
+	if len(a.Steps) != 3 || a.Steps[0] != 250e6 || a.Steps[1] != 2e9 || a.Steps[2] != 100 {
+		t.Errorf("bad Steps %v", a.Steps)
+	}
+	for _, s := range []string{"", "h", "1x", "1.2.3s"} {
+		if _, err := parseDuration(s); err == nil {
+			t.Errorf("expected error parsing duration %q", s)
+		}
+	}
+}
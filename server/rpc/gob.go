@@ -0,0 +1,36 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"gob"
+	"os"
+	"github.com/petar/GoHTTP/http"
+)
+
+// GobContentType is the Content-Type of gob-encoded calls. Go clients
+// can avoid the cost of JSON by POSTing the gob encoding of the argument
+// value with this Content-Type. The reply value then comes back
+// gob-encoded as well, with the same Content-Type, while failed calls are
+// answered as usual. Values of interface type, e.g. in Args.Body and
+// Ret.Value, must have their concrete types registered with gob.Register.
+const GobContentType = "application/x-gob"
+
+// hasGobBody returns true if req is a POST or PUT request with a gob body.
+func hasGobBody(req *http.Request) bool {
+	if req.Body == nil || (req.Method != "POST" && req.Method != "PUT") {
+		return false
+	}
+	return mediaType(req.Header.Get("Content-Type")) == GobContentType
+}
+
+func gobEncode(v interface{}) ([]byte, os.Error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,37 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"gob"
+	"testing"
+	"github.com/petar/GoHTTP/http"
+)
+
+func TestGobBody(t *testing.T) {
+	req := &http.Request{
+		Method: "POST",
+		Header: http.Header{"Content-Type": []string{"application/x-gob"}},
+		Body:   http.NewBodyBytes(nil),
+	}
+	if !hasGobBody(req) {
+		t.Errorf("expected gob body")
+	}
+	req.Method = "GET"
+	if hasGobBody(req) {
+		t.Errorf("GET requests have no gob body")
+	}
+
+	qx := &queryCodec{gob: true}
+	b, ctype, err := qx.encode(&sumReply{C: 7})
+	if err != nil || ctype != GobContentType {
+		t.Fatalf("encode: %s %s", ctype, err)
+	}
+	var reply sumReply
+	if err = gob.NewDecoder(bytes.NewBuffer(b)).Decode(&reply); err != nil || reply.C != 7 {
+		t.Errorf("bad gob reply %#v (%v)", reply, err)
+	}
+}
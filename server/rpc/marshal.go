@@ -0,0 +1,173 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"json"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"github.com/petar/GoHTTP/http"
+)
+
+// A Marshaler encodes reply values in a particular format.
+type Marshaler func(v interface{}) ([]byte, os.Error)
+
+const (
+	JSONContentType = "application/json"
+	XMLContentType  = "application/xml"
+)
+
+// defaultMarshalers are the Marshalers every RPC sub starts with.
+var defaultMarshalers = map[string]Marshaler{
+	JSONContentType: json.Marshal,
+	XMLContentType:  MarshalXML,
+	"text/xml":      MarshalXML,
+}
+
+// RegisterMarshaler sets the Marshaler used for replies to clients that
+// accept contentType, e.g. "application/xml". The reply format is chosen
+// by the Accept header of the request: the first acceptable media type
+// that has a Marshaler wins, and JSON is used if there is none.
+func (rpcsub *RPC) RegisterMarshaler(contentType string, m Marshaler) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	// Copy on write, since codecs hold on to the map without locking
+	ms := make(map[string]Marshaler)
+	for k, v := range rpcsub.marshalers {
+		ms[k] = v
+	}
+	ms[strings.ToLower(contentType)] = m
+	rpcsub.marshalers = ms
+}
+
+// negotiate returns the content type and Marshaler for the reply to req.
+func negotiate(req *http.Request, marshalers map[string]Marshaler) (string, Marshaler) {
+	for _, accept := range http.ParseAccept(req.Header.List("Accept")) {
+		if m, ok := marshalers[accept.Value]; ok && accept.Q > 0 {
+			return accept.Value, m
+		}
+	}
+	return JSONContentType, json.Marshal
+}
+
+// MarshalXML encodes v as XML. Maps and structs become elements with one
+// child per key or exported field, named after it; slices and arrays
+// repeat the enclosing element once per item, and other values are
+// written as text. The root element is named "reply". Map keys that are
+// not XML names, e.g. "" or "a b", cannot be marshaled.
+func MarshalXML(v interface{}) ([]byte, os.Error) {
+	var w bytes.Buffer
+	w.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	if err := writeXML(&w, "reply", reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+func writeXML(w *bytes.Buffer, name string, v reflect.Value) os.Error {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			v = reflect.Value{}
+			break
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		fmt.Fprintf(w, "<%s/>", name)
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := writeXML(w, name, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return os.NewError("rpc: cannot marshal " + v.Type().String() + " to XML")
+		}
+		var keys []string
+		elems := make(map[string]reflect.Value)
+		for _, k := range v.MapKeys() {
+			if !isXMLName(k.String()) {
+				return os.NewError("rpc: cannot marshal map key " + strconv.Quote(k.String()) + " to XML")
+			}
+			keys = append(keys, k.String())
+			elems[k.String()] = v.MapIndex(k)
+		}
+		sort.SortStrings(keys)
+		fmt.Fprintf(w, "<%s>", name)
+		for _, k := range keys {
+			if err := writeXML(w, k, elems[k]); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "</%s>", name)
+		return nil
+	case reflect.Struct:
+		fmt.Fprintf(w, "<%s>", name)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			if err := writeXML(w, t.Field(i).Name, v.Field(i)); err != nil {
+				return err
+			}
+		}
+		fmt.Fprintf(w, "</%s>", name)
+		return nil
+	case reflect.Chan, reflect.Func, reflect.UnsafePointer:
+		return os.NewError("rpc: cannot marshal " + v.Type().String() + " to XML")
+	}
+	fmt.Fprintf(w, "<%s>", name)
+	xmlEscape(w, fmt.Sprint(v.Interface()))
+	fmt.Fprintf(w, "</%s>", name)
+	return nil
+}
+
+// isXMLName returns true if s is a valid XML element name. Names with
+// colons are refused, since they would refer to namespaces.
+func isXMLName(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		switch {
+		case unicode.IsLetter(c) || c == '_':
+		case i > 0 && (unicode.IsDigit(c) || c == '-' || c == '.'):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func xmlEscape(w *bytes.Buffer, s string) {
+	for _, c := range s {
+		switch c {
+		case '<':
+			w.WriteString("&lt;")
+		case '>':
+			w.WriteString("&gt;")
+		case '&':
+			w.WriteString("&amp;")
+		case '"':
+			w.WriteString("&#34;")
+		case '\'':
+			w.WriteString("&#39;")
+		default:
+			w.WriteRune(c)
+		}
+	}
+}
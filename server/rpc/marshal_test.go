@@ -0,0 +1,65 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"github.com/petar/GoHTTP/http"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept, ctype string
+	}{
+		{"", JSONContentType},
+		{"*/*", JSONContentType},
+		{"application/xml", XMLContentType},
+		{"text/html, application/xml;q=0.9", XMLContentType},
+		{"application/xml;q=0, application/json", JSONContentType},
+		{"text/xml", "text/xml"},
+		{"application/json;q=0.5, application/xml", XMLContentType},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: http.Header{"Accept": []string{tt.accept}}}
+		if ctype, _ := negotiate(req, defaultMarshalers); ctype != tt.ctype {
+			t.Errorf("Accept %q: expected %s, got %s", tt.accept, tt.ctype, ctype)
+		}
+	}
+}
+
+func TestMarshalXML(t *testing.T) {
+	v := map[string]interface{}{
+		"b":    []int{1, 2},
+		"a":    "x<y",
+		"sum":  &sumReply{C: 3},
+		"none": nil,
+	}
+	b, err := MarshalXML(v)
+	if err != nil {
+		t.Fatalf("MarshalXML: %s", err)
+	}
+	const expect = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<reply><a>x&lt;y</a><b>1</b><b>2</b><none/><sum><C>3</C></sum></reply>`
+	if string(b) != expect {
+		t.Errorf("expected\n%s\ngot\n%s", expect, b)
+	}
+}
+
+func TestMarshalXMLKeys(t *testing.T) {
+	for _, key := range []string{"a><script>alert(1)</script><b", "", "1x", "a b", "x:y", "-a"} {
+		if b, err := MarshalXML(map[string]int{key: 1}); err == nil {
+			t.Errorf("key %q marshaled to %s", key, b)
+		}
+	}
+	b, err := MarshalXML(map[string]int{"_a-1.b": 1, "é": 2})
+	if err != nil {
+		t.Fatalf("MarshalXML: %s", err)
+	}
+	const expect = `<?xml version="1.0" encoding="UTF-8"?>` + "\n" +
+		`<reply><_a-1.b>1</_a-1.b><é>2</é></reply>`
+	if string(b) != expect {
+		t.Errorf("expected\n%s\ngot\n%s", expect, b)
+	}
+}
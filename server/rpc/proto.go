@@ -0,0 +1,274 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"github.com/petar/GoHTTP/rpc"
+	"goprotobuf.googlecode.com/hg/proto"
+)
+
+// protoCodec is an rpc.ServerCodec that speaks length-prefixed protocol
+// buffers. Every request on the wire is a header message followed by a
+// body message, and likewise for every response. Each message is preceded
+// by its length, encoded as a base-128 varint. This is the framing used by
+// most proto-based RPC stacks, so clients in other languages can talk to
+// GoHTTP services using their stock proto tooling.
+//
+// The header messages are defined as follows:
+//
+//	message Request {
+//		required string service_method = 1;
+//		required uint64 seq = 2;
+//		optional int64 timeout = 3; // nanoseconds, zero for no deadline
+//	}
+//
+//	message Response {
+//		required string service_method = 1;
+//		required uint64 seq = 2;
+//		optional string error = 3;
+//		optional bool more = 4; // set on the items of a streamed reply
+//		optional int32 code = 5; // rpc.ErrorCode of the error
+//	}
+//
+// A streaming method answers with a sequence of responses with more set,
+// followed by a concluding response with an empty body.
+//
+// Argument and reply values of registered methods must be protocol
+// buffer structs generated by goprotobuf.
+type protoCodec struct {
+	rwc io.ReadWriteCloser
+	r   *bufio.Reader
+	w   *bufio.Writer
+}
+
+var ErrProto = os.NewError("malformed protobuf message")
+
+// maxFrameSize bounds the length of the messages read, so that a hostile
+// length prefix cannot make the codec allocate more.
+const maxFrameSize = 16 << 20
+
+// NewProtoServerCodec returns an rpc.StreamServerCodec that reads requests
+// from and writes responses to conn using length-prefixed protobufs.
+func NewProtoServerCodec(conn io.ReadWriteCloser) rpc.StreamServerCodec {
+	return &protoCodec{
+		rwc: conn,
+		r:   bufio.NewReader(conn),
+		w:   bufio.NewWriter(conn),
+	}
+}
+
+func (pc *protoCodec) ReadRequestHeader(req *rpc.Request) os.Error {
+	buf, err := pc.readFrame()
+	if err != nil {
+		return err
+	}
+	return decodeProtoHeader(buf, &req.ServiceMethod, &req.Seq, &req.Timeout, nil)
+}
+
+func (pc *protoCodec) ReadRequestBody(args interface{}) os.Error {
+	buf, err := pc.readFrame()
+	if err != nil {
+		return err
+	}
+	if args == nil {
+		return nil
+	}
+	return proto.Unmarshal(buf, args)
+}
+
+func (pc *protoCodec) WriteResponse(resp *rpc.Response, ret interface{}) (err os.Error) {
+	var body []byte
+	if resp.Error == "" && ret != nil {
+		if body, err = proto.Marshal(ret); err != nil {
+			return err
+		}
+	}
+	header := encodeProtoHeader(resp.ServiceMethod, resp.Seq, resp.Error)
+	if resp.Error != "" {
+		header = appendUvarint(header, 5<<3|wireVarint)
+		header = appendUvarint(header, uint64(resp.Code))
+	}
+	return pc.writeResponse(header, body)
+}
+
+func (pc *protoCodec) WriteStreamResponse(resp *rpc.Response, item interface{}) os.Error {
+	body, err := proto.Marshal(item)
+	if err != nil {
+		return err
+	}
+	header := encodeProtoHeader(resp.ServiceMethod, resp.Seq, "")
+	header = appendUvarint(header, 4<<3|wireVarint)
+	header = appendUvarint(header, 1)
+	return pc.writeResponse(header, body)
+}
+
+func (pc *protoCodec) writeResponse(header, body []byte) os.Error {
+	if err := pc.writeFrame(header); err != nil {
+		return err
+	}
+	if err := pc.writeFrame(body); err != nil {
+		return err
+	}
+	return pc.w.Flush()
+}
+
+func (pc *protoCodec) Close() os.Error { return pc.rwc.Close() }
+
+func (pc *protoCodec) readFrame() ([]byte, os.Error) {
+	n, err := readUvarint(pc.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxFrameSize {
+		return nil, ErrProto
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(pc.r, buf); err != nil {
+		if err == os.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (pc *protoCodec) writeFrame(buf []byte) os.Error {
+	if _, err := pc.w.Write(appendUvarint(nil, uint64(len(buf)))); err != nil {
+		return err
+	}
+	_, err := pc.w.Write(buf)
+	return err
+}
+
+// Protobuf wire types used by the header messages
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// encodeProtoHeader encodes a Request or Response header message.
+// An empty errmsg is omitted from the encoding, which makes the result a
+// valid Request message as well.
+func encodeProtoHeader(serviceMethod string, seq uint64, errmsg string) []byte {
+	var buf []byte
+	buf = appendUvarint(buf, 1<<3|wireBytes)
+	buf = appendUvarint(buf, uint64(len(serviceMethod)))
+	buf = append(buf, serviceMethod...)
+	buf = appendUvarint(buf, 2<<3|wireVarint)
+	buf = appendUvarint(buf, seq)
+	if errmsg != "" {
+		buf = appendUvarint(buf, 3<<3|wireBytes)
+		buf = appendUvarint(buf, uint64(len(errmsg)))
+		buf = append(buf, errmsg...)
+	}
+	return buf
+}
+
+// decodeProtoHeader parses a Request or Response header message.
+// Field 3 is the timeout of a Request or the error of a Response; it is
+// skipped if the respective pointer is nil. Unknown fields are skipped too.
+func decodeProtoHeader(buf []byte, serviceMethod *string, seq *uint64, timeout *int64, errmsg *string) os.Error {
+	for len(buf) > 0 {
+		key, n := uvarint(buf)
+		if n <= 0 {
+			return ErrProto
+		}
+		buf = buf[n:]
+		switch key & 7 {
+		case wireVarint:
+			v, n := uvarint(buf)
+			if n <= 0 {
+				return ErrProto
+			}
+			buf = buf[n:]
+			switch key >> 3 {
+			case 2:
+				*seq = v
+			case 3:
+				if timeout != nil {
+					*timeout = int64(v)
+				}
+			}
+		case wireBytes:
+			l, n := uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l {
+				return ErrProto
+			}
+			s := string(buf[n : n+int(l)])
+			buf = buf[n+int(l):]
+			switch key >> 3 {
+			case 1:
+				*serviceMethod = s
+			case 3:
+				if errmsg != nil {
+					*errmsg = s
+				}
+			}
+		case wireFixed64:
+			if len(buf) < 8 {
+				return ErrProto
+			}
+			buf = buf[8:]
+		case wireFixed32:
+			if len(buf) < 4 {
+				return ErrProto
+			}
+			buf = buf[4:]
+		default:
+			return ErrProto
+		}
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	for v >= 0x80 {
+		buf = append(buf, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(buf, byte(v))
+}
+
+// uvarint decodes a varint from the front of buf. It returns the value
+// and the number of bytes consumed, or n <= 0 if buf is malformed.
+func uvarint(buf []byte) (v uint64, n int) {
+	var s uint
+	for i, b := range buf {
+		if i == 10 {
+			return 0, -1
+		}
+		if b < 0x80 {
+			return v | uint64(b)<<s, i + 1
+		}
+		v |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return 0, 0
+}
+
+func readUvarint(r *bufio.Reader) (uint64, os.Error) {
+	var v uint64
+	var s uint
+	for i := 0; i < 10; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == os.EOF && i > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if b < 0x80 {
+			return v | uint64(b)<<s, nil
+		}
+		v |= uint64(b&0x7f) << s
+		s += 7
+	}
+	return 0, ErrProto
+}
@@ -0,0 +1,90 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestProtoHeader(t *testing.T) {
+	buf := encodeProtoHeader("Service.Method", 300, "boom")
+	var sm, errmsg string
+	var seq uint64
+	if err := decodeProtoHeader(buf, &sm, &seq, nil, &errmsg); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if sm != "Service.Method" || seq != 300 || errmsg != "boom" {
+		t.Errorf("got %q, %d, %q", sm, seq, errmsg)
+	}
+	if err := decodeProtoHeader(buf[:len(buf)-1], &sm, &seq, nil, &errmsg); err == nil {
+		t.Errorf("expected error on truncated header")
+	}
+}
+
+func TestProtoHeaderUnknownFields(t *testing.T) {
+	buf := encodeProtoHeader("Service.Method", 7, "")
+	buf = appendUvarint(buf, 9<<3|wireVarint)
+	buf = appendUvarint(buf, 1<<40)
+	buf = appendUvarint(buf, 10<<3|wireFixed64)
+	buf = append(buf, 1, 2, 3, 4, 5, 6, 7, 8)
+	buf = appendUvarint(buf, 11<<3|wireBytes)
+	buf = appendUvarint(buf, 3)
+	buf = append(buf, "abc"...)
+	buf = appendUvarint(buf, 12<<3|wireFixed32)
+	buf = append(buf, 1, 2, 3, 4)
+	var sm string
+	var seq uint64
+	var timeout int64
+	if err := decodeProtoHeader(buf, &sm, &seq, &timeout, nil); err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if sm != "Service.Method" || seq != 7 || timeout != 0 {
+		t.Errorf("got %q, %d, %d", sm, seq, timeout)
+	}
+	if err := decodeProtoHeader(buf[:len(buf)-1], &sm, &seq, &timeout, nil); err != ErrProto {
+		t.Errorf("truncated fixed32 field: got %v, want ErrProto", err)
+	}
+	bad := appendUvarint(nil, 1<<3|3) // Start group
+	if err := decodeProtoHeader(bad, &sm, &seq, &timeout, nil); err != ErrProto {
+		t.Errorf("unsupported wire type: got %v, want ErrProto", err)
+	}
+}
+
+func TestProtoFrame(t *testing.T) {
+	var b bytes.Buffer
+	pc := &protoCodec{r: bufio.NewReader(&b), w: bufio.NewWriter(&b)}
+	body := []byte(strings.Repeat("x", 200))
+	if err := pc.writeResponse(encodeProtoHeader("S.M", 1, ""), body); err != nil {
+		t.Fatalf("write: %s", err)
+	}
+	if _, err := pc.readFrame(); err != nil {
+		t.Fatalf("read header frame: %s", err)
+	}
+	if buf, err := pc.readFrame(); err != nil || !bytes.Equal(buf, body) {
+		t.Errorf("read body frame: got %d bytes, %v", len(buf), err)
+	}
+	if _, err := pc.readFrame(); err != os.EOF {
+		t.Errorf("read past the last frame: got %v, want EOF", err)
+	}
+
+	b.Write(appendUvarint(nil, 5))
+	b.WriteString("abc")
+	if _, err := pc.readFrame(); err != io.ErrUnexpectedEOF {
+		t.Errorf("truncated frame: got %v, want ErrUnexpectedEOF", err)
+	}
+	b.Write(appendUvarint(nil, maxFrameSize+1))
+	if _, err := pc.readFrame(); err != ErrProto {
+		t.Errorf("oversized frame: got %v, want ErrProto", err)
+	}
+	b.Write(appendUvarint(nil, 1<<63))
+	if _, err := pc.readFrame(); err != ErrProto {
+		t.Errorf("frame larger than memory: got %v, want ErrProto", err)
+	}
+}
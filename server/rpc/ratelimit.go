@@ -0,0 +1,153 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+var ErrRateLimited = rpc.NewError(rpc.CodeOverload, "rpc: rate limit exceeded")
+
+// RateLimit bounds the rate at which each client may call a method.
+// Calls are admitted as long as the client has tokens left; it starts
+// with Burst tokens, and regains Rate tokens every second, up to Burst.
+type RateLimit struct {
+	Rate  float64 // Tokens regained per second
+	Burst int     // Most tokens a client can hold
+}
+
+// A RateKeyFunc returns the key identifying the client of req, for the
+// purpose of rate limiting. The default key is the bearer token of the
+// request if present, and its remote IP address otherwise.
+type RateKeyFunc func(req *http.Request) string
+
+// maxRateBuckets is the number of clients tracked per method, beyond
+// which the clients that have regained all their tokens are forgotten.
+const maxRateBuckets = 4096
+
+// rateLimits holds the rate limits of the methods of an RPC sub.
+type rateLimits struct {
+	sync.Mutex
+	key     RateKeyFunc
+	methods map[string]*methodLimit
+}
+
+type methodLimit struct {
+	limit   RateLimit
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   int64 // Time of the last update, in nanoseconds
+}
+
+// SetRateLimit limits the rate at which each client may call the method
+// serviceMethod, of the form "Service.Method", in every API version.
+// Calls over the limit are answered with 429 Too Many Requests, and a
+// Retry-After header. A limit with a non-positive Rate removes the limit.
+func (rpcsub *RPC) SetRateLimit(serviceMethod string, limit RateLimit) {
+	rpcsub.limits.set(serviceMethod, limit)
+}
+
+// SetRateKey sets the function that identifies clients for rate limiting.
+func (rpcsub *RPC) SetRateKey(key RateKeyFunc) {
+	rpcsub.limits.Lock()
+	defer rpcsub.limits.Unlock()
+	rpcsub.limits.key = key
+}
+
+func (rl *rateLimits) set(serviceMethod string, limit RateLimit) {
+	rl.Lock()
+	defer rl.Unlock()
+	if limit.Rate <= 0 {
+		rl.methods[serviceMethod] = nil, false
+		return
+	}
+	if rl.methods == nil {
+		rl.methods = make(map[string]*methodLimit)
+	}
+	rl.methods[serviceMethod] = &methodLimit{limit: limit, buckets: make(map[string]*rateBucket)}
+}
+
+// clientKey returns the key of the client of req.
+func (rl *rateLimits) clientKey(req *http.Request) string {
+	rl.Lock()
+	key := rl.key
+	rl.Unlock()
+	if key != nil {
+		return key(req)
+	}
+	if token := bearerToken(req.Header.Get("Authorization")); token != "" {
+		return "token:" + token
+	}
+	addr := req.RemoteAddr
+	if i := strings.LastIndex(addr, ":"); i >= 0 {
+		addr = addr[:i]
+	}
+	return "addr:" + addr
+}
+
+// take takes a token for a call to serviceMethod by the client key.
+// It returns zero if the call is admitted, and otherwise the number
+// of nanoseconds until the client regains a token.
+func (rl *rateLimits) take(serviceMethod, key string) int64 {
+	rl.Lock()
+	defer rl.Unlock()
+	ml := rl.methods[serviceMethod]
+	if ml == nil {
+		return 0
+	}
+	now := time.Nanoseconds()
+	b := ml.buckets[key]
+	if b == nil {
+		if len(ml.buckets) >= maxRateBuckets {
+			ml.prune(now)
+		}
+		b = &rateBucket{tokens: float64(ml.limit.Burst), last: now}
+		ml.buckets[key] = b
+	}
+	ml.refill(b, now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return int64((1 - b.tokens) / ml.limit.Rate * 1e9)
+}
+
+func (ml *methodLimit) refill(b *rateBucket, now int64) {
+	b.tokens += float64(now-b.last) / 1e9 * ml.limit.Rate
+	if b.tokens > float64(ml.limit.Burst) {
+		b.tokens = float64(ml.limit.Burst)
+	}
+	b.last = now
+}
+
+// prune forgets the clients that have regained all their tokens.
+func (ml *methodLimit) prune(now int64) {
+	for key, b := range ml.buckets {
+		ml.refill(b, now)
+		if b.tokens >= float64(ml.limit.Burst) {
+			ml.buckets[key] = nil, false
+		}
+	}
+}
+
+// newTooManyResponse returns a 429 response, asking the
+// client to retry after wait nanoseconds.
+func newTooManyResponse(req *http.Request, wait int64) *http.Response {
+	resp := http.NewResponse200(req)
+	resp.StatusCode = http.StatusTooManyRequests
+	resp.Status = http.StatusText(http.StatusTooManyRequests)
+	secs := (wait + 1e9 - 1) / 1e9
+	resp.Header = make(http.Header)
+	resp.Header.Set("Retry-After", strconv.Itoa64(secs))
+	return resp
+}
@@ -0,0 +1,52 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"github.com/petar/GoHTTP/http"
+)
+
+func TestRateLimit(t *testing.T) {
+	var rl rateLimits
+	rl.set("Arith.Add", RateLimit{Rate: 0.001, Burst: 2})
+
+	for i := 0; i < 2; i++ {
+		if wait := rl.take("Arith.Add", "a"); wait != 0 {
+			t.Fatalf("call %d: unexpected wait %d", i, wait)
+		}
+	}
+	if wait := rl.take("Arith.Add", "a"); wait <= 0 {
+		t.Errorf("expected call over the burst to wait")
+	}
+	if wait := rl.take("Arith.Add", "b"); wait != 0 {
+		t.Errorf("clients must have separate budgets")
+	}
+	if wait := rl.take("Arith.Mul", "a"); wait != 0 {
+		t.Errorf("methods without a limit must not wait")
+	}
+
+	rl.set("Arith.Add", RateLimit{})
+	if wait := rl.take("Arith.Add", "a"); wait != 0 {
+		t.Errorf("removed limit still applies")
+	}
+}
+
+func TestRateKey(t *testing.T) {
+	var rl rateLimits
+	req := &http.Request{Header: http.Header{}, RemoteAddr: "10.0.0.1:4321"}
+	if key := rl.clientKey(req); key != "addr:10.0.0.1" {
+		t.Errorf("bad address key %q", key)
+	}
+	req.Header.Set("Authorization", "Bearer xyz")
+	if key := rl.clientKey(req); key != "token:xyz" {
+		t.Errorf("bad token key %q", key)
+	}
+
+	resp := newTooManyResponse(req, 1500e6)
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") != "2" {
+		t.Errorf("bad 429 response %d %v", resp.StatusCode, resp.Header)
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"strings"
+	"github.com/petar/GoHTTP/http"
+)
+
+// pathRoute maps the URL paths matching a pattern to a method.
+type pathRoute struct {
+	verb          string
+	segments      []string
+	serviceMethod string
+}
+
+// Map routes calls with the HTTP method verb, and a URL path below the
+// sub (or one of its versions) matching pattern, to the method
+// serviceMethod, of the form "Service.Method". An empty verb matches any
+// HTTP method. The segments of pattern that begin with a colon match any
+// single path segment, whose value is passed to the method as an argument
+// named after the segment. For example, after
+//
+//	rpcsub.Map("GET", "/users/:id", "Users.Get")
+//
+// a GET of /users/42 calls Users.Get with the argument id set to 42,
+// and sets Vars["id"] of its Query to 42.
+// Path arguments take precedence over URL parameters and body fields of
+// the same name. Routes are tried in the order they were added, before
+// the usual mapping of /Service/Method paths.
+func (rpcsub *RPC) Map(verb, pattern, serviceMethod string) os.Error {
+	if len(strings.Split(serviceMethod, ".")) != 2 {
+		return os.NewError("rpc: malformed method name " + serviceMethod)
+	}
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	rpcsub.routes = append(rpcsub.routes, &pathRoute{
+		verb:          verb,
+		segments:      splitPath(pattern),
+		serviceMethod: serviceMethod,
+	})
+	return nil
+}
+
+// mapPath rewrites the URL path of req to that of the method its route
+// maps to, if any, and returns the path arguments of the route.
+func (rpcsub *RPC) mapPath(req *http.Request) map[string][]string {
+	verb := req.Method
+	if verb == "OPTIONS" {
+		// Match CORS preflights against the verb of the actual call
+		verb = req.Header.Get("Access-Control-Request-Method")
+	}
+	segments := splitPath(req.URL.Path)
+	rpcsub.Lock()
+	routes := rpcsub.routes
+	rpcsub.Unlock()
+	for _, r := range routes {
+		if r.verb != "" && r.verb != verb {
+			continue
+		}
+		if params, ok := r.match(segments); ok {
+			req.URL.Path = "/" + strings.Replace(r.serviceMethod, ".", "/", -1)
+			return params
+		}
+	}
+	return nil
+}
+
+// match returns the path arguments, if the path segments match the route.
+func (r *pathRoute) match(segments []string) (map[string][]string, bool) {
+	if len(segments) != len(r.segments) {
+		return nil, false
+	}
+	var params map[string][]string
+	for i, s := range r.segments {
+		if strings.HasPrefix(s, ":") {
+			if params == nil {
+				params = make(map[string][]string)
+			}
+			params[s[1:]] = []string{segments[i]}
+		} else if s != segments[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+func splitPath(p string) []string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
@@ -0,0 +1,57 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"url"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/server"
+)
+
+func TestMapPath(t *testing.T) {
+	rpcsub := NewRPC()
+	rpcsub.Map("GET", "/users/:id", "Users.Get")
+	rpcsub.Map("", "/users/:id/posts/:post", "Posts.Get")
+	if err := rpcsub.Map("GET", "/x", "Users"); err == nil {
+		t.Errorf("expected error for malformed method name")
+	}
+
+	req := &http.Request{Method: "GET", URL: &url.URL{Path: "/users/42"}}
+	params := rpcsub.mapPath(req)
+	if req.URL.Path != "/Users/Get" || len(params["id"]) != 1 || params["id"][0] != "42" {
+		t.Errorf("bad mapping to %q with %v", req.URL.Path, params)
+	}
+
+	req = &http.Request{Method: "POST", URL: &url.URL{Path: "/users/42/posts/7/"}}
+	params = rpcsub.mapPath(req)
+	if req.URL.Path != "/Posts/Get" || params["id"][0] != "42" || params["post"][0] != "7" {
+		t.Errorf("bad mapping to %q with %v", req.URL.Path, params)
+	}
+
+	req = &http.Request{Method: "DELETE", URL: &url.URL{Path: "/users/42"}}
+	if params = rpcsub.mapPath(req); params != nil || req.URL.Path != "/users/42" {
+		t.Errorf("route matched the wrong verb")
+	}
+}
+
+func TestServeVars(t *testing.T) {
+	rpcsub := NewRPC()
+	rpcsub.Map("", "/users/:id/posts/:post", "Posts.Get")
+
+	// PATCH calls are refused before they reach a codec, so the queries
+	// need no connection
+	q := &server.Query{Req: &http.Request{Method: "PATCH", URL: &url.URL{Path: "/users/42/posts/7"}}}
+	rpcsub.Serve(q)
+	if len(q.Vars) != 2 || q.Vars["id"] != "42" || q.Vars["post"] != "7" {
+		t.Errorf("bad vars %v", q.Vars)
+	}
+
+	q = &server.Query{Req: &http.Request{Method: "PATCH", URL: &url.URL{Path: "/Posts/Get"}}}
+	rpcsub.Serve(q)
+	if q.Vars != nil {
+		t.Errorf("unrouted path set vars %v", q.Vars)
+	}
+}
@@ -5,9 +5,12 @@
 package rpc
 
 import (
+	"io"
 	"os"
-	"rpc"
+	"strings"
 	"sync"
+	"time"
+	"github.com/petar/GoHTTP/rpc"
 	"github.com/petar/GoHTTP/server"
 )
 
@@ -19,14 +22,21 @@ import (
 // body.
 type RPC struct {
 	rpcs       *rpc.Server // does not need locking, since re-entrant
-	sync.Mutex             // protects auto
+	sync.Mutex             // protects the fields below, except limits
 	auto       uint64
+	cors       *CORS
+	versions   map[string]*version
+	limits     rateLimits
+	marshalers map[string]Marshaler
+	routes     []*pathRoute
+	logger     AccessLogger
 }
 
 func NewRPC() *RPC {
 	return &RPC{
-		rpcs: rpc.NewServer(),
-		auto: 1, // Start seq numbers from 1, so that 0 is always an invalid seq number
+		rpcs:       rpc.NewServer(),
+		marshalers: defaultMarshalers,
+		auto:       1, // Start seq numbers from 1, so that 0 is always an invalid seq number
 	}
 }
 
@@ -38,12 +48,100 @@ func (rpcsub *RPC) RegisterName(name string, rcvr interface{}) os.Error {
 	return rpcsub.rpcs.RegisterName(name, rcvr)
 }
 
+// SetAuthorizer installs auth to be consulted before every call.
+// The caller data available to auth is described by the Caller constants.
+func (rpcsub *RPC) SetAuthorizer(auth rpc.Authorizer) {
+	rpcsub.rpcs.SetAuthorizer(auth)
+}
+
+// SetMaxCalls bounds the number of calls running at once, and the
+// number of calls queued behind them, as in rpc.Server.SetMaxCalls.
+func (rpcsub *RPC) SetMaxCalls(maxCalls, maxQueue int) {
+	rpcsub.rpcs.SetMaxCalls(maxCalls, maxQueue)
+}
+
+// SetCORS enables cross-origin calls from browsers as configured by cors.
+// A nil cors disables them, which is the default.
+func (rpcsub *RPC) SetCORS(cors *CORS) {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	rpcsub.cors = cors
+}
+
 func (rpcsub *RPC) Serve(q *server.Query) {
-	qx := &queryCodec{Query: q}
+	qx := &queryCodec{Query: q, t0: time.Nanoseconds()}
 	rpcsub.Lock()
 	qx.seq = rpcsub.auto
 	rpcsub.auto++
+	qx.cors = rpcsub.cors
+	qx.marshalers = rpcsub.marshalers
+	qx.logger = rpcsub.logger
 	rpcsub.Unlock()
+	rpcs, v := rpcsub.route(q.Req)
+	qx.version = v
+	qx.params = rpcsub.mapPath(q.Req)
+	for k, vv := range qx.params {
+		if q.Vars == nil {
+			q.Vars = make(map[string]string)
+		}
+		q.Vars[k] = vv[0]
+	}
+	if qx.cors != nil && q.Req.Method == "OPTIONS" {
+		registered := rpcs.HasMethod(pathToServiceMethod(q.Req.URL.Path))
+		q.ContinueAndWrite(qx.cors.preflight(q.Req, registered))
+		return
+	}
+	if isStub(q.Req) {
+		base := stubBase(q.OrigPath(), q.Req)
+		q.ContinueAndWrite(newStubResponse(q.Req, base, rpcs))
+		return
+	}
+	if isSchema(q.Req) {
+		q.ContinueAndWrite(newSchemaResponse(q.Req, rpcs))
+		return
+	}
+	if !verbAllowed(q.Req.Method) {
+		q.ContinueAndWrite(newVerbResponse(q.Req))
+		return
+	}
+	qx.limits = &rpcsub.limits
+	if isBatch(q.Req) {
+		q.Continue()
+		serveBatch(rpcs, qx)
+		return
+	}
+	sm := pathToServiceMethod(q.Req.URL.Path)
+	if wait := qx.limits.take(sm, qx.limits.clientKey(q.Req)); wait > 0 {
+		if qx.logger != nil {
+			resp := &rpc.Response{ServiceMethod: sm, Code: ErrRateLimited.Code, Error: ErrRateLimited.Message}
+			logAccess(qx.logger, qx.t0, resp, qx.caller())
+		}
+		q.ContinueAndWrite(newTooManyResponse(q.Req, wait))
+		return
+	}
 	q.Continue()
-	rpcsub.rpcs.ServeCodec(qx)
+	rpcs.ServeCodec(qx)
+}
+
+// ServeProto serves the registered services on conn, using the
+// length-prefixed protobuf wire format. It blocks until the client hangs up.
+func (rpcsub *RPC) ServeProto(conn io.ReadWriteCloser) {
+	rpcsub.rpcs.ServeCodec(NewProtoServerCodec(conn))
+}
+
+// MethodStats returns the call statistics of the registered methods.
+func (rpcsub *RPC) MethodStats() []rpc.MethodStats {
+	return rpcsub.rpcs.MethodStats()
+}
+
+// SummaryLine implements server.StatsReporter, summarizing the calls to
+// each registered method. Add the RPC sub to the server with
+// AddStatsReporter to have it logged along with the server statistics.
+func (rpcsub *RPC) SummaryLine() string {
+	ms := rpcsub.rpcs.MethodStats()
+	lines := make([]string, len(ms))
+	for i, _ := range ms {
+		lines[i] = ms[i].String()
+	}
+	return "RPC " + strings.Join(lines, "; ")
 }
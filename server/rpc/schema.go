@@ -0,0 +1,129 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"json"
+	"reflect"
+	"strings"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+// SchemaPath is the path, below the RPC sub or one of its versions, at
+// which a JSON description of the registered methods is served. The
+// description is a Schema, derived from the methods' Go types.
+const SchemaPath = "schema"
+
+// Schema describes the registered services of an RPC registry.
+type Schema struct {
+	Services []ServiceSchema
+}
+
+// ServiceSchema describes a registered service.
+type ServiceSchema struct {
+	Name    string
+	Methods []MethodSchema // sorted by name
+}
+
+// MethodSchema describes a registered method. The reply of a streaming
+// method describes the type of the items it sends, which is not known
+// statically, so it is nil.
+type MethodSchema struct {
+	Name   string
+	Args   *TypeSchema
+	Reply  *TypeSchema
+	Stream bool
+}
+
+// TypeSchema describes a Go type. Pointers are described by the types
+// they point to. A named struct type that refers to itself is described
+// in full only once; inner references carry just its Kind and Name.
+type TypeSchema struct {
+	Kind   string        // Go kind, e.g. "struct", "string", "int64", "slice"
+	Name   string        // Type name, e.g. "time.Time", or "" if unnamed
+	Fields []FieldSchema // Exported fields of structs
+	Key    *TypeSchema   // Key type of maps
+	Elem   *TypeSchema   // Element type of slices, arrays and maps
+}
+
+// FieldSchema describes a struct field.
+type FieldSchema struct {
+	Name string
+	Type *TypeSchema
+}
+
+// isSchema returns true if req asks for the schema.
+func isSchema(req *http.Request) bool {
+	return req.Method == "GET" && strings.Trim(req.URL.Path, "/") == SchemaPath
+}
+
+// describeSchema returns the schema of the services registered with rpcs.
+func describeSchema(rpcs *rpc.Server) *Schema {
+	schema := &Schema{}
+	for _, si := range rpcs.Services() {
+		ss := ServiceSchema{Name: si.Name}
+		for _, mi := range si.Methods {
+			argType, replyType, ok := rpcs.MethodType(si.Name + "." + mi.Name)
+			if !ok {
+				// Unregistered meanwhile
+				continue
+			}
+			ms := MethodSchema{
+				Name:   mi.Name,
+				Args:   describeType(argType, make(map[reflect.Type]bool)),
+				Stream: mi.Stream,
+			}
+			if !mi.Stream {
+				ms.Reply = describeType(replyType, make(map[reflect.Type]bool))
+			}
+			ss.Methods = append(ss.Methods, ms)
+		}
+		schema.Services = append(schema.Services, ss)
+	}
+	return schema
+}
+
+// describeType returns the schema of t. Seen holds the struct
+// types being described, to stop at recursive references.
+func describeType(t reflect.Type, seen map[reflect.Type]bool) *TypeSchema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	ts := &TypeSchema{Kind: t.Kind().String()}
+	if t.Name() != "" {
+		ts.Name = t.String()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if seen[t] || t == typeOfTime {
+			break
+		}
+		seen[t] = true
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.PkgPath != "" {
+				continue
+			}
+			ts.Fields = append(ts.Fields, FieldSchema{Name: f.Name, Type: describeType(f.Type, seen)})
+		}
+		seen[t] = false
+	case reflect.Map:
+		ts.Key = describeType(t.Key(), seen)
+		ts.Elem = describeType(t.Elem(), seen)
+	case reflect.Slice, reflect.Array:
+		ts.Elem = describeType(t.Elem(), seen)
+	}
+	return ts
+}
+
+// newSchemaResponse returns a response carrying the schema of rpcs.
+func newSchemaResponse(req *http.Request, rpcs *rpc.Server) *http.Response {
+	body, err := json.Marshal(describeSchema(rpcs))
+	if err != nil {
+		return http.NewResponse500(req)
+	}
+	return newBodyResponse(req, http.StatusOK, JSONContentType, body)
+}
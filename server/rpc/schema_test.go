@@ -0,0 +1,47 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"reflect"
+	"testing"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+type treeNode struct {
+	Label    string
+	Children []*treeNode
+	private  int
+}
+
+func TestDescribeType(t *testing.T) {
+	ts := describeType(reflect.TypeOf(&treeNode{}), make(map[reflect.Type]bool))
+	if ts.Kind != "struct" || ts.Name != "rpc.treeNode" || len(ts.Fields) != 2 {
+		t.Fatalf("bad schema %#v", ts)
+	}
+	if f := ts.Fields[0]; f.Name != "Label" || f.Type.Kind != "string" {
+		t.Errorf("bad field %#v", f)
+	}
+	children := ts.Fields[1].Type
+	if children.Kind != "slice" || children.Elem.Name != "rpc.treeNode" || children.Elem.Fields != nil {
+		t.Errorf("bad recursive field %#v", children)
+	}
+}
+
+func TestDescribeSchema(t *testing.T) {
+	rpcs := rpc.NewServer()
+	rpcs.Register(new(Summer))
+	schema := describeSchema(rpcs)
+	if len(schema.Services) != 1 || len(schema.Services[0].Methods) != 2 {
+		t.Fatalf("bad schema %#v", schema)
+	}
+	add := schema.Services[0].Methods[0]
+	if add.Name != "Add" || len(add.Args.Fields) != 2 || add.Args.Fields[1].Name != "B" {
+		t.Errorf("bad Add args %#v", add.Args)
+	}
+	if add.Reply.Name != "rpc.sumReply" || add.Reply.Fields[0].Type.Kind != "int" {
+		t.Errorf("bad Add reply %#v", add.Reply)
+	}
+}
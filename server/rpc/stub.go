@@ -0,0 +1,110 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"bytes"
+	"fmt"
+	"json"
+	"strings"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+// StubPath is the path, below the RPC sub or one of its versions, at which
+// a JavaScript file of client stubs for the registered methods is served.
+// Since method names are capitalized, it cannot collide with a method.
+//
+// The stubs are attached to a global RPC object, one function per method:
+//
+//	RPC.Arith.Add({A: 1, B: 2}, function(err, result) { ... })
+//
+// Each call is a POST with a JSON body to the method's URL. The callback
+// receives the decoded JSON reply, or an Error holding the response body
+// if the call failed. Streaming methods get no stubs.
+const StubPath = "client.js"
+
+const stubPrologue = `// Client stubs for RPC services. Generated; do not edit.
+var RPC = RPC || {};
+(function() {
+	var base = %s;
+	function call(path, args, callback) {
+		var xhr = new XMLHttpRequest();
+		xhr.open("POST", base + path, true);
+		xhr.setRequestHeader("Content-Type", "application/json");
+		xhr.onreadystatechange = function() {
+			if (xhr.readyState != 4 || !callback) {
+				return;
+			}
+			if (xhr.status < 200 || xhr.status >= 300) {
+				callback(new Error(xhr.responseText || xhr.statusText), null);
+				return;
+			}
+			var result = null;
+			if (xhr.responseText) {
+				try {
+					result = JSON.parse(xhr.responseText);
+				} catch (e) {
+					callback(e, null);
+					return;
+				}
+			}
+			callback(null, result);
+		};
+		xhr.send(JSON.stringify(args || {}));
+	}
+`
+
+const stubEpilogue = `})();
+`
+
+// isStub returns true if req asks for the client stubs.
+func isStub(req *http.Request) bool {
+	return req.Method == "GET" && strings.Trim(req.URL.Path, "/") == StubPath
+}
+
+// stubBase returns the URL path of the registry that serves req, given
+// the path of the request as it was received.
+func stubBase(origPath string, req *http.Request) string {
+	p := req.URL.Path
+	if strings.HasSuffix(origPath, p) {
+		origPath = origPath[:len(origPath)-len(p)]
+	}
+	if !strings.HasSuffix(origPath, "/") {
+		origPath += "/"
+	}
+	return origPath
+}
+
+// generateStub returns the JavaScript client stubs for services,
+// which are served at the URL path base.
+func generateStub(base string, services []rpc.ServiceInfo) []byte {
+	var w bytes.Buffer
+	fmt.Fprintf(&w, stubPrologue, jsString(base))
+	for _, si := range services {
+		fmt.Fprintf(&w, "\tRPC[%s] = {\n", jsString(si.Name))
+		for _, mi := range si.Methods {
+			if mi.Stream {
+				continue
+			}
+			fmt.Fprintf(&w, "\t\t%s: function(args, callback) { call(%s, args, callback); },\n",
+				jsString(mi.Name), jsString(si.Name+"/"+mi.Name))
+		}
+		fmt.Fprintf(&w, "\t};\n")
+	}
+	w.WriteString(stubEpilogue)
+	return w.Bytes()
+}
+
+// jsString returns s as a JavaScript string literal.
+func jsString(s string) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// newStubResponse returns a response carrying the client stubs for rpcs.
+func newStubResponse(req *http.Request, base string, rpcs *rpc.Server) *http.Response {
+	return newBodyResponse(req, http.StatusOK, "application/javascript", generateStub(base, rpcs.Services()))
+}
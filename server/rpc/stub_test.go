@@ -0,0 +1,40 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"strings"
+	"testing"
+	"url"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+func TestStubBase(t *testing.T) {
+	req := &http.Request{URL: &url.URL{Path: "/client.js"}}
+	if b := stubBase("/api/v2/client.js", req); b != "/api/v2/" {
+		t.Errorf("bad base %q", b)
+	}
+	req.URL.Path = "client.js"
+	if b := stubBase("/api/client.js", req); b != "/api/" {
+		t.Errorf("bad base %q", b)
+	}
+}
+
+func TestGenerateStub(t *testing.T) {
+	rpcs := rpc.NewServer()
+	rpcs.Register(new(Summer))
+	js := string(generateStub("/api/", rpcs.Services()))
+	for _, s := range []string{
+		`var base = "/api/";`,
+		`RPC["Summer"] = {`,
+		`"Add": function(args, callback) { call("Summer/Add", args, callback); },`,
+		`"Echo": function(args, callback) { call("Summer/Echo", args, callback); },`,
+	} {
+		if !strings.Contains(js, s) {
+			t.Errorf("stub lacks %q:\n%s", s, js)
+		}
+	}
+}
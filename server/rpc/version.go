@@ -0,0 +1,92 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"os"
+	"strings"
+	"github.com/petar/GoHTTP/http"
+	"github.com/petar/GoHTTP/rpc"
+)
+
+var ErrNoVersion = os.NewError("rpc: unknown API version")
+
+// version is a versioned registry of services of an RPC sub.
+type version struct {
+	rpcs       *rpc.Server
+	deprecated bool
+	sunset     string
+}
+
+// Version returns the registry of services of the API version name, e.g.
+// "v2", creating it if necessary. Calls to /name/Service/Method below the
+// sub are served by this registry, while calls without a version prefix
+// are served by the services registered with the sub itself. This allows
+// incompatible revisions of a service to be served side by side.
+//
+// The registry is a server of its own: its authorizer, call limits and
+// statistics are independent of those of the sub.
+func (rpcsub *RPC) Version(name string) *rpc.Server {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	if rpcsub.versions == nil {
+		rpcsub.versions = make(map[string]*version)
+	}
+	v := rpcsub.versions[name]
+	if v == nil {
+		v = &version{rpcs: rpc.NewServer()}
+		rpcsub.versions[name] = v
+	}
+	return v.rpcs
+}
+
+// Deprecate marks the API version name as deprecated. Responses to its
+// calls carry a "Deprecation: true" header and, if sunset is non-empty, a
+// Sunset header announcing the HTTP date on which the version goes away.
+func (rpcsub *RPC) Deprecate(name, sunset string) os.Error {
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	v := rpcsub.versions[name]
+	if v == nil {
+		return ErrNoVersion
+	}
+	v.deprecated = true
+	v.sunset = sunset
+	return nil
+}
+
+// route returns the registry that serves req, stripping the version
+// prefix, if any, from the URL path. It returns a copy of the version as
+// well, which is nil for the unversioned registry.
+func (rpcsub *RPC) route(req *http.Request) (*rpc.Server, *version) {
+	p := strings.TrimLeft(req.URL.Path, "/")
+	name, rest := p, ""
+	if i := strings.Index(p, "/"); i >= 0 {
+		name, rest = p[:i], p[i:]
+	}
+	rpcsub.Lock()
+	defer rpcsub.Unlock()
+	v := rpcsub.versions[name]
+	if v == nil {
+		return rpcsub.rpcs, nil
+	}
+	req.URL.Path = rest
+	vc := *v
+	return v.rpcs, &vc
+}
+
+// setHeaders adds the deprecation headers of v to resp, if any.
+func (v *version) setHeaders(resp *http.Response) {
+	if !v.deprecated {
+		return
+	}
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Deprecation", "true")
+	if v.sunset != "" {
+		resp.Header.Set("Sunset", v.sunset)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rpc
+
+import (
+	"testing"
+	"url"
+	"github.com/petar/GoHTTP/http"
+)
+
+func TestVersionRoute(t *testing.T) {
+	rpcsub := NewRPC()
+	v2 := rpcsub.Version("v2")
+	if rpcsub.Version("v2") != v2 {
+		t.Fatalf("Version is not idempotent")
+	}
+
+	req := &http.Request{URL: &url.URL{Path: "/v2/Arith/Add"}}
+	rpcs, v := rpcsub.route(req)
+	if rpcs != v2 || v == nil || req.URL.Path != "/Arith/Add" {
+		t.Errorf("bad route to v2: path %q", req.URL.Path)
+	}
+
+	req = &http.Request{URL: &url.URL{Path: "/Arith/Add"}}
+	rpcs, v = rpcsub.route(req)
+	if rpcs != rpcsub.rpcs || v != nil || req.URL.Path != "/Arith/Add" {
+		t.Errorf("bad route to unversioned registry: path %q", req.URL.Path)
+	}
+
+	if err := rpcsub.Deprecate("v9", ""); err != ErrNoVersion {
+		t.Errorf("expected ErrNoVersion, got %v", err)
+	}
+	if err := rpcsub.Deprecate("v2", "Sat, 01 Jan 2022 00:00:00 GMT"); err != nil {
+		t.Fatalf("Deprecate: %s", err)
+	}
+	_, v = rpcsub.route(&http.Request{URL: &url.URL{Path: "/v2/Arith/Add"}})
+	resp := http.NewResponse200(nil)
+	v.setHeaders(resp)
+	if resp.Header.Get("Deprecation") != "true" || resp.Header.Get("Sunset") != "Sat, 01 Jan 2022 00:00:00 GMT" {
+		t.Errorf("bad deprecation headers %v", resp.Header)
+	}
+}
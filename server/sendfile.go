@@ -0,0 +1,48 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"io"
+	"net/http"
+	"os"
+)
+
+// A FileBody is a response body read from a file, e.g. the contents of a
+// large file streamed from a cache. If the rest of the body is the rest
+// of its file, Write hands the file to the connection, which sends it
+// with sendfile where the platform allows, instead of copying it
+// through user space.
+type FileBody interface {
+	io.ReadCloser
+	SourceFile() *os.File // File the body is read from, or nil
+}
+
+// fileBody returns the file holding the rest of the body of resp, or nil
+// if the body is not read from a file, or only from part of one.
+func fileBody(resp *http.Response) *os.File {
+	if resp.ContentLength <= 0 || len(resp.TransferEncoding) > 0 {
+		return nil
+	}
+	var f *os.File
+	switch b := resp.Body.(type) {
+	case *os.File:
+		f = b
+	case FileBody:
+		f = b.SourceFile()
+	}
+	if f == nil {
+		return nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil
+	}
+	off, err := f.Seek(0, os.SEEK_CUR)
+	if err != nil || fi.Size()-off != resp.ContentLength {
+		return nil
+	}
+	return f
+}
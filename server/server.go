@@ -6,7 +6,8 @@ package server
 
 import (
 	//"fmt"
-	"container/list"
+	"errors"
+	"html/template"
 	"log"
 	"net"
 	"os"
@@ -17,21 +18,31 @@ import (
 	"github.com/petar/GoHTTP/util"
 )
 
+var errParkUnsupported = errors.New("server: parking idle connections is not supported")
+
 // Server automates the reception of incoming HTTP connections
 // at a given net.Listener. Server accepts new connections and
 // manages each one with an ServerConn object. Server also
 // makes sure that a pre-specified limit of active connections (i.e.
 // file descriptors) is not exceeded.
 type Server struct {
-	sync.Mutex // protects listen and conns
+	sync.Mutex              // protects listen and pending
+	cfg        sync.RWMutex // protects subs, exts, reps, errorTmpl and requestID
 
 	// Real-time state
-	listen net.Listener
-	conns  map[*StampedServerConn]int
-	qch    chan *Query
-	fdl    util.FDLimiter
-	subs   []*subcfg
-	exts   []*extcfg
+	listen  net.Listener
+	conns   connSet
+	pending int // Number of queries read but not yet settled
+	qch     chan *Query
+	fdl     *util.FDLimiter
+	subs    []*subcfg
+	exts    []*extcfg
+	reps    []StatsReporter
+	parker  *parker    // Parks idle connections, if config.ParkIdle is set
+	pool    *queryPool // Released queries, if config.PoolQueries is set
+
+	errorTmpl *template.Template // Template of error pages, or nil for the built-in page
+	requestID string             // Request header holding the ID of requests, if any
 
 	config Config // Server configuration
 	stats  Stats  // Real-time statistics
@@ -42,18 +53,38 @@ type Server struct {
 // timout set to tmo nanoseconds. The Server object ensures that at no
 // time more than fdlim file descriptors are allocated to incoming connections.
 func NewServer(l net.Listener, config Config, fdlim int) *Server {
+	fdl := new(util.FDLimiter)
+	fdl.Init(fdlim)
+	return NewServerLimiter(l, config, fdl)
+}
+
+// NewServerLimiter is like NewServer, but allocates the file descriptors of
+// incoming connections from fdl, which is owned by the caller and may be
+// shared with other components, e.g. the client of a proxy, to enforce one
+// budget of file descriptors across the process.
+func NewServerLimiter(l net.Listener, config Config, fdl *util.FDLimiter) *Server {
 	if config.Timeout < 2 {
 		panic("timeout too small")
 	}
-	// TODO(petar): Perhaps a better design passes the FDLimiter as a parameter
 	srv := &Server{
 		config: config,
 		listen: l,
-		conns:  make(map[*StampedServerConn]int),
 		qch:    make(chan *Query),
+		fdl:    fdl,
 	}
-	srv.fdl.Init(fdlim)
+	srv.conns.init(config.Timeout)
 	srv.stats.Init()
+	if config.PoolQueries {
+		srv.pool = newQueryPool(config.DebugPool)
+	}
+	if config.ParkIdle {
+		p, err := newParker(func(ssc *StampedServerConn) { go srv.read(ssc) })
+		if err != nil {
+			log.Printf("Parking idle connections: %s\n", err)
+		} else {
+			srv.parker = p
+		}
+	}
 	go srv.acceptLoop()
 	go srv.expireLoop()
 	return srv
@@ -64,38 +95,44 @@ func NewServerEasy(addr string) (*Server, error) {
 	if err != nil {
 		return nil, err
 	}
-	return NewServer(l, Config{5e9}, 200), nil
+	return NewServer(l, Config{Timeout: 5e9}, 200), nil
 }
 
-func (srv *Server) GetFDLimiter() *util.FDLimiter { return &srv.fdl }
+func (srv *Server) GetFDLimiter() *util.FDLimiter { return srv.fdl }
+
+// Pending returns the number of queries that have been read, but have
+// been neither answered, hijacked nor had their connections closed.
+func (srv *Server) Pending() int {
+	srv.Lock()
+	defer srv.Unlock()
+	return srv.pending
+}
+
+func (srv *Server) settle() {
+	srv.Lock()
+	defer srv.Unlock()
+	srv.pending--
+}
 
 func (srv *Server) expireLoop() {
 	for i := 0; ; i++ {
 		srv.Lock()
-		if srv.listen == nil {
-			srv.Unlock()
+		l := srv.listen
+		srv.Unlock()
+		if l == nil {
 			return
 		}
-		now := time.Now().UnixNano()
-		kills := list.New()
-		for ssc, _ := range srv.conns {
-			if now-ssc.GetStamp() >= srv.config.Timeout {
-				kills.PushBack(ssc)
-				srv.stats.IncExpireConn()
-			}
-		}
-		srv.Unlock()
-		elm := kills.Front()
-		for elm != nil {
-			ssc := elm.Value.(*StampedServerConn)
+		for _, ssc := range srv.conns.expire(time.Now().UnixNano()) {
+			srv.stats.IncExpireConn()
 			srv.bury(ssc)
-			elm = elm.Next()
 		}
-		kills.Init()
-		kills = nil
-		time.Sleep(time.Duration(srv.config.Timeout))
-		if i%4 == 0 {
+		time.Sleep(time.Duration(srv.conns.tick))
+		if i%(4*wheelTicks) == 0 {
 			log.Println(srv.stats.SummaryLine())
+			log.Println(srv.fdl.SummaryLine())
+			for _, r := range srv.copyStatsReporters() {
+				log.Println(r.SummaryLine())
+			}
 		}
 	}
 }
@@ -108,13 +145,13 @@ func (srv *Server) acceptLoop() {
 		if l == nil {
 			return
 		}
-		srv.fdl.Lock()
+		tok, _ := srv.fdl.Acquire(nil)
 		c, err := l.Accept()
 		if err != nil {
 			if c != nil {
 				c.Close()
 			}
-			srv.fdl.Unlock()
+			tok.Release()
 			srv.qch <- newQueryErr(err)
 			return
 		}
@@ -124,7 +161,7 @@ func (srv *Server) acceptLoop() {
 		if err != nil {
 			log.Printf("Set read timeout: %s\n", err)
 			c.Close()
-			srv.fdl.Unlock()
+			tok.Release()
 			srv.qch <- newQueryErr(err)
 			return
 		}
@@ -132,14 +169,14 @@ func (srv *Server) acceptLoop() {
 		if err != nil {
 			log.Printf("Set write timeout: %s\n", err)
 			c.Close()
-			srv.fdl.Unlock()
+			tok.Release()
 			srv.qch <- newQueryErr(err)
 			return
 		}
-		c = util.NewRunOnCloseConn(c, func() { srv.fdl.Unlock() })
+		c = util.NewRunOnCloseConn(c, func() { tok.Release() })
 		ssc := NewStampedServerConn(c, nil)
 		srv.register(ssc)
-		go srv.read(ssc)
+		srv.readNext(ssc)
 	}
 }
 
@@ -189,20 +226,37 @@ func (srv *Server) Launch(parallel int) {
 }
 
 func (srv *Server) AddSub(url string, sub Sub) {
-	srv.Lock()
-	defer srv.Unlock()
+	srv.cfg.Lock()
+	defer srv.cfg.Unlock()
 	srv.subs = append(srv.subs, &subcfg{url, sub})
 }
 
 func (srv *Server) AddExt(name, url string, ext Extension) {
-	srv.Lock()
-	defer srv.Unlock()
+	srv.cfg.Lock()
+	defer srv.cfg.Unlock()
 	srv.exts = append(srv.exts, &extcfg{name, url, ext})
 }
 
+// AddStatsReporter adds r to the components whose statistics
+// are logged periodically by the server.
+func (srv *Server) AddStatsReporter(r StatsReporter) {
+	srv.cfg.Lock()
+	defer srv.cfg.Unlock()
+	srv.reps = append(srv.reps, r)
+}
+
+func (srv *Server) copyStatsReporters() []StatsReporter {
+	srv.cfg.RLock()
+	defer srv.cfg.RUnlock()
+
+	rr := make([]StatsReporter, len(srv.reps))
+	copy(rr, srv.reps)
+	return rr
+}
+
 func (srv *Server) copySub() []*subcfg {
-	srv.Lock()
-	defer srv.Unlock()
+	srv.cfg.RLock()
+	defer srv.cfg.RUnlock()
 
 	ss := make([]*subcfg, len(srv.subs))
 	copy(ss, srv.subs)
@@ -210,8 +264,8 @@ func (srv *Server) copySub() []*subcfg {
 }
 
 func (srv *Server) copyExt() []*extcfg {
-	srv.Lock()
-	defer srv.Unlock()
+	srv.cfg.RLock()
+	defer srv.cfg.RUnlock()
 
 	ee := make([]*extcfg, len(srv.exts))
 	copy(ee, srv.exts)
@@ -219,8 +273,8 @@ func (srv *Server) copyExt() []*extcfg {
 }
 
 func (srv *Server) copyExtRev() []*extcfg {
-	srv.Lock()
-	defer srv.Unlock()
+	srv.cfg.RLock()
+	defer srv.cfg.RUnlock()
 
 	ee := make([]*extcfg, len(srv.exts))
 	for i := 0; i < len(ee); i++ {
@@ -233,15 +287,24 @@ func (srv *Server) process(q *Query) *Query {
 
 	// Apply extensions
 	p := q.origPath
-	q.Ext = make(map[string]interface{})
+	if q.extBuf == nil {
+		q.extBuf = make(map[string]interface{})
+	}
+	q.Ext = q.extBuf
 	exts := srv.copyExt()
 	for _, ec := range exts {
 		if strings.HasPrefix(p, ec.SubURL) {
 			if err := ec.Ext.ReadRequest(q.Req, q.Ext); err != nil {
+				// Nobody will answer the query, so drop its connection
+				q.bury()
+				q.release()
 				return nil
 			}
 		}
 	}
+	if srv.config.Watchdog > 0 {
+		q.watch(srv.config.Watchdog)
+	}
 
 	// Serve using a sub?
 	p = q.Req.URL.Path
@@ -257,6 +320,18 @@ func (srv *Server) process(q *Query) *Query {
 	return q
 }
 
+// readNext reads the next request on ssc in a new goroutine. If idle
+// connections are parked, the goroutine is only started once the request
+// starts arriving, so that idle connections cost no goroutine.
+func (srv *Server) readNext(ssc *StampedServerConn) {
+	if srv.parker != nil && ssc.Buffered() == 0 {
+		if err := srv.parker.park(ssc); err == nil {
+			return
+		}
+	}
+	go srv.read(ssc)
+}
+
 func (srv *Server) read(ssc *StampedServerConn) {
 	for {
 		req, err := ssc.Read()
@@ -279,31 +354,35 @@ func (srv *Server) read(ssc *StampedServerConn) {
 			srv.bury(ssc)
 			return
 		}
-		srv.qch <- &Query{
-			Req:      req,
-			srv:      srv,
-			ssc:      ssc,
-			origPath: req.URL.Path,
-			t0:       time.Nanoseconds(),
-		}
+		req.Body = util.TrackBody(req.Body)
+		srv.Lock()
+		srv.pending++
+		srv.Unlock()
+		q := srv.pool.get()
+		q.Req = req
+		q.srv = srv
+		q.ssc = ssc
+		q.origPath = req.URL.Path
+		q.t0 = time.Nanoseconds()
+		q.remote = ssc.RemoteAddr()
+		q.local = ssc.LocalAddr()
+		q.tls = ssc.TLS()
+		q.hold = req.Method == "CONNECT"
+		srv.qch <- q
 		srv.stats.IncRequest()
 		return
 	}
 }
 
 func (srv *Server) register(ssc *StampedServerConn) {
-	srv.Lock()
-	defer srv.Unlock()
-	if _, present := srv.conns[ssc]; present {
-		panic("register twice")
-	}
-	srv.conns[ssc] = 1
+	srv.conns.add(ssc)
 }
 
 func (srv *Server) unregister(ssc *StampedServerConn) {
-	srv.Lock()
-	defer srv.Unlock()
-	srv.conns[ssc] = 0, false
+	if srv.parker != nil {
+		srv.parker.forget(ssc)
+	}
+	srv.conns.remove(ssc)
 }
 
 func (srv *Server) bury(ssc *StampedServerConn) {
@@ -324,12 +403,12 @@ func (srv *Server) Shutdown() (err error) {
 	if l != nil {
 		err = l.Close()
 	}
+	if srv.parker != nil {
+		srv.parker.close()
+	}
 	// Then, force-close all open connections
-	srv.Lock()
-	for ssc, _ := range srv.conns {
+	for _, ssc := range srv.conns.removeAll() {
 		ssc.Close()
-		srv.conns[ssc] = 0, false
 	}
-	srv.Unlock()
 	return
 }
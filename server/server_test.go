@@ -0,0 +1,93 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package server
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestParkIdle(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+	srv := NewServer(l, Config{Timeout: 5e9, ParkIdle: true}, 10)
+	srv.Launch(1)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	req := &http.Request{Method: "GET"}
+
+	// Each request arrives on a connection parked after the last
+	for i := 0; i < 3; i++ {
+		if _, err := io.WriteString(c, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"); err != nil {
+			t.Fatalf("write request %d: %s", i, err)
+		}
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			t.Fatalf("read response %d: %s", i, err)
+		}
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("response %d: got status %d, want %d", i, resp.StatusCode, http.StatusNotFound)
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// benchmarkPipeline measures the throughput of a server answering
+// requests pipelined depth at a time on one connection.
+func benchmarkPipeline(b *testing.B, depth int) {
+	b.StopTimer()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+	srv := NewServer(l, Config{Timeout: 5e9}, 10)
+	srv.Launch(1)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		b.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	batch := []byte(strings.Repeat("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n", depth))
+	req := &http.Request{Method: "GET"}
+	b.StartTimer()
+
+	for i := 0; i < b.N; i += depth {
+		if _, err := c.Write(batch); err != nil {
+			b.Fatalf("write requests: %s", err)
+		}
+		for j := 0; j < depth; j++ {
+			resp, err := http.ReadResponse(br, req)
+			if err != nil {
+				b.Fatalf("read response: %s", err)
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+	}
+
+	b.StopTimer()
+}
+
+func BenchmarkPipeline1(b *testing.B)  { benchmarkPipeline(b, 1) }
+func BenchmarkPipeline2(b *testing.B)  { benchmarkPipeline(b, 2) }
+func BenchmarkPipeline4(b *testing.B)  { benchmarkPipeline(b, 4) }
+func BenchmarkPipeline8(b *testing.B)  { benchmarkPipeline(b, 8) }
+func BenchmarkPipeline16(b *testing.B) { benchmarkPipeline(b, 16) }
+func BenchmarkPipeline32(b *testing.B) { benchmarkPipeline(b, 32) }
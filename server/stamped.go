@@ -6,6 +6,7 @@ package server
 
 import (
 	"bufio"
+	"crypto/tls"
 	"net"
 	"sync"
 	"time"
@@ -19,13 +20,44 @@ type StampedServerConn struct {
 	*httputil.ServerConn
 	stamp int64
 	lk    sync.Mutex
+	conn  net.Conn
+	r     *bufio.Reader
+	addr  string // remote address of the connection
+	shard int    // Shard of the connSet of the server holding the connection
 }
 
 func NewStampedServerConn(c net.Conn, r *bufio.Reader) *StampedServerConn {
+	if r == nil {
+		r = bufio.NewReader(c)
+	}
 	return &StampedServerConn{
 		ServerConn: http.NewServerConn(c, r),
 		stamp:      time.Nanoseconds(),
+		conn:       c,
+		r:          r,
+		addr:       c.RemoteAddr().String(),
+	}
+}
+
+// Buffered returns the number of bytes received on the connection, but
+// not yet read as part of a request.
+func (ssc *StampedServerConn) Buffered() int { return ssc.r.Buffered() }
+
+// RemoteAddr returns the remote network address of the connection.
+func (ssc *StampedServerConn) RemoteAddr() net.Addr { return ssc.conn.RemoteAddr() }
+
+// LocalAddr returns the local network address of the connection.
+func (ssc *StampedServerConn) LocalAddr() net.Addr { return ssc.conn.LocalAddr() }
+
+// TLS returns the state of the TLS connection, or nil if the connection
+// does not use TLS. The state is complete once a request has been read.
+func (ssc *StampedServerConn) TLS() *tls.ConnectionState {
+	tc, ok := ssc.conn.(*tls.Conn)
+	if !ok {
+		return nil
 	}
+	state := tc.ConnectionState()
+	return &state
 }
 
 func (ssc *StampedServerConn) touch() {
@@ -40,10 +72,16 @@ func (ssc *StampedServerConn) GetStamp() int64 {
 	return ssc.stamp
 }
 
+// Read reads the next request, and sets its RemoteAddr
+// to the remote address of the connection.
 func (ssc *StampedServerConn) Read() (req *http.Request, err error) {
 	ssc.touch()
 	defer ssc.touch()
-	return ssc.ServerConn.Read()
+	req, err = ssc.ServerConn.Read()
+	if req != nil {
+		req.RemoteAddr = ssc.addr
+	}
+	return req, err
 }
 
 func (ssc *StampedServerConn) Write(req *http.Request, resp *http.Response) (err error) {
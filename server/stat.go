@@ -11,6 +11,13 @@ import (
 	"time"
 )
 
+// A StatsReporter is a component, typically a Sub, that keeps statistics
+// of its own. The Server logs the summary lines of its reporters along
+// with its own.
+type StatsReporter interface {
+	SummaryLine() string
+}
+
 // Stats maintains server statistics and methods for
 // querying into them.
 type Stats struct {
@@ -19,6 +26,7 @@ type Stats struct {
 	ResponseCount   uint64 // Number of responses successfully received
 	ExpireConnCount uint64 // Number of connections, expired by the server
 	AcceptConnCount uint64
+	UnansweredCount uint64 // Number of queries caught by the watchdog
 	MaxReqRespTime  uint64 // Duration of longest request-response cycle
 	lk              sync.Mutex
 }
@@ -59,12 +67,18 @@ func (s *Stats) IncAcceptConn() {
 	s.AcceptConnCount++
 }
 
+func (s *Stats) IncUnanswered() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.UnansweredCount++
+}
+
 func (s *Stats) SummaryLine() string {
 	s.lk.Lock()
 	defer s.lk.Unlock()
-	return fmt.Sprintf("Running %d mins, %d accept, %d expire, %d req, %d resp; MaxReqRespTime: %dms; %d goroutine",
+	return fmt.Sprintf("Running %d mins, %d accept, %d expire, %d req, %d resp, %d unanswered; MaxReqRespTime: %dms; %d goroutine",
 		(time.Nanoseconds()-s.TimeStarted)/(60*1e9),
-		s.AcceptConnCount, s.ExpireConnCount, s.RequestCount, s.ResponseCount,
+		s.AcceptConnCount, s.ExpireConnCount, s.RequestCount, s.ResponseCount, s.UnansweredCount,
 		s.MaxReqRespTime/1e6,
 		runtime.Goroutines())
 }
@@ -5,7 +5,12 @@
 package static
 
 import (
+	"fmt"
+	"io"
+	"os"
 	"path"
+	"strconv"
+	"strings"
 	http "net/http/httputil"
 	"github.com/petar/GoHTTP/cache"
 	"github.com/petar/GoHTTP/server"
@@ -17,11 +22,46 @@ type StaticSub struct {
 	cache      *cache.Cache
 }
 
-func NewStaticSub(staticPath string) *StaticSub {
+// NewStaticSub returns a StaticSub serving the files under staticPath.
+// Files are only served if their real path, with symbolic links resolved,
+// lies within staticPath. It fails if the real path of staticPath cannot
+// be resolved, e.g. because the directory does not exist.
+func NewStaticSub(staticPath string) (*StaticSub, error) {
+	c, err := newCache(staticPath)
+	if err != nil {
+		return nil, err
+	}
 	return &StaticSub{
 		staticPath: staticPath,
-		cache: cache.NewCache(),
+		cache:      c,
+	}, nil
+}
+
+// streamThreshold is the size above which static files are not held in
+// memory, but read from disk on every request.
+const streamThreshold = 4 << 20
+
+func newCache(root string) (*cache.Cache, error) {
+	c := cache.NewCache()
+	c.SetCompress(true)
+	c.SetStreamThreshold(streamThreshold)
+	if err := c.SetRoot(root); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
+
+// Preload reads the files under the static directory whose base names
+// match one of patterns, or all files if there are none, into the cache.
+func (ss *StaticSub) Preload(patterns ...string) error {
+	return ss.cache.PreloadDir(ss.staticPath, patterns)
+}
+
+// SummaryLine implements server.StatsReporter, summarizing the use of
+// the file cache. Add the StaticSub to the server with AddStatsReporter
+// to have it logged along with the server statistics.
+func (ss *StaticSub) SummaryLine() string {
+	return "Static cache " + ss.cache.Stats().String()
 }
 
 func (ss *StaticSub) Serve(q *server.Query) {
@@ -33,21 +73,181 @@ func (ss *StaticSub) Serve(q *server.Query) {
 	p := req.URL.Path
 	if len(p) == 0 {
 		p = "index.html"
-	} else if p[0] == '/' {
-		p = p[1:]
 	}
-	full := path.Clean(path.Join(ss.staticPath, p))
-	buf, mimetype, err := ss.cache.Get(full)
+	if strings.IndexRune(p, 0) >= 0 {
+		q.ContinueAndWrite(http.NewResponse404(req))
+		return
+	}
+	// Cleaning p as a rooted path drops any leading "..", which would
+	// otherwise escape the static directory once joined to it
+	full := path.Join(ss.staticPath, path.Clean("/"+p))
+	if req.Header.Get("Range") != "" {
+		ss.serveRange(q, full)
+		return
+	}
+	var content cache.Content
+	var mimetype, etag string
+	var gzipped bool
+	var err error
+	if acceptsGzip(req) {
+		content, mimetype, etag, gzipped, err = ss.cache.OpenGzip(full)
+	} else {
+		content, mimetype, etag, err = ss.cache.Open(full)
+	}
 	if err != nil {
 		q.ContinueAndWrite(http.NewResponse404(req))
 		return
 	}
-	resp := http.NewResponseWithBytes(req, buf)
+	var resp *http.Response
+	if matchETag(req.Header.Get("If-None-Match"), etag) {
+		content.Close()
+		resp = http.NewResponse304(req)
+		resp.Header = make(http.Header)
+	} else {
+		resp = newContentResponse(req, http.StatusOK, content, content.Size(), content)
+	}
+	if mimetype != "" {
+		resp.Header.Set("Content-Type", mimetype)
+	}
+	if gzipped {
+		resp.Header.Set("Content-Encoding", "gzip")
+	}
+	resp.Header.Set("ETag", etag)
+	resp.Header.Set("Vary", "Accept-Encoding")
+	resp.Header.Set("Accept-Ranges", "bytes")
+	q.ContinueAndWrite(resp)
+}
+
+// serveRange responds to a request for a byte range of the file full.
+// Only single ranges are supported; requests for several ranges get the
+// whole file. Ranges are served from the uncompressed contents.
+func (ss *StaticSub) serveRange(q *server.Query, full string) {
+	req := q.Req
+	content, mimetype, etag, err := ss.cache.Open(full)
+	if err != nil {
+		q.ContinueAndWrite(http.NewResponse404(req))
+		return
+	}
+	size := content.Size()
+	var resp *http.Response
+	start, n, ok := parseRange(req.Header.Get("Range"), size)
+	switch {
+	case !ok:
+		content.Close()
+		resp = newContentResponse(req, http.StatusRequestedRangeNotSatisfiable, nil, 0, nil)
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+	case n == size:
+		resp = newContentResponse(req, http.StatusOK, content, size, content)
+	default:
+		resp = newContentResponse(req, http.StatusPartialContent, io.NewSectionReader(content, start, n), n, content)
+		resp.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+n-1, size))
+	}
 	if mimetype != "" {
-		if resp.Header == nil {
-			resp.Header = make(http.Header)
-		}
 		resp.Header.Set("Content-Type", mimetype)
 	}
+	resp.Header.Set("ETag", etag)
+	resp.Header.Set("Accept-Ranges", "bytes")
 	q.ContinueAndWrite(resp)
 }
+
+// newContentResponse returns a response with the given status code, whose
+// body is the n bytes read from body. The closer, if any, is closed along
+// with the body, after the response is written.
+func newContentResponse(req *http.Request, code int, body io.Reader, n int64, closer io.Closer) *http.Response {
+	resp := http.NewResponse200(req)
+	resp.StatusCode = code
+	resp.Status = http.StatusText(code)
+	resp.Header = make(http.Header)
+	if body != nil {
+		resp.Body = readCloser{body, closer}
+		resp.ContentLength = n
+	}
+	return resp
+}
+
+// readCloser pairs a Reader with the Closer of its underlying resource.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// SourceFile implements server.FileBody, returning the file of contents
+// streamed from disk, or nil.
+func (rc readCloser) SourceFile() *os.File {
+	if fb, ok := rc.Reader.(interface{ SourceFile() *os.File }); ok {
+		return fb.SourceFile()
+	}
+	return nil
+}
+
+// parseRange parses a Range header for a single byte range of a file of
+// the given size, returning the offset and length of the range. It
+// returns the whole file for headers it does not support, and ok false
+// if the range is unsatisfiable.
+func parseRange(s string, size int64) (start, n int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, size, true
+	}
+	spec := strings.TrimSpace(s[len("bytes="):])
+	i := strings.Index(spec, "-")
+	if i < 0 {
+		return 0, size, true
+	}
+	first, last := strings.TrimSpace(spec[:i]), strings.TrimSpace(spec[i+1:])
+	if first == "" {
+		// Suffix range: the last bytes of the file
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil {
+			return 0, size, true
+		}
+		if suffix <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, true
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil {
+		return 0, size, true
+	}
+	if start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, size, true
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, true
+}
+
+// matchETag returns true if the If-None-Match header ifNoneMatch lists etag.
+func matchETag(ifNoneMatch, etag string) bool {
+	for _, t := range strings.Split(ifNoneMatch, ",") {
+		t = strings.TrimSpace(t)
+		if strings.HasPrefix(t, "W/") {
+			t = t[2:]
+		}
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip returns true if the client accepts gzip-encoded responses,
+// and prefers them to unencoded ones, as negotiated from all of its
+// Accept-Encoding headers. Clients that send none get no gzip.
+func acceptsGzip(req *http.Request) bool {
+	if !req.Header.Has("Accept-Encoding") {
+		return false
+	}
+	return req.Header.Negotiate("Accept-Encoding", "gzip", "identity") == "gzip"
+}
@@ -0,0 +1,373 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package static
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	http "net/http/httputil"
+	"github.com/petar/GoHTTP/server"
+)
+
+// newTestDir returns a temporary directory holding the files in files,
+// by slash-separated name, and a function that removes it.
+func newTestDir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// staticConn is a connection to a server serving a StaticSub at /s.
+type staticConn struct {
+	t  *testing.T
+	c  net.Conn
+	br *bufio.Reader
+	l  net.Listener
+}
+
+func newStaticConn(t *testing.T, ss *StaticSub) *staticConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 10)
+	srv.AddSub("/s", ss)
+	srv.Launch(1)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatalf("dial: %s", err)
+	}
+	return &staticConn{t, c, bufio.NewReader(c), l}
+}
+
+func (sc *staticConn) Close() {
+	sc.c.Close()
+	sc.l.Close()
+}
+
+// get sends a GET request for uri with the header lines in header, and
+// returns the response and its body.
+func (sc *staticConn) get(uri string, header ...string) (*http.Response, []byte) {
+	req := "GET " + uri + " HTTP/1.1\r\nHost: localhost\r\n"
+	for _, h := range header {
+		req += h + "\r\n"
+	}
+	if _, err := fmt.Fprint(sc.c, req+"\r\n"); err != nil {
+		sc.t.Fatalf("%s: send request: %s", uri, err)
+	}
+	resp, err := http.ReadResponse(sc.br, &http.Request{Method: "GET"})
+	if err != nil {
+		sc.t.Fatalf("%s: read response: %s", uri, err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		sc.t.Fatalf("%s: read body: %s", uri, err)
+	}
+	return resp, body
+}
+
+func TestStaticRoot(t *testing.T) {
+	dir, remove := newTestDir(t, map[string]string{
+		"root/a.txt": "inside",
+		"secret.txt": "outside",
+	})
+	defer remove()
+	root := filepath.Join(dir, "root")
+	if err := os.Symlink(filepath.Join(dir, "secret.txt"), filepath.Join(root, "link.txt")); err != nil {
+		t.Fatalf("Symlink: %s", err)
+	}
+	if _, err := NewStaticSub(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("NewStaticSub of a missing directory returned no error")
+	}
+	ss, err := NewStaticSub(root)
+	if err != nil {
+		t.Fatalf("NewStaticSub: %s", err)
+	}
+	sc := newStaticConn(t, ss)
+	defer sc.Close()
+
+	tests := []struct {
+		uri    string
+		status int
+		body   string
+	}{
+		{"/s/a.txt", 200, "inside"},
+		{"/s/../secret.txt", 404, ""},
+		{"/s/%2e%2e/secret.txt", 404, ""},
+		{"/s/link.txt", 404, ""},
+	}
+	for _, tt := range tests {
+		resp, body := sc.get(tt.uri)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.uri, resp.StatusCode, tt.status)
+		}
+		if tt.status == 200 && string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.uri, body, tt.body)
+		}
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		values []string
+		gzip   bool
+	}{
+		{nil, false},
+		{[]string{"gzip"}, true},
+		{[]string{"deflate, gzip"}, true},
+		{[]string{"GZIP;q=0.5"}, true},
+		{[]string{"gzip;q=0"}, false},
+		{[]string{"gzip;q=0.0"}, false},
+		{[]string{"gzip; q=0.000"}, false},
+		{[]string{"*"}, true},
+		{[]string{"*;q=0"}, false},
+		{[]string{"identity"}, false},
+		{[]string{"gzip;q=0.5, identity"}, false},
+		{[]string{"deflate", "gzip"}, true},
+	}
+	for _, tt := range tests {
+		req := &http.Request{Header: http.Header{}}
+		for _, v := range tt.values {
+			req.Header.Add("Accept-Encoding", v)
+		}
+		if gz := acceptsGzip(req); gz != tt.gzip {
+			t.Errorf("Accept-Encoding %q: gzip %v, want %v", tt.values, gz, tt.gzip)
+		}
+	}
+}
+
+func TestStaticGzip(t *testing.T) {
+	text := strings.Repeat("compressible ", 100)
+	dir, remove := newTestDir(t, map[string]string{"a.txt": text})
+	defer remove()
+	ss, err := NewStaticSub(dir)
+	if err != nil {
+		t.Fatalf("NewStaticSub: %s", err)
+	}
+	sc := newStaticConn(t, ss)
+	defer sc.Close()
+
+	resp, body := sc.get("/s/a.txt", "Accept-Encoding: gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("gzip response with header %v", resp.Header)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("gzip reader: %s", err)
+	}
+	if data, err := ioutil.ReadAll(zr); err != nil || string(data) != text {
+		t.Errorf("bad gzip body %q (%v)", data, err)
+	}
+	gzTag := resp.Header.Get("ETag")
+
+	resp, body = sc.get("/s/a.txt", "Accept-Encoding: gzip;q=0")
+	if resp.Header.Get("Content-Encoding") != "" || string(body) != text {
+		t.Errorf("refused gzip sent with encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	if resp.Header.Get("ETag") == gzTag {
+		t.Errorf("compressed and plain contents share the entity tag %s", gzTag)
+	}
+}
+
+func TestMatchETag(t *testing.T) {
+	tests := []struct {
+		ifNoneMatch string
+		match       bool
+	}{
+		{``, false},
+		{`"abc"`, true},
+		{`"abd"`, false},
+		{`W/"abc"`, true},
+		{`"x", "abc"`, true},
+		{`"x","y"`, false},
+		{`*`, true},
+		{`abc`, false},
+	}
+	for _, tt := range tests {
+		if m := matchETag(tt.ifNoneMatch, `"abc"`); m != tt.match {
+			t.Errorf("If-None-Match %q: match %v, want %v", tt.ifNoneMatch, m, tt.match)
+		}
+	}
+}
+
+func TestStaticNotModified(t *testing.T) {
+	dir, remove := newTestDir(t, map[string]string{"a.txt": "hello"})
+	defer remove()
+	ss, err := NewStaticSub(dir)
+	if err != nil {
+		t.Fatalf("NewStaticSub: %s", err)
+	}
+	sc := newStaticConn(t, ss)
+	defer sc.Close()
+
+	resp, _ := sc.get("/s/a.txt")
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != 200 || etag == "" {
+		t.Fatalf("status %d, entity tag %q", resp.StatusCode, etag)
+	}
+	resp, body := sc.get("/s/a.txt", "If-None-Match: "+etag)
+	if resp.StatusCode != 304 || len(body) != 0 {
+		t.Errorf("If-None-Match %s: status %d, body %q", etag, resp.StatusCode, body)
+	}
+	if resp.Header.Get("ETag") != etag {
+		t.Errorf("304 response with entity tag %q, want %s", resp.Header.Get("ETag"), etag)
+	}
+	resp, body = sc.get("/s/a.txt", `If-None-Match: "other"`)
+	if resp.StatusCode != 200 || string(body) != "hello" {
+		t.Errorf("stale If-None-Match: status %d, body %q", resp.StatusCode, body)
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		start, n int64
+		ok       bool
+	}{
+		{"bytes=0-4", 0, 5, true},
+		{"bytes=2-", 2, 8, true},
+		{"bytes=-3", 7, 3, true},
+		{"bytes=-20", 0, 10, true},
+		{"bytes=5-100", 5, 5, true},
+		{"bytes= 1 - 2", 1, 2, true},
+		{"bytes=10-", 0, 0, false},
+		{"bytes=20-30", 0, 0, false},
+		{"bytes=-0", 0, 0, false},
+		{"bytes=0-1,4-5", 0, 10, true},
+		{"bytes=5-2", 0, 10, true},
+		{"bytes=x-2", 0, 10, true},
+		{"lines=0-1", 0, 10, true},
+	}
+	for _, tt := range tests {
+		start, n, ok := parseRange(tt.header, 10)
+		if start != tt.start || n != tt.n || ok != tt.ok {
+			t.Errorf("%q: range %d+%d %v, want %d+%d %v", tt.header, start, n, ok, tt.start, tt.n, tt.ok)
+		}
+	}
+	if _, _, ok := parseRange("bytes=-5", 0); ok {
+		t.Errorf("suffix range of an empty file is satisfiable")
+	}
+}
+
+func TestStaticRange(t *testing.T) {
+	dir, remove := newTestDir(t, map[string]string{"a.txt": "0123456789"})
+	defer remove()
+	ss, err := NewStaticSub(dir)
+	if err != nil {
+		t.Fatalf("NewStaticSub: %s", err)
+	}
+	sc := newStaticConn(t, ss)
+	defer sc.Close()
+
+	tests := []struct {
+		rng    string
+		status int
+		body   string
+		cr     string
+	}{
+		{"bytes=2-4", 206, "234", "bytes 2-4/10"},
+		{"bytes=7-", 206, "789", "bytes 7-9/10"},
+		{"bytes=-2", 206, "89", "bytes 8-9/10"},
+		{"bytes=8-50", 206, "89", "bytes 8-9/10"},
+		{"bytes=0-", 200, "0123456789", ""},
+		{"bytes=0-1,3-4", 200, "0123456789", ""},
+		{"bytes=10-", 416, "", "bytes */10"},
+	}
+	for _, tt := range tests {
+		resp, body := sc.get("/s/a.txt", "Range: "+tt.rng)
+		if resp.StatusCode != tt.status || string(body) != tt.body {
+			t.Errorf("%s: status %d, body %q, want %d, %q", tt.rng, resp.StatusCode, body, tt.status, tt.body)
+		}
+		if cr := resp.Header.Get("Content-Range"); cr != tt.cr {
+			t.Errorf("%s: Content-Range %q, want %q", tt.rng, cr, tt.cr)
+		}
+		if resp.Header.Get("Accept-Ranges") != "bytes" {
+			t.Errorf("%s: no Accept-Ranges", tt.rng)
+		}
+	}
+	resp, _ := sc.get("/s/missing.txt", "Range: bytes=0-1")
+	if resp.StatusCode != 404 {
+		t.Errorf("range of a missing file: status %d", resp.StatusCode)
+	}
+}
+
+func TestStaticStream(t *testing.T) {
+	text := strings.Repeat("0123456789", 10)
+	dir, remove := newTestDir(t, map[string]string{"large.txt": text, "small.txt": "small"})
+	defer remove()
+	ss, err := NewStaticSub(dir)
+	if err != nil {
+		t.Fatalf("NewStaticSub: %s", err)
+	}
+	ss.cache.SetStreamThreshold(50)
+
+	content, _, _, err := ss.cache.Open(filepath.Join(dir, "large.txt"))
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	rc := readCloser{content, content}
+	if rc.SourceFile() == nil {
+		t.Errorf("streamed body has no source file")
+	}
+	rc.Close()
+	content, _, _, err = ss.cache.Open(filepath.Join(dir, "small.txt"))
+	if err != nil {
+		t.Fatalf("Open: %s", err)
+	}
+	if rc = (readCloser{content, content}); rc.SourceFile() != nil {
+		t.Errorf("body held in memory has a source file")
+	}
+	rc.Close()
+
+	sc := newStaticConn(t, ss)
+	defer sc.Close()
+	resp, body := sc.get("/s/large.txt", "Accept-Encoding: gzip")
+	if resp.StatusCode != 200 || string(body) != text {
+		t.Errorf("streamed file: status %d, body %q", resp.StatusCode, body)
+	}
+	if resp.ContentLength != int64(len(text)) || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("streamed file: length %d, encoding %q", resp.ContentLength, resp.Header.Get("Content-Encoding"))
+	}
+	resp, body = sc.get("/s/large.txt", "Range: bytes=95-")
+	if resp.StatusCode != 206 || string(body) != "56789" {
+		t.Errorf("range of a streamed file: status %d, body %q", resp.StatusCode, body)
+	}
+}
+
+func TestStaticSummaryLine(t *testing.T) {
+	dir, remove := newTestDir(t, map[string]string{"a.txt": "hello"})
+	defer remove()
+	ss, err := NewStaticSub(dir)
+	if err != nil {
+		t.Fatalf("NewStaticSub: %s", err)
+	}
+	sc := newStaticConn(t, ss)
+	defer sc.Close()
+	sc.get("/s/a.txt")
+	sc.get("/s/a.txt")
+	if line := ss.SummaryLine(); !strings.HasPrefix(line, "Static cache 1 hit, 1 miss") {
+		t.Errorf("SummaryLine() = %q", line)
+	}
+}
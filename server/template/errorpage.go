@@ -0,0 +1,93 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package template
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Lines of source shown around the offending line of a template
+const errorContext = 5
+
+// errorRegexp matches the errors of the template packages, e.g.
+//
+//	template: blog/post.html:12: unexpected "}" in operand
+//	template: blog/post.html:12:5: executing "blog/post.html" at <.Title>: ...
+var errorRegexp = regexp.MustCompile(`^template: ([^:]+):([0-9]+)(?::[0-9]+)?: (?:executing "[^"]*" at <(.*?)>: )?(.*)$`)
+
+// templateError is a template error, broken down for the error page.
+type templateError struct {
+	Err    string // The error as a whole
+	Name   string // Name of the template, or "" if unknown
+	Line   int
+	Action string // The action being executed, if any
+	Msg    string
+	Source []sourceLine // Lines of source around Line
+}
+
+type sourceLine struct {
+	N         int
+	Text      string
+	Offending bool
+}
+
+// parseError breaks down err. The source of a template is read with
+// source, and shown if it can be read.
+func parseError(err error, source func(name string) ([]byte, error)) *templateError {
+	te := &templateError{Err: err.Error(), Msg: err.Error()}
+	m := errorRegexp.FindStringSubmatch(strings.TrimSpace(te.Err))
+	if m == nil {
+		return te
+	}
+	te.Name, te.Action, te.Msg = m[1], m[3], m[4]
+	te.Line, _ = strconv.Atoi(m[2])
+	text, err := source(te.Name)
+	if err != nil {
+		return te
+	}
+	lines := strings.Split(strings.TrimRight(string(text), "\n"), "\n")
+	first, last := te.Line-errorContext, te.Line+errorContext
+	if first < 1 {
+		first = 1
+	}
+	if last > len(lines) {
+		last = len(lines)
+	}
+	for n := first; n <= last; n++ {
+		te.Source = append(te.Source, sourceLine{n, lines[n-1], n == te.Line})
+	}
+	return te
+}
+
+var errorPage = htmltemplate.Must(htmltemplate.New("error").Parse(`<html>
+<head><title>500 Template Error</title>
+<style>
+body { font-family: sans-serif; }
+pre { background: #f4f4f4; padding: 0.5em; }
+.offending { background: #fdd; font-weight: bold; }
+</style>
+</head>
+<body bgcolor="white">
+<h1>500 Template Error</h1>
+{{if .Name}}<p>In <b>{{.Name}}</b>, line {{.Line}}{{if .Action}}, executing <code>{{.Action}}</code>{{end}}:</p>
+<p><b>{{.Msg}}</b></p>
+{{if .Source}}<pre>{{range .Source}}<span{{if .Offending}} class="offending"{{end}}>{{printf "%4d" .N}}  {{.Text}}</span>
+{{end}}</pre>{{end}}{{else}}<p><b>{{.Err}}</b></p>{{end}}
+<hr><center>Go HTTP package</center>
+</body></html>
+`))
+
+// renderError returns the error page of te.
+func renderError(te *templateError) []byte {
+	var w bytes.Buffer
+	if err := errorPage.Execute(&w, te); err != nil {
+		return []byte(err.Error())
+	}
+	return w.Bytes()
+}
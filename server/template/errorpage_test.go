@@ -0,0 +1,109 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// numberedSource returns a source of templates holding n numbered lines,
+// with text in place of line bad.
+func numberedSource(n, bad int, text string) func(string) ([]byte, error) {
+	var lines []string
+	for i := 1; i <= n; i++ {
+		if i == bad {
+			lines = append(lines, text)
+		} else {
+			lines = append(lines, fmt.Sprintf("line %d", i))
+		}
+	}
+	return func(name string) ([]byte, error) {
+		if name != "page.html" {
+			return nil, os.ErrNotExist
+		}
+		return []byte(strings.Join(lines, "\n") + "\n"), nil
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		n, bad      int
+		text        string
+		exec        bool
+		first, last int
+		action      string
+	}{
+		{20, 10, "{{if}}", false, 5, 15, ""},
+		{20, 2, "{{end}}", false, 1, 7, ""},
+		{12, 11, "{{.Missing.Field}}", true, 6, 12, ".Missing.Field"},
+		{8, 8, "{{end}}", false, 3, 8, ""},
+	}
+	for _, tt := range tests {
+		source := numberedSource(tt.n, tt.bad, tt.text)
+		text, _ := source("page.html")
+		tmpl, err := template.New("page.html").Parse(string(text))
+		if tt.exec {
+			if err != nil {
+				t.Fatalf("%s: Parse: %s", tt.text, err)
+			}
+			err = tmpl.Execute(ioutil.Discard, struct{ Missing *struct{ Field int } }{})
+		}
+		if err == nil {
+			t.Fatalf("%s: no error", tt.text)
+		}
+		te := parseError(err, source)
+		if te.Name != "page.html" || te.Line != tt.bad || te.Action != tt.action || te.Msg == "" {
+			t.Errorf("%s: error %q broken down into %q, line %d, action %q, message %q",
+				tt.text, err, te.Name, te.Line, te.Action, te.Msg)
+			continue
+		}
+		if len(te.Source) != tt.last-tt.first+1 || te.Source[0].N != tt.first {
+			t.Errorf("%s: source window %v, want lines %d to %d", tt.text, te.Source, tt.first, tt.last)
+			continue
+		}
+		for _, l := range te.Source {
+			if l.Offending != (l.N == tt.bad) {
+				t.Errorf("%s: line %d marked offending %v", tt.text, l.N, l.Offending)
+			}
+			if l.N == tt.bad && l.Text != tt.text {
+				t.Errorf("%s: offending line %q", tt.text, l.Text)
+			}
+		}
+	}
+
+	// Errors of other forms, or of templates without source, are shown whole
+	err := errors.New("disk on fire")
+	if te := parseError(err, numberedSource(3, 1, "")); te.Name != "" || te.Msg != "disk on fire" || te.Source != nil {
+		t.Errorf("unknown error broken down into %+v", te)
+	}
+	err = errors.New("template: other.html:3: unexpected EOF")
+	if te := parseError(err, numberedSource(3, 1, "")); te.Name != "other.html" || te.Line != 3 || te.Source != nil {
+		t.Errorf("error without source broken down into %+v", te)
+	}
+}
+
+func TestRenderError(t *testing.T) {
+	err := errors.New(`template: page.html:2: unexpected "<" in command`)
+	page := string(renderError(parseError(err, numberedSource(3, 2, "{{<script>}}"))))
+	for _, want := range []string{
+		"<b>page.html</b>, line 2",
+		`<span class="offending">   2  {{&lt;script&gt;}}</span>`,
+		"<span>   3  line 3</span>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("error page lacks %q:\n%s", want, page)
+		}
+	}
+	page = string(renderError(parseError(errors.New("a <b> error"), nil)))
+	if !strings.Contains(page, "<p><b>a &lt;b&gt; error</b></p>") {
+		t.Errorf("error page of an unknown error:\n%s", page)
+	}
+}
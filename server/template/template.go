@@ -0,0 +1,231 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package template
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"mime"
+	"os"
+	"path"
+	"strings"
+	http "net/http/httputil"
+	"github.com/petar/GoHTTP/cache"
+	"github.com/petar/GoHTTP/server"
+)
+
+// DataFunc provides the data a page is rendered with, for the request of q.
+type DataFunc func(q *server.Query) (interface{}, error)
+
+// KeyFunc returns the key of the output of a page for the request of q
+// in a RenderCache, which must identify the data the page is rendered
+// with. The result ok is false if the output must not be cached.
+type KeyFunc func(q *server.Query) (key string, ok bool)
+
+// TemplateSub is a Sub that renders the pages of a TemplateDir. The URL
+// path of a request, relative to the Sub, names the page: "/blog/post"
+// is rendered from the template "blog/post" plus the extension of the
+// Sub, e.g. "blog/post.html", and paths ending in a slash are rendered
+// from the "index" page of the directory.
+type TemplateSub struct {
+	dir    *cache.TemplateDir
+	ext    string
+	data   DataFunc
+	ctype  string
+	dev    bool // Whether errors are shown in detail
+	stream bool // Whether pages are executed into the response
+	rc     *cache.RenderCache
+	key    KeyFunc
+}
+
+// NewTemplateSub returns a TemplateSub rendering the pages with extension
+// ext, e.g. ".html", in dir, with the data returned by data for each
+// request. The Content-Type of the pages is derived from ext.
+func NewTemplateSub(dir *cache.TemplateDir, ext string, data DataFunc) *TemplateSub {
+	ctype := mime.TypeByExtension(ext)
+	if ctype == "" {
+		ctype = "text/html; charset=utf-8"
+	}
+	return &TemplateSub{dir: dir, ext: ext, data: data, ctype: ctype}
+}
+
+func (ts *TemplateSub) Serve(q *server.Query) {
+	req := q.Req
+	if req.Method != "GET" && req.Method != "HEAD" {
+		q.ContinueAndWrite(http.NewResponse404(req))
+		return
+	}
+	name := ts.page(req.URL.Path)
+	tmpl, err := ts.dir.Get(name)
+	if err != nil {
+		if os.IsNotExist(err) || err == cache.ErrBadTemplateName {
+			q.ContinueAndWrite(http.NewResponse404(req))
+			return
+		}
+		log.Printf("Template %s: %s\n", req.URL.Path, err)
+		ts.fail(q, err)
+		return
+	}
+	var key string
+	cached := false
+	if ts.rc != nil {
+		key, cached = ts.key(q)
+	}
+	if ts.stream && !cached {
+		ts.serveStream(q, tmpl)
+		return
+	}
+	var out []byte
+	ok := false
+	if cached {
+		out, ok = ts.rc.Get(name, key, tmpl)
+	}
+	if !ok {
+		if out, err = ts.render(q, tmpl); err != nil {
+			ts.fail(q, err)
+			return
+		}
+		if cached {
+			ts.rc.Set(name, key, tmpl, out)
+		}
+	}
+	resp := http.NewResponseWithBytes(req, out)
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Content-Type", ts.ctype)
+	resp.Header.Set("Cache-Control", "no-cache")
+	q.ContinueAndWrite(resp)
+}
+
+// fetch returns the data for the request of q.
+func (ts *TemplateSub) fetch(q *server.Query) (interface{}, error) {
+	if ts.data == nil {
+		return nil, nil
+	}
+	data, err := ts.data(q)
+	if err != nil {
+		log.Printf("Template data %s: %s\n", q.Req.URL.Path, err)
+		return nil, err
+	}
+	return data, nil
+}
+
+// render executes tmpl with the data for the request of q.
+func (ts *TemplateSub) render(q *server.Query, tmpl cache.Template) ([]byte, error) {
+	data, err := ts.fetch(q)
+	if err != nil {
+		return nil, err
+	}
+	var w bytes.Buffer
+	if err = tmpl.Execute(&w, data); err != nil {
+		log.Printf("Template execute %s: %s\n", q.Req.URL.Path, err)
+		return nil, err
+	}
+	return w.Bytes(), nil
+}
+
+// serveStream responds to q with a chunked response, into which tmpl is
+// executed as the response is written.
+func (ts *TemplateSub) serveStream(q *server.Query, tmpl cache.Template) {
+	data, err := ts.fetch(q)
+	if err != nil {
+		ts.fail(q, err)
+		return
+	}
+	body := newPageReader(q.Req.URL.Path, tmpl, data)
+	resp := http.NewResponseWithBody(q.Req, body)
+	// NewResponseWithBody hides the Close of body, which stops the
+	// execution when the response cannot be written
+	resp.Body = body
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Content-Type", ts.ctype)
+	resp.Header.Set("Cache-Control", "no-cache")
+	q.ContinueAndWrite(resp)
+}
+
+// newPageReader returns a reader of the output of tmpl, executed with
+// data, for the URL path p. Closing the reader stops the execution.
+func newPageReader(p string, tmpl cache.Template, data interface{}) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go executePage(pw, p, tmpl, data)
+	return pr
+}
+
+func executePage(pw *io.PipeWriter, p string, tmpl cache.Template, data interface{}) {
+	// Buffer the output, so that each chunk holds more than a few bytes
+	w := bufio.NewWriter(pw)
+	err := tmpl.Execute(w, data)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		if err != io.ErrClosedPipe {
+			log.Printf("Template execute %s: %s\n", p, err)
+		}
+		// The response is cut short
+		pw.CloseWithError(err)
+		return
+	}
+	pw.Close()
+}
+
+// SetRenderCache makes the Sub keep the output of pages in rc, under the
+// key returned by key for each request, so that pages are only rendered,
+// and their data only fetched, when there is no output in rc. A nil rc
+// turns caching off.
+func (ts *TemplateSub) SetRenderCache(rc *cache.RenderCache, key KeyFunc) {
+	ts.rc, ts.key = rc, key
+}
+
+// SetStreaming sets whether pages are executed directly into the response,
+// which is sent with chunked encoding as the output is produced, rather
+// than rendered into memory first. Streaming spares the memory of large
+// pages, but a page that fails to execute midway can only be cut short,
+// not replaced by an error response. Pages kept in a RenderCache are not
+// streamed.
+func (ts *TemplateSub) SetStreaming(stream bool) {
+	ts.stream = stream
+}
+
+// SetDevMode sets whether failures to parse or execute a page are shown
+// in a detailed error page, with the offending line of the template and
+// the source around it, rather than the error page of the server. These
+// pages reveal the source of the templates, so developer mode should not
+// be used in production.
+func (ts *TemplateSub) SetDevMode(dev bool) {
+	ts.dev = dev
+}
+
+// fail responds to q with an Internal Server Error, caused by err, on the
+// error page of the server, or the detailed page in developer mode.
+func (ts *TemplateSub) fail(q *server.Query, err error) {
+	req := q.Req
+	if !ts.dev {
+		q.Continue()
+		q.WriteError(http.StatusInternalServerError, err)
+		return
+	}
+	resp := http.NewResponseWithBytes(req, renderError(parseError(err, ts.dir.Source)))
+	resp.Status, resp.StatusCode = "Internal Server Error", http.StatusInternalServerError
+	if resp.Header == nil {
+		resp.Header = make(http.Header)
+	}
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Header.Set("Cache-Control", "no-cache")
+	q.ContinueAndWrite(resp)
+}
+
+// page returns the name of the page at the URL path p.
+func (ts *TemplateSub) page(p string) string {
+	if p == "" || strings.HasSuffix(p, "/") {
+		p += "index"
+	}
+	return path.Clean("/" + p)[1:] + ts.ext
+}
@@ -0,0 +1,297 @@
+// Copyright 2009 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package template
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	http "net/http/httputil"
+	"github.com/petar/GoHTTP/cache"
+	"github.com/petar/GoHTTP/server"
+)
+
+// newTestDir returns a TemplateDir of HTML templates holding the files in
+// files, by slash-separated name, and a function that removes it.
+func newTestDir(t *testing.T, files map[string]string) (*cache.TemplateDir, func()) {
+	dir, err := ioutil.TempDir("", "template")
+	if err != nil {
+		t.Fatalf("TempDir: %s", err)
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatalf("MkdirAll: %s", err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatalf("WriteFile: %s", err)
+		}
+	}
+	td, err := cache.NewHTMLTemplateDir(dir, nil)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatalf("NewHTMLTemplateDir: %s", err)
+	}
+	return td, func() { os.RemoveAll(dir) }
+}
+
+// testConn is a connection to a server serving a TemplateSub at /t.
+type testConn struct {
+	t  *testing.T
+	c  net.Conn
+	br *bufio.Reader
+	l  net.Listener
+}
+
+func newTestConn(t *testing.T, ts *TemplateSub) *testConn {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	srv := server.NewServer(l, server.Config{Timeout: 5e9}, 10)
+	srv.AddSub("/t", ts)
+	srv.Launch(1)
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		l.Close()
+		t.Fatalf("dial: %s", err)
+	}
+	return &testConn{t, c, bufio.NewReader(c), l}
+}
+
+func (tc *testConn) Close() {
+	tc.c.Close()
+	tc.l.Close()
+}
+
+// do sends a request for uri with the given method, and returns the
+// response and its body. A body cut short is returned along with the
+// error reading it.
+func (tc *testConn) do(method, uri string) (*http.Response, []byte, error) {
+	if _, err := fmt.Fprintf(tc.c, "%s %s HTTP/1.1\r\nHost: localhost\r\n\r\n", method, uri); err != nil {
+		tc.t.Fatalf("%s: send request: %s", uri, err)
+	}
+	resp, err := http.ReadResponse(tc.br, &http.Request{Method: method})
+	if err != nil {
+		tc.t.Fatalf("%s: read response: %s", uri, err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	return resp, body, err
+}
+
+// get is like do for GET requests, but fails the test if the body cannot
+// be read.
+func (tc *testConn) get(uri string) (*http.Response, []byte) {
+	resp, body, err := tc.do("GET", uri)
+	if err != nil {
+		tc.t.Fatalf("%s: read body: %s", uri, err)
+	}
+	return resp, body
+}
+
+func TestPage(t *testing.T) {
+	ts := NewTemplateSub(nil, ".html", nil)
+	tests := []struct {
+		path, page string
+	}{
+		{"", "index.html"},
+		{"/", "index.html"},
+		{"/about", "about.html"},
+		{"/blog/", "blog/index.html"},
+		{"/blog/post", "blog/post.html"},
+		{"/blog/../about", "about.html"},
+		{"/../../about", "about.html"},
+	}
+	for _, tt := range tests {
+		if page := ts.page(tt.path); page != tt.page {
+			t.Errorf("page(%q) = %q, want %q", tt.path, page, tt.page)
+		}
+	}
+}
+
+func TestTemplateSub(t *testing.T) {
+	td, remove := newTestDir(t, map[string]string{
+		"_base.html":      `{{define "base"}}<title>{{template "title"}}</title>{{.}}{{end}}`,
+		"index.html":      `{{define "title"}}home{{end}}{{template "base" .}}`,
+		"blog/index.html": `{{define "title"}}blog{{end}}{{template "base" .}}`,
+		"blog/post.html":  `{{define "title"}}post{{end}}{{template "base" .}}`,
+		"broken.html":     `{{if}}`,
+		"failing.html":    `{{template "missing"}}`,
+		"nodata.html":     `{{.}}`,
+	})
+	defer remove()
+	ts := NewTemplateSub(td, ".html", func(q *server.Query) (interface{}, error) {
+		if strings.HasSuffix(q.Req.URL.Path, "nodata") {
+			return nil, errors.New("no data")
+		}
+		return q.Req.URL.Path, nil
+	})
+	tc := newTestConn(t, ts)
+	defer tc.Close()
+
+	tests := []struct {
+		uri    string
+		status int
+		body   string
+	}{
+		{"/t/", 200, "<title>home</title>/"},
+		{"/t/blog/", 200, "<title>blog</title>/blog/"},
+		{"/t/blog/post", 200, "<title>post</title>/blog/post"},
+		{"/t/missing", 404, ""},
+		{"/t/blog/missing/", 404, ""},
+		{"/t/_base", 404, ""},
+		{"/t/broken", 500, ""},
+		{"/t/failing", 500, ""},
+		{"/t/nodata", 500, ""},
+	}
+	for _, tt := range tests {
+		resp, body := tc.get(tt.uri)
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.uri, resp.StatusCode, tt.status)
+			continue
+		}
+		if tt.status != 200 {
+			continue
+		}
+		if string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.uri, body, tt.body)
+		}
+		if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("%s: Content-Type %q", tt.uri, ct)
+		}
+	}
+	if resp, _, _ := tc.do("POST", "/t/"); resp.StatusCode != 404 {
+		t.Errorf("POST: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestTemplateSubDevMode(t *testing.T) {
+	td, remove := newTestDir(t, map[string]string{
+		"broken.html": "<p>\n{{if .Secret}}\n</p>",
+	})
+	defer remove()
+	ts := NewTemplateSub(td, ".html", nil)
+	tc := newTestConn(t, ts)
+	defer tc.Close()
+
+	resp, body := tc.get("/t/broken")
+	if resp.StatusCode != 500 || strings.Contains(string(body), ".Secret") {
+		t.Errorf("status %d, body %q outside developer mode", resp.StatusCode, body)
+	}
+	ts.SetDevMode(true)
+	resp, body = tc.get("/t/broken")
+	if resp.StatusCode != 500 {
+		t.Errorf("status %d in developer mode, want 500", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "<b>broken.html</b>") || !strings.Contains(string(body), `class="offending"`) {
+		t.Errorf("developer error page lacks the offending line:\n%s", body)
+	}
+}
+
+func TestTemplateSubRenderCache(t *testing.T) {
+	td, remove := newTestDir(t, map[string]string{"page.html": "{{.}}"})
+	defer remove()
+	calls := 0
+	ts := NewTemplateSub(td, ".html", func(q *server.Query) (interface{}, error) {
+		calls++
+		return calls, nil
+	})
+	ts.SetRenderCache(cache.NewRenderCache(0), func(q *server.Query) (string, bool) {
+		return q.Req.URL.RawQuery, q.Req.URL.RawQuery != "nocache"
+	})
+	tc := newTestConn(t, ts)
+	defer tc.Close()
+
+	tests := []struct {
+		uri, body string
+	}{
+		{"/t/page?a", "1"},
+		{"/t/page?a", "1"},
+		{"/t/page?b", "2"},
+		{"/t/page?nocache", "3"},
+		{"/t/page?nocache", "4"},
+		{"/t/page?b", "2"},
+	}
+	for _, tt := range tests {
+		if _, body := tc.get(tt.uri); string(body) != tt.body {
+			t.Errorf("%s: body %q, want %q", tt.uri, body, tt.body)
+		}
+	}
+}
+
+// streamData is the data of a page that fails to execute after writing
+// Big.
+type streamData struct {
+	Big string
+}
+
+func (streamData) Fail() (string, error) {
+	return "", errors.New("failed midway")
+}
+
+func TestTemplateSubStream(t *testing.T) {
+	td, remove := newTestDir(t, map[string]string{
+		"page.html":    "<p>{{.Big}}</p>",
+		"failing.html": "<p>{{.Big}}</p>{{.Fail}}",
+	})
+	defer remove()
+	big := strings.Repeat("x", 10000)
+	ts := NewTemplateSub(td, ".html", func(q *server.Query) (interface{}, error) {
+		return streamData{big}, nil
+	})
+	ts.SetStreaming(true)
+	tc := newTestConn(t, ts)
+	defer tc.Close()
+
+	resp, body := tc.get("/t/page")
+	if resp.StatusCode != 200 || string(body) != "<p>"+big+"</p>" {
+		t.Errorf("streamed page: status %d, %d bytes", resp.StatusCode, len(body))
+	}
+	if len(resp.TransferEncoding) == 0 || resp.TransferEncoding[0] != "chunked" {
+		t.Errorf("streamed page with transfer encoding %v", resp.TransferEncoding)
+	}
+
+	// The status is sent before the page fails, so the response can only
+	// be cut short
+	resp, body, err := tc.do("GET", "/t/failing")
+	if resp.StatusCode != 200 || err == nil {
+		t.Errorf("failing page: status %d, body read error %v", resp.StatusCode, err)
+	}
+	if page := "<p>" + big + "</p>"; len(body) == 0 || len(body) >= len(page) || !strings.HasPrefix(page, string(body)) {
+		t.Errorf("failing page: %d bytes of body", len(body))
+	}
+}
+
+func TestPageReaderClose(t *testing.T) {
+	td, remove := newTestDir(t, map[string]string{"page.html": "{{range .}}{{.}}{{end}}"})
+	defer remove()
+	tmpl, err := td.Get("page.html")
+	if err != nil {
+		t.Fatalf("Get: %s", err)
+	}
+	items := make(chan string)
+	r := newPageReader("/page", tmpl, items)
+	chunk := strings.Repeat("x", 8192)
+	items <- chunk
+	buf := make([]byte, 10)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("Read: %s", err)
+	}
+	r.Close()
+
+	// The pending write fails, and the execution takes no more items
+	select {
+	case items <- chunk:
+		t.Errorf("page executed on after the reader was closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"io"
+)
+
+// BufferPool keeps free buffers in size classes, so that buffers used
+// for a single transfer, e.g. to copy a body, can be reused instead of
+// allocated afresh. Each class holds a bounded number of free buffers;
+// buffers returned to a full class are left to the garbage collector.
+// It is safe for concurrent use.
+type BufferPool struct {
+	classes []bufClass // In increasing order of size
+}
+
+type bufClass struct {
+	size int
+	free chan []byte
+}
+
+// NewBufferPool returns a BufferPool with a class for each of sizes,
+// which must be in increasing order, holding up to n free buffers each.
+func NewBufferPool(sizes []int, n int) *BufferPool {
+	p := &BufferPool{classes: make([]bufClass, len(sizes))}
+	for i, size := range sizes {
+		if size <= 0 || (i > 0 && size <= sizes[i-1]) {
+			panic("BufferPool, bad size classes")
+		}
+		p.classes[i] = bufClass{size, make(chan []byte, n)}
+	}
+	return p
+}
+
+// Buffers is the BufferPool used by Copy, and shared by the packages of
+// GoHTTP.
+var Buffers = NewBufferPool([]int{4 << 10, 32 << 10, 256 << 10}, 64)
+
+// class returns the smallest class of buffers of at least n bytes, or nil.
+func (p *BufferPool) class(n int) *bufClass {
+	for i := range p.classes {
+		if p.classes[i].size >= n {
+			return &p.classes[i]
+		}
+	}
+	return nil
+}
+
+// Get returns a buffer of n bytes. Its contents are undefined. Buffers
+// larger than the largest class are allocated, and not kept by Put.
+func (p *BufferPool) Get(n int) []byte {
+	c := p.class(n)
+	if c == nil {
+		return make([]byte, n)
+	}
+	select {
+	case b := <-c.free:
+		return b[:n]
+	default:
+	}
+	return make([]byte, n, c.size)
+}
+
+// Put returns b, obtained from Get, to the pool. The caller must not use
+// b afterwards.
+func (p *BufferPool) Put(b []byte) {
+	c := p.class(cap(b))
+	if c == nil || c.size != cap(b) {
+		return
+	}
+	select {
+	case c.free <- b[:cap(b)]:
+	default:
+	}
+}
+
+// Copy is like io.Copy, but copies through a buffer from Buffers.
+func Copy(dst io.Writer, src io.Reader) (written int64, err error) {
+	buf := Buffers.Get(32 << 10)
+	defer Buffers.Put(buf)
+	for {
+		nr, er := src.Read(buf)
+		if nr > 0 {
+			nw, ew := dst.Write(buf[0:nr])
+			written += int64(nw)
+			if ew != nil {
+				return written, ew
+			}
+			if nw != nr {
+				return written, io.ErrShortWrite
+			}
+		}
+		if er == io.EOF {
+			return written, nil
+		}
+		if er != nil {
+			return written, er
+		}
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestBufferPool(t *testing.T) {
+	p := NewBufferPool([]int{16, 64}, 1)
+	b := p.Get(10)
+	if len(b) != 10 || cap(b) != 16 {
+		t.Fatalf("Get(10) returned len %d cap %d, expected 10 and 16", len(b), cap(b))
+	}
+	p.Put(b)
+	if c := p.Get(16); &c[0] != &b[0] {
+		t.Error("buffer not reused")
+	}
+	if c := p.Get(100); len(c) != 100 {
+		t.Errorf("Get(100) returned len %d", len(c))
+	}
+	p.Put(make([]byte, 20)) // Not of a class; dropped
+	if c := p.Get(17); cap(c) != 64 {
+		t.Errorf("Get(17) returned cap %d, expected 64", cap(c))
+	}
+}
+
+func TestCopy(t *testing.T) {
+	src := bytes.Repeat([]byte("0123456789"), 10000)
+	var dst bytes.Buffer
+	n, err := Copy(&dst, bytes.NewReader(src))
+	if err != nil || n != int64(len(src)) || !bytes.Equal(dst.Bytes(), src) {
+		t.Fatalf("Copy returned %d, %v", n, err)
+	}
+}
@@ -5,20 +5,79 @@
 package util
 
 import (
+	"container/list"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var ErrTimeout = errors.New("timeout")
+var (
+	ErrTimeout   = errors.New("timeout")
+	ErrCanceled  = errors.New("canceled")
+	ErrOverLimit = errors.New("weight over limit")
+)
 
 // FDLimiter helps keep track of the number of file descriptors in use.
+// Callers waiting for an fd are served in the order they started waiting.
+//
+// Fds can be allocated in named categories, e.g. "inbound", "outbound" and
+// "tunnel", each of which may have a sub-limit of its own under the global
+// limit, so that one class of traffic cannot starve the others. A waiter
+// held back by the sub-limit of its category does not hold back waiters
+// of other categories. An acquisition may also have a weight, the number
+// of fds it stands for, e.g. 2 for a tunnel. Lock and Acquire allocate
+// one fd in the default category, named "", which has no sub-limit unless
+// one is set.
 type FDLimiter struct {
-	limit int
+	limit   int
+	count   int
+	lk      sync.Mutex
+	cats    map[string]*fdCategory
+	waiters list.List // Of *fdWaiter, in the order they started waiting
+	nfych   chan<- int
+	stats   FDStats
+}
+
+// FDStats holds the statistics of an FDLimiter.
+type FDStats struct {
+	InUse        int    // Fds currently in use
+	Peak         int    // Most fds in use at any time
+	Limit        int    // Current global limit
+	Waiting      int    // Callers currently waiting
+	Acquisitions uint64 // Successful acquisitions
+	Timeouts     uint64 // Waits given up on a timeout or cancelation
+	WaitTime     int64  // Total time waited by successful acquisitions, in nanoseconds
+}
+
+// AvgWait returns the average time an acquisition waited, in nanoseconds.
+func (s FDStats) AvgWait() int64 {
+	if s.Acquisitions == 0 {
+		return 0
+	}
+	return s.WaitTime / int64(s.Acquisitions)
+}
+
+func (s FDStats) String() string {
+	return fmt.Sprintf("%d/%d fds, %d peak, %d waiting; %d acquire, %d timeout, avg wait %dus",
+		s.InUse, s.Limit, s.Peak, s.Waiting, s.Acquisitions, s.Timeouts, s.AvgWait()/1e3)
+}
+
+// fdCategory is the budget of a category of fds.
+type fdCategory struct {
+	limit int // Sub-limit, or zero for none
 	count int
-	lk    sync.Mutex
-	ch    chan int
-	nfych chan<- int
+}
+
+// fdWaiter is a caller waiting for fds.
+type fdWaiter struct {
+	cat     string
+	weight  int
+	since   int64         // Time the wait started
+	ready   chan struct{} // Closed when the fds are granted, or the wait failed
+	granted bool
+	err     error // Why the wait failed, if it did
 }
 
 // Init initializes (or resets) an FDLimiter object.
@@ -29,10 +88,51 @@ func (fdl *FDLimiter) Init(fdlim int) {
 	}
 	fdl.limit = fdlim
 	fdl.count = 0
-	fdl.ch = make(chan int)
+	for _, c := range fdl.cats {
+		c.count = 0
+	}
+	fdl.waiters.Init()
+	fdl.stats = FDStats{}
 	fdl.lk.Unlock()
 }
 
+// SetCategoryLimit sets the sub-limit of the category cat. Zero means
+// the category is only bound by the global limit. Waiters of cat for
+// more fds than the new limit fail with ErrOverLimit.
+func (fdl *FDLimiter) SetCategoryLimit(cat string, limit int) {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	if limit < 0 {
+		panic("FDLimiter, bad category limit")
+	}
+	fdl.category(cat).limit = limit
+	fdl.failOverLimit()
+	fdl.grant()
+}
+
+// CategoryCount returns the number of fds in use in the category cat.
+func (fdl *FDLimiter) CategoryCount(cat string) int {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	if c, ok := fdl.cats[cat]; ok {
+		return c.count
+	}
+	return 0
+}
+
+// category returns the budget of cat, creating it if needed.
+func (fdl *FDLimiter) category(cat string) *fdCategory {
+	if fdl.cats == nil {
+		fdl.cats = make(map[string]*fdCategory)
+	}
+	c, ok := fdl.cats[cat]
+	if !ok {
+		c = &fdCategory{}
+		fdl.cats[cat] = c
+	}
+	return c
+}
+
 // SetNotifyChan instructs the FDLimiter to send the current
 // number of utilized file descriptors every time that number changes.
 // Calling this method with a nil argument, removes the notify channel.
@@ -54,95 +154,239 @@ func (fdl *FDLimiter) LockCount() int {
 	return fdl.count
 }
 
-func (fdl *FDLimiter) Limit() int { return fdl.limit }
+func (fdl *FDLimiter) Limit() int {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	return fdl.limit
+}
+
+// SetLimit changes the global limit while the FDLimiter is in use, e.g.
+// after a change of ulimit. Raising the limit wakes as many waiters as
+// now fit. Lowering it below the number of fds in use revokes nothing:
+// new fds are only allocated once usage has drained below the new limit.
+// Waiters for more fds than the new limit fail with ErrOverLimit.
+func (fdl *FDLimiter) SetLimit(fdlim int) {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	if fdlim <= 0 {
+		panic("FDLimiter, bad limit")
+	}
+	fdl.limit = fdlim
+	fdl.failOverLimit()
+	fdl.grant()
+}
+
+// Stats returns the statistics of the FDLimiter, since it was initialized.
+func (fdl *FDLimiter) Stats() FDStats {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	s := fdl.stats
+	s.InUse, s.Limit, s.Waiting = fdl.count, fdl.limit, fdl.waiters.Len()
+	return s
+}
+
+// SummaryLine summarizes the statistics of the FDLimiter, for logging.
+func (fdl *FDLimiter) SummaryLine() string {
+	return "FD limiter " + fdl.Stats().String()
+}
+
+// enqueue allocates weight fds in the category cat if they are free, in
+// which case it returns nil. Otherwise, it queues and returns a waiter,
+// whose ready chan is closed when the fds are granted.
+func (fdl *FDLimiter) enqueue(cat string, weight int) *list.Element {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	if weight <= 0 || weight > fdl.limit {
+		panic("FDLimiter, bad weight")
+	}
+	if l := fdl.category(cat).limit; l > 0 && weight > l {
+		panic("FDLimiter, weight over category limit")
+	}
+	e := fdl.waiters.PushBack(&fdWaiter{cat: cat, weight: weight, since: time.Now().UnixNano(), ready: make(chan struct{})})
+	fdl.grant()
+	if e.Value.(*fdWaiter).granted {
+		return nil
+	}
+	return e
+}
+
+// abandon gives up the wait of e. If the fds were granted meanwhile, they
+// are passed on.
+func (fdl *FDLimiter) abandon(e *list.Element) {
+	fdl.lk.Lock()
+	defer fdl.lk.Unlock()
+	w := e.Value.(*fdWaiter)
+	if w.granted {
+		fdl.release(w.cat, w.weight)
+		return
+	}
+	if w.err != nil {
+		// Failed meanwhile, and no longer queued
+		return
+	}
+	fdl.waiters.Remove(e)
+	fdl.stats.Timeouts++
+	// Waiters behind w may fit now
+	fdl.grant()
+}
+
+// release frees weight fds in the category cat, and hands them to waiters.
+func (fdl *FDLimiter) release(cat string, weight int) {
+	c := fdl.category(cat)
+	if fdl.count < weight || c.count < weight {
+		panic("FDLimiter")
+	}
+	fdl.count -= weight
+	c.count -= weight
+	fdl.notify()
+	fdl.grant()
+}
+
+// failOverLimit fails the waiters for more fds than the global limit or
+// the limit of their category, which could never be granted, and would
+// hold back the waiters behind them forever.
+func (fdl *FDLimiter) failOverLimit() {
+	for e := fdl.waiters.Front(); e != nil; {
+		next := e.Next()
+		w := e.Value.(*fdWaiter)
+		if l := fdl.category(w.cat).limit; w.weight > fdl.limit || (l > 0 && w.weight > l) {
+			fdl.waiters.Remove(e)
+			w.err = ErrOverLimit
+			close(w.ready)
+		}
+		e = next
+	}
+}
+
+// grant allocates fds to waiters, in the order they started waiting.
+// Waiters held back by the limit of their category are passed over, but
+// a waiter held back by the global limit holds back those behind it, so
+// that heavy acquisitions are not starved by light ones.
+func (fdl *FDLimiter) grant() {
+	granted := false
+	now := time.Now().UnixNano()
+	for e := fdl.waiters.Front(); e != nil && fdl.count < fdl.limit; {
+		next := e.Next()
+		w := e.Value.(*fdWaiter)
+		c := fdl.category(w.cat)
+		if c.limit > 0 && c.count+w.weight > c.limit {
+			e = next
+			continue
+		}
+		if fdl.count+w.weight > fdl.limit {
+			break
+		}
+		fdl.waiters.Remove(e)
+		fdl.count += w.weight
+		c.count += w.weight
+		w.granted = true
+		close(w.ready)
+		granted = true
+		fdl.stats.Acquisitions++
+		fdl.stats.WaitTime += now - w.since
+		if fdl.count > fdl.stats.Peak {
+			fdl.stats.Peak = fdl.count
+		}
+		e = next
+	}
+	if granted {
+		fdl.notify()
+	}
+}
 
 // Lock blocks until it can allocate one fd without violating the limit.
+// Acquire is preferred in new code, since its Token cannot be released twice.
 func (fdl *FDLimiter) Lock() {
-	for {
-		fdl.lk.Lock()
-		if fdl.count < fdl.limit {
-			fdl.count++
-			fdl.notify()
-			fdl.lk.Unlock()
-			return
-		}
-		fdl.lk.Unlock()
-		<-fdl.ch
+	if e := fdl.enqueue("", 1); e != nil {
+		<-e.Value.(*fdWaiter).ready
 	}
-	panic("FDLimiter, unreachable")
 }
 
 // LockOrTimeout proceeds as Lock, except that it returns an ErrTimeout
 // error, if a lock cannot be obtained within ns nanoseconds.
 func (fdl *FDLimiter) LockOrTimeout(ns int64) error {
-	waitsofar := int64(0)
-	for {
-		// Try to get an fd
-		fdl.lk.Lock()
-		if fdl.count < fdl.limit {
-			fdl.count++
-			fdl.notify()
-			fdl.lk.Unlock()
-			return nil
-		}
-		fdl.lk.Unlock()
-
-		// Or, wait for an fd or timeout
-		if waitsofar >= ns {
-			return ErrTimeout
-		}
-		t0 := time.Now().UnixNano()
-		alrm := alarmOnce(ns - waitsofar)
-		select {
-		case <-alrm:
-		case <-fdl.ch:
-		}
-		waitsofar += time.Now().UnixNano() - t0
+	e := fdl.enqueue("", 1)
+	if e == nil {
+		return nil
+	}
+	t := time.NewTimer(time.Duration(ns))
+	defer t.Stop()
+	select {
+	case <-e.Value.(*fdWaiter).ready:
+		return nil
+	case <-t.C:
+		fdl.abandon(e)
+		return ErrTimeout
 	}
 	panic("FDLimiter, unreachable")
 }
 
 func (fdl *FDLimiter) LockOrChan(ch <-chan interface{}) (msg interface{}, err error) {
-	for {
-		fdl.lk.Lock()
-		if fdl.count < fdl.limit {
-			fdl.count++
-			fdl.notify()
-			fdl.lk.Unlock()
-			return nil, nil
-		}
-		fdl.lk.Unlock()
+	e := fdl.enqueue("", 1)
+	if e == nil {
+		return nil, nil
+	}
+	select {
+	case <-e.Value.(*fdWaiter).ready:
+		return nil, nil
+	case msg = <-ch:
+		fdl.abandon(e)
+		return msg, ErrTimeout
+	}
+	panic("FDLimiter, unreachable")
+}
+
+// Token stands for fds allocated by Acquire. Release gives them back.
+type Token struct {
+	fdl      *FDLimiter
+	cat      string
+	weight   int
+	released *int32 // Set by the first call to Release
+}
 
+// Release indicates that the fd of the token has been released. Only the
+// first call has an effect, so Release may be called more than once, e.g.
+// both on an error path and in a deferred call. Releasing the zero Token
+// does nothing.
+func (t Token) Release() {
+	if t.fdl != nil && atomic.CompareAndSwapInt32(t.released, 0, 1) {
+		t.fdl.lk.Lock()
+		defer t.fdl.lk.Unlock()
+		t.fdl.release(t.cat, t.weight)
+	}
+}
+
+// Acquire blocks until it can allocate one fd without violating the limit,
+// and returns a Token that must be released when the fd is. If cancel is
+// closed, or receives a value, before an fd can be allocated, Acquire
+// returns ErrCanceled instead. A nil cancel means Acquire waits forever.
+func (fdl *FDLimiter) Acquire(cancel <-chan struct{}) (Token, error) {
+	return fdl.AcquireWeighted("", 1, cancel)
+}
+
+// AcquireWeighted is like Acquire, but allocates weight fds in the
+// category cat, within both the global limit and the limit of cat. If
+// either limit is lowered below weight while it waits, AcquireWeighted
+// returns ErrOverLimit.
+func (fdl *FDLimiter) AcquireWeighted(cat string, weight int, cancel <-chan struct{}) (Token, error) {
+	if e := fdl.enqueue(cat, weight); e != nil {
+		w := e.Value.(*fdWaiter)
 		select {
-		case msg = <-ch:
-			return msg, ErrTimeout
-		case <-fdl.ch:
+		case <-w.ready:
+			if w.err != nil {
+				return Token{}, w.err
+			}
+		case <-cancel:
+			fdl.abandon(e)
+			return Token{}, ErrCanceled
 		}
 	}
-	panic("FDLimiter, unreachable")
+	return Token{fdl, cat, weight, new(int32)}, nil
 }
 
 // Call Unlock to indicate that a file descriptor has been released.
 func (fdl *FDLimiter) Unlock() {
 	fdl.lk.Lock()
-	if fdl.count <= 0 {
-		panic("FDLimiter")
-	}
-	fdl.count--
-	fdl.notify()
-	if fdl.count == fdl.limit-1 {
-		fdl.ch <- 1
-	}
-	fdl.lk.Unlock()
-}
-
-// alarmOnce sends "1" to the returned chan after ns nanoseconds
-func alarmOnce(ns int64) <-chan int {
-	backchan := make(chan int)
-	go func() {
-		time.Sleep(time.Duration(ns))
-		backchan <- 1
-		close(backchan)
-	}()
-	return backchan
+	defer fdl.lk.Unlock()
+	fdl.release("", 1)
 }
@@ -0,0 +1,283 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n callers are waiting on fdl.
+func waitQueued(fdl *FDLimiter, n int) {
+	for {
+		fdl.lk.Lock()
+		l := fdl.waiters.Len()
+		fdl.lk.Unlock()
+		if l >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFDLimiterFIFO(t *testing.T) {
+	var fdl FDLimiter
+	fdl.Init(1)
+	fdl.Lock()
+	const n = 10
+	order := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			fdl.Lock()
+			order <- i
+			fdl.Unlock()
+		}(i)
+		waitQueued(&fdl, i+1)
+	}
+	fdl.Unlock()
+	for i := 0; i < n; i++ {
+		if j := <-order; j != i {
+			t.Fatalf("waiter %d served in place of %d", j, i)
+		}
+	}
+	if c := fdl.LockCount(); c != 0 {
+		t.Errorf("count %d after all unlocks", c)
+	}
+}
+
+func TestFDLimiterCancel(t *testing.T) {
+	var fdl FDLimiter
+	fdl.Init(1)
+	tok, err := fdl.Acquire(nil)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	cancel := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		_, err := fdl.Acquire(cancel)
+		done <- err
+	}()
+	waitQueued(&fdl, 1)
+	close(cancel)
+	if err = <-done; err != ErrCanceled {
+		t.Fatalf("canceled acquire returned %v", err)
+	}
+	if err = fdl.LockOrTimeout(1e6); err != ErrTimeout {
+		t.Fatalf("lock of a busy limiter returned %v", err)
+	}
+	tok.Release()
+	tok.Release()
+	if c := fdl.LockCount(); c != 0 {
+		t.Fatalf("count %d after release", c)
+	}
+	if _, err = fdl.Acquire(cancel); err != nil {
+		t.Fatalf("acquire of a free limiter: %s", err)
+	}
+	s := fdl.Stats()
+	if s.Acquisitions != 2 || s.Timeouts != 2 || s.Peak != 1 || s.InUse != 1 {
+		t.Errorf("stats %+v, expected 2 acquisitions, 2 timeouts, 1 peak and 1 in use", s)
+	}
+}
+
+// TestFDLimiterStress checks that the limit holds, and that no waiter is
+// left blocked, when many callers acquire and give up fds concurrently.
+func TestFDLimiterStress(t *testing.T) {
+	const limit, workers, rounds = 4, 50, 200
+	var fdl FDLimiter
+	fdl.Init(limit)
+	var mu sync.Mutex
+	held := 0
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < rounds; j++ {
+				var err error
+				release := func() { fdl.Unlock() }
+				switch r.Intn(3) {
+				case 0:
+					fdl.Lock()
+				case 1:
+					err = fdl.LockOrTimeout(int64(r.Intn(100)) * 1e3)
+				case 2:
+					cancel := make(chan struct{})
+					if r.Intn(2) == 0 {
+						close(cancel)
+					}
+					var tok Token
+					tok, err = fdl.Acquire(cancel)
+					release = func() { tok.Release() }
+				}
+				if err != nil {
+					continue
+				}
+				mu.Lock()
+				held++
+				if held > limit {
+					t.Errorf("%d fds held, over the limit of %d", held, limit)
+				}
+				mu.Unlock()
+				time.Sleep(time.Duration(r.Intn(20)) * time.Microsecond)
+				mu.Lock()
+				held--
+				mu.Unlock()
+				release()
+			}
+		}(int64(i))
+	}
+	done := make(chan bool)
+	go func() {
+		wg.Wait()
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatal("waiters left blocked")
+	}
+	if c := fdl.LockCount(); c != 0 {
+		t.Errorf("count %d after all unlocks", c)
+	}
+}
+
+func TestFDLimiterCategories(t *testing.T) {
+	var fdl FDLimiter
+	fdl.Init(4)
+	fdl.SetCategoryLimit("tunnel", 2)
+	tun, err := fdl.AcquireWeighted("tunnel", 2, nil)
+	if err != nil {
+		t.Fatalf("acquire tunnel: %s", err)
+	}
+	// A second tunnel is held back by the sub-limit, but not inbound fds
+	done := make(chan Token)
+	go func() {
+		tok, _ := fdl.AcquireWeighted("tunnel", 2, nil)
+		done <- tok
+	}()
+	waitQueued(&fdl, 1)
+	in, err := fdl.AcquireWeighted("inbound", 1, nil)
+	if err != nil {
+		t.Fatalf("acquire inbound: %s", err)
+	}
+	if c := fdl.LockCount(); c != 3 {
+		t.Fatalf("count %d, expected 3", c)
+	}
+	select {
+	case <-done:
+		t.Fatal("tunnel acquired over its sub-limit")
+	default:
+	}
+	tun.Release()
+	tun = <-done
+	if c := fdl.CategoryCount("tunnel"); c != 2 {
+		t.Fatalf("tunnel count %d, expected 2", c)
+	}
+	// A heavy waiter held back by the global limit is not overtaken
+	go func() {
+		tok, _ := fdl.AcquireWeighted("outbound", 2, nil)
+		done <- tok
+	}()
+	waitQueued(&fdl, 1)
+	cancel := make(chan struct{})
+	close(cancel)
+	if _, err = fdl.AcquireWeighted("inbound", 1, cancel); err != ErrCanceled {
+		t.Fatalf("light acquire overtook a heavy waiter: %v", err)
+	}
+	tun.Release()
+	out := <-done
+	in.Release()
+	out.Release()
+	if c := fdl.LockCount(); c != 0 {
+		t.Errorf("count %d after all releases", c)
+	}
+}
+
+func TestFDLimiterSetLimit(t *testing.T) {
+	var fdl FDLimiter
+	fdl.Init(2)
+	fdl.Lock()
+	fdl.Lock()
+	done := make(chan bool)
+	for i := 0; i < 2; i++ {
+		go func() {
+			fdl.Lock()
+			done <- true
+		}()
+	}
+	waitQueued(&fdl, 2)
+	fdl.SetLimit(4)
+	<-done
+	<-done
+	// Usage drains below a lowered limit before fds are allocated again
+	fdl.SetLimit(2)
+	fdl.Unlock()
+	fdl.Unlock()
+	if err := fdl.LockOrTimeout(1e6); err != ErrTimeout {
+		t.Fatalf("lock over the lowered limit returned %v", err)
+	}
+	fdl.Unlock()
+	if err := fdl.LockOrTimeout(1e6); err != nil {
+		t.Fatalf("lock under the lowered limit: %s", err)
+	}
+}
+
+// TestFDLimiterOverLimit checks that lowering a limit below the weight of
+// a queued waiter fails the waiter, rather than holding back the waiters
+// behind it forever.
+func TestFDLimiterOverLimit(t *testing.T) {
+	var fdl FDLimiter
+	fdl.Init(2)
+	fdl.Lock()
+	fdl.Lock()
+	heavy := make(chan error)
+	go func() {
+		_, err := fdl.AcquireWeighted("", 2, nil)
+		heavy <- err
+	}()
+	waitQueued(&fdl, 1)
+	light := make(chan bool)
+	go func() {
+		fdl.Lock()
+		light <- true
+	}()
+	waitQueued(&fdl, 2)
+	fdl.SetLimit(1)
+	if err := <-heavy; err != ErrOverLimit {
+		t.Fatalf("waiter over the lowered limit returned %v", err)
+	}
+	fdl.Unlock()
+	fdl.Unlock()
+	<-light
+	fdl.Unlock()
+
+	// Likewise for category limits
+	fdl.Init(4)
+	fdl.SetCategoryLimit("tunnel", 4)
+	tok, err := fdl.AcquireWeighted("tunnel", 2, nil)
+	if err != nil {
+		t.Fatalf("acquire: %s", err)
+	}
+	go func() {
+		_, err := fdl.AcquireWeighted("tunnel", 3, nil)
+		heavy <- err
+	}()
+	waitQueued(&fdl, 1)
+	fdl.SetCategoryLimit("tunnel", 2)
+	if err = <-heavy; err != ErrOverLimit {
+		t.Fatalf("waiter over the lowered category limit returned %v", err)
+	}
+	tok.Release()
+	if _, err = fdl.AcquireWeighted("tunnel", 2, nil); err != nil {
+		t.Fatalf("acquire within the lowered category limit: %s", err)
+	}
+	if s := fdl.Stats(); s.Waiting != 0 || s.InUse != 2 {
+		t.Errorf("stats %+v, expected none waiting and 2 in use", s)
+	}
+}
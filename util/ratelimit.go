@@ -0,0 +1,173 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+// ErrNeverAllowed is returned by Wait for events that can never happen,
+// since the rate is zero and the bucket holds too few tokens.
+var ErrNeverAllowed = errors.New("never allowed")
+
+// RateLimiter is a token bucket. Tokens are added to the bucket at a
+// steady rate, up to a burst size, and every event takes tokens out.
+// Events may be checked with Allow, which never blocks, waited for with
+// Wait, or scheduled with Reserve. It is safe for concurrent use.
+type RateLimiter struct {
+	lk     sync.Mutex
+	rate   float64 // Tokens added per second
+	burst  int
+	tokens float64 // Tokens in the bucket, negative if reserved ahead
+	last   int64   // Time tokens was last brought up to date
+}
+
+// NewRateLimiter returns a RateLimiter that allows rate events per second
+// on average, and bursts of up to burst events. The bucket starts full.
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	if rate < 0 || burst <= 0 {
+		panic("RateLimiter, bad rate or burst")
+	}
+	return &RateLimiter{rate: rate, burst: burst, tokens: float64(burst), last: time.Now().UnixNano()}
+}
+
+// SetRate changes the rate and burst size, e.g. on reconfiguration.
+// Tokens already in the bucket are kept, up to the new burst size.
+func (rl *RateLimiter) SetRate(rate float64, burst int) {
+	if rate < 0 || burst <= 0 {
+		panic("RateLimiter, bad rate or burst")
+	}
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	rl.advance(time.Now().UnixNano())
+	rl.rate, rl.burst = rate, burst
+	if rl.tokens > float64(burst) {
+		rl.tokens = float64(burst)
+	}
+}
+
+// advance adds the tokens accrued since the last update, as of now.
+func (rl *RateLimiter) advance(now int64) {
+	if now > rl.last {
+		rl.tokens += float64(now-rl.last) * rl.rate / 1e9
+		if rl.tokens > float64(rl.burst) {
+			rl.tokens = float64(rl.burst)
+		}
+	}
+	rl.last = now
+}
+
+// Allow reports whether an event may happen now, taking a token if so.
+func (rl *RateLimiter) Allow() bool { return rl.AllowN(1) }
+
+// AllowN reports whether n events may happen now, taking n tokens if so.
+func (rl *RateLimiter) AllowN(n int) bool {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	rl.advance(time.Now().UnixNano())
+	if rl.tokens < float64(n) {
+		return false
+	}
+	rl.tokens -= float64(n)
+	return true
+}
+
+// Reservation is a permission for events to happen after a delay.
+type Reservation struct {
+	rl *RateLimiter
+	n  int
+	at int64 // Time the events may happen, or math.MaxInt64 for never
+}
+
+// Delay returns how long to wait before the reserved events may happen.
+func (r *Reservation) Delay() time.Duration {
+	if r.at == math.MaxInt64 {
+		return math.MaxInt64
+	}
+	if d := r.at - time.Now().UnixNano(); d > 0 {
+		return time.Duration(d)
+	}
+	return 0
+}
+
+// Cancel gives the tokens of the reservation back to the bucket, if the
+// events have not happened yet, so that later events need not wait for
+// them.
+func (r *Reservation) Cancel() {
+	rl := r.rl
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	now := time.Now().UnixNano()
+	if r.n == 0 || now >= r.at {
+		return
+	}
+	rl.advance(now)
+	rl.tokens += float64(r.n)
+	if rl.tokens > float64(rl.burst) {
+		rl.tokens = float64(rl.burst)
+	}
+	r.n = 0
+}
+
+// Reserve reserves a token for an event, which may happen after the
+// delay of the returned Reservation.
+func (rl *RateLimiter) Reserve() *Reservation { return rl.ReserveN(1) }
+
+// ReserveN reserves n tokens for n events, which may happen after the
+// delay of the returned Reservation. It panics if n exceeds the burst
+// size, since the bucket never holds that many tokens. If the rate is
+// zero and the bucket holds fewer than n tokens, the events can never
+// happen: the Reservation takes no tokens, and its Delay is
+// math.MaxInt64.
+func (rl *RateLimiter) ReserveN(n int) *Reservation {
+	rl.lk.Lock()
+	defer rl.lk.Unlock()
+	if n <= 0 || n > rl.burst {
+		panic("RateLimiter, bad number of tokens")
+	}
+	now := time.Now().UnixNano()
+	rl.advance(now)
+	r := &Reservation{rl: rl, n: n, at: now}
+	if rl.tokens < float64(n) {
+		if rl.rate == 0 {
+			// No tokens are ever added
+			r.n, r.at = 0, math.MaxInt64
+			return r
+		}
+		r.at = now + int64((float64(n)-rl.tokens)*1e9/rl.rate)
+	}
+	rl.tokens -= float64(n)
+	return r
+}
+
+// Wait blocks until an event may happen. If cancel is closed, or receives
+// a value, first, Wait returns ErrCanceled and the token is given back.
+// A nil cancel means Wait does not give up. If the event can never
+// happen, Wait returns ErrNeverAllowed at once.
+func (rl *RateLimiter) Wait(cancel <-chan struct{}) error { return rl.WaitN(1, cancel) }
+
+// WaitN is like Wait, but waits until n events may happen.
+func (rl *RateLimiter) WaitN(n int, cancel <-chan struct{}) error {
+	r := rl.ReserveN(n)
+	if r.at == math.MaxInt64 {
+		return ErrNeverAllowed
+	}
+	d := r.Delay()
+	if d == 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-cancel:
+		r.Cancel()
+		return ErrCanceled
+	}
+}
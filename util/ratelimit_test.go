@@ -0,0 +1,81 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	rl := NewRateLimiter(100, 3)
+	for i := 0; i < 3; i++ {
+		if !rl.Allow() {
+			t.Fatalf("event %d of the burst not allowed", i)
+		}
+	}
+	if rl.Allow() {
+		t.Fatal("event over the burst allowed")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !rl.Allow() {
+		t.Fatal("event not allowed after tokens were added")
+	}
+}
+
+func TestRateLimiterReserve(t *testing.T) {
+	rl := NewRateLimiter(10, 1)
+	if d := rl.Reserve().Delay(); d != 0 {
+		t.Fatalf("first reservation delayed by %v", d)
+	}
+	r := rl.Reserve()
+	if d := r.Delay(); d < 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("second reservation delayed by %v, expected about 100ms", d)
+	}
+	r.Cancel()
+	if d := rl.Reserve().Delay(); d < 50*time.Millisecond || d > 100*time.Millisecond {
+		t.Fatalf("reservation after a cancelation delayed by %v, expected about 100ms", d)
+	}
+}
+
+func TestRateLimiterWait(t *testing.T) {
+	rl := NewRateLimiter(50, 1)
+	t0 := time.Now()
+	for i := 0; i < 3; i++ {
+		if err := rl.Wait(nil); err != nil {
+			t.Fatalf("wait: %s", err)
+		}
+	}
+	if d := time.Now().Sub(t0); d < 30*time.Millisecond {
+		t.Errorf("3 events at 50/s took %v, expected about 40ms", d)
+	}
+	cancel := make(chan struct{})
+	close(cancel)
+	if err := NewRateLimiter(0, 1).WaitN(1, nil); err != nil {
+		t.Fatalf("wait for the burst: %s", err)
+	}
+	rl = NewRateLimiter(0, 1)
+	rl.Allow()
+	if err := rl.Wait(cancel); err != ErrNeverAllowed {
+		t.Fatalf("wait on an empty bucket without a rate returned %v", err)
+	}
+	if err := rl.WaitN(1, nil); err != ErrNeverAllowed {
+		t.Fatalf("wait on an empty bucket without a rate returned %v", err)
+	}
+	if r := rl.Reserve(); r.Delay() != math.MaxInt64 {
+		t.Errorf("reservation on an empty bucket without a rate delayed by %v", r.Delay())
+	}
+	// The bucket fills again once there is a rate
+	rl.SetRate(1000, 1)
+	if err := rl.Wait(nil); err != nil {
+		t.Fatalf("wait after the rate was set: %s", err)
+	}
+	rl = NewRateLimiter(10, 1)
+	rl.Allow()
+	if err := rl.Wait(cancel); err != ErrCanceled {
+		t.Fatalf("canceled wait returned %v", err)
+	}
+}
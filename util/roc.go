@@ -5,47 +5,137 @@
 package util
 
 import (
+	"errors"
 	"io"
 	"net"
+	"sync"
+	"syscall"
 )
 
+// runOnClose closes an io.Closer once, however many times and from however
+// many goroutines it is closed, and executes run with the result of the
+// first Close.
+type runOnClose struct {
+	once sync.Once
+	run  func(error)
+	err  error // Result of the first Close
+}
+
+func (r *runOnClose) close(c io.Closer) error {
+	r.once.Do(func() {
+		r.err = c.Close()
+		if r.run != nil {
+			r.run(r.err)
+		}
+	})
+	return r.err
+}
+
+// ignoreErr adapts f to a callback that ignores the result of Close.
+func ignoreErr(f func()) func(error) {
+	if f == nil {
+		return nil
+	}
+	return func(error) { f() }
+}
+
 // runOnCloseReader wraps an io.ReadCloser, and executes a user-provided
 // function run, after the first call to Close.
 type runOnCloseReader struct {
 	io.ReadCloser
-	run func()
+	roc *runOnClose
 }
 
 func NewRunOnCloseReader(c io.ReadCloser, f func()) *runOnCloseReader {
-	return &runOnCloseReader{c, f}
+	return NewRunOnCloseReaderErr(c, ignoreErr(f))
+}
+
+// NewRunOnCloseReaderErr is like NewRunOnCloseReader, but f is passed
+// the result of the first Close.
+func NewRunOnCloseReaderErr(c io.ReadCloser, f func(error)) *runOnCloseReader {
+	return &runOnCloseReader{c, &runOnClose{run: f}}
 }
 
+// Close closes the underlying reader and runs the function on the first
+// call. Later calls return the result of the first.
 func (t *runOnCloseReader) Close() error {
-	err := t.ReadCloser.Close()
-	if t.run != nil {
-		t.run()
-		t.run = nil
-	}
-	return err
+	return t.roc.close(t.ReadCloser)
+}
+
+// runOnCloseWriter wraps an io.WriteCloser, and executes a user-provided
+// function run, after the first call to Close.
+type runOnCloseWriter struct {
+	io.WriteCloser
+	roc *runOnClose
+}
+
+// NewRunOnCloseWriter returns a wrapper of c, which passes the result of
+// the first Close to f.
+func NewRunOnCloseWriter(c io.WriteCloser, f func(error)) *runOnCloseWriter {
+	return &runOnCloseWriter{c, &runOnClose{run: f}}
+}
+
+func (t *runOnCloseWriter) Close() error {
+	return t.roc.close(t.WriteCloser)
+}
+
+// runOnCloseReadWriter wraps an io.ReadWriteCloser, and executes a
+// user-provided function run, after the first call to Close.
+type runOnCloseReadWriter struct {
+	io.ReadWriteCloser
+	roc *runOnClose
+}
+
+// NewRunOnCloseReadWriter returns a wrapper of c, which passes the result
+// of the first Close to f.
+func NewRunOnCloseReadWriter(c io.ReadWriteCloser, f func(error)) *runOnCloseReadWriter {
+	return &runOnCloseReadWriter{c, &runOnClose{run: f}}
+}
+
+func (t *runOnCloseReadWriter) Close() error {
+	return t.roc.close(t.ReadWriteCloser)
 }
 
 // runOnCloseConn wraps a net.Conn, and executes a user-provided
 // function run, after the first call to Close.
 type runOnCloseConn struct {
 	net.Conn
-	run func()
+	roc *runOnClose
 }
 
 func NewRunOnCloseConn(c net.Conn, f func()) *runOnCloseConn {
-	return &runOnCloseConn{c, f}
+	return NewRunOnCloseConnErr(c, ignoreErr(f))
 }
 
-// XXX: make re-entrant
+// NewRunOnCloseConnErr is like NewRunOnCloseConn, but f is passed the
+// result of the first Close.
+func NewRunOnCloseConnErr(c net.Conn, f func(error)) *runOnCloseConn {
+	return &runOnCloseConn{c, &runOnClose{run: f}}
+}
+
+// Close closes the underlying connection and runs the function on the
+// first call. It is safe to call concurrently; later calls return the
+// result of the first.
 func (t *runOnCloseConn) Close() error {
-	err := t.Conn.Close()
-	if t.run != nil {
-		t.run()
-		t.run = nil
+	return t.roc.close(t.Conn)
+}
+
+// ReadFrom copies r to the underlying connection using its ReadFrom, if
+// it has one, so that a TCP connection can send files with sendfile.
+func (t *runOnCloseConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := t.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(t.Conn, r)
+}
+
+var errNoSyscallConn = errors.New("util: connection has no file descriptor")
+
+// SyscallConn returns the raw connection underlying t, if any, so that
+// the readiness of its file descriptor can be polled.
+func (t *runOnCloseConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := t.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
 	}
-	return err
+	return nil, errNoSyscallConn
 }
@@ -0,0 +1,100 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type countingCloser struct {
+	sync.Mutex
+	closes int
+	err    error
+}
+
+func (c *countingCloser) Read(p []byte) (int, error)  { return 0, nil }
+func (c *countingCloser) Write(p []byte) (int, error) { return len(p), nil }
+
+func (c *countingCloser) Close() error {
+	c.Lock()
+	defer c.Unlock()
+	c.closes++
+	return c.err
+}
+
+func TestRunOnCloseOnce(t *testing.T) {
+	c := &countingCloser{err: errors.New("close failed")}
+	runs := 0
+	var got error
+	rw := NewRunOnCloseReadWriter(c, func(err error) {
+		runs++
+		got = err
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := rw.Close(); err != c.err {
+				t.Errorf("Close returned %v, expected %v", err, c.err)
+			}
+		}()
+	}
+	wg.Wait()
+	if c.closes != 1 || runs != 1 {
+		t.Errorf("closed %d times and ran %d times, expected once", c.closes, runs)
+	}
+	if got != c.err {
+		t.Errorf("callback got %v, expected %v", got, c.err)
+	}
+}
+
+// readFromConn is a net.Conn that records the bytes copied by ReadFrom.
+type readFromConn struct {
+	net.Conn
+	n int64
+}
+
+func (c *readFromConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(ioutil.Discard, r)
+	c.n += n
+	return n, err
+}
+
+func TestRunOnCloseConnReadFrom(t *testing.T) {
+	c := &readFromConn{}
+	n, err := NewRunOnCloseConn(c, nil).ReadFrom(strings.NewReader("hello"))
+	if err != nil || n != 5 {
+		t.Fatalf("ReadFrom returned %d, %v, expected 5, nil", n, err)
+	}
+	if c.n != 5 {
+		t.Errorf("underlying ReadFrom copied %d bytes, expected 5", c.n)
+	}
+}
+
+func TestRunOnCloseConnSyscallConn(t *testing.T) {
+	if _, err := NewRunOnCloseConn(&readFromConn{}, nil).SyscallConn(); err != errNoSyscallConn {
+		t.Errorf("SyscallConn of a conn without a descriptor returned %v, expected %v", err, errNoSyscallConn)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %s", err)
+	}
+	defer l.Close()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	defer c.Close()
+	if _, err := NewRunOnCloseConn(c, nil).SyscallConn(); err != nil {
+		t.Errorf("SyscallConn of a TCP conn returned %v", err)
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"io"
+	"log"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TrackBodies turns on the tracking of the bodies that GoHTTP hands to
+// user code, with TrackBody. It is meant for debugging leaks of bodies
+// that are never closed, and costs a stack trace per body.
+var TrackBodies = false
+
+// TrackTimeout is how long a body tracked by TrackBody may stay open
+// before it is reported.
+var TrackTimeout = time.Minute
+
+// Number of tracked ReadClosers not closed yet
+var tracked int64
+
+// TrackedReadCloser wraps an io.ReadCloser, and logs the stack of its
+// creation if it is not closed within a timeout.
+type TrackedReadCloser struct {
+	io.ReadCloser
+	stack []byte
+	timer *time.Timer
+	once  sync.Once
+}
+
+// NewTrackedReadCloser returns a TrackedReadCloser of rc, which is reported
+// if not closed within timeout.
+func NewTrackedReadCloser(rc io.ReadCloser, timeout time.Duration) *TrackedReadCloser {
+	buf := make([]byte, 4096)
+	t := &TrackedReadCloser{ReadCloser: rc, stack: buf[:runtime.Stack(buf, false)]}
+	atomic.AddInt64(&tracked, 1)
+	t.timer = time.AfterFunc(timeout, func() {
+		log.Printf("ReadCloser not closed after %s, created at:\n%s", timeout, t.stack)
+	})
+	return t
+}
+
+// Close closes the underlying ReadCloser. Later calls only close it again.
+func (t *TrackedReadCloser) Close() error {
+	t.once.Do(func() {
+		t.timer.Stop()
+		atomic.AddInt64(&tracked, -1)
+	})
+	return t.ReadCloser.Close()
+}
+
+// Unclosed returns the number of TrackedReadClosers not closed yet.
+func Unclosed() int64 { return atomic.LoadInt64(&tracked) }
+
+// TrackBody returns rc wrapped in a TrackedReadCloser with TrackTimeout
+// if TrackBodies is on, and rc itself otherwise.
+func TrackBody(rc io.ReadCloser) io.ReadCloser {
+	if !TrackBodies || rc == nil {
+		return rc
+	}
+	return NewTrackedReadCloser(rc, TrackTimeout)
+}
@@ -0,0 +1,28 @@
+// Copyright 2011 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package util
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestTrackedReadCloser(t *testing.T) {
+	n := Unclosed()
+	rc := NewTrackedReadCloser(ioutil.NopCloser(bytes.NewBufferString("x")), time.Hour)
+	if u := Unclosed(); u != n+1 {
+		t.Fatalf("%d unclosed, expected %d", u, n+1)
+	}
+	rc.Close()
+	rc.Close()
+	if u := Unclosed(); u != n {
+		t.Fatalf("%d unclosed after Close, expected %d", u, n)
+	}
+	if !bytes.Contains(rc.stack, []byte("TestTrackedReadCloser")) {
+		t.Errorf("stack does not show the creator:\n%s", rc.stack)
+	}
+}